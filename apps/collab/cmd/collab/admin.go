@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/NoumanAMalik/maple/apps/collab/internal/config"
+	"github.com/NoumanAMalik/maple/apps/collab/internal/db"
+	"github.com/NoumanAMalik/maple/apps/collab/internal/models"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// adminCommand is an operator subcommand such as "users list"
+type adminCommand struct {
+	usage string
+	run   func(ctx context.Context, env *adminEnv, args []string) error
+}
+
+var adminCommands = map[string]map[string]adminCommand{
+	"users": {
+		"create":  {usage: "users create --email EMAIL [--name NAME] [--password PASSWORD] [--json]", run: usersCreate},
+		"list":    {usage: "users list [--limit N] [--json]", run: usersList},
+		"disable": {usage: "users disable USER [--json]", run: usersDisable},
+	},
+	"sessions": {
+		"revoke": {usage: "sessions revoke SESSION_ID | --user USER [--json]", run: sessionsRevoke},
+	},
+	"docs": {
+		"export": {usage: "docs export DOC_ID [--version N] [--out FILE] [--json]", run: docsExport},
+		"import": {usage: "docs import FILE --owner USER [--title TITLE] [--language LANG] [--json]", run: docsImport},
+		"purge":  {usage: "docs purge [DOC_ID...] [--older-than DURATION] [--json]", run: docsPurge},
+	},
+	"rooms": {
+		"list":     {usage: "rooms list [--server URL] [--token TOKEN] [--json]", run: roomsList},
+		"show":     {usage: "rooms show ROOM [--server URL] [--token TOKEN] [--json]", run: roomsShow},
+		"close":    {usage: "rooms close ROOM [--reason REASON] [--server URL] [--token TOKEN] [--json]", run: roomsClose},
+		"snapshot": {usage: "rooms snapshot ROOM [--message MESSAGE] [--server URL] [--token TOKEN] [--json]", run: roomsSnapshot},
+	},
+}
+
+// adminEnv is what admin subcommands run against. The pool is opened on
+// first use so commands that talk to a running server need no database.
+type adminEnv struct {
+	cfg    *config.Config
+	stdout io.Writer
+	pool   *pgxpool.Pool
+}
+
+func (env *adminEnv) db(ctx context.Context) (*pgxpool.Pool, error) {
+	if env.pool == nil {
+		pool, err := db.NewPool(ctx, env.cfg.DatabaseURL)
+		if err != nil {
+			return nil, fmt.Errorf("database connection failed: %w", err)
+		}
+		env.pool = pool
+	}
+	return env.pool, nil
+}
+
+func isAdminCommand(name string) bool {
+	_, ok := adminCommands[name]
+	return ok
+}
+
+// runAdmin runs an admin subcommand and returns the process exit code
+func runAdmin(cfg *config.Config, args []string) int {
+	group := adminCommands[args[0]]
+	if len(args) < 2 {
+		printAdminUsage(args[0])
+		return 2
+	}
+	cmd, ok := group[args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "collab: unknown command %q\n", strings.Join(args[:2], " "))
+		printAdminUsage(args[0])
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	env := &adminEnv{cfg: cfg, stdout: os.Stdout}
+	defer func() {
+		if env.pool != nil {
+			env.pool.Close()
+		}
+	}()
+
+	if err := cmd.run(ctx, env, args[2:]); err != nil {
+		if errors.Is(err, errUsage) {
+			fmt.Fprintf(os.Stderr, "usage: collab %s\n", cmd.usage)
+			return 2
+		}
+		fmt.Fprintf(os.Stderr, "collab: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func printAdminUsage(group string) {
+	names := make([]string, 0, len(adminCommands[group]))
+	for name := range adminCommands[group] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(os.Stderr, "usage:")
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  collab %s\n", adminCommands[group][name].usage)
+	}
+}
+
+var errUsage = errors.New("usage")
+
+// parseFlags parses flags that may appear before, after or between
+// positional arguments, and returns the positional arguments.
+func parseFlags(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			// The flag package has already reported the problem
+			return nil, errUsage
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// output prints results as a table, or as indented JSON with --json
+type output struct {
+	json bool
+	w    io.Writer
+}
+
+func newOutput(fs *flag.FlagSet, env *adminEnv) *output {
+	out := &output{w: env.stdout}
+	fs.BoolVar(&out.json, "json", false, "print JSON instead of a table")
+	return out
+}
+
+// print writes value as JSON, or the rows under header as a table
+func (o *output) print(value any, header []string, rows [][]string) error {
+	if o.json {
+		enc := json.NewEncoder(o.w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(value)
+	}
+
+	tw := tabwriter.NewWriter(o.w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+// resolveUser finds a user by email when ref contains an @, by ID otherwise
+func resolveUser(ctx context.Context, users *db.UserRepo, ref string) (*models.User, error) {
+	var (
+		user *models.User
+		err  error
+	)
+	if strings.Contains(ref, "@") {
+		user, err = users.GetByEmail(ctx, ref)
+	} else {
+		user, err = users.GetByID(ctx, ref)
+	}
+	if errors.Is(err, db.ErrNotFound) {
+		return nil, fmt.Errorf("user %q not found", ref)
+	}
+	return user, err
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/NoumanAMalik/maple/apps/collab/internal/db"
+	"github.com/NoumanAMalik/maple/apps/collab/internal/history"
+	"github.com/NoumanAMalik/maple/apps/collab/internal/models"
+)
+
+// exportedDocument is the --json form of docs export
+type exportedDocument struct {
+	Document *models.Document `json:"document"`
+	Version  int64            `json:"version"`
+	Content  string           `json:"content"`
+}
+
+func docsExport(ctx context.Context, env *adminEnv, args []string) error {
+	fs := flag.NewFlagSet("docs export", flag.ContinueOnError)
+	version := fs.Int64("version", -1, "version to export (defaults to the current one)")
+	outFile := fs.String("out", "", "write to this file instead of stdout")
+	out := newOutput(fs, env)
+	rest, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 1 {
+		return errUsage
+	}
+
+	pool, err := env.db(ctx)
+	if err != nil {
+		return err
+	}
+	docs := db.NewDocumentRepo(pool)
+	doc, err := docs.GetByID(ctx, rest[0])
+	if errors.Is(err, db.ErrNotFound) {
+		return fmt.Errorf("document %q not found", rest[0])
+	}
+	if err != nil {
+		return err
+	}
+
+	if *version < 0 {
+		*version = doc.CurrentVersion
+	}
+	historyService := history.NewService(docs, db.NewOpRepo(pool), db.NewSnapshotRepo(pool), db.NewWebhookRepo(pool))
+	content, err := historyService.Materialize(ctx, doc.ID, *version)
+	if errors.Is(err, history.ErrVersionUnavailable) {
+		return fmt.Errorf("version %d of document %s is not available", *version, doc.ID)
+	}
+	if err != nil {
+		return err
+	}
+
+	if *outFile != "" {
+		f, err := os.Create(*outFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out.w = f
+	}
+
+	if out.json {
+		return out.print(exportedDocument{Document: doc, Version: *version, Content: content}, nil, nil)
+	}
+	_, err = fmt.Fprint(out.w, content)
+	return err
+}
+
+func docsImport(ctx context.Context, env *adminEnv, args []string) error {
+	fs := flag.NewFlagSet("docs import", flag.ContinueOnError)
+	ownerRef := fs.String("owner", "", "owner of the new document (ID or email)")
+	title := fs.String("title", "", "document title (defaults to the file name)")
+	language := fs.String("language", "", "document language")
+	out := newOutput(fs, env)
+	rest, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 1 || *ownerRef == "" {
+		return errUsage
+	}
+
+	content, err := os.ReadFile(rest[0])
+	if err != nil {
+		return err
+	}
+	docTitle := strings.TrimSpace(*title)
+	if docTitle == "" {
+		docTitle = filepath.Base(rest[0])
+	}
+
+	pool, err := env.db(ctx)
+	if err != nil {
+		return err
+	}
+	owner, err := resolveUser(ctx, db.NewUserRepo(pool), *ownerRef)
+	if err != nil {
+		return err
+	}
+	doc, _, err := db.NewDocumentRepo(pool).CreateWithSnapshot(ctx, owner.ID, docTitle, *language, string(content), nil)
+	if err != nil {
+		return err
+	}
+
+	return out.print(doc, []string{"ID", "TITLE", "OWNER", "VERSION"}, [][]string{
+		{doc.ID, doc.Title, owner.Email, strconv.FormatInt(doc.CurrentVersion, 10)},
+	})
+}
+
+type purgedDocuments struct {
+	DocumentIDs []string `json:"documentIds,omitempty"`
+	Purged      int64    `json:"purged"`
+}
+
+// docsPurge permanently removes trashed documents, either the ones named or
+// every one trashed longer than --older-than ago. Documents not in the trash
+// are refused.
+func docsPurge(ctx context.Context, env *adminEnv, args []string) error {
+	fs := flag.NewFlagSet("docs purge", flag.ContinueOnError)
+	olderThan := fs.Duration("older-than", 0, "purge everything trashed at least this long ago")
+	out := newOutput(fs, env)
+	rest, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if (len(rest) == 0) == (*olderThan <= 0) {
+		return errUsage
+	}
+
+	pool, err := env.db(ctx)
+	if err != nil {
+		return err
+	}
+	docs := db.NewDocumentRepo(pool)
+
+	var result purgedDocuments
+	if *olderThan > 0 {
+		purged, err := docs.PurgeDeletedBefore(ctx, time.Now().Add(-*olderThan))
+		if err != nil {
+			return err
+		}
+		result.DocumentIDs = append(result.DocumentIDs, purged...)
+		result.Purged = int64(len(purged))
+	}
+	for _, docID := range rest {
+		doc, err := docs.GetByID(ctx, docID)
+		if errors.Is(err, db.ErrNotFound) {
+			return fmt.Errorf("document %q not found", docID)
+		}
+		if err != nil {
+			return err
+		}
+		if doc.DeletedAt == nil {
+			return fmt.Errorf("document %s is not in the trash", docID)
+		}
+		if err := docs.HardDelete(ctx, doc.ID, doc.OwnerID); err != nil {
+			return err
+		}
+		result.DocumentIDs = append(result.DocumentIDs, doc.ID)
+		result.Purged++
+	}
+
+	return out.print(result, []string{"PURGED"}, [][]string{{strconv.FormatInt(result.Purged, 10)}})
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/NoumanAMalik/maple/apps/collab/internal/collab"
+	"github.com/NoumanAMalik/maple/apps/collab/internal/httpapi"
+)
+
+// roomsList asks a running server for its open rooms; they live in memory,
+// not the database
+func roomsList(ctx context.Context, env *adminEnv, args []string) error {
+	fs := flag.NewFlagSet("rooms list", flag.ContinueOnError)
+	api := newAdminAPI(fs, env)
+	out := newOutput(fs, env)
+	rest, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(rest) > 0 {
+		return errUsage
+	}
+
+	var resp httpapi.AdminRoomListResponse
+	if err := api.request(ctx, http.MethodGet, "/v1/admin/rooms", nil, &resp); err != nil {
+		return err
+	}
+
+	rows := make([][]string, 0, len(resp.Rooms))
+	for _, room := range resp.Rooms {
+		rows = append(rows, []string{
+			room.RoomID,
+			orDash(room.DocumentID),
+			strconv.Itoa(room.ParticipantCount),
+			strconv.Itoa(room.Version),
+			strconv.Itoa(room.ContentBytes),
+			strconv.Itoa(room.SnapshotCount),
+			(time.Duration(room.IdleSeconds) * time.Second).String(),
+			time.Since(room.CreatedAt).Round(time.Second).String(),
+		})
+	}
+	return out.print(resp.Rooms, []string{"ROOM", "DOCUMENT", "CLIENTS", "VERSION", "BYTES", "SNAPSHOTS", "IDLE", "AGE"}, rows)
+}
+
+// roomsShow prints a room's summary and who is connected to it
+func roomsShow(ctx context.Context, env *adminEnv, args []string) error {
+	fs := flag.NewFlagSet("rooms show", flag.ContinueOnError)
+	api := newAdminAPI(fs, env)
+	out := newOutput(fs, env)
+	rest, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 1 {
+		return errUsage
+	}
+
+	var room httpapi.AdminRoomDetailResponse
+	if err := api.request(ctx, http.MethodGet, "/v1/admin/rooms/"+url.PathEscape(rest[0]), nil, &room); err != nil {
+		return err
+	}
+
+	if !out.json {
+		fmt.Fprintf(env.stdout, "room %s  document %s  version %d  %d bytes  %d snapshots  idle %s\n\n",
+			room.RoomID, orDash(room.DocumentID), room.Version, room.ContentBytes, room.SnapshotCount,
+			time.Duration(room.IdleSeconds)*time.Second)
+	}
+
+	rows := make([][]string, 0, len(room.Participants))
+	for _, p := range room.Participants {
+		cursor := "-"
+		if p.Presence != nil && p.Presence.Cursor != nil {
+			cursor = fmt.Sprintf("%d:%d", p.Presence.Cursor.Line, p.Presence.Cursor.Column)
+		}
+		rows = append(rows, []string{
+			p.ClientID,
+			orDash(p.UserID),
+			orDash(p.DisplayName),
+			cursor,
+			time.Since(p.JoinedAt).Round(time.Second).String(),
+		})
+	}
+	return out.print(room, []string{"CLIENT", "USER", "NAME", "CURSOR", "CONNECTED"}, rows)
+}
+
+type closedRoom struct {
+	RoomID string `json:"roomId"`
+	Reason string `json:"reason"`
+}
+
+// roomsClose disconnects everyone in a room and removes it from the server
+func roomsClose(ctx context.Context, env *adminEnv, args []string) error {
+	fs := flag.NewFlagSet("rooms close", flag.ContinueOnError)
+	api := newAdminAPI(fs, env)
+	reason := fs.String("reason", "", "reason shown to connected clients")
+	out := newOutput(fs, env)
+	rest, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 1 {
+		return errUsage
+	}
+
+	body := httpapi.AdminCloseRoomRequest{Reason: *reason}
+	if err := api.request(ctx, http.MethodPost, "/v1/admin/rooms/"+url.PathEscape(rest[0])+"/close", body, nil); err != nil {
+		return err
+	}
+
+	result := closedRoom{RoomID: rest[0], Reason: *reason}
+	return out.print(result, []string{"ROOM", "REASON"}, [][]string{{rest[0], orDash(*reason)}})
+}
+
+// roomsSnapshot takes a manual snapshot of a room, as if a participant had
+// saved
+func roomsSnapshot(ctx context.Context, env *adminEnv, args []string) error {
+	fs := flag.NewFlagSet("rooms snapshot", flag.ContinueOnError)
+	api := newAdminAPI(fs, env)
+	message := fs.String("message", "", "snapshot message")
+	out := newOutput(fs, env)
+	rest, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 1 {
+		return errUsage
+	}
+
+	var snapshot collab.Snapshot
+	body := httpapi.AdminSnapshotRequest{Message: *message}
+	if err := api.request(ctx, http.MethodPost, "/v1/admin/rooms/"+url.PathEscape(rest[0])+"/snapshots", body, &snapshot); err != nil {
+		return err
+	}
+
+	return out.print(snapshot, []string{"SNAPSHOT", "VERSION", "MESSAGE"}, [][]string{{snapshot.ID, strconv.Itoa(snapshot.Version), orDash(snapshot.Message)}})
+}
+
+// adminAPI is a running server's admin API, as given by the --server and
+// --token flags
+type adminAPI struct {
+	server *string
+	token  *string
+}
+
+func newAdminAPI(fs *flag.FlagSet, env *adminEnv) *adminAPI {
+	return &adminAPI{
+		server: fs.String("server", "http://localhost:"+env.cfg.Port, "base URL of the running server"),
+		token:  fs.String("token", env.cfg.AdminToken, "admin token (defaults to ADMIN_TOKEN)"),
+	}
+}
+
+func (a *adminAPI) request(ctx context.Context, method, path string, body, out any) error {
+	if *a.token == "" {
+		return fmt.Errorf("an admin token is required; set ADMIN_TOKEN or pass --token")
+	}
+	return adminRequest(ctx, method, *a.server, path, *a.token, body, out)
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// adminRequest calls an admin endpoint, sending body as JSON unless it is
+// nil, and decodes the JSON response into out
+func adminRequest(ctx context.Context, method, server, path, token string, body, out any) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(server, "/")+path, reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%s has no admin API; is ADMIN_TOKEN set on the server?", server)
+	}
+	if res.StatusCode >= 300 {
+		var apiErr httpapi.ErrorResponse
+		if json.NewDecoder(res.Body).Decode(&apiErr) == nil && apiErr.Error != "" {
+			return fmt.Errorf("%s: %s", res.Status, apiErr.Error)
+		}
+		return fmt.Errorf("%s %s: %s", method, path, res.Status)
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"net/mail"
+	"strconv"
+	"strings"
+
+	"github.com/NoumanAMalik/maple/apps/collab/internal/auth"
+	"github.com/NoumanAMalik/maple/apps/collab/internal/db"
+	"github.com/NoumanAMalik/maple/apps/collab/internal/models"
+)
+
+const minPasswordLength = 8
+
+type createdUser struct {
+	User *models.User `json:"user"`
+	// Set only when the password was generated
+	Password string `json:"password,omitempty"`
+}
+
+func usersCreate(ctx context.Context, env *adminEnv, args []string) error {
+	fs := flag.NewFlagSet("users create", flag.ContinueOnError)
+	email := fs.String("email", "", "email address")
+	name := fs.String("name", "", "display name (defaults to the email's local part)")
+	password := fs.String("password", "", "password (generated when empty)")
+	out := newOutput(fs, env)
+	if rest, err := parseFlags(fs, args); err != nil {
+		return err
+	} else if len(rest) > 0 || *email == "" {
+		return errUsage
+	}
+
+	address, err := mail.ParseAddress(strings.ToLower(strings.TrimSpace(*email)))
+	if err != nil {
+		return fmt.Errorf("invalid email %q", *email)
+	}
+	displayName := strings.TrimSpace(*name)
+	if displayName == "" {
+		displayName, _, _ = strings.Cut(address.Address, "@")
+	}
+
+	result := createdUser{}
+	if *password == "" {
+		buf := make([]byte, 12)
+		if _, err := rand.Read(buf); err != nil {
+			return err
+		}
+		*password = base64.RawURLEncoding.EncodeToString(buf)
+		result.Password = *password
+	}
+	if len(strings.TrimSpace(*password)) < minPasswordLength {
+		return fmt.Errorf("password must be at least %d characters", minPasswordLength)
+	}
+
+	passwordHash, err := auth.DefaultPasswordHasher().Hash(*password)
+	if err != nil {
+		return err
+	}
+
+	pool, err := env.db(ctx)
+	if err != nil {
+		return err
+	}
+	user, err := db.NewUserRepo(pool).Create(ctx, address.Address, displayName, passwordHash)
+	if errors.Is(err, db.ErrDuplicate) {
+		return fmt.Errorf("email %s is already registered", address.Address)
+	}
+	if err != nil {
+		return err
+	}
+	result.User = user
+
+	header := []string{"ID", "EMAIL", "NAME"}
+	row := []string{user.ID, user.Email, user.DisplayName}
+	if result.Password != "" {
+		header = append(header, "PASSWORD")
+		row = append(row, result.Password)
+	}
+	return out.print(result, header, [][]string{row})
+}
+
+func usersList(ctx context.Context, env *adminEnv, args []string) error {
+	fs := flag.NewFlagSet("users list", flag.ContinueOnError)
+	limit := fs.Int("limit", 100, "maximum number of users")
+	out := newOutput(fs, env)
+	if rest, err := parseFlags(fs, args); err != nil {
+		return err
+	} else if len(rest) > 0 || *limit <= 0 {
+		return errUsage
+	}
+
+	pool, err := env.db(ctx)
+	if err != nil {
+		return err
+	}
+	users, err := db.NewUserRepo(pool).List(ctx, *limit)
+	if err != nil {
+		return err
+	}
+	if users == nil {
+		users = []models.User{}
+	}
+
+	rows := make([][]string, 0, len(users))
+	for _, user := range users {
+		rows = append(rows, []string{user.ID, user.Email, user.DisplayName, formatTime(&user.CreatedAt), formatTime(user.DisabledAt)})
+	}
+	return out.print(users, []string{"ID", "EMAIL", "NAME", "CREATED", "DISABLED"}, rows)
+}
+
+type disabledUser struct {
+	UserID          string `json:"userId"`
+	RevokedSessions int64  `json:"revokedSessions"`
+}
+
+func usersDisable(ctx context.Context, env *adminEnv, args []string) error {
+	fs := flag.NewFlagSet("users disable", flag.ContinueOnError)
+	out := newOutput(fs, env)
+	rest, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 1 {
+		return errUsage
+	}
+
+	pool, err := env.db(ctx)
+	if err != nil {
+		return err
+	}
+	users := db.NewUserRepo(pool)
+	user, err := resolveUser(ctx, users, rest[0])
+	if err != nil {
+		return err
+	}
+	revoked, err := users.Disable(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+
+	result := disabledUser{UserID: user.ID, RevokedSessions: revoked}
+	return out.print(result, []string{"USER", "REVOKED SESSIONS"}, [][]string{{user.ID, strconv.FormatInt(revoked, 10)}})
+}
+
+type revokedSessions struct {
+	SessionID string `json:"sessionId,omitempty"`
+	UserID    string `json:"userId,omitempty"`
+	Revoked   int64  `json:"revoked"`
+}
+
+func sessionsRevoke(ctx context.Context, env *adminEnv, args []string) error {
+	fs := flag.NewFlagSet("sessions revoke", flag.ContinueOnError)
+	userRef := fs.String("user", "", "revoke every session of this user (ID or email)")
+	out := newOutput(fs, env)
+	rest, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	// Exactly one of a session ID or --user
+	if len(rest) > 1 || (*userRef == "") == (len(rest) == 0) {
+		return errUsage
+	}
+
+	pool, err := env.db(ctx)
+	if err != nil {
+		return err
+	}
+	sessions := db.NewSessionRepo(pool)
+
+	var result revokedSessions
+	if *userRef != "" {
+		user, err := resolveUser(ctx, db.NewUserRepo(pool), *userRef)
+		if err != nil {
+			return err
+		}
+		result.UserID = user.ID
+		if result.Revoked, err = sessions.RevokeAllForUser(ctx, user.ID); err != nil {
+			return err
+		}
+	} else {
+		result.SessionID = rest[0]
+		err := sessions.RevokeIfActive(ctx, rest[0])
+		if errors.Is(err, db.ErrNotFound) {
+			return fmt.Errorf("no active session %q", rest[0])
+		}
+		if err != nil {
+			return err
+		}
+		result.Revoked = 1
+	}
+
+	target := result.SessionID
+	if target == "" {
+		target = "user " + result.UserID
+	}
+	return out.print(result, []string{"TARGET", "REVOKED"}, [][]string{{target, strconv.FormatInt(result.Revoked, 10)}})
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/NoumanAMalik/maple/apps/collab/internal/config"
+)
+
+const (
+	configPrintUsage  = "config print [--json] [--config FILE] [--SETTING VALUE...]"
+	configReloadUsage = "config reload [--server URL] [--token TOKEN] [--json]"
+)
+
+func runConfig(args []string) int {
+	if len(args) > 0 {
+		switch args[0] {
+		case "print":
+			return configPrint(args[1:])
+		case "reload":
+			return configReload(args[1:])
+		}
+	}
+	fmt.Fprintf(os.Stderr, "usage:\n  collab %s\n  collab %s\n", configPrintUsage, configReloadUsage)
+	return 2
+}
+
+// configPrint prints the configuration serve would run with, given the same
+// flags, and exits non-zero if serve would refuse it
+func configPrint(args []string) int {
+	fs := flag.NewFlagSet("config print", flag.ContinueOnError)
+	flags := config.NewFlags(fs)
+	asJSON := fs.Bool("json", false, "print JSON instead of YAML")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "usage: collab %s\n", configPrintUsage)
+		return 2
+	}
+
+	cfg, err := config.Load(flags)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "collab: %v\n", err)
+		return 1
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(cfg.Entries())
+	} else {
+		err = printConfigYAML(os.Stdout, cfg)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "collab: %v\n", err)
+		return 1
+	}
+
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, "collab: serve would refuse this configuration:")
+		for _, problem := range strings.Split(err.Error(), "\n") {
+			fmt.Fprintf(os.Stderr, "  - %s\n", problem)
+		}
+		return 1
+	}
+	return 0
+}
+
+// configReload asks a running server to re-read its config, like sending it
+// SIGHUP, and prints what changed
+func configReload(args []string) int {
+	cfg, err := config.Load(nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "collab: %v\n", err)
+		return 2
+	}
+	env := &adminEnv{cfg: cfg, stdout: os.Stdout}
+
+	fs := flag.NewFlagSet("config reload", flag.ContinueOnError)
+	api := newAdminAPI(fs, env)
+	out := newOutput(fs, env)
+	rest, err := parseFlags(fs, args)
+	if err != nil || len(rest) > 0 {
+		fmt.Fprintf(os.Stderr, "usage: collab %s\n", configReloadUsage)
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	var result config.ReloadResult
+	if err := api.request(ctx, http.MethodPost, "/v1/admin/reload", nil, &result); err != nil {
+		fmt.Fprintf(os.Stderr, "collab: %v\n", err)
+		return 1
+	}
+
+	var rows [][]string
+	for _, key := range result.Applied {
+		rows = append(rows, []string{key, "applied"})
+	}
+	for _, key := range result.RestartRequired {
+		rows = append(rows, []string{key, "needs restart"})
+	}
+	if err := out.print(result, []string{"SETTING", "STATUS"}, rows); err != nil {
+		fmt.Fprintf(os.Stderr, "collab: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// printConfigYAML writes the config as a YAML file serve could read back,
+// noting where each value came from. Redacted secrets would of course need
+// filling in.
+func printConfigYAML(w io.Writer, cfg *config.Config) error {
+	if cfg.File != "" {
+		fmt.Fprintf(w, "# config file: %s\n", cfg.File)
+	}
+	for _, entry := range cfg.Entries() {
+		value, err := yamlValue(entry.Value)
+		if err != nil {
+			return err
+		}
+		note := entry.Source
+		if entry.Reloadable {
+			note += ", reloadable"
+		}
+		if _, err := fmt.Fprintf(w, "%s: %s  # %s\n", entry.Key, value, note); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func yamlValue(value any) (string, error) {
+	if list, ok := value.([]string); ok {
+		items := make([]string, len(list))
+		for i, item := range list {
+			items[i] = strconv.Quote(item)
+		}
+		return "[" + strings.Join(items, ", ") + "]", nil
+	}
+	data, err := yaml.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(data), "\n"), nil
+}
@@ -0,0 +1,488 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode/utf16"
+
+	"github.com/NoumanAMalik/maple/apps/collab/internal/config"
+	"github.com/NoumanAMalik/maple/apps/collab/internal/httpapi"
+	"github.com/NoumanAMalik/maple/apps/collab/pkg/collabclient"
+)
+
+const loadtestUsage = "loadtest [--server URL] [--rooms M] [--clients N] [--duration 30s] [--rate 2] [--batch 3] [--ramp 5s] [--settle 30s] [--seed N] [--json]"
+
+const (
+	// loadtestTargetSize is the document size edits hover around; past it,
+	// deletes become more likely than inserts
+	loadtestTargetSize = 4096
+	loadtestAlphabet   = "abcdefghijklmnopqrstuvwxyz      \n\n{}();=.éü→😀"
+)
+
+type loadtestOptions struct {
+	server   string
+	rooms    int
+	clients  int
+	duration time.Duration
+	rate     float64
+	batch    int
+	ramp     time.Duration
+	settle   time.Duration
+	seed     uint64
+}
+
+// loadtestReport is what a run measured. Latencies are in milliseconds.
+type loadtestReport struct {
+	Rooms           int            `json:"rooms"`
+	Clients         int            `json:"clients"`
+	Joined          int            `json:"joined"`
+	FailedJoins     int            `json:"failedJoins"`
+	Disconnected    int            `json:"disconnected"`
+	DurationSeconds float64        `json:"durationSeconds"`
+	BatchesSent     int            `json:"batchesSent"`
+	BatchesAcked    int            `json:"batchesAcked"`
+	OpsSent         int            `json:"opsSent"`
+	BatchesPerSec   float64        `json:"batchesPerSecond"`
+	AckLatency      latencySummary `json:"ackLatencyMs"`
+	RemoteOps       int            `json:"remoteOps"`
+	Reconnects      int            `json:"reconnects"`
+	Resyncs         int            `json:"resyncs"`
+	ResyncRate      float64        `json:"resyncRate"`
+	LostBroadcasts  int            `json:"lostBroadcasts"`
+	DroppedOps      int            `json:"droppedOps"`
+	Unsettled       int            `json:"unsettled"`
+	DivergedClients int            `json:"divergedClients"`
+	DivergedRooms   []string       `json:"divergedRooms,omitempty"`
+}
+
+type latencySummary struct {
+	P50 float64 `json:"p50"`
+	P90 float64 `json:"p90"`
+	P99 float64 `json:"p99"`
+	Max float64 `json:"max"`
+}
+
+// simClient is one simulated editor
+type simClient struct {
+	roomID string
+	client *collabclient.Client
+	ops    int
+}
+
+// loadtest collects measurements shared by every simulated client
+type loadtest struct {
+	opts loadtestOptions
+
+	mu        sync.Mutex
+	latencies []time.Duration
+}
+
+// runLoadtest drives simulated clients against a running server and returns
+// the process exit code: 1 when any client diverged from the server
+func runLoadtest(cfg *config.Config, args []string) int {
+	fs := flag.NewFlagSet("loadtest", flag.ContinueOnError)
+	opts := loadtestOptions{}
+	fs.StringVar(&opts.server, "server", "http://localhost:"+cfg.Port, "base URL of the running server")
+	fs.IntVar(&opts.rooms, "rooms", 10, "number of rooms to create")
+	fs.IntVar(&opts.clients, "clients", 50, "number of simulated clients, spread evenly over the rooms")
+	fs.DurationVar(&opts.duration, "duration", 30*time.Second, "how long clients keep editing once all have joined")
+	fs.Float64Var(&opts.rate, "rate", 2, "average edit batches per second per client")
+	fs.IntVar(&opts.batch, "batch", 3, "most operations in one batch")
+	fs.DurationVar(&opts.ramp, "ramp", 5*time.Second, "period over which clients join")
+	fs.DurationVar(&opts.settle, "settle", 30*time.Second, "how long to wait for clients to converge after editing stops")
+	fs.Uint64Var(&opts.seed, "seed", uint64(time.Now().UnixNano()), "random seed, to replay a run's edits")
+	asJSON := fs.Bool("json", false, "print JSON instead of a summary")
+	rest, err := parseFlags(fs, args)
+	if err != nil || len(rest) > 0 || opts.rooms < 1 || opts.clients < opts.rooms || opts.rate <= 0 || opts.batch < 1 {
+		fmt.Fprintf(os.Stderr, "usage: collab %s\n", loadtestUsage)
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	lt := &loadtest{opts: opts}
+	report, err := lt.run(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "collab: %v\n", err)
+		return 1
+	}
+
+	out := &output{json: *asJSON, w: os.Stdout}
+	if err := out.print(report, []string{"METRIC", "VALUE"}, loadtestRows(report)); err != nil {
+		fmt.Fprintf(os.Stderr, "collab: %v\n", err)
+		return 1
+	}
+	if report.DivergedClients > 0 {
+		return 1
+	}
+	return 0
+}
+
+func (lt *loadtest) run(ctx context.Context) (*loadtestReport, error) {
+	opts := lt.opts
+	report := &loadtestReport{Rooms: opts.rooms, Clients: opts.clients}
+
+	roomIDs := make([]string, 0, opts.rooms)
+	defer func() {
+		for _, roomID := range roomIDs {
+			lt.deleteRoom(roomID)
+		}
+	}()
+	seedRNG := rand.New(rand.NewPCG(opts.seed, 0))
+	for i := 0; i < opts.rooms; i++ {
+		roomID, err := lt.createRoom(ctx, randomText(seedRNG, 200+seedRNG.IntN(800)))
+		if err != nil {
+			return nil, fmt.Errorf("creating room: %w", err)
+		}
+		roomIDs = append(roomIDs, roomID)
+	}
+
+	// Join in a steady stream over the ramp, round-robin across rooms
+	var (
+		clientsMu sync.Mutex
+		clients   []*simClient
+		joinWG    sync.WaitGroup
+	)
+	interval := opts.ramp / time.Duration(opts.clients)
+	for i := 0; i < opts.clients && ctx.Err() == nil; i++ {
+		if i > 0 && interval > 0 {
+			select {
+			case <-ctx.Done():
+				continue
+			case <-time.After(interval):
+			}
+		}
+		joinWG.Add(1)
+		go func(i int) {
+			defer joinWG.Done()
+			sim, err := lt.join(ctx, roomIDs[i%len(roomIDs)], i)
+			clientsMu.Lock()
+			defer clientsMu.Unlock()
+			if err != nil {
+				report.FailedJoins++
+				return
+			}
+			clients = append(clients, sim)
+		}(i)
+	}
+	joinWG.Wait()
+	defer func() {
+		for _, sim := range clients {
+			sim.client.Close()
+		}
+	}()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	report.Joined = len(clients)
+	if len(clients) == 0 {
+		return nil, errors.New("no client could join")
+	}
+
+	editCtx, stopEditing := context.WithTimeout(ctx, opts.duration)
+	defer stopEditing()
+	started := time.Now()
+	var editWG sync.WaitGroup
+	for i, sim := range clients {
+		editWG.Add(1)
+		go func() {
+			defer editWG.Done()
+			lt.edit(editCtx, sim, rand.New(rand.NewPCG(opts.seed, uint64(i)+1)))
+		}()
+	}
+	editWG.Wait()
+	elapsed := time.Since(started)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	report.Unsettled = lt.settle(ctx, clients)
+	if err := lt.verify(ctx, roomIDs, clients, report); err != nil {
+		return nil, err
+	}
+
+	report.DurationSeconds = elapsed.Seconds()
+	for _, sim := range clients {
+		stats := sim.client.Stats()
+		report.BatchesSent += stats.BatchesSent
+		report.BatchesAcked += stats.BatchesAcked
+		report.RemoteOps += stats.RemoteOps
+		report.Reconnects += stats.Reconnects
+		report.Resyncs += stats.Resyncs
+		report.LostBroadcasts += stats.LostEvents
+		report.DroppedOps += stats.DroppedOps
+		report.OpsSent += sim.ops
+		select {
+		case <-sim.client.Done():
+			report.Disconnected++
+		default:
+		}
+	}
+	if elapsed > 0 {
+		report.BatchesPerSec = float64(report.BatchesAcked) / elapsed.Seconds()
+	}
+	if report.BatchesSent > 0 {
+		report.ResyncRate = float64(report.Resyncs) / float64(report.BatchesSent)
+	}
+	report.AckLatency = lt.latencySummary()
+	return report, nil
+}
+
+func (lt *loadtest) join(ctx context.Context, roomID string, i int) (*simClient, error) {
+	client, err := collabclient.Dial(ctx, lt.opts.server, roomID, collabclient.Options{
+		DisplayName:   "loadtest " + strconv.Itoa(i),
+		MaxReconnects: 5,
+		OnAck: func(_ int, latency time.Duration) {
+			lt.recordAck(latency)
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &simClient{roomID: roomID, client: client}, nil
+}
+
+// edit sends random batches at Poisson-distributed intervals until ctx ends
+func (lt *loadtest) edit(ctx context.Context, sim *simClient, rng *rand.Rand) {
+	for {
+		wait := time.Duration(rng.ExpFloat64() / lt.opts.rate * float64(time.Second))
+		select {
+		case <-ctx.Done():
+			return
+		case <-sim.client.Done():
+			return
+		case <-time.After(wait):
+		}
+
+		var sent int
+		err := sim.client.Edit(func(content string) []collabclient.Operation {
+			ops := randomBatch(rng, content, 1+rng.IntN(lt.opts.batch))
+			sent = len(ops)
+			return ops
+		})
+		if err == nil {
+			sim.ops += sent
+		}
+	}
+}
+
+// settle waits for every connected client's edits to be acknowledged and
+// returns how many still had some outstanding when it gave up
+func (lt *loadtest) settle(ctx context.Context, clients []*simClient) int {
+	deadline := time.Now().Add(lt.opts.settle)
+	for {
+		pending := 0
+		for _, sim := range clients {
+			if sim.client.Err() == nil && sim.client.Pending() {
+				pending++
+			}
+		}
+		if pending == 0 || time.Now().After(deadline) || ctx.Err() != nil {
+			return pending
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// verify joins each room once more; the welcome carries the server's
+// content, which every client in the room must match once it has caught up
+// with the welcome's version
+func (lt *loadtest) verify(ctx context.Context, roomIDs []string, clients []*simClient, report *loadtestReport) error {
+	byRoom := make(map[string][]*simClient)
+	for _, sim := range clients {
+		byRoom[sim.roomID] = append(byRoom[sim.roomID], sim)
+	}
+
+	for _, roomID := range roomIDs {
+		verifier, err := collabclient.Dial(ctx, lt.opts.server, roomID, collabclient.Options{DisplayName: "loadtest verifier"})
+		if err != nil {
+			return fmt.Errorf("joining room %s to verify: %w", roomID, err)
+		}
+		server, version := verifier.Content(), verifier.Version()
+		verifier.Close()
+
+		diverged := 0
+		for _, sim := range byRoom[roomID] {
+			if sim.client.Err() != nil {
+				continue
+			}
+			if !waitForVersion(ctx, sim.client, version, lt.opts.settle) || sim.client.Content() != server {
+				diverged++
+			}
+		}
+		if diverged > 0 {
+			report.DivergedClients += diverged
+			report.DivergedRooms = append(report.DivergedRooms, roomID)
+		}
+	}
+	return nil
+}
+
+func waitForVersion(ctx context.Context, client *collabclient.Client, version int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for client.Version() < version {
+		if time.Now().After(deadline) || ctx.Err() != nil {
+			return false
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return true
+}
+
+func (lt *loadtest) recordAck(latency time.Duration) {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	lt.latencies = append(lt.latencies, latency)
+}
+
+func (lt *loadtest) latencySummary() latencySummary {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	if len(lt.latencies) == 0 {
+		return latencySummary{}
+	}
+
+	sort.Slice(lt.latencies, func(i, j int) bool { return lt.latencies[i] < lt.latencies[j] })
+	at := func(q float64) float64 {
+		i := int(q * float64(len(lt.latencies)-1))
+		return float64(lt.latencies[i].Microseconds()) / 1000
+	}
+	return latencySummary{P50: at(0.5), P90: at(0.9), P99: at(0.99), Max: at(1)}
+}
+
+func (lt *loadtest) createRoom(ctx context.Context, content string) (string, error) {
+	body, _ := json.Marshal(httpapi.CreateRoomRequest{Content: content, Language: "plaintext"})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(lt.opts.server, "/")+"/v1/rooms", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusCreated {
+		return "", fmt.Errorf("POST /v1/rooms: %s", res.Status)
+	}
+
+	var created httpapi.CreateRoomResponse
+	if err := json.NewDecoder(res.Body).Decode(&created); err != nil {
+		return "", err
+	}
+	return created.RoomID, nil
+}
+
+// deleteRoom removes a room the run created. It runs during cleanup, after
+// an interrupt may already have cancelled the run's context.
+func (lt *loadtest) deleteRoom(roomID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, strings.TrimRight(lt.opts.server, "/")+"/v1/rooms/"+roomID, nil)
+	if err != nil {
+		return
+	}
+	if res, err := http.DefaultClient.Do(req); err == nil {
+		res.Body.Close()
+	}
+}
+
+// randomBatch returns up to n operations that apply in sequence to content:
+// mostly typing, some deletes and the occasional paste. Positions are in
+// UTF-16 code units and never split a surrogate pair.
+func randomBatch(rng *rand.Rand, content string, n int) []collabclient.Operation {
+	units := utf16.Encode([]rune(content))
+	ops := make([]collabclient.Operation, 0, n)
+
+	for i := 0; i < n; i++ {
+		pos := boundary(units, rng.IntN(len(units)+1))
+		deleteChance := 0.25
+		if len(units) > loadtestTargetSize {
+			deleteChance = 0.6
+		}
+
+		if len(units) > 0 && rng.Float64() < deleteChance {
+			if pos == len(units) {
+				pos = boundary(units, pos-1)
+			}
+			end := boundary(units, min(len(units), pos+1+rng.IntN(12)))
+			if end <= pos {
+				end = boundary(units, min(len(units), pos+2))
+			}
+			if end <= pos {
+				continue
+			}
+			ops = append(ops, collabclient.Delete(pos, end-pos))
+			units = append(units[:pos:pos], units[end:]...)
+			continue
+		}
+
+		length := 1 + rng.IntN(8)
+		if rng.IntN(20) == 0 {
+			length = 40 + rng.IntN(200)
+		}
+		text := randomText(rng, length)
+		ops = append(ops, collabclient.Insert(pos, text))
+		inserted := utf16.Encode([]rune(text))
+		units = append(units[:pos:pos], append(inserted, units[pos:]...)...)
+	}
+	return ops
+}
+
+// boundary moves pos back off the second half of a surrogate pair
+func boundary(units []uint16, pos int) int {
+	if pos > 0 && pos < len(units) && utf16.IsSurrogate(rune(units[pos])) && units[pos] >= 0xDC00 {
+		return pos - 1
+	}
+	return pos
+}
+
+func randomText(rng *rand.Rand, n int) string {
+	alphabet := []rune(loadtestAlphabet)
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		b.WriteRune(alphabet[rng.IntN(len(alphabet))])
+	}
+	return b.String()
+}
+
+func loadtestRows(r *loadtestReport) [][]string {
+	rows := [][]string{
+		{"rooms", strconv.Itoa(r.Rooms)},
+		{"clients joined", fmt.Sprintf("%d/%d", r.Joined, r.Clients)},
+		{"disconnected", strconv.Itoa(r.Disconnected)},
+		{"duration", fmt.Sprintf("%.1fs", r.DurationSeconds)},
+		{"batches sent/acked", fmt.Sprintf("%d/%d", r.BatchesSent, r.BatchesAcked)},
+		{"ops sent", strconv.Itoa(r.OpsSent)},
+		{"throughput", fmt.Sprintf("%.1f batches/s", r.BatchesPerSec)},
+		{"ack latency", fmt.Sprintf("p50 %.1fms  p90 %.1fms  p99 %.1fms  max %.1fms", r.AckLatency.P50, r.AckLatency.P90, r.AckLatency.P99, r.AckLatency.Max)},
+		{"remote ops received", strconv.Itoa(r.RemoteOps)},
+		{"lost broadcasts", strconv.Itoa(r.LostBroadcasts)},
+		{"resyncs", fmt.Sprintf("%d (%.2f%% of batches)", r.Resyncs, r.ResyncRate*100)},
+		{"reconnects", strconv.Itoa(r.Reconnects)},
+		{"dropped ops", strconv.Itoa(r.DroppedOps)},
+		{"unsettled clients", strconv.Itoa(r.Unsettled)},
+		{"diverged clients", strconv.Itoa(r.DivergedClients)},
+	}
+	if len(r.DivergedRooms) > 0 {
+		rows = append(rows, []string{"diverged rooms", strings.Join(r.DivergedRooms, ", ")})
+	}
+	return rows
+}
@@ -2,6 +2,9 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
@@ -13,61 +16,140 @@ import (
 	"github.com/NoumanAMalik/maple/apps/collab/internal/config"
 	"github.com/NoumanAMalik/maple/apps/collab/internal/db"
 	"github.com/NoumanAMalik/maple/apps/collab/internal/httpapi"
+	"github.com/NoumanAMalik/maple/apps/collab/internal/metrics"
+	"github.com/NoumanAMalik/maple/apps/collab/internal/tracing"
 )
 
 func main() {
-	cfg := config.Load()
+	command, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
 
-	logLevel := new(slog.LevelVar)
-	switch strings.ToLower(cfg.LogLevel) {
-	case "debug":
-		logLevel.Set(slog.LevelDebug)
-	case "warn", "warning":
-		logLevel.Set(slog.LevelWarn)
-	case "error":
-		logLevel.Set(slog.LevelError)
+	switch command {
+	case "serve":
+		os.Exit(runServe(args))
+	case "config":
+		os.Exit(runConfig(args))
+	}
+
+	// Other commands take their own flags, so their config comes from the
+	// environment and CONFIG_FILE only
+	cfg, err := config.Load(nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "collab: %v\n", err)
+		os.Exit(2)
+	}
+	logger, _ := newLogger(cfg.LogLevel)
+
+	switch {
+	case isAdminCommand(command):
+		os.Exit(runAdmin(cfg, os.Args[1:]))
+	case command == "sync":
+		os.Exit(runSync(cfg, args))
+	case command == "loadtest":
+		os.Exit(runLoadtest(cfg, args))
+	case command == "migrate":
+		direction := "up"
+		if len(args) > 0 {
+			direction = args[0]
+		}
+		if err := db.RunMigrations(cfg.DatabaseURL, direction); err != nil {
+			logger.Error("migration failed", "error", err)
+			os.Exit(1)
+		}
+		logger.Info("migration complete", "direction", direction)
 	default:
-		logLevel.Set(slog.LevelInfo)
+		logger.Error("unknown command", "command", command)
+		os.Exit(1)
 	}
+}
+
+// newLogger returns the default logger and the level it filters at, which
+// can be changed while it is in use
+func newLogger(level string) (*slog.Logger, *slog.LevelVar) {
+	logLevel := new(slog.LevelVar)
+	logLevel.Set(parseLevel(level))
 
 	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
 		Level: logLevel,
 	}))
 	slog.SetDefault(logger)
+	return logger, logLevel
+}
 
-	if len(os.Args) > 1 {
-		if os.Args[1] == "migrate" {
-			direction := "up"
-			if len(os.Args) > 2 {
-				direction = os.Args[2]
-			}
-			if err := db.RunMigrations(cfg.DatabaseURL, direction); err != nil {
-				logger.Error("migration failed", "error", err)
-				os.Exit(1)
-			}
-			logger.Info("migration complete", "direction", direction)
-			return
-		}
-		if os.Args[1] != "serve" {
-			logger.Error("unknown command", "command", os.Args[1])
-			os.Exit(1)
+func parseLevel(level string) slog.Level {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// runServe runs the server until SIGINT or SIGTERM and returns the process
+// exit code. It refuses to start on a configuration that fails validation.
+func runServe(args []string) int {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	flags := config.NewFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
 		}
+		return 2
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "collab: unexpected argument %q\n", fs.Arg(0))
+		return 2
+	}
+
+	cfg, err := config.Load(flags)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "collab: %v\n", err)
+		return 2
+	}
+	logger, logLevel := newLogger(cfg.LogLevel)
+	if err := cfg.Validate(); err != nil {
+		logger.Error("refusing to start with invalid configuration",
+			"environment", cfg.Environment,
+			"problems", strings.Split(err.Error(), "\n"))
+		return 1
+	}
+	if cfg.File != "" {
+		logger.Info("loaded config file", "path", cfg.File)
 	}
 
+	reloader := config.NewReloader(cfg, func() (*config.Config, error) {
+		return config.Load(flags)
+	})
+	reloader.Subscribe(func(cfg *config.Config) {
+		logLevel.Set(parseLevel(cfg.LogLevel))
+	})
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	shutdownTracing, err := tracing.Setup(ctx, cfg.TracesExporter)
+	if err != nil {
+		logger.Error("tracing setup failed", "error", err)
+		return 1
+	}
+
 	dbPool, err := db.NewPool(ctx, cfg.DatabaseURL)
 	if err != nil {
 		logger.Error("database connection failed", "error", err)
-		os.Exit(1)
+		return 1
 	}
 	defer dbPool.Close()
 
-	router, err := httpapi.NewRouter(ctx, cfg, logger, dbPool)
+	router, err := httpapi.NewRouter(ctx, reloader, logger, dbPool)
 	if err != nil {
 		logger.Error("router initialization failed", "error", err)
-		os.Exit(1)
+		return 1
 	}
 
 	server := &http.Server{
@@ -78,6 +160,18 @@ func main() {
 		IdleTimeout:  60 * time.Second,
 	}
 
+	var metricsServer *http.Server
+	if cfg.MetricsAddr != "" {
+		metricsServer = newMetricsServer(cfg, logger)
+		go func() {
+			logger.Info("starting metrics server", "addr", cfg.MetricsAddr)
+			if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				logger.Error("metrics server error", "error", err)
+				os.Exit(1)
+			}
+		}()
+	}
+
 	go func() {
 		logger.Info("starting collab server", "port", cfg.Port)
 		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
@@ -86,6 +180,21 @@ func main() {
 		}
 	}()
 
+	// SIGHUP reloads what can change at runtime; connections stay open
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	go func() {
+		for range hup {
+			result, err := reloader.Reload()
+			if err != nil {
+				logger.Error("config reload rejected", "problems", strings.Split(err.Error(), "\n"))
+				continue
+			}
+			logger.Info("config reloaded", "applied", result.Applied, "restartRequired", result.RestartRequired)
+		}
+	}()
+
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
@@ -98,6 +207,31 @@ func main() {
 	if err := server.Shutdown(shutdownCtx); err != nil {
 		logger.Error("server shutdown error", "error", err)
 	}
+	if metricsServer != nil {
+		metricsServer.Shutdown(shutdownCtx)
+	}
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		logger.Error("trace flush error", "error", err)
+	}
 
 	logger.Info("server stopped")
+	return 0
+}
+
+// newMetricsServer serves /metrics on its own address, typically one only
+// reachable from inside the cluster. METRICS_TOKEN is still required when set.
+func newMetricsServer(cfg *config.Config, logger *slog.Logger) *http.Server {
+	handler := metrics.Handler()
+	if cfg.MetricsToken != "" {
+		handler = httpapi.MetricsMiddleware(cfg.MetricsToken, logger)(handler)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", handler)
+
+	return &http.Server{
+		Addr:         cfg.MetricsAddr,
+		Handler:      mux,
+		ReadTimeout:  15 * time.Second,
+		WriteTimeout: 15 * time.Second,
+	}
 }
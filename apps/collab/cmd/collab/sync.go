@@ -0,0 +1,372 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/NoumanAMalik/maple/apps/collab/internal/config"
+	"github.com/NoumanAMalik/maple/apps/collab/pkg/collabclient"
+)
+
+const syncUsage = "sync FILE ROOM_URL [--server URL] [--name NAME] [--push | --pull] [--debounce 300ms] [--poll 250ms]"
+
+// remoteActor stands in for everyone else in the room when merging
+const remoteActor = "~remote"
+
+// flushTimeout is how long to wait on exit for the room to acknowledge the
+// file's last edits
+const flushTimeout = 5 * time.Second
+
+// errEditPending means the last edit sent to the room is not acknowledged
+// yet, so merging has to wait
+var errEditPending = errors.New("edit not yet acknowledged")
+
+// fileSync keeps a local file and a room in step. base is the content both
+// sides last agreed on; edits on either side since then are merged three
+// ways, so typing in the file and in the room at once loses neither.
+type fileSync struct {
+	path     string
+	client   *collabclient.Client
+	debounce time.Duration
+	logger   *slog.Logger
+
+	mu   sync.Mutex
+	base string
+	// fallback is what base reverts to if the room drops the last edit sent:
+	// the file's content without that edit, as the room acknowledged it
+	fallback string
+	modTime  time.Time
+	size     int64
+	timer    *time.Timer
+}
+
+// runSync joins a room as a participant and mirrors it into a file until
+// interrupted. It returns the process exit code.
+func runSync(cfg *config.Config, args []string) int {
+	fs := flag.NewFlagSet("sync", flag.ContinueOnError)
+	server := fs.String("server", "", "API base URL, needed when ROOM_URL is a share link (defaults to localhost)")
+	name := fs.String("name", defaultSyncName(), "display name shown to others")
+	push := fs.Bool("push", false, "replace the room's content with the file on start")
+	pull := fs.Bool("pull", false, "replace the file with the room's content on start")
+	debounce := fs.Duration("debounce", 300*time.Millisecond, "quiet period before writing remote edits to the file")
+	poll := fs.Duration("poll", 250*time.Millisecond, "how often to check the file for changes")
+	rest, err := parseFlags(fs, args)
+	if err != nil || len(rest) != 2 || (*push && *pull) || *poll <= 0 {
+		fmt.Fprintf(os.Stderr, "usage: collab %s\n", syncUsage)
+		return 2
+	}
+
+	if *server == "" {
+		*server = "http://localhost:" + cfg.Port
+	}
+	baseURL, roomID, err := parseRoomURL(rest[1], *server)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "collab: %v\n", err)
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	if err := syncFile(ctx, rest[0], baseURL, roomID, *name, *push, *pull, *debounce, *poll, logger); err != nil {
+		fmt.Fprintf(os.Stderr, "collab: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func syncFile(ctx context.Context, path, baseURL, roomID, name string, push, pull bool, debounce, poll time.Duration, logger *slog.Logger) error {
+	s := &fileSync{path: path, debounce: debounce, logger: logger}
+
+	client, err := collabclient.Dial(ctx, baseURL, roomID, collabclient.Options{
+		DisplayName:   name,
+		MaxReconnects: 10,
+		OnRemoteOp: func([]collabclient.Operation, collabclient.ActorInfo, int) {
+			s.scheduleWrite()
+		},
+		OnResync: func(_ string, version int, dropped []collabclient.Operation) {
+			logger.Warn("resynced with room", "version", version, "droppedOps", len(dropped))
+			s.resynced(len(dropped) > 0)
+		},
+		OnUserJoined: func(actor collabclient.ActorInfo) {
+			logger.Info("user joined", "name", actor.DisplayName)
+		},
+		OnUserLeft: func(clientID string) {
+			logger.Info("user left", "clientId", clientID)
+		},
+		OnError: func(msg collabclient.ErrorMessage) {
+			logger.Warn("server error", "code", msg.Code, "message", msg.Message)
+		},
+		OnDisconnect: func(err error) {
+			logger.Warn("disconnected, reconnecting", "error", err)
+		},
+		OnReconnect: func(version int) {
+			logger.Info("reconnected", "version", version)
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("joining room %s: %w", roomID, err)
+	}
+	defer client.Close()
+	s.client = client
+
+	if err := s.start(push, pull); err != nil {
+		return err
+	}
+	logger.Info("syncing", "file", path, "room", roomID, "version", client.Version())
+
+	ticker := time.NewTicker(poll)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			s.stopTimer()
+			return s.flush()
+		case <-client.Done():
+			s.stopTimer()
+			return client.Err()
+		case <-ticker.C:
+			if s.fileChanged() {
+				// A pending edit leaves the file marked changed for the next poll
+				if err := s.reconcile(); err != nil && !errors.Is(err, errEditPending) {
+					logger.Error("sync failed", "error", err)
+				}
+			}
+		}
+	}
+}
+
+// start settles the initial state. A missing file takes the room's content;
+// an existing one that differs needs --push or --pull to pick a side.
+func (s *fileSync) start(push, pull bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	room := s.client.Content()
+	s.fallback = room
+	data, err := os.ReadFile(s.path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		s.base = room
+		return s.writeLocked(room)
+	case err != nil:
+		return err
+	case string(data) == room:
+		s.base = room
+		return s.statLocked()
+	case push:
+		if err := s.client.Replace(string(data)); err != nil {
+			return err
+		}
+		s.base = string(data)
+		return s.statLocked()
+	case pull:
+		s.base = room
+		return s.writeLocked(room)
+	}
+	return fmt.Errorf("%s differs from the room; pass --push to upload it or --pull to overwrite it", s.path)
+}
+
+// reconcile merges file edits and room edits made since base, then writes the
+// result back to the file if it differs. It returns errEditPending while the
+// previous edit is unacknowledged, so at most one is ever in flight and
+// fallback stays accurate.
+func (s *fileSync) reconcile() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.client.Pending() {
+		return errEditPending
+	}
+
+	read, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+	local := string(data)
+
+	// Nothing is pending, so the room's content is all acknowledged
+	acked := s.client.Content()
+	if local != s.base {
+		base := s.base
+		clientID := s.client.ClientID()
+		err := s.client.Edit(func(current string) []collabclient.Operation {
+			acked = current
+			ops := collabclient.Diff(base, local)
+			remote := collabclient.Diff(base, current)
+			return collabclient.Transform(ops, remote, clientID, remoteActor)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	merged := s.client.Content()
+	if merged == local {
+		s.base = merged
+		s.fallback = acked
+		return s.statLocked()
+	}
+	if info, err := os.Stat(s.path); err == nil && (!info.ModTime().Equal(read.ModTime()) || info.Size() != read.Size()) {
+		// Saved again while we merged. The room already has what we read,
+		// so merge the newer save against that on the next poll. The file
+		// never got the room's edits, so without ours it is the old base.
+		s.fallback = s.base
+		s.base = local
+		return nil
+	}
+	s.base = merged
+	s.fallback = acked
+	return s.writeLocked(merged)
+}
+
+// resynced handles the client rebuilding its state from a fresh snapshot.
+// Edits it dropped are still in the file, so base goes back to before them
+// and the next merge sends them again. The room may have applied them after
+// all, but the client only drops a batch too old for the server to check,
+// and repeating an edit beats overwriting the file without it.
+func (s *fileSync) resynced(dropped bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if dropped {
+		s.base = s.fallback
+	}
+	s.scheduleLocked()
+}
+
+// flush merges the file's last edits into the room and waits for them to be
+// acknowledged before the client is closed
+func (s *fileSync) flush() error {
+	deadline := time.Now().Add(flushTimeout)
+	for {
+		err := s.reconcile()
+		if err == nil && !s.client.Pending() {
+			return nil
+		}
+		if err != nil && !errors.Is(err, errEditPending) {
+			return err
+		}
+		if time.Now().After(deadline) {
+			return errors.New("room did not acknowledge the last edits")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// scheduleWrite reconciles once remote edits have been quiet for the
+// debounce period
+func (s *fileSync) scheduleWrite() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scheduleLocked()
+}
+
+func (s *fileSync) scheduleLocked() {
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	s.timer = time.AfterFunc(s.debounce, func() {
+		err := s.reconcile()
+		if errors.Is(err, errEditPending) {
+			s.scheduleWrite()
+			return
+		}
+		if err != nil {
+			s.logger.Error("sync failed", "error", err)
+		}
+	})
+}
+
+func (s *fileSync) stopTimer() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+}
+
+// fileChanged reports whether the file was modified since it was last read
+// or written
+func (s *fileSync) fileChanged() bool {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return !info.ModTime().Equal(s.modTime) || info.Size() != s.size
+}
+
+func (s *fileSync) writeLocked(content string) error {
+	mode := os.FileMode(0o644)
+	if info, err := os.Stat(s.path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	if err := os.WriteFile(s.path, []byte(content), mode); err != nil {
+		return err
+	}
+	return s.statLocked()
+}
+
+func (s *fileSync) statLocked() error {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+	s.modTime = info.ModTime()
+	s.size = info.Size()
+	return nil
+}
+
+// parseRoomURL accepts a share link (/share/ID), an API room URL
+// (/v1/rooms/ID, optionally ending in /ws, over http or ws) or a bare room
+// ID. Share links and bare IDs are served by server.
+func parseRoomURL(raw, server string) (string, string, error) {
+	if !strings.Contains(raw, "/") {
+		return server, raw, nil
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return "", "", fmt.Errorf("invalid room URL %q", raw)
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+
+	switch {
+	case len(parts) >= 3 && parts[0] == "v1" && parts[1] == "rooms":
+		scheme := u.Scheme
+		switch scheme {
+		case "ws":
+			scheme = "http"
+		case "wss":
+			scheme = "https"
+		}
+		return scheme + "://" + u.Host, parts[2], nil
+	case len(parts) == 2 && parts[0] == "share":
+		return server, parts[1], nil
+	}
+	return "", "", fmt.Errorf("%q is not a room URL", raw)
+}
+
+func defaultSyncName() string {
+	if user := os.Getenv("USER"); user != "" {
+		return user + " (terminal)"
+	}
+	return "Terminal"
+}
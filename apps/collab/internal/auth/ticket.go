@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+// TicketTTL is how long a ticket can be redeemed after it was issued
+const TicketTTL = 30 * time.Second
+
+// TicketStore issues short-lived, single-use tickets standing in for an
+// access token on requests that cannot carry headers, such as browser
+// WebSocket and EventSource connections. A ticket in a URL is worthless once
+// the connection it opened has started, unlike the access token itself.
+type TicketStore struct {
+	mu      sync.Mutex
+	tickets map[string]ticket
+}
+
+type ticket struct {
+	userID    string
+	expiresAt time.Time
+}
+
+func NewTicketStore() *TicketStore {
+	return &TicketStore{tickets: make(map[string]ticket)}
+}
+
+// Issue returns a new ticket for userID and when it expires
+func (s *TicketStore) Issue(userID string) (string, time.Time, error) {
+	value, err := NewRefreshToken()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(TicketTTL)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Tickets are few and short-lived, so expired ones are swept here rather
+	// than by a background loop
+	for key, t := range s.tickets {
+		if now.After(t.expiresAt) {
+			delete(s.tickets, key)
+		}
+	}
+	s.tickets[value] = ticket{userID: userID, expiresAt: expiresAt}
+	return value, expiresAt, nil
+}
+
+// Redeem returns the user a ticket was issued to and invalidates it. It
+// reports false for unknown, used or expired tickets.
+func (s *TicketStore) Redeem(value string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.tickets[value]
+	if !ok {
+		return "", false
+	}
+	delete(s.tickets, value)
+	if time.Now().After(t.expiresAt) {
+		return "", false
+	}
+	return t.userID, true
+}
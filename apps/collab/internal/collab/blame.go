@@ -0,0 +1,326 @@
+package collab
+
+import (
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// Attribution identifies the change that last touched a range of text
+type Attribution struct {
+	UserID    string    `json:"userId,omitempty"`
+	ClientID  string    `json:"clientId,omitempty"`
+	Version   int       `json:"version"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// BlameLine is the attribution of a single line of the document
+type BlameLine struct {
+	Line    int    `json:"line"`
+	Content string `json:"content"`
+	Attribution
+}
+
+type blameSpan struct {
+	length int // UTF-16 code units, matching Operation positions
+	attr   Attribution
+}
+
+// BlameSpan is the attribution of a run of Length UTF-16 code units, as
+// stored with compaction snapshots
+type BlameSpan struct {
+	Length int `json:"length"`
+	Attribution
+}
+
+// BlameTracker follows a document through op batches and records the
+// author of every character range
+type BlameTracker struct {
+	spans []blameSpan
+}
+
+func NewBlameTracker(content string, attr Attribution) *BlameTracker {
+	t := &BlameTracker{}
+	t.Reset(content, attr)
+	return t
+}
+
+// RestoreBlameTracker resumes tracking content from spans saved by Spans. It
+// reports false if the spans do not cover content exactly.
+func RestoreBlameTracker(content string, spans []BlameSpan) (*BlameTracker, bool) {
+	t := &BlameTracker{spans: make([]blameSpan, 0, len(spans))}
+	total := 0
+	for _, span := range spans {
+		if span.Length <= 0 {
+			return nil, false
+		}
+		t.spans = append(t.spans, blameSpan{length: span.Length, attr: span.Attribution})
+		total += span.Length
+	}
+	if total != utf16Length(content) {
+		return nil, false
+	}
+	t.merge()
+	return t, true
+}
+
+// Spans returns the attribution of the tracked content, run by run
+func (t *BlameTracker) Spans() []BlameSpan {
+	spans := make([]BlameSpan, len(t.spans))
+	for i, span := range t.spans {
+		spans[i] = BlameSpan{Length: span.length, Attribution: span.attr}
+	}
+	return spans
+}
+
+// Reset attributes the whole of content to attr
+func (t *BlameTracker) Reset(content string, attr Attribution) {
+	t.spans = t.spans[:0]
+	if length := utf16Length(content); length > 0 {
+		t.spans = append(t.spans, blameSpan{length: length, attr: attr})
+	}
+}
+
+// Apply records ops, applied in order, as authored by attr
+func (t *BlameTracker) Apply(ops []Operation, attr Attribution) {
+	for _, op := range ops {
+		switch op.Type {
+		case OpInsert:
+			t.insert(op.Pos, utf16Length(op.Text), attr)
+		case OpDelete:
+			t.delete(op.Pos, op.Len)
+		}
+	}
+}
+
+func (t *BlameTracker) length() int {
+	total := 0
+	for _, span := range t.spans {
+		total += span.length
+	}
+	return total
+}
+
+// split ensures a span boundary at pos and returns the index of the span
+// starting there
+func (t *BlameTracker) split(pos int) int {
+	offset := 0
+	for i, span := range t.spans {
+		if pos == offset {
+			return i
+		}
+		if pos < offset+span.length {
+			head := blameSpan{length: pos - offset, attr: span.attr}
+			tail := blameSpan{length: span.length - head.length, attr: span.attr}
+			t.spans = append(t.spans[:i+1], t.spans[i:]...)
+			t.spans[i] = head
+			t.spans[i+1] = tail
+			return i + 1
+		}
+		offset += span.length
+	}
+	return len(t.spans)
+}
+
+func (t *BlameTracker) insert(pos, length int, attr Attribution) {
+	if length <= 0 {
+		return
+	}
+	pos = clamp(pos, 0, t.length())
+
+	i := t.split(pos)
+	t.spans = append(t.spans[:i], append([]blameSpan{{length: length, attr: attr}}, t.spans[i:]...)...)
+	t.merge()
+}
+
+func (t *BlameTracker) delete(pos, length int) {
+	if length <= 0 {
+		return
+	}
+	total := t.length()
+	pos = clamp(pos, 0, total)
+	end := clamp(pos+length, pos, total)
+
+	start := t.split(pos)
+	stop := t.split(end)
+	t.spans = append(t.spans[:start], t.spans[stop:]...)
+	t.merge()
+}
+
+func (t *BlameTracker) merge() {
+	if len(t.spans) < 2 {
+		return
+	}
+	merged := t.spans[:1]
+	for _, span := range t.spans[1:] {
+		last := &merged[len(merged)-1]
+		if last.attr == span.attr {
+			last.length += span.length
+			continue
+		}
+		merged = append(merged, span)
+	}
+	t.spans = merged
+}
+
+// Lines returns per-line attribution for content, which must be the text
+// the tracker has been following. Each line is attributed to the most
+// recent change among its characters; an empty line is attributed to the
+// newline that opened it.
+func (t *BlameTracker) Lines(content string) []BlameLine {
+	lines := splitLines(content)
+	result := make([]BlameLine, 0, len(lines))
+	cursor := spanCursor{spans: t.spans}
+	offset := 0
+	for i, line := range lines {
+		lineLen := utf16Length(line)
+
+		var attr Attribution
+		switch {
+		case lineLen > 0:
+			attr = cursor.latest(offset, offset+lineLen)
+		case offset > 0:
+			attr = cursor.at(offset - 1)
+		default:
+			attr = cursor.at(offset)
+		}
+
+		result = append(result, BlameLine{
+			Line:        i + 1,
+			Content:     line,
+			Attribution: attr,
+		})
+		offset += lineLen + 1
+	}
+
+	return result
+}
+
+// spanCursor walks the spans forward, so attributing every line costs one
+// pass over them. Positions past the last span have no attribution.
+type spanCursor struct {
+	spans []blameSpan
+	i     int
+	start int // offset of spans[i]
+}
+
+// at returns the attribution of the unit at pos, which must not be before
+// the previous position asked for
+func (c *spanCursor) at(pos int) Attribution {
+	for c.i < len(c.spans) && c.start+c.spans[c.i].length <= pos {
+		c.start += c.spans[c.i].length
+		c.i++
+	}
+	if c.i == len(c.spans) {
+		return Attribution{}
+	}
+	return c.spans[c.i].attr
+}
+
+// latest returns the most recent attribution among the units in
+// [pos, end), preferring the earliest on a tie
+func (c *spanCursor) latest(pos, end int) Attribution {
+	attr := c.at(pos)
+	start := c.start
+	for j := c.i; j < len(c.spans) && start < end; j++ {
+		if c.spans[j].attr.Version > attr.Version {
+			attr = c.spans[j].attr
+		}
+		start += c.spans[j].length
+	}
+	return attr
+}
+
+// DiffToOps converts the change from oldContent to newContent into an op
+// batch. The common prefix and suffix are trimmed first so small edits stay
+// character-precise; the remainder is diffed line by line.
+func DiffToOps(oldContent, newContent string) []Operation {
+	if oldContent == newContent {
+		return nil
+	}
+
+	oldUnits := utf16.Encode([]rune(oldContent))
+	newUnits := utf16.Encode([]rune(newContent))
+
+	prefix := 0
+	for prefix < len(oldUnits) && prefix < len(newUnits) && oldUnits[prefix] == newUnits[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(oldUnits)-prefix && suffix < len(newUnits)-prefix &&
+		oldUnits[len(oldUnits)-1-suffix] == newUnits[len(newUnits)-1-suffix] {
+		suffix++
+	}
+	// Never split a surrogate pair between the trimmed and diffed regions.
+	if prefix > 0 && isHighSurrogate(oldUnits[prefix-1]) {
+		prefix--
+	}
+	if suffix > 0 && isLowSurrogate(oldUnits[len(oldUnits)-suffix]) {
+		suffix--
+	}
+
+	oldMiddle := string(utf16.Decode(oldUnits[prefix : len(oldUnits)-suffix]))
+	newMiddle := string(utf16.Decode(newUnits[prefix : len(newUnits)-suffix]))
+
+	oldLines := splitLinesAfter(oldMiddle)
+	newLines := splitLinesAfter(newMiddle)
+	lcs := computeLCS(oldLines, newLines)
+
+	var ops []Operation
+	pos := prefix
+	oldIdx, newIdx := 0, 0
+	for _, common := range append(lcs, "") {
+		var removed, added strings.Builder
+		for oldIdx < len(oldLines) && (common == "" || oldLines[oldIdx] != common) {
+			removed.WriteString(oldLines[oldIdx])
+			oldIdx++
+		}
+		for newIdx < len(newLines) && (common == "" || newLines[newIdx] != common) {
+			added.WriteString(newLines[newIdx])
+			newIdx++
+		}
+
+		if removedLen := utf16Length(removed.String()); removedLen > 0 {
+			ops = append(ops, Operation{Type: OpDelete, Pos: pos, Len: removedLen})
+		}
+		if added.Len() > 0 {
+			ops = append(ops, Operation{Type: OpInsert, Pos: pos, Text: added.String()})
+			pos += utf16Length(added.String())
+		}
+
+		if common != "" {
+			pos += utf16Length(common)
+			oldIdx++
+			newIdx++
+		}
+	}
+
+	return ops
+}
+
+// splitLinesAfter splits content into lines that keep their trailing newline
+func splitLinesAfter(content string) []string {
+	lines := strings.SplitAfter(content, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+func isHighSurrogate(unit uint16) bool {
+	return unit >= 0xd800 && unit < 0xdc00
+}
+
+func isLowSurrogate(unit uint16) bool {
+	return unit >= 0xdc00 && unit < 0xe000
+}
+
+func clamp(value, low, high int) int {
+	if value < low {
+		return low
+	}
+	if value > high {
+		return high
+	}
+	return value
+}
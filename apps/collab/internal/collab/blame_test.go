@@ -0,0 +1,43 @@
+package collab
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRestoredBlameTrackerContinuesWhereSpansLeftOff(t *testing.T) {
+	at := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	content := "one\ntwo\n"
+	tracker := NewBlameTracker(content, Attribution{UserID: "owner", Version: 0, Timestamp: at})
+	edit := []Operation{{Type: OpInsert, Pos: 4, Text: "2"}}
+	content = mustApply(t, content, edit)
+	tracker.Apply(edit, Attribution{UserID: "alice", Version: 1, Timestamp: at})
+
+	data, err := json.Marshal(tracker.Spans())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var spans []BlameSpan
+	if err := json.Unmarshal(data, &spans); err != nil {
+		t.Fatal(err)
+	}
+	restored, ok := RestoreBlameTracker(content, spans)
+	if !ok {
+		t.Fatalf("spans %s did not restore over %q", data, content)
+	}
+
+	next := []Operation{{Type: OpInsert, Pos: len(content), Text: "three"}}
+	content = mustApply(t, content, next)
+	for _, tr := range []*BlameTracker{tracker, restored} {
+		tr.Apply(next, Attribution{UserID: "bob", Version: 2, Timestamp: at})
+	}
+	if got, want := restored.Lines(content), tracker.Lines(content); !reflect.DeepEqual(got, want) {
+		t.Errorf("restored blame = %+v, want %+v", got, want)
+	}
+
+	if _, ok := RestoreBlameTracker(content+"x", spans); ok {
+		t.Error("spans restored over content of a different length")
+	}
+}
@@ -0,0 +1,59 @@
+package collab
+
+import "github.com/NoumanAMalik/maple/apps/collab/internal/events"
+
+// Event types published for document-backed rooms
+const (
+	EventOp       = "op"
+	EventJoin     = "join"
+	EventLeave    = "leave"
+	EventSnapshot = "snapshot"
+	EventRestore  = "restore"
+	EventRename   = "rename"
+)
+
+// OpEvent - an op batch applied to the document
+type OpEvent struct {
+	Version  int         `json:"version"`
+	OpID     string      `json:"opId"`
+	ClientID string      `json:"clientId,omitempty"`
+	UserID   string      `json:"userId,omitempty"`
+	Ops      []Operation `json:"ops"`
+}
+
+// ParticipantEvent - a client joined or left the document's room
+type ParticipantEvent struct {
+	Actor        ActorInfo `json:"actor"`
+	Participants int       `json:"participants"`
+}
+
+// SnapshotEvent - a snapshot was taken in the document's room
+type SnapshotEvent struct {
+	SnapshotID string       `json:"snapshotId"`
+	Version    int          `json:"version"`
+	Type       SnapshotType `json:"type"`
+	CreatedBy  string       `json:"createdBy"`
+}
+
+// RestoreEvent - the document was restored to a snapshot
+type RestoreEvent struct {
+	SnapshotID string `json:"snapshotId"`
+	Version    int    `json:"version"`
+}
+
+// RenameEvent - the document's title changed
+type RenameEvent struct {
+	Title string `json:"title"`
+}
+
+// publish sends an event for document-backed rooms to the event hub
+func (r *Room) publish(version int, eventType string, data any) {
+	if r.DocumentID == "" {
+		return
+	}
+	r.events.Publish(r.DocumentID, events.Event{
+		ID:   int64(version),
+		Type: eventType,
+		Data: data,
+	})
+}
@@ -49,6 +49,19 @@ type WelcomeMessage struct {
 	IsOwner       bool           `json:"isOwner"`
 }
 
+// PingMessage - Client keepalive for connections that may sit idle; the
+// server answers with a PongMessage
+type PingMessage struct {
+	V int    `json:"v"`
+	T string `json:"t"`
+}
+
+// PongMessage - Server reply to a PingMessage
+type PongMessage struct {
+	V int    `json:"v"`
+	T string `json:"t"`
+}
+
 type UserJoinedMessage struct {
 	V     int       `json:"v"`
 	T     string    `json:"t"`
@@ -62,15 +75,17 @@ type UserLeftMessage struct {
 }
 
 type ErrorMessage struct {
-	V       int    `json:"v"`
-	T       string `json:"t"`
-	Code    string `json:"code"`
-	Message string `json:"message"`
+	V         int    `json:"v"`
+	T         string `json:"t"`
+	RequestID string `json:"requestId,omitempty"`
+	Code      string `json:"code"`
+	Message   string `json:"message"`
 }
 
 type PresenceMessage struct {
 	V           int        `json:"v"`
 	T           string     `json:"t"`
+	FileID      string     `json:"fileId,omitempty"` // project connections only
 	Cursor      Position   `json:"cursor"`
 	Selection   *Selection `json:"selection,omitempty"`
 	DisplayName string     `json:"displayName,omitempty"`
@@ -79,6 +94,7 @@ type PresenceMessage struct {
 type PresenceUpdateMessage struct {
 	V         int        `json:"v"`
 	T         string     `json:"t"`
+	FileID    string     `json:"fileId,omitempty"`
 	Actor     ActorInfo  `json:"actor"`
 	Cursor    Position   `json:"cursor"`
 	Selection *Selection `json:"selection,omitempty"`
@@ -121,6 +137,14 @@ type SnapshotsListMessage struct {
 	Snapshots []Snapshot `json:"snapshots"`
 }
 
+// RoomClosedMessage - Server notifies clients that an operator closed the
+// room; the connection is closed straight after and the room is gone
+type RoomClosedMessage struct {
+	V      int    `json:"v"`
+	T      string `json:"t"`
+	Reason string `json:"reason"`
+}
+
 // SnapshotRestoredMessage - Server notifies clients of a restore
 type SnapshotRestoredMessage struct {
 	V          int    `json:"v"`
@@ -151,13 +175,32 @@ type DiffResultMessage struct {
 	Result         DiffResult `json:"result"`
 }
 
+// GetBlameMessage - Client requests per-line attribution of the current content
+type GetBlameMessage struct {
+	V         int    `json:"v"`
+	T         string `json:"t"`
+	RequestID string `json:"requestId"`
+}
+
+// BlameResultMessage - Server sends blame result to client
+type BlameResultMessage struct {
+	V             int         `json:"v"`
+	T             string      `json:"t"`
+	RequestID     string      `json:"requestId"`
+	ServerVersion int         `json:"serverVersion"`
+	Lines         []BlameLine `json:"lines"`
+}
+
 var clientColors = []string{
 	"#e91e63", "#9c27b0", "#673ab7", "#3f51b5",
 	"#2196f3", "#00bcd4", "#009688", "#4caf50",
 	"#ff9800", "#ff5722", "#795548", "#607d8b",
 }
 
-func (h *WSHandler) HandleConnection(ctx context.Context, conn *websocket.Conn, roomID string) {
+// HandleConnection runs the room protocol for conn. userID is the signed-in
+// user making the connection, or empty for anonymous clients; their edits
+// are attributed to it.
+func (h *WSHandler) HandleConnection(ctx context.Context, conn *websocket.Conn, roomID, userID string) {
 	room, ok := h.registry.GetRoom(roomID)
 	if !ok {
 		h.sendError(ctx, conn, "room_not_found", "Room does not exist")
@@ -178,13 +221,21 @@ func (h *WSHandler) HandleConnection(ctx context.Context, conn *websocket.Conn,
 		return
 	}
 
+	if max := h.registry.Limits().MaxRoomClients; max > 0 && room.ClientCount() >= max {
+		h.sendError(ctx, conn, "room_full", "Room has reached its participant limit")
+		conn.Close(websocket.StatusTryAgainLater, "room full")
+		return
+	}
+
 	clientCtx, cancel := context.WithCancel(ctx)
 	client := &Client{
 		ID:          hello.ClientID,
+		UserID:      userID,
 		DisplayName: "",
 		Color:       clientColors[room.ClientCount()%len(clientColors)],
 		Conn:        conn,
 		Room:        room,
+		JoinedAt:    time.Now(),
 		send:        make(chan []byte, 256),
 		ctx:         clientCtx,
 		cancel:      cancel,
@@ -203,15 +254,18 @@ func (h *WSHandler) HandleConnection(ctx context.Context, conn *websocket.Conn,
 		room.Broadcast(leftMsg, client.ID)
 	}()
 
+	// Read content and version together so an op landing in between is not
+	// applied twice by the joining client
+	snapshot, version := room.State()
 	welcome := WelcomeMessage{
 		V:             1,
 		T:             "welcome",
 		DocID:         room.ID,
-		ServerVersion: room.GetVersion(),
-		Snapshot:      room.GetSnapshot(),
+		ServerVersion: version,
+		Snapshot:      snapshot,
 		Presence:      room.GetPresenceList(client.ID),
 		Snapshots:     room.GetSnapshots(),
-		IsOwner:       room.OwnerID == client.ID,
+		IsOwner:       room.isOwnedBy(client),
 	}
 	if err := client.Send(welcome); err != nil {
 		h.logger.Error("failed to send welcome", "error", err)
@@ -283,24 +337,40 @@ func (h *WSHandler) readLoop(client *Client) {
 
 		switch base.T {
 		case "op":
-			h.handleOp(client, data)
+			ctx, span := startMessageSpan(client, base.T)
+			h.handleOp(ctx, client, data)
+			span.End()
 		case "presence":
 			h.handlePresence(client, data)
 		case "save":
-			h.handleSave(client, data)
+			ctx, span := startMessageSpan(client, base.T)
+			h.handleSave(ctx, client, data)
+			span.End()
 		case "restore":
-			h.handleRestore(client, data)
+			ctx, span := startMessageSpan(client, base.T)
+			h.handleRestore(ctx, client, data)
+			span.End()
 		case "get_snapshots":
 			h.handleGetSnapshots(client)
 		case "get_diff":
-			h.handleGetDiff(client, data)
+			ctx, span := startMessageSpan(client, base.T)
+			h.handleGetDiff(ctx, client, data)
+			span.End()
+		case "get_blame":
+			h.handleGetBlame(client, data)
+		case "ping":
+			client.Send(PongMessage{V: 1, T: "pong"})
 		default:
 			h.logger.Warn("unknown message type", "type", base.T)
 		}
 	}
 }
 
-func (h *WSHandler) handleOp(client *Client, data []byte) {
+// storeUnavailableMessage is sent with edits refused by a document room
+// while its database is down
+const storeUnavailableMessage = "The database is unavailable; edits are paused until it is back"
+
+func (h *WSHandler) handleOp(ctx context.Context, client *Client, data []byte) {
 	var msg OpMessage
 	if err := json.Unmarshal(data, &msg); err != nil {
 		h.logger.Warn("invalid op message", "clientId", client.ID, "error", err)
@@ -312,12 +382,36 @@ func (h *WSHandler) handleOp(client *Client, data []byte) {
 		return
 	}
 
-	transformed, newVersion, err := client.Room.ApplyOpBatch(client.ID, msg.OpID, msg.BaseVersion, msg.Ops)
+	transformed, newVersion, err := client.Room.Apply(ctx, OpBatch{
+		ClientID:    client.ID,
+		UserID:      client.UserID,
+		OpID:        msg.OpID,
+		BaseVersion: msg.BaseVersion,
+		Ops:         msg.Ops,
+		Presence:    msg.Presence,
+	})
+	if errors.Is(err, ErrDuplicateBatch) {
+		// A retry of a batch already applied, e.g. after a reconnect
+		client.Send(AckMessage{V: 1, T: "ack", OpID: msg.OpID, NewVersion: newVersion})
+		return
+	}
 	if err != nil {
 		if errors.Is(err, ErrResyncRequired) {
 			client.Send(ResyncRequiredMessage{V: 1, T: "resync_required"})
 			return
 		}
+		if errors.Is(err, ErrPersistFailed) {
+			// The room is being closed; the op is never acked
+			client.Send(ErrorMessage{V: 1, T: "error", Code: "persist_failed", Message: PersistFailedReason})
+			return
+		}
+		if errors.Is(err, ErrStoreUnavailable) {
+			// Nothing was applied; the client rebases the batch onto a fresh
+			// snapshot and sends it again
+			client.Send(ErrorMessage{V: 1, T: "error", Code: "database_unavailable", Message: storeUnavailableMessage})
+			client.Send(ResyncRequiredMessage{V: 1, T: "resync_required"})
+			return
+		}
 		h.logger.Warn("failed to apply ops", "clientId", client.ID, "error", err)
 		return
 	}
@@ -337,23 +431,11 @@ func (h *WSHandler) handleOp(client *Client, data []byte) {
 		NewVersion: newVersion,
 	})
 
-	if len(transformed) == 0 {
-		return
-	}
-
-	remote := RemoteOpMessage{
-		V:       1,
-		T:       "remote_op",
-		Version: newVersion,
-		Actor: ActorInfo{
-			ClientID:    client.ID,
-			DisplayName: client.DisplayName,
-			Color:       client.Color,
-		},
-		Ops: transformed,
-	}
-	remoteData, _ := json.Marshal(remote)
-	client.Room.Broadcast(remoteData, client.ID)
+	client.Room.BroadcastRemoteOp(ActorInfo{
+		ClientID:    client.ID,
+		DisplayName: client.DisplayName,
+		Color:       client.Color,
+	}, newVersion, transformed, client.ID)
 }
 
 func (h *WSHandler) handlePresence(client *Client, data []byte) {
@@ -402,28 +484,19 @@ func (h *WSHandler) sendError(ctx context.Context, conn *websocket.Conn, code, m
 }
 
 // handleSave handles a save/snapshot request from a client
-func (h *WSHandler) handleSave(client *Client, data []byte) {
+func (h *WSHandler) handleSave(ctx context.Context, client *Client, data []byte) {
 	var msg SaveMessage
 	if err := json.Unmarshal(data, &msg); err != nil {
 		h.logger.Warn("invalid save message", "clientId", client.ID, "error", err)
 		return
 	}
 
-	// Create snapshot
-	snapshot := client.Room.CreateSnapshot(client.ID, SnapshotManual, msg.Message)
-
-	// Broadcast snapshot created to all clients
-	snapshotMsg := SnapshotCreatedMessage{
-		V:        1,
-		T:        "snapshot_created",
-		Snapshot: *snapshot,
-	}
-	snapshotData, _ := json.Marshal(snapshotMsg)
-	client.Room.Broadcast(snapshotData, "") // Send to all clients including sender
+	// Snapshot and announce to all clients, including the sender
+	client.Room.SaveSnapshot(ctx, client.ID, msg.Message)
 }
 
 // handleRestore handles a restore request from a client (admin only)
-func (h *WSHandler) handleRestore(client *Client, data []byte) {
+func (h *WSHandler) handleRestore(ctx context.Context, client *Client, data []byte) {
 	var msg RestoreMessage
 	if err := json.Unmarshal(data, &msg); err != nil {
 		h.logger.Warn("invalid restore message", "clientId", client.ID, "error", err)
@@ -431,7 +504,7 @@ func (h *WSHandler) handleRestore(client *Client, data []byte) {
 	}
 
 	// Only owner can restore
-	if client.Room.OwnerID != client.ID {
+	if !client.Room.isOwnedBy(client) {
 		client.Send(ErrorMessage{
 			V:       1,
 			T:       "error",
@@ -441,13 +514,20 @@ func (h *WSHandler) handleRestore(client *Client, data []byte) {
 		return
 	}
 
-	snapshot, err := client.Room.RestoreToSnapshot(msg.SnapshotID)
+	snapshot, err := client.Room.RestoreToSnapshot(ctx, msg.SnapshotID)
 	if err != nil {
+		message := err.Error()
+		switch {
+		case errors.Is(err, ErrPersistFailed):
+			message = PersistFailedReason
+		case errors.Is(err, ErrStoreUnavailable):
+			message = storeUnavailableMessage
+		}
 		client.Send(ErrorMessage{
 			V:       1,
 			T:       "error",
 			Code:    "restore_failed",
-			Message: err.Error(),
+			Message: message,
 		})
 		return
 	}
@@ -477,7 +557,7 @@ func (h *WSHandler) handleGetSnapshots(client *Client) {
 }
 
 // handleGetDiff computes and sends a diff between a snapshot and current content
-func (h *WSHandler) handleGetDiff(client *Client, data []byte) {
+func (h *WSHandler) handleGetDiff(ctx context.Context, client *Client, data []byte) {
 	var msg GetDiffMessage
 	if err := json.Unmarshal(data, &msg); err != nil {
 		h.logger.Warn("invalid get_diff message", "clientId", client.ID, "error", err)
@@ -495,7 +575,7 @@ func (h *WSHandler) handleGetDiff(client *Client, data []byte) {
 	}
 
 	// Compute diff
-	diff, err := client.Room.GetDiff(msg.BaseSnapshotID, msg.Target)
+	diff, err := client.Room.GetDiff(ctx, msg.BaseSnapshotID, msg.Target)
 	if err != nil {
 		client.Send(ErrorMessage{
 			V:       1,
@@ -519,3 +599,30 @@ func (h *WSHandler) handleGetDiff(client *Client, data []byte) {
 	}
 	client.Send(result)
 }
+
+// handleGetBlame sends per-line attribution of the room content to the requesting client
+func (h *WSHandler) handleGetBlame(client *Client, data []byte) {
+	var msg GetBlameMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		h.logger.Warn("invalid get_blame message", "clientId", client.ID, "error", err)
+		return
+	}
+
+	if msg.RequestID == "" {
+		client.Send(ErrorMessage{
+			V:       1,
+			T:       "error",
+			Code:    "invalid_request",
+			Message: "requestId is required",
+		})
+		return
+	}
+
+	client.Send(BlameResultMessage{
+		V:             1,
+		T:             "blame_result",
+		RequestID:     msg.RequestID,
+		ServerVersion: client.Room.GetVersion(),
+		Lines:         client.Room.GetBlame(),
+	})
+}
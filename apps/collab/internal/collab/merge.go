@@ -0,0 +1,187 @@
+package collab
+
+import (
+	"errors"
+	"strings"
+)
+
+const (
+	ResolveOurs   = "ours"
+	ResolveTheirs = "theirs"
+	ResolveBoth   = "both"
+)
+
+var ErrUnresolvedConflicts = errors.New("unresolved merge conflicts")
+
+// MergeConflict is a region both sides changed differently. Each side is
+// expressed as a DiffHunk against the base lines, like a snapshot diff.
+type MergeConflict struct {
+	Index     int      `json:"index"`
+	BaseStart int      `json:"baseStart"`
+	BaseCount int      `json:"baseCount"`
+	Ours      DiffHunk `json:"ours"`
+	Theirs    DiffHunk `json:"theirs"`
+}
+
+// MergeResult is the outcome of a three-way line merge. Content is only set
+// when the merge is clean; otherwise Conflicts lists the regions that need a
+// resolution.
+type MergeResult struct {
+	Clean     bool            `json:"clean"`
+	Content   string          `json:"content,omitempty"`
+	Conflicts []MergeConflict `json:"conflicts"`
+
+	chunks []mergeChunk
+}
+
+type mergeChunk struct {
+	lines    []string // resolved lines; nil for conflicts
+	conflict bool
+	ours     []string
+	theirs   []string
+}
+
+// ThreeWayMerge merges the changes ours and theirs each made to base, line
+// by line. Regions changed on only one side, or identically on both, merge
+// cleanly; anything else is reported as a conflict.
+func ThreeWayMerge(base, ours, theirs string) *MergeResult {
+	baseLines := splitLines(base)
+	oursLines := splitLines(ours)
+	theirsLines := splitLines(theirs)
+
+	toOurs := lcsMatches(baseLines, oursLines)
+	toTheirs := lcsMatches(baseLines, theirsLines)
+
+	result := &MergeResult{Clean: true, Conflicts: []MergeConflict{}}
+	i, j, k := 0, 0, 0
+	for i < len(baseLines) || j < len(oursLines) || k < len(theirsLines) {
+		// Find the next base line kept by both sides
+		next := i
+		for next < len(baseLines) && (toOurs[next] < 0 || toTheirs[next] < 0) {
+			next++
+		}
+		nextOurs, nextTheirs := len(oursLines), len(theirsLines)
+		if next < len(baseLines) {
+			nextOurs, nextTheirs = toOurs[next], toTheirs[next]
+		}
+
+		if next == i && nextOurs == j && nextTheirs == k {
+			result.chunks = append(result.chunks, mergeChunk{lines: []string{baseLines[i]}})
+			i, j, k = i+1, j+1, k+1
+			continue
+		}
+
+		baseChunk := baseLines[i:next]
+		oursChunk := oursLines[j:nextOurs]
+		theirsChunk := theirsLines[k:nextTheirs]
+
+		switch {
+		case equalLines(oursChunk, baseChunk):
+			result.chunks = append(result.chunks, mergeChunk{lines: theirsChunk})
+		case equalLines(theirsChunk, baseChunk), equalLines(oursChunk, theirsChunk):
+			result.chunks = append(result.chunks, mergeChunk{lines: oursChunk})
+		default:
+			result.Clean = false
+			result.Conflicts = append(result.Conflicts, MergeConflict{
+				Index:     len(result.Conflicts),
+				BaseStart: i + 1,
+				BaseCount: len(baseChunk),
+				Ours:      conflictHunk(baseChunk, oursChunk, i, j),
+				Theirs:    conflictHunk(baseChunk, theirsChunk, i, k),
+			})
+			result.chunks = append(result.chunks, mergeChunk{conflict: true, ours: oursChunk, theirs: theirsChunk})
+		}
+
+		i, j, k = next, nextOurs, nextTheirs
+	}
+
+	if result.Clean {
+		result.Content, _ = result.Resolve(nil)
+	}
+	return result
+}
+
+// Resolve builds the merged content, taking one resolution per conflict in
+// order: "ours", "theirs" or "both" (ours followed by theirs).
+func (m *MergeResult) Resolve(resolutions []string) (string, error) {
+	lines := make([]string, 0)
+	conflict := 0
+	for _, chunk := range m.chunks {
+		if !chunk.conflict {
+			lines = append(lines, chunk.lines...)
+			continue
+		}
+
+		if conflict >= len(resolutions) {
+			return "", ErrUnresolvedConflicts
+		}
+		switch resolutions[conflict] {
+		case ResolveOurs:
+			lines = append(lines, chunk.ours...)
+		case ResolveTheirs:
+			lines = append(lines, chunk.theirs...)
+		case ResolveBoth:
+			lines = append(lines, chunk.ours...)
+			lines = append(lines, chunk.theirs...)
+		default:
+			return "", ErrUnresolvedConflicts
+		}
+		conflict++
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// conflictHunk describes one side of a conflict as a hunk replacing the
+// base lines, with line numbers taken from base and that side
+func conflictHunk(baseChunk, sideChunk []string, baseStart, sideStart int) DiffHunk {
+	hunk := DiffHunk{
+		OldStart: baseStart + 1,
+		OldCount: len(baseChunk),
+		NewStart: sideStart + 1,
+		NewCount: len(sideChunk),
+		Lines:    make([]DiffLine, 0, len(baseChunk)+len(sideChunk)),
+	}
+	for n, line := range baseChunk {
+		hunk.Lines = append(hunk.Lines, DiffLine{Type: "remove", Content: line, OldLine: baseStart + n + 1})
+	}
+	for n, line := range sideChunk {
+		hunk.Lines = append(hunk.Lines, DiffLine{Type: "add", Content: line, NewLine: sideStart + n + 1})
+	}
+	return hunk
+}
+
+// lcsMatches maps each line of a to the index of the line of b it is paired
+// with in their longest common subsequence, or -1 when it has no partner
+func lcsMatches(a, b []string) []int {
+	matches := make([]int, len(a))
+	for i := range matches {
+		matches[i] = -1
+	}
+
+	lcs := computeLCS(a, b)
+	i, j := 0, 0
+	for _, line := range lcs {
+		for a[i] != line {
+			i++
+		}
+		for b[j] != line {
+			j++
+		}
+		matches[i] = j
+		i++
+		j++
+	}
+	return matches
+}
+
+func equalLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -1,7 +1,9 @@
 package collab
 
 import (
+	"context"
 	"errors"
+	"time"
 	"unicode/utf16"
 )
 
@@ -12,6 +14,27 @@ const (
 
 var ErrResyncRequired = errors.New("resync required")
 
+// ErrDuplicateBatch is returned, along with the original result, for a batch
+// whose opId the room has already applied for the same client. Retries must
+// be acked without being applied or broadcast again.
+var ErrDuplicateBatch = errors.New("duplicate op batch")
+
+// ErrRoomClosed is returned for batches sent to a room that has been closed
+// or whose project file was deleted
+var ErrRoomClosed = errors.New("room closed")
+
+// ErrContentTooLarge is returned when a batch would grow content past the
+// room's size limit
+var ErrContentTooLarge = errors.New("content too large")
+
+// ErrPersistFailed is returned when a batch could not be saved. The batch is
+// not applied and the room takes no further edits.
+var ErrPersistFailed = errors.New("persist failed")
+
+// ErrStoreUnavailable is returned for edits to a document room while its
+// database is known to be down. Nothing is applied and the room stays open.
+var ErrStoreUnavailable = errors.New("document store unavailable")
+
 type Operation struct {
 	Type string `json:"type"`
 	Pos  int    `json:"pos"`
@@ -22,6 +45,7 @@ type Operation struct {
 type OpMessage struct {
 	V           int         `json:"v"`
 	T           string      `json:"t"`
+	FileID      string      `json:"fileId,omitempty"` // project connections only
 	OpID        string      `json:"opId"`
 	BaseVersion int         `json:"baseVersion"`
 	Ops         []Operation `json:"ops"`
@@ -31,6 +55,7 @@ type OpMessage struct {
 type AckMessage struct {
 	V          int    `json:"v"`
 	T          string `json:"t"`
+	FileID     string `json:"fileId,omitempty"`
 	OpID       string `json:"opId"`
 	NewVersion int    `json:"newVersion"`
 }
@@ -38,21 +63,37 @@ type AckMessage struct {
 type RemoteOpMessage struct {
 	V       int         `json:"v"`
 	T       string      `json:"t"`
+	FileID  string      `json:"fileId,omitempty"`
 	Version int         `json:"version"`
 	Actor   ActorInfo   `json:"actor"`
 	Ops     []Operation `json:"ops"`
 }
 
 type ResyncRequiredMessage struct {
-	V int    `json:"v"`
-	T string `json:"t"`
+	V      int    `json:"v"`
+	T      string `json:"t"`
+	FileID string `json:"fileId,omitempty"`
 }
 
 type OpHistoryEntry struct {
-	Version  int
-	Ops      []Operation
-	ClientID string
-	OpID     string
+	Version   int
+	Ops       []Operation
+	ClientID  string
+	UserID    string
+	OpID      string
+	Presence  *Presence
+	AppliedAt time.Time
+}
+
+// OpSink persists op batches applied to document-backed rooms
+type OpSink interface {
+	PersistOps(ctx context.Context, docID string, entry OpHistoryEntry) error
+}
+
+// RoomCloser is optionally implemented by an OpSink that wants to know when
+// its document room is closed
+type RoomCloser interface {
+	DocumentRoomClosed(ctx context.Context, docID string, version int) error
 }
 
 func utf16Length(text string) int {
@@ -80,7 +121,7 @@ func isNoop(op Operation) bool {
 	return true
 }
 
-func applyOperations(content string, ops []Operation) (string, error) {
+func ApplyOperations(content string, ops []Operation) (string, error) {
 	updated := content
 	for _, op := range ops {
 		var err error
@@ -155,6 +196,64 @@ func applyDelete(content string, pos int, length int) string {
 	return string(utf16.Decode(result))
 }
 
+// TransformBatch transforms ops past a batch that was applied first, the way
+// a room transforms late submissions. Both batches are sequential and made
+// against the same content. Ties between inserts at the same position go to
+// the lower client ID. Ops that become no-ops are dropped.
+func TransformBatch(ops, applied []Operation, opsClientID, appliedClientID string) []Operation {
+	transformed, _ := TransformPair(ops, applied, opsClientID, appliedClientID)
+	return transformed
+}
+
+// TransformPair transforms two concurrent batches past each other: applying
+// a and then bPrime gives the same content as b and then aPrime.
+func TransformPair(a, b []Operation, aClientID, bClientID string) (aPrime, bPrime []Operation) {
+	aPrime, bPrime = transformLists(a, b, aClientID, bClientID)
+	return dropNoops(aPrime), dropNoops(bPrime)
+}
+
+func transformLists(a, b []Operation, aClientID, bClientID string) ([]Operation, []Operation) {
+	switch {
+	case len(a) == 0 || len(b) == 0:
+		return a, b
+	case len(a) == 1 && len(b) == 1:
+		return transformOp(a[0], b[0], aClientID, bClientID), transformOp(b[0], a[0], bClientID, aClientID)
+	case len(a) > 1:
+		// a[1:] was made after a[0], so it moves past b as b stood after a[0]
+		head, bRest := transformLists(a[:1], b, aClientID, bClientID)
+		tail, bPrime := transformLists(a[1:], bRest, aClientID, bClientID)
+		return append(head, tail...), bPrime
+	default:
+		aRest, head := transformLists(a, b[:1], aClientID, bClientID)
+		aPrime, tail := transformLists(aRest, b[1:], aClientID, bClientID)
+		return aPrime, append(head, tail...)
+	}
+}
+
+// transformOp transforms op past other. A delete spanning the position of
+// a concurrent insert is split around it so the inserted text survives.
+func transformOp(op, other Operation, opClientID, otherClientID string) []Operation {
+	if op.Type == OpDelete && other.Type == OpInsert && !isNoop(other) &&
+		op.Pos < other.Pos && other.Pos < op.Pos+op.Len {
+		before := other.Pos - op.Pos
+		return []Operation{
+			{Type: OpDelete, Pos: op.Pos, Len: before},
+			{Type: OpDelete, Pos: op.Pos + utf16Length(other.Text), Len: op.Len - before},
+		}
+	}
+	return []Operation{transformOperation(op, other, opClientID, otherClientID)}
+}
+
+func dropNoops(ops []Operation) []Operation {
+	kept := make([]Operation, 0, len(ops))
+	for _, op := range ops {
+		if !isNoop(op) {
+			kept = append(kept, op)
+		}
+	}
+	return kept
+}
+
 func transformOperation(op Operation, other Operation, opClientID, otherClientID string) Operation {
 	if isNoop(op) {
 		return op
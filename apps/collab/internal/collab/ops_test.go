@@ -0,0 +1,150 @@
+package collab
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// randomBatch returns n sequential random edits to content, each made
+// against the content the previous ones leave
+func randomBatch(rng *rand.Rand, content string, n int) []Operation {
+	texts := []string{"x", "yz", "é", "😀", "\n", "abc"}
+	ops := make([]Operation, 0, n)
+	for range n {
+		length := utf16Length(content)
+		var op Operation
+		if length > 0 && rng.Intn(2) == 0 {
+			pos := rng.Intn(length)
+			op = Operation{Type: OpDelete, Pos: pos, Len: 1 + rng.Intn(min(5, length-pos))}
+		} else {
+			op = Operation{Type: OpInsert, Pos: rng.Intn(length + 1), Text: texts[rng.Intn(len(texts))]}
+		}
+		next, err := ApplyOperations(content, []Operation{op})
+		if err != nil || strings.ContainsRune(next, utf8.RuneError) {
+			// The op split a surrogate pair; try another
+			continue
+		}
+		content = next
+		ops = append(ops, op)
+	}
+	return ops
+}
+
+func mustApply(t *testing.T, content string, ops []Operation) string {
+	t.Helper()
+	out, err := ApplyOperations(content, ops)
+	if err != nil {
+		t.Fatalf("apply %+v to %q: %v", ops, content, err)
+	}
+	return out
+}
+
+func TestTransformPairConverges(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for i := range 5000 {
+		content := mustApply(t, "", randomBatch(rng, "", rng.Intn(8)))
+		a := randomBatch(rng, content, 1+rng.Intn(4))
+		b := randomBatch(rng, content, 1+rng.Intn(4))
+
+		aPrime, bPrime := TransformPair(a, b, "alice", "bob")
+		viaA := mustApply(t, mustApply(t, content, a), bPrime)
+		viaB := mustApply(t, mustApply(t, content, b), aPrime)
+		if viaA != viaB {
+			t.Fatalf("case %d: %q with a=%+v b=%+v: a then b' = %q, b then a' = %q", i, content, a, b, viaA, viaB)
+		}
+	}
+}
+
+func TestTransformBatchMatchesTransformPair(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	for range 1000 {
+		content := mustApply(t, "", randomBatch(rng, "", 6))
+		a := randomBatch(rng, content, 3)
+		b := randomBatch(rng, content, 3)
+
+		aPrime, _ := TransformPair(a, b, "alice", "bob")
+		if got := TransformBatch(a, b, "alice", "bob"); !reflect.DeepEqual(got, aPrime) {
+			t.Fatalf("TransformBatch = %+v, TransformPair = %+v", got, aPrime)
+		}
+	}
+}
+
+func TestTransformPairSplitsDeleteAroundInsert(t *testing.T) {
+	del := []Operation{{Type: OpDelete, Pos: 1, Len: 4}}
+	ins := []Operation{{Type: OpInsert, Pos: 3, Text: "XY"}}
+
+	delPrime, insPrime := TransformPair(del, ins, "alice", "bob")
+	want := []Operation{
+		{Type: OpDelete, Pos: 1, Len: 2},
+		{Type: OpDelete, Pos: 3, Len: 2},
+	}
+	if !reflect.DeepEqual(delPrime, want) {
+		t.Errorf("delete' = %+v, want %+v", delPrime, want)
+	}
+	if got := mustApply(t, mustApply(t, "abcdef", ins), delPrime); got != "aXYf" {
+		t.Errorf("insert then delete' = %q, want %q", got, "aXYf")
+	}
+	if got := mustApply(t, mustApply(t, "abcdef", del), insPrime); got != "aXYf" {
+		t.Errorf("delete then insert' = %q, want %q", got, "aXYf")
+	}
+}
+
+func TestTransformPairBreaksInsertTiesByClientID(t *testing.T) {
+	a := []Operation{{Type: OpInsert, Pos: 1, Text: "A"}}
+	b := []Operation{{Type: OpInsert, Pos: 1, Text: "B"}}
+
+	aPrime, bPrime := TransformPair(a, b, "alice", "bob")
+	for _, got := range []string{
+		mustApply(t, mustApply(t, "xy", a), bPrime),
+		mustApply(t, mustApply(t, "xy", b), aPrime),
+	} {
+		if got != "xABy" {
+			t.Errorf("got %q, want %q", got, "xABy")
+		}
+	}
+}
+
+func TestTransformPairDropsNoops(t *testing.T) {
+	del := []Operation{{Type: OpDelete, Pos: 0, Len: 3}}
+	aPrime, bPrime := TransformPair(del, del, "alice", "bob")
+	if len(aPrime) != 0 || len(bPrime) != 0 {
+		t.Errorf("identical deletes transformed to %+v and %+v, want nothing", aPrime, bPrime)
+	}
+}
+
+func TestRoomBroadcastsBatchesTransformedAway(t *testing.T) {
+	room := NewRoom("room", "abcdef", "plaintext", "", slog.New(slog.NewTextHandler(io.Discard, nil)))
+	watcher := &Client{ID: "watcher", Room: room, send: make(chan []byte, 4)}
+	room.AddClient(watcher)
+
+	del := []Operation{{Type: OpDelete, Pos: 1, Len: 2}}
+	if _, _, err := room.Apply(context.Background(), OpBatch{ClientID: "alice", OpID: "op_a", Ops: del}); err != nil {
+		t.Fatal(err)
+	}
+	ops, version, err := room.Apply(context.Background(), OpBatch{ClientID: "bob", OpID: "op_b", Ops: del})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ops) != 0 || version != 2 {
+		t.Fatalf("concurrent identical delete = %+v at version %d, want no ops at version 2", ops, version)
+	}
+
+	room.BroadcastRemoteOp(ActorInfo{ClientID: "bob"}, version, ops, "bob")
+	var msg RemoteOpMessage
+	if err := json.Unmarshal(<-watcher.send, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Version != 2 || msg.Ops == nil || len(msg.Ops) != 0 {
+		t.Errorf("remote_op = %+v, want version 2 with an empty op list", msg)
+	}
+	if content, _ := room.State(); content != "adef" {
+		t.Errorf("content = %q, want %q", content, "adef")
+	}
+}
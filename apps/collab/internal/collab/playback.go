@@ -0,0 +1,333 @@
+package collab
+
+import (
+	"context"
+	"encoding/json"
+	"hash/fnv"
+	"time"
+
+	"nhooyr.io/websocket"
+)
+
+const (
+	defaultPlaybackSpeed = 1.0
+	maxPlaybackSpeed     = 64.0
+	// Long idle stretches in a recording are squeezed to this gap so replays
+	// don't sit silent while nobody was typing.
+	maxPlaybackGap = 3 * time.Second
+)
+
+// PlaybackFrame is one recorded op batch, with the presence sent alongside it
+type PlaybackFrame struct {
+	Version  int
+	Actor    ActorInfo
+	Ops      []Operation
+	Presence *Presence
+	At       time.Time
+}
+
+// PlaybackControlMessage - Client controls a replay session
+type PlaybackControlMessage struct {
+	V       int     `json:"v"`
+	T       string  `json:"t"`
+	Action  string  `json:"action"` // "play", "pause", "seek", "speed"
+	Version int     `json:"version,omitempty"`
+	Speed   float64 `json:"speed,omitempty"`
+}
+
+// PlaybackStateMessage - Server reports the replay position; content is the
+// document at that version and replaces whatever the client is showing
+type PlaybackStateMessage struct {
+	V           int     `json:"v"`
+	T           string  `json:"t"`
+	Version     int     `json:"version"`
+	FromVersion int     `json:"fromVersion"`
+	ToVersion   int     `json:"toVersion"`
+	Content     string  `json:"content"`
+	Playing     bool    `json:"playing"`
+	Speed       float64 `json:"speed"`
+}
+
+// PlaybackEndedMessage - Server notifies that the last frame has been sent
+type PlaybackEndedMessage struct {
+	V       int    `json:"v"`
+	T       string `json:"t"`
+	Version int    `json:"version"`
+}
+
+type playbackSession struct {
+	conn        *websocket.Conn
+	initial     string
+	fromVersion int
+	frames      []PlaybackFrame
+
+	content string
+	next    int // index of the next frame to send
+	playing bool
+	speed   float64
+}
+
+// PlaybackActor builds the actor shown for a recorded client, with a colour
+// that stays stable across replays
+func PlaybackActor(clientID string) ActorInfo {
+	h := fnv.New32a()
+	h.Write([]byte(clientID))
+	return ActorInfo{
+		ClientID: clientID,
+		Color:    clientColors[int(h.Sum32()%uint32(len(clientColors)))],
+	}
+}
+
+// HandlePlayback runs a replay-only session of the room protocol. After the
+// usual hello/welcome handshake the recorded frames are sent as remote_op and
+// presence_update messages, paced by their original timing; op messages from
+// the client are rejected.
+func (h *WSHandler) HandlePlayback(ctx context.Context, conn *websocket.Conn, docID, content string, fromVersion int, frames []PlaybackFrame, speed float64) {
+	hello, err := h.readHello(ctx, conn)
+	if err != nil {
+		h.logger.Error("failed to read hello", "error", err)
+		conn.Close(websocket.StatusPolicyViolation, "invalid hello")
+		return
+	}
+
+	if hello.DocID != docID {
+		h.sendError(ctx, conn, "room_mismatch", "DocID does not match document")
+		conn.Close(websocket.StatusPolicyViolation, "document mismatch")
+		return
+	}
+	defer conn.Close(websocket.StatusNormalClosure, "")
+
+	session := &playbackSession{
+		conn:        conn,
+		initial:     content,
+		fromVersion: fromVersion,
+		frames:      frames,
+		content:     content,
+		playing:     len(frames) > 0,
+		speed:       clampSpeed(speed),
+	}
+
+	welcome := WelcomeMessage{
+		V:             1,
+		T:             "welcome",
+		DocID:         docID,
+		ServerVersion: fromVersion,
+		Snapshot:      content,
+		Presence:      []PresenceInfo{},
+		Snapshots:     []Snapshot{},
+	}
+	if err := session.write(ctx, welcome); err != nil {
+		return
+	}
+	if err := session.write(ctx, session.state()); err != nil {
+		return
+	}
+
+	controls := make(chan PlaybackControlMessage)
+	readCtx, cancelRead := context.WithCancel(ctx)
+	defer cancelRead()
+	go h.readPlaybackControls(readCtx, conn, controls)
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	if !session.playing {
+		stopTimer(timer)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ctrl, ok := <-controls:
+			if !ok {
+				return
+			}
+			session.control(ctrl)
+			if err := session.write(ctx, session.state()); err != nil {
+				return
+			}
+			stopTimer(timer)
+			if session.playing && session.next < len(session.frames) {
+				timer.Reset(0)
+			}
+		case <-timer.C:
+			if !session.playing || session.next >= len(session.frames) {
+				continue
+			}
+			if err := session.sendFrame(ctx); err != nil {
+				return
+			}
+			if session.next >= len(session.frames) {
+				session.playing = false
+				if err := session.write(ctx, PlaybackEndedMessage{V: 1, T: "playback_ended", Version: session.version()}); err != nil {
+					return
+				}
+				continue
+			}
+			timer.Reset(session.delay())
+		}
+	}
+}
+
+func (h *WSHandler) readPlaybackControls(ctx context.Context, conn *websocket.Conn, controls chan<- PlaybackControlMessage) {
+	defer close(controls)
+	for {
+		_, data, err := conn.Read(ctx)
+		if err != nil {
+			return
+		}
+
+		var base ClientMessage
+		if err := json.Unmarshal(data, &base); err != nil {
+			continue
+		}
+
+		switch base.T {
+		case "playback_control":
+			var msg PlaybackControlMessage
+			if err := json.Unmarshal(data, &msg); err != nil {
+				h.logger.Warn("invalid playback_control message", "error", err)
+				continue
+			}
+			select {
+			case controls <- msg:
+			case <-ctx.Done():
+				return
+			}
+		case "op", "save", "restore":
+			h.sendError(ctx, conn, "read_only", "Playback sessions are read-only")
+		}
+	}
+}
+
+func (s *playbackSession) control(msg PlaybackControlMessage) {
+	switch msg.Action {
+	case "play":
+		s.playing = s.next < len(s.frames)
+	case "pause":
+		s.playing = false
+	case "speed":
+		s.speed = clampSpeed(msg.Speed)
+	case "seek":
+		s.seek(msg.Version)
+	}
+}
+
+// seek rebuilds the content at version by replaying frames from the start
+func (s *playbackSession) seek(version int) {
+	s.content = s.initial
+	s.next = 0
+	for s.next < len(s.frames) && s.frames[s.next].Version <= version {
+		updated, err := ApplyOperations(s.content, s.frames[s.next].Ops)
+		if err != nil {
+			break
+		}
+		s.content = updated
+		s.next++
+	}
+	if s.next >= len(s.frames) {
+		s.playing = false
+	}
+}
+
+func (s *playbackSession) sendFrame(ctx context.Context) error {
+	frame := s.frames[s.next]
+	updated, err := ApplyOperations(s.content, frame.Ops)
+	if err != nil {
+		return err
+	}
+	s.content = updated
+	s.next++
+
+	if len(frame.Ops) > 0 {
+		if err := s.write(ctx, RemoteOpMessage{
+			V:       1,
+			T:       "remote_op",
+			Version: frame.Version,
+			Actor:   frame.Actor,
+			Ops:     frame.Ops,
+		}); err != nil {
+			return err
+		}
+	}
+
+	if frame.Presence != nil && frame.Presence.Cursor != nil {
+		return s.write(ctx, PresenceUpdateMessage{
+			V:         1,
+			T:         "presence_update",
+			Actor:     frame.Actor,
+			Cursor:    *frame.Presence.Cursor,
+			Selection: frame.Presence.Selection,
+		})
+	}
+	return nil
+}
+
+// delay returns how long to wait before sending the next frame
+func (s *playbackSession) delay() time.Duration {
+	if s.next == 0 || s.next >= len(s.frames) {
+		return 0
+	}
+	gap := s.frames[s.next].At.Sub(s.frames[s.next-1].At)
+	if gap < 0 {
+		gap = 0
+	}
+	gap = time.Duration(float64(gap) / s.speed)
+	if gap > maxPlaybackGap {
+		gap = maxPlaybackGap
+	}
+	return gap
+}
+
+func (s *playbackSession) version() int {
+	if s.next == 0 {
+		return s.fromVersion
+	}
+	return s.frames[s.next-1].Version
+}
+
+func (s *playbackSession) state() PlaybackStateMessage {
+	toVersion := s.fromVersion
+	if len(s.frames) > 0 {
+		toVersion = s.frames[len(s.frames)-1].Version
+	}
+	return PlaybackStateMessage{
+		V:           1,
+		T:           "playback_state",
+		Version:     s.version(),
+		FromVersion: s.fromVersion,
+		ToVersion:   toVersion,
+		Content:     s.content,
+		Playing:     s.playing,
+		Speed:       s.speed,
+	}
+}
+
+func (s *playbackSession) write(ctx context.Context, msg any) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	writeCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	return s.conn.Write(writeCtx, websocket.MessageText, data)
+}
+
+func clampSpeed(speed float64) float64 {
+	if speed <= 0 {
+		return defaultPlaybackSpeed
+	}
+	if speed > maxPlaybackSpeed {
+		return maxPlaybackSpeed
+	}
+	return speed
+}
+
+func stopTimer(timer *time.Timer) {
+	if !timer.Stop() {
+		select {
+		case <-timer.C:
+		default:
+		}
+	}
+}
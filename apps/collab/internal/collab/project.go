@@ -0,0 +1,427 @@
+package collab
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NoumanAMalik/maple/apps/collab/internal/metrics"
+)
+
+// NodeKind is the kind of an entry in a project's file tree
+type NodeKind string
+
+const (
+	NodeFile   NodeKind = "file"
+	NodeFolder NodeKind = "folder"
+)
+
+var (
+	ErrNodeNotFound  = errors.New("file not found")
+	ErrInvalidName   = errors.New("invalid file name")
+	ErrNameTaken     = errors.New("name already taken in folder")
+	ErrInvalidParent = errors.New("invalid parent folder")
+	ErrProjectFull   = errors.New("project has too many files")
+)
+
+const (
+	// MaxProjectNodes is how many files and folders one project may hold
+	MaxProjectNodes = 256
+	// MaxProjectFileBytes is how large one project file may grow
+	MaxProjectFileBytes = 256 << 10
+)
+
+// ProjectNode is a file or folder in a project's tree. Top-level entries
+// have an empty ParentID.
+type ProjectNode struct {
+	ID       string   `json:"id"`
+	ParentID string   `json:"parentId,omitempty"`
+	Name     string   `json:"name"`
+	Kind     NodeKind `json:"kind"`
+	Language string   `json:"language,omitempty"`
+}
+
+// ProjectFileState is the content of one project file at a version
+type ProjectFileState struct {
+	FileID  string `json:"fileId"`
+	Version int    `json:"version"`
+	Content string `json:"content"`
+}
+
+// Project is a tree of files and folders shared over a single connection.
+// Every file is backed by its own headless Room, so each one is a separate
+// OT stream with its own version.
+type Project struct {
+	ID        string
+	OwnerID   string
+	CreatedAt time.Time
+
+	clients sync.Map // map[string]*Client
+	mu      sync.RWMutex
+	treeMu  sync.Mutex // serializes tree changes with their broadcasts
+	logger  *slog.Logger
+	nodes   map[string]*ProjectNode
+	files   map[string]*Room
+	// closed is set once the project is deleted; nobody may join after
+	closed bool
+
+	// Hibernation: track when project became empty for cleanup
+	emptyAt *time.Time
+}
+
+func NewProject(id, ownerID string, logger *slog.Logger) *Project {
+	now := time.Now()
+	return &Project{
+		ID:        id,
+		OwnerID:   ownerID,
+		CreatedAt: now,
+		logger:    logger,
+		nodes:     make(map[string]*ProjectNode),
+		files:     make(map[string]*Room),
+		emptyAt:   &now,
+	}
+}
+
+// isOwnedBy reports whether client owns the project. Projects are
+// anonymous, so like an anonymous room the owner is the client that created
+// it.
+func (p *Project) isOwnedBy(client *Client) bool {
+	return p.OwnerID != "" && p.OwnerID == client.ID
+}
+
+// AddPath creates a file at a slash-separated path, creating any missing
+// folders along the way
+func (p *Project) AddPath(path, content, language string) (*ProjectNode, error) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	parentID := ""
+	for _, name := range parts[:len(parts)-1] {
+		if existing := p.childLocked(parentID, name); existing != nil {
+			if existing.Kind != NodeFolder {
+				return nil, ErrInvalidParent
+			}
+			parentID = existing.ID
+			continue
+		}
+		folder, err := p.createLocked(parentID, name, NodeFolder, "", "")
+		if err != nil {
+			return nil, err
+		}
+		parentID = folder.ID
+	}
+
+	return p.createLocked(parentID, parts[len(parts)-1], NodeFile, content, language)
+}
+
+// Create adds a file or folder under parentID
+func (p *Project) Create(parentID, name string, kind NodeKind, content, language string) (ProjectNode, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	node, err := p.createLocked(parentID, name, kind, content, language)
+	if err != nil {
+		return ProjectNode{}, err
+	}
+	return *node, nil
+}
+
+func (p *Project) createLocked(parentID, name string, kind NodeKind, content, language string) (*ProjectNode, error) {
+	if kind != NodeFile && kind != NodeFolder {
+		return nil, ErrInvalidName
+	}
+	if err := p.checkPlacementLocked("", parentID, name); err != nil {
+		return nil, err
+	}
+	if len(p.nodes) >= MaxProjectNodes {
+		return nil, ErrProjectFull
+	}
+	if kind == NodeFile && len(content) > MaxProjectFileBytes {
+		return nil, ErrContentTooLarge
+	}
+
+	node := &ProjectNode{
+		ID:       generateRoomID(),
+		ParentID: parentID,
+		Name:     name,
+		Kind:     kind,
+	}
+	if kind == NodeFile {
+		node.Language = language
+		room := NewRoom(node.ID, content, language, p.OwnerID, p.logger)
+		room.maxContentBytes = MaxProjectFileBytes
+		p.files[node.ID] = room
+	}
+	p.nodes[node.ID] = node
+	return node, nil
+}
+
+// Rename changes the name of a file or folder
+func (p *Project) Rename(id, name string) (ProjectNode, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	node, ok := p.nodes[id]
+	if !ok {
+		return ProjectNode{}, ErrNodeNotFound
+	}
+	if err := p.checkPlacementLocked(id, node.ParentID, name); err != nil {
+		return ProjectNode{}, err
+	}
+	node.Name = name
+	return *node, nil
+}
+
+// Move re-parents a file or folder. A folder cannot be moved into itself or
+// one of its descendants.
+func (p *Project) Move(id, parentID string) (ProjectNode, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	node, ok := p.nodes[id]
+	if !ok {
+		return ProjectNode{}, ErrNodeNotFound
+	}
+	for ancestor := parentID; ancestor != ""; ancestor = p.nodes[ancestor].ParentID {
+		if ancestor == id {
+			return ProjectNode{}, ErrInvalidParent
+		}
+		if _, ok := p.nodes[ancestor]; !ok {
+			return ProjectNode{}, ErrInvalidParent
+		}
+	}
+	if err := p.checkPlacementLocked(id, parentID, node.Name); err != nil {
+		return ProjectNode{}, err
+	}
+	node.ParentID = parentID
+	return *node, nil
+}
+
+// Delete removes a file, or a folder and everything under it, and returns
+// the ids of every removed node
+func (p *Project) Delete(id string) ([]string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.nodes[id]; !ok {
+		return nil, ErrNodeNotFound
+	}
+
+	removed := []string{id}
+	for i := 0; i < len(removed); i++ {
+		for _, node := range p.nodes {
+			if node.ParentID == removed[i] {
+				removed = append(removed, node.ID)
+			}
+		}
+	}
+	for _, nodeID := range removed {
+		delete(p.nodes, nodeID)
+		if room, ok := p.files[nodeID]; ok {
+			// An op already holding the room must not land in a file
+			// that is gone
+			room.retire()
+			delete(p.files, nodeID)
+		}
+	}
+	return removed, nil
+}
+
+// roomCount is how many rooms the project counts as against MaxRooms: one
+// per file, and at least one
+func (p *Project) roomCount() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return max(len(p.files), 1)
+}
+
+// File returns the room backing a file
+func (p *Project) File(id string) (*Room, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	room, ok := p.files[id]
+	return room, ok
+}
+
+// Tree returns every node, folders first and then by name
+func (p *Project) Tree() []ProjectNode {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	tree := make([]ProjectNode, 0, len(p.nodes))
+	for _, node := range p.nodes {
+		tree = append(tree, *node)
+	}
+	sort.Slice(tree, func(i, j int) bool {
+		if tree[i].Kind != tree[j].Kind {
+			return tree[i].Kind == NodeFolder
+		}
+		return tree[i].Name < tree[j].Name
+	})
+	return tree
+}
+
+// Files returns the current content and version of every file
+func (p *Project) Files() []ProjectFileState {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	files := make([]ProjectFileState, 0, len(p.files))
+	for id, room := range p.files {
+		content, version := room.State()
+		files = append(files, ProjectFileState{FileID: id, Version: version, Content: content})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].FileID < files[j].FileID })
+	return files
+}
+
+// checkPlacementLocked validates that name can be used for node id (empty
+// for a new node) inside parentID
+func (p *Project) checkPlacementLocked(id, parentID, name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\\") {
+		return ErrInvalidName
+	}
+	if parentID != "" {
+		parent, ok := p.nodes[parentID]
+		if !ok || parent.Kind != NodeFolder {
+			return ErrInvalidParent
+		}
+	}
+	if existing := p.childLocked(parentID, name); existing != nil && existing.ID != id {
+		return ErrNameTaken
+	}
+	return nil
+}
+
+func (p *Project) childLocked(parentID, name string) *ProjectNode {
+	for _, node := range p.nodes {
+		if node.ParentID == parentID && node.Name == name {
+			return node
+		}
+	}
+	return nil
+}
+
+// AddClient adds client to the project. It reports false if the project has
+// been closed.
+func (p *Project) AddClient(client *Client) bool {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return false
+	}
+	p.clients.Store(client.ID, client)
+	p.emptyAt = nil
+	p.mu.Unlock()
+	p.logger.Info("client joined project", "projectId", p.ID, "clientId", client.ID)
+	return true
+}
+
+func (p *Project) RemoveClient(clientID string) {
+	p.clients.Delete(clientID)
+	p.mu.Lock()
+	if p.clientCountLocked() == 0 {
+		now := time.Now()
+		p.emptyAt = &now
+	}
+	p.mu.Unlock()
+	p.logger.Info("client left project", "projectId", p.ID, "clientId", clientID)
+}
+
+func (p *Project) ClientCount() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.clientCountLocked()
+}
+
+func (p *Project) clientCountLocked() int {
+	count := 0
+	p.clients.Range(func(_, _ any) bool {
+		count++
+		return true
+	})
+	return count
+}
+
+func (p *Project) Broadcast(msg []byte, excludeClientID string) {
+	p.clients.Range(func(key, value any) bool {
+		client := value.(*Client)
+		if client.ID == excludeClientID {
+			return true
+		}
+		select {
+		case client.send <- msg:
+		default:
+			metrics.DroppedMessages.Inc()
+			p.logger.Warn("client send buffer full, dropping message", "clientId", client.ID)
+		}
+		return true
+	})
+}
+
+func (p *Project) GetPresenceList(excludeClientID string) []PresenceInfo {
+	presence := []PresenceInfo{}
+	p.clients.Range(func(key, value any) bool {
+		client := value.(*Client)
+		if client.ID == excludeClientID {
+			return true
+		}
+		client.mu.RLock()
+		clientPresence := Presence{}
+		if client.Presence != nil {
+			clientPresence = *client.Presence
+		}
+		presence = append(presence, PresenceInfo{
+			Actor: ActorInfo{
+				ClientID:    client.ID,
+				DisplayName: client.DisplayName,
+				Color:       client.Color,
+			},
+			Presence: clientPresence,
+		})
+		client.mu.RUnlock()
+		return true
+	})
+	return presence
+}
+
+// Close retires every file and disconnects every client, sending each a
+// room_closed message with reason
+func (p *Project) Close(reason string) {
+	p.mu.Lock()
+	p.closed = true
+	for _, room := range p.files {
+		room.retire()
+	}
+	p.mu.Unlock()
+
+	msg, _ := json.Marshal(RoomClosedMessage{V: 1, T: "room_closed", Reason: reason})
+	var wg sync.WaitGroup
+	p.clients.Range(func(_, value any) bool {
+		client := value.(*Client)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			client.closeWith(msg, reason)
+		}()
+		return true
+	})
+	wg.Wait()
+	p.logger.Info("project closed", "projectId", p.ID, "reason", reason)
+}
+
+// IsStale returns whether the project has been empty longer than the given duration
+func (p *Project) IsStale(maxEmptyDuration time.Duration) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.emptyAt == nil {
+		return false
+	}
+	return time.Since(*p.emptyAt) > maxEmptyDuration
+}
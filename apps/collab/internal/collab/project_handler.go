@@ -0,0 +1,493 @@
+package collab
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"nhooyr.io/websocket"
+)
+
+// ProjectWelcomeMessage - Server sends the file tree and every file's content
+// after a project hello
+type ProjectWelcomeMessage struct {
+	V         int                `json:"v"`
+	T         string             `json:"t"`
+	ProjectID string             `json:"projectId"`
+	Tree      []ProjectNode      `json:"tree"`
+	Files     []ProjectFileState `json:"files"`
+	Presence  []PresenceInfo     `json:"presence"`
+	IsOwner   bool               `json:"isOwner"`
+}
+
+// FileCreateMessage - Client creates a file or folder in the project tree
+type FileCreateMessage struct {
+	V         int      `json:"v"`
+	T         string   `json:"t"`
+	RequestID string   `json:"requestId,omitempty"`
+	ParentID  string   `json:"parentId,omitempty"`
+	Name      string   `json:"name"`
+	Kind      NodeKind `json:"kind"`
+	Content   string   `json:"content,omitempty"`
+	Language  string   `json:"language,omitempty"`
+}
+
+// FileRenameMessage - Client renames a file or folder
+type FileRenameMessage struct {
+	V         int    `json:"v"`
+	T         string `json:"t"`
+	RequestID string `json:"requestId,omitempty"`
+	FileID    string `json:"fileId"`
+	Name      string `json:"name"`
+}
+
+// FileMoveMessage - Client moves a file or folder to another folder; an
+// empty parentId moves it to the top level
+type FileMoveMessage struct {
+	V         int    `json:"v"`
+	T         string `json:"t"`
+	RequestID string `json:"requestId,omitempty"`
+	FileID    string `json:"fileId"`
+	ParentID  string `json:"parentId,omitempty"`
+}
+
+// FileDeleteMessage - Client deletes a file, or a folder and its contents
+type FileDeleteMessage struct {
+	V         int    `json:"v"`
+	T         string `json:"t"`
+	RequestID string `json:"requestId,omitempty"`
+	FileID    string `json:"fileId"`
+}
+
+// FileCreatedMessage - Server notifies clients of a new file or folder
+type FileCreatedMessage struct {
+	V         int         `json:"v"`
+	T         string      `json:"t"`
+	RequestID string      `json:"requestId,omitempty"`
+	Actor     ActorInfo   `json:"actor"`
+	Node      ProjectNode `json:"node"`
+	Content   string      `json:"content,omitempty"`
+}
+
+// FileChangedMessage - Server notifies clients that a node was renamed
+// ("file_renamed") or moved ("file_moved")
+type FileChangedMessage struct {
+	V         int         `json:"v"`
+	T         string      `json:"t"`
+	RequestID string      `json:"requestId,omitempty"`
+	Actor     ActorInfo   `json:"actor"`
+	Node      ProjectNode `json:"node"`
+}
+
+// FileDeletedMessage - Server notifies clients that nodes were removed
+type FileDeletedMessage struct {
+	V         int       `json:"v"`
+	T         string    `json:"t"`
+	RequestID string    `json:"requestId,omitempty"`
+	Actor     ActorInfo `json:"actor"`
+	FileIDs   []string  `json:"fileIds"`
+}
+
+// HandleProjectConnection runs the project protocol: the room protocol's op
+// and presence messages addressed by fileId, plus file tree messages, all on
+// one connection.
+func (h *WSHandler) HandleProjectConnection(ctx context.Context, conn *websocket.Conn, projectID, userID string) {
+	project, ok := h.registry.GetProject(projectID)
+	if !ok {
+		h.sendError(ctx, conn, "project_not_found", "Project does not exist")
+		conn.Close(websocket.StatusPolicyViolation, "project not found")
+		return
+	}
+
+	hello, err := h.readHello(ctx, conn)
+	if err != nil {
+		h.logger.Error("failed to read hello", "error", err)
+		conn.Close(websocket.StatusPolicyViolation, "invalid hello")
+		return
+	}
+
+	if hello.DocID != projectID {
+		h.sendError(ctx, conn, "project_mismatch", "DocID does not match project")
+		conn.Close(websocket.StatusPolicyViolation, "project mismatch")
+		return
+	}
+
+	if max := h.registry.Limits().MaxRoomClients; max > 0 && project.ClientCount() >= max {
+		h.sendError(ctx, conn, "room_full", "Project has reached its participant limit")
+		conn.Close(websocket.StatusTryAgainLater, "project full")
+		return
+	}
+
+	clientCtx, cancel := context.WithCancel(ctx)
+	client := &Client{
+		ID:       hello.ClientID,
+		UserID:   userID,
+		Color:    clientColors[project.ClientCount()%len(clientColors)],
+		Conn:     conn,
+		Project:  project,
+		JoinedAt: time.Now(),
+		send:     make(chan []byte, 256),
+		ctx:      clientCtx,
+		cancel:   cancel,
+	}
+
+	// Join under the tree lock so every tree change is either in the
+	// welcome or broadcast after it, never both
+	project.treeMu.Lock()
+	if !project.AddClient(client) {
+		project.treeMu.Unlock()
+		cancel()
+		h.sendError(ctx, conn, "project_not_found", "Project does not exist")
+		conn.Close(websocket.StatusPolicyViolation, "project not found")
+		return
+	}
+	defer func() {
+		project.RemoveClient(client.ID)
+		cancel()
+
+		leftMsg, _ := json.Marshal(UserLeftMessage{
+			V:        1,
+			T:        "user_left",
+			ClientID: client.ID,
+		})
+		project.Broadcast(leftMsg, client.ID)
+	}()
+
+	welcome := ProjectWelcomeMessage{
+		V:         1,
+		T:         "project_welcome",
+		ProjectID: project.ID,
+		Tree:      project.Tree(),
+		Files:     project.Files(),
+		Presence:  project.GetPresenceList(client.ID),
+		IsOwner:   project.isOwnedBy(client),
+	}
+	err = client.Send(welcome)
+	project.treeMu.Unlock()
+	if err != nil {
+		h.logger.Error("failed to send welcome", "error", err)
+		return
+	}
+
+	joinedMsg, _ := json.Marshal(UserJoinedMessage{
+		V:     1,
+		T:     "user_joined",
+		Actor: clientActor(client),
+	})
+	project.Broadcast(joinedMsg, client.ID)
+
+	go client.WriteLoop()
+
+	h.projectReadLoop(client)
+}
+
+func (h *WSHandler) projectReadLoop(client *Client) {
+	for {
+		ctx, cancel := context.WithTimeout(client.ctx, 60*time.Second)
+		_, data, err := client.Conn.Read(ctx)
+		cancel()
+
+		if err != nil {
+			h.logger.Debug("client read error", "clientId", client.ID, "error", err)
+			return
+		}
+
+		var base ClientMessage
+		if err := json.Unmarshal(data, &base); err != nil {
+			h.logger.Warn("invalid message format", "clientId", client.ID, "error", err)
+			continue
+		}
+
+		switch base.T {
+		case "op":
+			ctx, span := startMessageSpan(client, base.T)
+			h.handleProjectOp(ctx, client, data)
+			span.End()
+		case "presence":
+			h.handleProjectPresence(client, data)
+		case "file_create":
+			h.handleFileCreate(client, data)
+		case "file_rename":
+			h.handleFileRename(client, data)
+		case "file_move":
+			h.handleFileMove(client, data)
+		case "file_delete":
+			h.handleFileDelete(client, data)
+		case "ping":
+			client.Send(PongMessage{V: 1, T: "pong"})
+		default:
+			h.logger.Warn("unknown message type", "type", base.T)
+		}
+	}
+}
+
+func (h *WSHandler) handleProjectOp(ctx context.Context, client *Client, data []byte) {
+	var msg OpMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		h.logger.Warn("invalid op message", "clientId", client.ID, "error", err)
+		return
+	}
+
+	if msg.OpID == "" || len(msg.Ops) == 0 {
+		h.logger.Warn("invalid op payload", "clientId", client.ID)
+		return
+	}
+
+	file, ok := client.Project.File(msg.FileID)
+	if !ok {
+		sendProjectError(client, "", ErrNodeNotFound)
+		return
+	}
+
+	transformed, newVersion, err := file.Apply(ctx, OpBatch{
+		ClientID:    client.ID,
+		UserID:      client.UserID,
+		OpID:        msg.OpID,
+		BaseVersion: msg.BaseVersion,
+		Ops:         msg.Ops,
+		Presence:    msg.Presence,
+	})
+	if errors.Is(err, ErrDuplicateBatch) {
+		client.Send(AckMessage{V: 1, T: "ack", FileID: msg.FileID, OpID: msg.OpID, NewVersion: newVersion})
+		return
+	}
+	if err != nil {
+		if errors.Is(err, ErrResyncRequired) {
+			client.Send(ResyncRequiredMessage{V: 1, T: "resync_required", FileID: msg.FileID})
+			return
+		}
+		if errors.Is(err, ErrRoomClosed) {
+			sendProjectError(client, "", ErrNodeNotFound)
+			return
+		}
+		if errors.Is(err, ErrContentTooLarge) {
+			// The batch is refused, so the client must drop it
+			sendProjectError(client, "", err)
+			client.Send(ResyncRequiredMessage{V: 1, T: "resync_required", FileID: msg.FileID})
+			return
+		}
+		h.logger.Warn("failed to apply ops", "clientId", client.ID, "fileId", msg.FileID, "error", err)
+		return
+	}
+
+	if msg.Presence != nil {
+		h.updateProjectPresence(client, msg.FileID, msg.Presence.Cursor, msg.Presence.Selection)
+	}
+
+	client.Send(AckMessage{
+		V:          1,
+		T:          "ack",
+		FileID:     msg.FileID,
+		OpID:       msg.OpID,
+		NewVersion: newVersion,
+	})
+
+	remote, err := json.Marshal(RemoteOpMessage{
+		V:       1,
+		T:       "remote_op",
+		FileID:  msg.FileID,
+		Version: newVersion,
+		Actor:   clientActor(client),
+		Ops:     transformed,
+	})
+	if err != nil {
+		return
+	}
+	client.Project.Broadcast(remote, client.ID)
+}
+
+func (h *WSHandler) handleProjectPresence(client *Client, data []byte) {
+	var msg PresenceMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		h.logger.Warn("invalid presence message", "clientId", client.ID, "error", err)
+		return
+	}
+
+	if msg.DisplayName != "" {
+		client.UpdateDisplayName(msg.DisplayName)
+	}
+
+	h.updateProjectPresence(client, msg.FileID, &msg.Cursor, msg.Selection)
+}
+
+func (h *WSHandler) updateProjectPresence(client *Client, fileID string, cursor *Position, selection *Selection) {
+	client.mu.Lock()
+	client.Presence = &Presence{
+		FileID:    fileID,
+		Cursor:    cursor,
+		Selection: selection,
+	}
+	client.mu.Unlock()
+
+	if cursor == nil {
+		return
+	}
+	update, _ := json.Marshal(PresenceUpdateMessage{
+		V:         1,
+		T:         "presence_update",
+		FileID:    fileID,
+		Actor:     clientActor(client),
+		Cursor:    *cursor,
+		Selection: selection,
+	})
+	client.Project.Broadcast(update, client.ID)
+}
+
+func (h *WSHandler) handleFileCreate(client *Client, data []byte) {
+	var msg FileCreateMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		h.logger.Warn("invalid file_create message", "clientId", client.ID, "error", err)
+		return
+	}
+	if msg.Kind == "" {
+		msg.Kind = NodeFile
+	}
+
+	err := client.Project.changeTree(func() (any, error) {
+		node, err := client.Project.Create(msg.ParentID, msg.Name, msg.Kind, msg.Content, msg.Language)
+		if err != nil {
+			return nil, err
+		}
+		return FileCreatedMessage{
+			V:         1,
+			T:         "file_created",
+			RequestID: msg.RequestID,
+			Actor:     clientActor(client),
+			Node:      node,
+			Content:   msg.Content,
+		}, nil
+	})
+	if err != nil {
+		sendProjectError(client, msg.RequestID, err)
+	}
+}
+
+func (h *WSHandler) handleFileRename(client *Client, data []byte) {
+	var msg FileRenameMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		h.logger.Warn("invalid file_rename message", "clientId", client.ID, "error", err)
+		return
+	}
+
+	err := client.Project.changeTree(func() (any, error) {
+		node, err := client.Project.Rename(msg.FileID, msg.Name)
+		if err != nil {
+			return nil, err
+		}
+		return FileChangedMessage{
+			V:         1,
+			T:         "file_renamed",
+			RequestID: msg.RequestID,
+			Actor:     clientActor(client),
+			Node:      node,
+		}, nil
+	})
+	if err != nil {
+		sendProjectError(client, msg.RequestID, err)
+	}
+}
+
+func (h *WSHandler) handleFileMove(client *Client, data []byte) {
+	var msg FileMoveMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		h.logger.Warn("invalid file_move message", "clientId", client.ID, "error", err)
+		return
+	}
+
+	err := client.Project.changeTree(func() (any, error) {
+		node, err := client.Project.Move(msg.FileID, msg.ParentID)
+		if err != nil {
+			return nil, err
+		}
+		return FileChangedMessage{
+			V:         1,
+			T:         "file_moved",
+			RequestID: msg.RequestID,
+			Actor:     clientActor(client),
+			Node:      node,
+		}, nil
+	})
+	if err != nil {
+		sendProjectError(client, msg.RequestID, err)
+	}
+}
+
+func (h *WSHandler) handleFileDelete(client *Client, data []byte) {
+	var msg FileDeleteMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		h.logger.Warn("invalid file_delete message", "clientId", client.ID, "error", err)
+		return
+	}
+
+	err := client.Project.changeTree(func() (any, error) {
+		removed, err := client.Project.Delete(msg.FileID)
+		if err != nil {
+			return nil, err
+		}
+		return FileDeletedMessage{
+			V:         1,
+			T:         "file_deleted",
+			RequestID: msg.RequestID,
+			Actor:     clientActor(client),
+			FileIDs:   removed,
+		}, nil
+	})
+	if err != nil {
+		sendProjectError(client, msg.RequestID, err)
+	}
+}
+
+// changeTree runs a tree mutation and broadcasts the message it returns to
+// every client, including the sender. Changes are serialized so all clients
+// see them in the same order.
+func (p *Project) changeTree(change func() (any, error)) error {
+	p.treeMu.Lock()
+	defer p.treeMu.Unlock()
+
+	msg, err := change()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	p.Broadcast(data, "")
+	return nil
+}
+
+func sendProjectError(client *Client, requestID string, err error) {
+	code := "invalid_request"
+	switch {
+	case errors.Is(err, ErrNodeNotFound):
+		code = "file_not_found"
+	case errors.Is(err, ErrNameTaken):
+		code = "name_taken"
+	case errors.Is(err, ErrInvalidName):
+		code = "invalid_name"
+	case errors.Is(err, ErrInvalidParent):
+		code = "invalid_parent"
+	case errors.Is(err, ErrProjectFull):
+		code = "project_full"
+	case errors.Is(err, ErrContentTooLarge):
+		code = "file_too_large"
+	}
+	client.Send(ErrorMessage{
+		V:         1,
+		T:         "error",
+		RequestID: requestID,
+		Code:      code,
+		Message:   err.Error(),
+	})
+}
+
+func clientActor(client *Client) ActorInfo {
+	client.mu.RLock()
+	defer client.mu.RUnlock()
+	return ActorInfo{
+		ClientID:    client.ID,
+		DisplayName: client.DisplayName,
+		Color:       client.Color,
+	}
+}
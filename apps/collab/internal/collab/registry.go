@@ -5,27 +5,81 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log/slog"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/NoumanAMalik/maple/apps/collab/internal/events"
 )
 
 type RoomRegistry struct {
-	rooms  sync.Map // map[string]*Room
-	logger *slog.Logger
-	ctx    context.Context
-	cancel context.CancelFunc
+	rooms    sync.Map // map[string]*Room
+	docRooms sync.Map // map[documentID]*Room
+	projects sync.Map // map[string]*Project
+	events   *events.Hub
+	logger   *slog.Logger
+	ctx      context.Context
+	cancel   context.CancelFunc
+
+	// When each background loop last finished a pass, in Unix nanoseconds
+	cleanupTick  atomic.Int64
+	autoSaveTick atomic.Int64
+
+	limits  atomic.Pointer[Limits]
+	storeUp atomic.Pointer[func() bool]
 }
 
-const autoSaveInterval = 30 * time.Second
+// Limits are quotas that can be changed while the server runs. Zero means
+// unlimited.
+type Limits struct {
+	// MaxRooms is how many rooms may be open before anonymous rooms and
+	// projects can no longer be created. Each file of a project counts as a
+	// room. Document rooms are bounded by their owners' documents and always
+	// open.
+	MaxRooms int
+	// MaxRoomClients is how many clients may join one room or project.
+	// Changing it never disconnects anyone already in.
+	MaxRoomClients int
+}
+
+// PersistFailedReason is sent to clients of a document room closed because
+// an edit could not be saved
+const PersistFailedReason = "Changes could not be saved; reopen the document to resync"
+
+// DocumentDeletedReason is sent to clients of a document room closed
+// because the document was trashed or purged
+const DocumentDeletedReason = "Document was deleted"
 
-func NewRoomRegistry(ctx context.Context, logger *slog.Logger) *RoomRegistry {
+// ProjectDeletedReason is sent to clients of a project that was deleted
+const ProjectDeletedReason = "Project was deleted"
+
+// ErrRoomLimit is returned when creating a room would exceed MaxRooms
+var ErrRoomLimit = errors.New("room limit reached")
+
+const (
+	autoSaveInterval = 30 * time.Second
+	cleanupEvery     = 60 * time.Second
+	autoSaveEvery    = 10 * time.Second // check more often than autoSaveInterval
+)
+
+// NewRoomRegistry creates a registry. Changes to document-backed rooms are
+// published to hub, which may be nil.
+func NewRoomRegistry(ctx context.Context, hub *events.Hub, logger *slog.Logger) *RoomRegistry {
 	ctx, cancel := context.WithCancel(ctx)
 	rr := &RoomRegistry{
+		events: hub,
 		logger: logger,
 		ctx:    ctx,
 		cancel: cancel,
 	}
+	rr.limits.Store(&Limits{})
+	now := time.Now().UnixNano()
+	rr.cleanupTick.Store(now)
+	rr.autoSaveTick.Store(now)
 	go rr.cleanupLoop()
 	go rr.autoSaveLoop()
 	return rr
@@ -35,8 +89,31 @@ func (rr *RoomRegistry) Stop() {
 	rr.cancel()
 }
 
+// CheckLoops reports an error if a background loop has stopped or has been
+// stuck in one pass for several of its intervals, which usually means a room
+// lock is held forever
+func (rr *RoomRegistry) CheckLoops() error {
+	if rr.ctx.Err() != nil {
+		return errors.New("registry stopped")
+	}
+	now := time.Now()
+	for _, loop := range []struct {
+		name  string
+		tick  *atomic.Int64
+		every time.Duration
+	}{
+		{"cleanup", &rr.cleanupTick, cleanupEvery},
+		{"auto-save", &rr.autoSaveTick, autoSaveEvery},
+	} {
+		if since := now.Sub(time.Unix(0, loop.tick.Load())); since > 3*loop.every {
+			return fmt.Errorf("%s loop last ran %s ago", loop.name, since.Round(time.Second))
+		}
+	}
+	return nil
+}
+
 func (rr *RoomRegistry) cleanupLoop() {
-	ticker := time.NewTicker(60 * time.Second)
+	ticker := time.NewTicker(cleanupEvery)
 	defer ticker.Stop()
 
 	for {
@@ -45,6 +122,7 @@ func (rr *RoomRegistry) cleanupLoop() {
 			return
 		case <-ticker.C:
 			rr.cleanupStaleRooms()
+			rr.cleanupTick.Store(time.Now().UnixNano())
 		}
 	}
 }
@@ -56,14 +134,24 @@ func (rr *RoomRegistry) cleanupStaleRooms() {
 		room := value.(*Room)
 		if room.ClientCount() == 0 && room.IsStale(maxEmptyDuration) {
 			rr.rooms.Delete(key)
+			rr.forgetDocumentRoom(room)
 			rr.logger.Info("cleaned up stale room", "roomId", room.ID)
 		}
 		return true
 	})
+
+	rr.projects.Range(func(key, value any) bool {
+		project := value.(*Project)
+		if project.ClientCount() == 0 && project.IsStale(maxEmptyDuration) {
+			rr.projects.Delete(key)
+			rr.logger.Info("cleaned up stale project", "projectId", project.ID)
+		}
+		return true
+	})
 }
 
 func (rr *RoomRegistry) autoSaveLoop() {
-	ticker := time.NewTicker(10 * time.Second) // Check more frequently than auto-save interval
+	ticker := time.NewTicker(autoSaveEvery)
 	defer ticker.Stop()
 
 	for {
@@ -72,6 +160,7 @@ func (rr *RoomRegistry) autoSaveLoop() {
 			return
 		case <-ticker.C:
 			rr.checkAutoSave()
+			rr.autoSaveTick.Store(time.Now().UnixNano())
 		}
 	}
 }
@@ -81,7 +170,7 @@ func (rr *RoomRegistry) checkAutoSave() {
 		room := value.(*Room)
 		// Only auto-save if there are changes and enough time has passed
 		if room.ClientCount() > 0 && room.ShouldAutoSave(autoSaveInterval) {
-			snapshot := room.CreateSnapshot("auto-save", SnapshotAuto, "")
+			snapshot := room.CreateSnapshot(rr.ctx, "auto-save", SnapshotAuto, "")
 			rr.logger.Info("auto-save triggered", "roomId", room.ID, "snapshotId", snapshot.ID)
 
 			// Broadcast to all clients
@@ -109,12 +198,70 @@ func broadcastAutoSave(room *Room, snapshot *Snapshot) {
 	room.Broadcast(data, "")
 }
 
-func (rr *RoomRegistry) CreateRoom(content, language, ownerID string) *Room {
+// SetLimits replaces the quotas; they apply from the next room created or
+// client joining
+func (rr *RoomRegistry) SetLimits(limits Limits) {
+	rr.limits.Store(&limits)
+}
+
+func (rr *RoomRegistry) Limits() Limits {
+	return *rr.limits.Load()
+}
+
+// SetStoreCheck sets how document rooms tell whether their database is
+// reachable. While check reports false they refuse edits with
+// ErrStoreUnavailable; anonymous rooms are unaffected.
+func (rr *RoomRegistry) SetStoreCheck(check func() bool) {
+	rr.storeUp.Store(&check)
+}
+
+func (rr *RoomRegistry) storeAvailable() bool {
+	check := rr.storeUp.Load()
+	return check == nil || (*check)()
+}
+
+// checkRoomLimit is best effort: concurrent creates may overshoot MaxRooms
+// by a few, which is fine for a quota
+func (rr *RoomRegistry) checkRoomLimit() error {
+	max := rr.Limits().MaxRooms
+	if max <= 0 {
+		return nil
+	}
+	open := rr.RoomCount()
+	rr.projects.Range(func(_, value any) bool {
+		// An empty project still holds a slot
+		open += value.(*Project).roomCount()
+		return open < max
+	})
+	if open >= max {
+		return ErrRoomLimit
+	}
+	return nil
+}
+
+func (rr *RoomRegistry) CreateRoom(content, language, ownerID string) (*Room, error) {
+	if err := rr.checkRoomLimit(); err != nil {
+		return nil, err
+	}
 	id := generateRoomID()
 	room := NewRoom(id, content, language, ownerID, rr.logger)
 	rr.rooms.Store(id, room)
 	rr.logger.Info("room created", "roomId", id, "ownerId", ownerID)
-	return room
+	return room, nil
+}
+
+// ForkRoom creates an anonymous room seeded with content taken from parent at version
+func (rr *RoomRegistry) ForkRoom(parent *Room, content string, version int) (*Room, error) {
+	if err := rr.checkRoomLimit(); err != nil {
+		return nil, err
+	}
+	id := generateRoomID()
+	room := NewRoom(id, content, parent.Language, "", rr.logger)
+	room.ParentRoomID = parent.ID
+	room.ParentVersion = version
+	rr.rooms.Store(id, room)
+	rr.logger.Info("room forked", "roomId", id, "parentRoomId", parent.ID, "parentVersion", version)
+	return room, nil
 }
 
 func (rr *RoomRegistry) GetRoom(id string) (*Room, bool) {
@@ -125,9 +272,126 @@ func (rr *RoomRegistry) GetRoom(id string) (*Room, bool) {
 	return val.(*Room), true
 }
 
-func (rr *RoomRegistry) DeleteRoom(id string) {
-	rr.rooms.Delete(id)
-	rr.logger.Info("room deleted", "roomId", id)
+// CloseRoom removes a room and disconnects its clients with reason. It
+// reports false if there was no such room.
+func (rr *RoomRegistry) CloseRoom(id, reason string) bool {
+	val, ok := rr.rooms.LoadAndDelete(id)
+	if !ok {
+		return false
+	}
+	room := val.(*Room)
+	rr.forgetDocumentRoom(room)
+	room.Close(reason)
+	return true
+}
+
+// CloseDocumentRoom closes the live room for a saved document, if one is
+// open, disconnecting its clients with reason
+func (rr *RoomRegistry) CloseDocumentRoom(docID, reason string) bool {
+	room, ok := rr.RoomForDocument(docID)
+	if !ok {
+		return false
+	}
+	return rr.CloseRoom(room.ID, reason)
+}
+
+// OpenDocumentRoom returns the live room for a saved document, creating it
+// from content at version when none is open. history holds the most recent
+// applied batches so submissions against slightly older versions can still
+// be transformed. The second result reports whether the room was created.
+func (rr *RoomRegistry) OpenDocumentRoom(docID, ownerID, language, content string, version int, history []OpHistoryEntry, sink OpSink) (*Room, bool) {
+	if room, ok := rr.RoomForDocument(docID); ok {
+		return room, false
+	}
+
+	room := NewRoom(generateRoomID(), content, language, ownerID, rr.logger)
+	room.DocumentID = docID
+	room.Version = version
+	room.sink = sink
+	room.events = rr.events
+	// Drop a room that fell out of step with the database; reopening the
+	// document loads a fresh one
+	room.onFailed = func(room *Room) {
+		rr.CloseRoom(room.ID, PersistFailedReason)
+	}
+	room.storeUp = rr.storeAvailable
+	if len(history) > OpHistoryLimit {
+		history = history[len(history)-OpHistoryLimit:]
+	}
+	room.opHistory = append(room.opHistory, history...)
+	room.snapshots[0].Version = version
+	// Earlier authorship lives in the persisted op log, not the live room
+	now := time.Now()
+	room.blame.Reset(content, Attribution{Version: version, Timestamp: now})
+	// Nobody has joined yet, so start the hibernation timer straight away
+	room.emptyAt = &now
+
+	if existing, loaded := rr.docRooms.LoadOrStore(docID, room); loaded {
+		return existing.(*Room), false
+	}
+	rr.rooms.Store(room.ID, room)
+	rr.logger.Info("document room opened", "roomId", room.ID, "documentId", docID, "version", version)
+	return room, true
+}
+
+// RoomForDocument returns the live room for a saved document, if one is open
+func (rr *RoomRegistry) RoomForDocument(docID string) (*Room, bool) {
+	val, ok := rr.docRooms.Load(docID)
+	if !ok {
+		return nil, false
+	}
+	return val.(*Room), true
+}
+
+func (rr *RoomRegistry) forgetDocumentRoom(room *Room) {
+	if room.DocumentID != "" && rr.docRooms.CompareAndDelete(room.DocumentID, room) {
+		room.closed()
+	}
+}
+
+// CreateProject creates an empty project, or returns ErrRoomLimit when
+// MaxRooms are already open
+func (rr *RoomRegistry) CreateProject(ownerID string) (*Project, error) {
+	if err := rr.checkRoomLimit(); err != nil {
+		return nil, err
+	}
+	id := generateRoomID()
+	project := NewProject(id, ownerID, rr.logger)
+	rr.projects.Store(id, project)
+	rr.logger.Info("project created", "projectId", id, "ownerId", ownerID)
+	return project, nil
+}
+
+func (rr *RoomRegistry) GetProject(id string) (*Project, bool) {
+	val, ok := rr.projects.Load(id)
+	if !ok {
+		return nil, false
+	}
+	return val.(*Project), true
+}
+
+// CloseProject removes a project, retiring its files and disconnecting its
+// clients with reason. It reports false if there was no such project.
+func (rr *RoomRegistry) CloseProject(id, reason string) bool {
+	val, ok := rr.projects.LoadAndDelete(id)
+	if !ok {
+		return false
+	}
+	val.(*Project).Close(reason)
+	return true
+}
+
+// Rooms returns the open rooms, oldest first
+func (rr *RoomRegistry) Rooms() []*Room {
+	var rooms []*Room
+	rr.rooms.Range(func(_, value any) bool {
+		rooms = append(rooms, value.(*Room))
+		return true
+	})
+	sort.Slice(rooms, func(i, j int) bool {
+		return rooms[i].CreatedAt.Before(rooms[j].CreatedAt)
+	})
+	return rooms
 }
 
 func (rr *RoomRegistry) RoomCount() int {
@@ -139,6 +403,20 @@ func (rr *RoomRegistry) RoomCount() int {
 	return count
 }
 
+// ClientCount returns the clients connected to rooms and projects
+func (rr *RoomRegistry) ClientCount() int {
+	count := 0
+	rr.rooms.Range(func(_, value any) bool {
+		count += value.(*Room).ClientCount()
+		return true
+	})
+	rr.projects.Range(func(_, value any) bool {
+		count += value.(*Project).ClientCount()
+		return true
+	})
+	return count
+}
+
 func generateRoomID() string {
 	bytes := make([]byte, 6)
 	rand.Read(bytes)
@@ -4,12 +4,21 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log/slog"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"nhooyr.io/websocket"
+
+	"github.com/NoumanAMalik/maple/apps/collab/internal/events"
+	"github.com/NoumanAMalik/maple/apps/collab/internal/metrics"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // SnapshotType represents the type of snapshot
@@ -30,6 +39,7 @@ type Snapshot struct {
 	CreatedBy string       `json:"createdBy"`
 	Type      SnapshotType `json:"type"`
 	Message   string       `json:"message,omitempty"`
+	Version   int          `json:"version"`
 	// Computed diff stats from previous snapshot
 	LinesAdded   int `json:"linesAdded"`
 	LinesRemoved int `json:"linesRemoved"`
@@ -63,10 +73,13 @@ const maxSnapshots = 50
 
 type Client struct {
 	ID          string
+	UserID      string
 	DisplayName string
 	Color       string
 	Conn        *websocket.Conn
 	Room        *Room
+	Project     *Project // set instead of Room for project connections
+	JoinedAt    time.Time
 	send        chan []byte
 	ctx         context.Context
 	cancel      context.CancelFunc
@@ -82,22 +95,49 @@ type Room struct {
 	CreatedAt time.Time
 	OwnerID   string
 
+	// Set for rooms backed by a saved document; applied ops go to sink and
+	// changes are published to events
+	DocumentID string
+	sink       OpSink
+	events     *events.Hub
+	// failed is set once a batch could not be persisted; the room may then
+	// disagree with the database, so it takes no more edits and onFailed
+	// is called to replace it
+	failed   bool
+	onFailed func(*Room)
+	// storeUp reports whether sink's database is reachable; while it is
+	// not, edits are refused up front instead of failing to save
+	storeUp func() bool
+	// retired is set once the room is closed; batches racing the close are
+	// refused
+	retired bool
+	// maxContentBytes, when positive, caps how large Content may grow
+	maxContentBytes int
+
+	// Set when the room was forked from another room
+	ParentRoomID  string
+	ParentVersion int
+
 	clients   sync.Map // map[string]*Client
 	mu        sync.RWMutex
 	logger    *slog.Logger
 	opHistory []OpHistoryEntry
+	blame     *BlameTracker
 
 	// Snapshot-related fields
 	snapshots           []*Snapshot
 	originalContent     string    // Content when sharing started
 	lastAutoSave        time.Time // Last auto-save timestamp
 	contentChangedSince bool      // Track if content changed since last snapshot
+	lastEditAt          time.Time
 
 	// Hibernation: track when room became empty for cleanup
 	emptyAt *time.Time
 }
 
-const opHistoryLimit = 256
+// OpHistoryLimit is how many applied batches a room keeps for transforming
+// batches submitted against older versions
+const OpHistoryLimit = 256
 
 func NewRoom(id, content, language, ownerID string, logger *slog.Logger) *Room {
 	now := time.Now()
@@ -109,10 +149,12 @@ func NewRoom(id, content, language, ownerID string, logger *slog.Logger) *Room {
 		CreatedAt:       now,
 		OwnerID:         ownerID,
 		logger:          logger,
-		opHistory:       make([]OpHistoryEntry, 0, opHistoryLimit),
+		opHistory:       make([]OpHistoryEntry, 0, OpHistoryLimit),
+		blame:           NewBlameTracker(content, Attribution{UserID: ownerID, Timestamp: now}),
 		snapshots:       make([]*Snapshot, 0, maxSnapshots),
 		originalContent: content,
 		lastAutoSave:    now,
+		lastEditAt:      now,
 	}
 
 	// Create the initial snapshot
@@ -125,20 +167,41 @@ func (r *Room) AddClient(client *Client) {
 	r.clients.Store(client.ID, client)
 	r.mu.Lock()
 	r.emptyAt = nil // Wake up room from hibernation
+	version, participants := r.Version, r.clientCountLocked()
 	r.mu.Unlock()
 	r.logger.Info("client joined room", "roomId", r.ID, "clientId", client.ID)
+	r.publish(version, EventJoin, ParticipantEvent{Actor: client.actor(), Participants: participants})
 }
 
 func (r *Room) RemoveClient(clientID string) {
-	r.clients.Delete(clientID)
+	val, loaded := r.clients.LoadAndDelete(clientID)
 	r.mu.Lock()
-	if r.clientCountLocked() == 0 {
+	participants := r.clientCountLocked()
+	if participants == 0 {
 		now := time.Now()
 		r.emptyAt = &now
 		r.logger.Info("room now empty, starting hibernation timer", "roomId", r.ID)
 	}
+	version := r.Version
 	r.mu.Unlock()
 	r.logger.Info("client left room", "roomId", r.ID, "clientId", clientID)
+	if loaded {
+		r.publish(version, EventLeave, ParticipantEvent{Actor: val.(*Client).actor(), Participants: participants})
+	}
+}
+
+// isOwnedBy reports whether client owns the room. A document room belongs to
+// the signed-in owner of its document; client IDs are chosen by the client,
+// so only an anonymous room, whose owner is the client that created it, is
+// matched on one.
+func (r *Room) isOwnedBy(client *Client) bool {
+	if r.OwnerID == "" {
+		return false
+	}
+	if r.DocumentID != "" {
+		return client.UserID != "" && r.OwnerID == client.UserID
+	}
+	return r.OwnerID == client.ID
 }
 
 func (r *Room) GetClient(clientID string) (*Client, bool) {
@@ -173,6 +236,7 @@ func (r *Room) Broadcast(msg []byte, excludeClientID string) {
 		select {
 		case client.send <- msg:
 		default:
+			metrics.DroppedMessages.Inc()
 			r.logger.Warn("client send buffer full, dropping message", "clientId", client.ID)
 		}
 		return true
@@ -191,38 +255,132 @@ func (r *Room) GetVersion() int {
 	return r.Version
 }
 
+// State returns the content together with the version it belongs to
+func (r *Room) State() (string, int) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.Content, r.Version
+}
+
+// RoomInfo is a point-in-time summary of a room for operators
+type RoomInfo struct {
+	Version       int
+	ContentBytes  int
+	SnapshotCount int
+	Clients       int
+	LastEditAt    time.Time
+	// EmptySince is set while nobody is connected
+	EmptySince *time.Time
+}
+
+// Info summarizes the room under a single lock
+func (r *Room) Info() RoomInfo {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	info := RoomInfo{
+		Version:       r.Version,
+		ContentBytes:  len(r.Content),
+		SnapshotCount: len(r.snapshots),
+		Clients:       r.clientCountLocked(),
+		LastEditAt:    r.lastEditAt,
+	}
+	if r.emptyAt != nil {
+		emptySince := *r.emptyAt
+		info.EmptySince = &emptySince
+	}
+	return info
+}
+
+// OpBatch is a batch of operations submitted against a base version
+type OpBatch struct {
+	ClientID    string
+	UserID      string
+	OpID        string
+	BaseVersion int
+	Ops         []Operation
+	Presence    *Presence
+}
+
 func (r *Room) ApplyOpBatch(clientID, opID string, baseVersion int, ops []Operation) ([]Operation, int, error) {
-	if len(ops) == 0 {
+	return r.Apply(context.Background(), OpBatch{
+		ClientID:    clientID,
+		OpID:        opID,
+		BaseVersion: baseVersion,
+		Ops:         ops,
+	})
+}
+
+// Apply transforms a batch against the ops applied since its base version,
+// applies it and, for document-backed rooms, persists the result
+func (r *Room) Apply(ctx context.Context, batch OpBatch) ([]Operation, int, error) {
+	if len(batch.Ops) == 0 {
 		return nil, r.GetVersion(), errors.New("empty operation batch")
 	}
 
-	r.mu.Lock()
-	defer r.mu.Unlock()
+	ctx, span := tracer.Start(ctx, "room.apply", trace.WithAttributes(append(roomAttributes(r),
+		attribute.Int("collab.base_version", batch.BaseVersion),
+		attribute.Int("collab.ops", len(batch.Ops)),
+	)...))
+	defer span.End()
+
+	start := time.Now()
+	metrics.OpBatchSize.Observe(float64(len(batch.Ops)))
 
-	if baseVersion > r.Version {
-		return nil, r.Version, ErrResyncRequired
+	r.lock(ctx)
+	entry, err := r.applyLocked(ctx, batch)
+	version := r.Version
+	r.mu.Unlock()
+	if errors.Is(err, ErrDuplicateBatch) {
+		return entry.Ops, entry.Version, err
 	}
+	if err != nil {
+		if errors.Is(err, ErrResyncRequired) {
+			metrics.Resyncs.Inc()
+		}
+		recordError(span, err)
+		return nil, version, err
+	}
+	span.SetAttributes(attribute.Int("collab.version", entry.Version))
 
-	historyStartVersion := r.Version - len(r.opHistory)
-	if baseVersion < historyStartVersion {
-		return nil, r.Version, ErrResyncRequired
+	metrics.OpsApplied.Add(float64(len(entry.Ops)))
+	metrics.OpBatchDuration.Observe(time.Since(start).Seconds())
+
+	return entry.Ops, entry.Version, nil
+}
+
+// applyLocked transforms and applies batch, persisting it before it becomes
+// the room's state so nothing is acknowledged that the database lacks
+func (r *Room) applyLocked(ctx context.Context, batch OpBatch) (OpHistoryEntry, error) {
+	if r.retired {
+		return OpHistoryEntry{}, ErrRoomClosed
+	}
+	if r.failed {
+		return OpHistoryEntry{}, ErrPersistFailed
+	}
+	if batch.OpID != "" {
+		for _, entry := range r.opHistory {
+			if entry.OpID == batch.OpID && entry.ClientID == batch.ClientID {
+				return entry, ErrDuplicateBatch
+			}
+		}
+	}
+	if batch.BaseVersion > r.Version {
+		return OpHistoryEntry{}, ErrResyncRequired
 	}
 
-	transformed := make([]Operation, 0, len(ops))
-	for _, op := range ops {
-		transformed = append(transformed, op)
+	historyStartVersion := r.Version - len(r.opHistory)
+	if batch.BaseVersion < historyStartVersion {
+		return OpHistoryEntry{}, ErrResyncRequired
 	}
 
+	_, transformSpan := tracer.Start(ctx, "room.transform")
+	transformed := batch.Ops
 	for _, entry := range r.opHistory {
-		if entry.Version <= baseVersion {
+		if entry.Version <= batch.BaseVersion {
 			continue
 		}
-		for i, incoming := range transformed {
-			for _, historyOp := range entry.Ops {
-				incoming = transformOperation(incoming, historyOp, clientID, entry.ClientID)
-			}
-			transformed[i] = incoming
-		}
+		transformed = TransformBatch(transformed, entry.Ops, batch.ClientID, entry.ClientID)
 	}
 
 	filtered := make([]Operation, 0, len(transformed))
@@ -232,25 +390,134 @@ func (r *Room) ApplyOpBatch(clientID, opID string, baseVersion int, ops []Operat
 		}
 	}
 
-	updated, err := applyOperations(r.Content, filtered)
+	updated, err := ApplyOperations(r.Content, filtered)
+	transformSpan.End()
 	if err != nil {
-		return nil, r.Version, err
+		return OpHistoryEntry{}, err
+	}
+	if r.maxContentBytes > 0 && len(updated) > r.maxContentBytes && len(updated) > len(r.Content) {
+		return OpHistoryEntry{}, ErrContentTooLarge
 	}
 
-	r.Version++
-	r.Content = updated
-	r.opHistory = append(r.opHistory, OpHistoryEntry{
-		Version:  r.Version,
+	entry := r.nextEntryLocked(OpHistoryEntry{
 		Ops:      filtered,
-		ClientID: clientID,
-		OpID:     opID,
+		ClientID: batch.ClientID,
+		UserID:   batch.UserID,
+		OpID:     batch.OpID,
+		Presence: batch.Presence,
+	})
+	if err := r.persistLocked(ctx, entry); err != nil {
+		return OpHistoryEntry{}, err
+	}
+	r.commitLocked(updated, entry)
+	r.publishOp(entry)
+	return entry, nil
+}
+
+// nextEntryLocked stamps entry with the version it will be committed as
+func (r *Room) nextEntryLocked(entry OpHistoryEntry) OpHistoryEntry {
+	entry.Version = r.Version + 1
+	entry.AppliedAt = time.Now()
+	return entry
+}
+
+// commitLocked makes content, produced by applying a stamped entry's ops,
+// the room's state and appends the entry to the op history
+func (r *Room) commitLocked(content string, entry OpHistoryEntry) {
+	r.Content = content
+	r.Version = entry.Version
+	r.lastEditAt = entry.AppliedAt
+
+	r.opHistory = append(r.opHistory, entry)
+	r.blame.Apply(entry.Ops, Attribution{
+		UserID:    entry.UserID,
+		ClientID:  entry.ClientID,
+		Version:   entry.Version,
+		Timestamp: entry.AppliedAt,
+	})
+
+	if len(r.opHistory) > OpHistoryLimit {
+		r.opHistory = r.opHistory[len(r.opHistory)-OpHistoryLimit:]
+	}
+}
+
+// persistLocked hands a stamped entry to the room's op sink, if it has one.
+// Once the lock is held the write goes ahead even if ctx is cancelled. A
+// failed write may still have landed in part, so the room is marked failed
+// and handed to onFailed.
+func (r *Room) persistLocked(ctx context.Context, entry OpHistoryEntry) error {
+	if r.sink == nil {
+		return nil
+	}
+	if r.storeUp != nil && !r.storeUp() {
+		return ErrStoreUnavailable
+	}
+
+	ctx, span := tracer.Start(ctx, "room.persist")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+	if err := r.sink.PersistOps(ctx, r.DocumentID, entry); err != nil {
+		recordError(span, err)
+		r.logger.Error("persist ops failed", "roomId", r.ID, "documentId", r.DocumentID, "version", entry.Version, "error", err)
+		r.failed = true
+		if r.onFailed != nil {
+			// Closing waits on every client, including the one whose op
+			// is being applied, so it cannot happen under the lock
+			go r.onFailed(r)
+		}
+		return fmt.Errorf("%w: %w", ErrPersistFailed, err)
+	}
+	return nil
+}
+
+// publishOp publishes an applied entry to the room's event feed. It is
+// called under the lock that committed the entry so the feed sees versions
+// in order.
+func (r *Room) publishOp(entry OpHistoryEntry) {
+	r.publish(entry.Version, EventOp, OpEvent{
+		Version:  entry.Version,
+		OpID:     entry.OpID,
+		ClientID: entry.ClientID,
+		UserID:   entry.UserID,
+		Ops:      entry.Ops,
 	})
+}
 
-	if len(r.opHistory) > opHistoryLimit {
-		r.opHistory = r.opHistory[len(r.opHistory)-opHistoryLimit:]
+// closed tells the room's sink, if it asked, that the room was closed
+func (r *Room) closed() {
+	closer, ok := r.sink.(RoomCloser)
+	if !ok {
+		return
 	}
 
-	return filtered, r.Version, nil
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := closer.DocumentRoomClosed(ctx, r.DocumentID, r.GetVersion()); err != nil {
+		r.logger.Error("report room closed failed", "roomId", r.ID, "documentId", r.DocumentID, "error", err)
+	}
+}
+
+// BroadcastRemoteOp sends applied ops to every client except excludeClientID
+func (r *Room) BroadcastRemoteOp(actor ActorInfo, version int, ops []Operation, excludeClientID string) {
+	// Sent even when the batch transformed away to nothing, so clients see
+	// every version and can tell a gap from a message still on its way
+	if ops == nil {
+		ops = []Operation{}
+	}
+
+	remote := RemoteOpMessage{
+		V:       1,
+		T:       "remote_op",
+		Version: version,
+		Actor:   actor,
+		Ops:     ops,
+	}
+	data, err := json.Marshal(remote)
+	if err != nil {
+		return
+	}
+	r.Broadcast(data, excludeClientID)
 }
 
 type PresenceInfo struct {
@@ -265,6 +532,7 @@ type ActorInfo struct {
 }
 
 type Presence struct {
+	FileID    string     `json:"fileId,omitempty"` // project connections only
 	Cursor    *Position  `json:"cursor,omitempty"`
 	Selection *Selection `json:"selection,omitempty"`
 }
@@ -279,6 +547,54 @@ type Selection struct {
 	End   Position `json:"end"`
 }
 
+// Participant describes a connected client for operators
+type Participant struct {
+	ActorInfo
+	UserID   string
+	JoinedAt time.Time
+	Presence *Presence
+}
+
+// Participants returns the connected clients, longest connected first
+func (r *Room) Participants() []Participant {
+	var participants []Participant
+	r.clients.Range(func(_, value any) bool {
+		client := value.(*Client)
+		participants = append(participants, Participant{
+			ActorInfo: client.actor(),
+			UserID:    client.UserID,
+			JoinedAt:  client.JoinedAt,
+			Presence:  client.GetPresence(),
+		})
+		return true
+	})
+	sort.Slice(participants, func(i, j int) bool {
+		return participants[i].JoinedAt.Before(participants[j].JoinedAt)
+	})
+	return participants
+}
+
+// Close disconnects every client, sending each a room_closed message with
+// reason first. Remove the room from the registry before closing it so
+// nobody can rejoin.
+func (r *Room) Close(reason string) {
+	r.retire()
+	msg, _ := json.Marshal(RoomClosedMessage{V: 1, T: "room_closed", Reason: reason})
+
+	var wg sync.WaitGroup
+	r.clients.Range(func(_, value any) bool {
+		client := value.(*Client)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			client.closeWith(msg, reason)
+		}()
+		return true
+	})
+	wg.Wait()
+	r.logger.Info("room closed", "roomId", r.ID, "reason", reason)
+}
+
 func (r *Room) GetPresenceList(excludeClientID string) []PresenceInfo {
 	var presence []PresenceInfo
 	r.clients.Range(func(key, value any) bool {
@@ -314,6 +630,16 @@ func (c *Client) UpdatePresence(cursor *Position, selection *Selection) {
 	}
 }
 
+func (c *Client) actor() ActorInfo {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return ActorInfo{
+		ClientID:    c.ID,
+		DisplayName: c.DisplayName,
+		Color:       c.Color,
+	}
+}
+
 func (c *Client) UpdateDisplayName(name string) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -359,6 +685,22 @@ func (c *Client) WriteLoop() {
 	}
 }
 
+// closeWith writes msg directly, ahead of anything still queued, and closes
+// the connection. The close frame carries as much of reason as fits.
+func (c *Client) closeWith(msg []byte, reason string) {
+	ctx, cancel := context.WithTimeout(c.ctx, 5*time.Second)
+	_ = c.Conn.Write(ctx, websocket.MessageText, msg)
+	cancel()
+
+	if len(reason) > maxCloseReason {
+		reason = reason[:maxCloseReason]
+	}
+	c.Conn.Close(websocket.StatusGoingAway, reason)
+}
+
+// maxCloseReason is the most a close frame's reason can hold, in bytes
+const maxCloseReason = 123
+
 func (c *Client) Send(msg any) error {
 	data, err := json.Marshal(msg)
 	if err != nil {
@@ -368,6 +710,7 @@ func (c *Client) Send(msg any) error {
 	case c.send <- data:
 		return nil
 	default:
+		metrics.DroppedMessages.Inc()
 		return nil // buffer full, drop message
 	}
 }
@@ -404,23 +747,38 @@ func (r *Room) createInitialSnapshot(content string) *Snapshot {
 	return snapshot
 }
 
+// SaveSnapshot creates a manual snapshot and tells every client about it
+func (r *Room) SaveSnapshot(ctx context.Context, createdBy, message string) *Snapshot {
+	snapshot := r.CreateSnapshot(ctx, createdBy, SnapshotManual, message)
+
+	data, _ := json.Marshal(SnapshotCreatedMessage{
+		V:        1,
+		T:        "snapshot_created",
+		Snapshot: *snapshot,
+	})
+	r.Broadcast(data, "")
+	return snapshot
+}
+
 // CreateSnapshot creates a new snapshot of the current document state
-func (r *Room) CreateSnapshot(createdBy string, snapType SnapshotType, message string) *Snapshot {
-	r.mu.Lock()
+func (r *Room) CreateSnapshot(ctx context.Context, createdBy string, snapType SnapshotType, message string) *Snapshot {
+	r.lock(ctx)
 	defer r.mu.Unlock()
 
-	return r.createSnapshotLocked(createdBy, snapType, message)
+	return r.createSnapshotLocked(ctx, createdBy, snapType, message)
 }
 
 // createSnapshotLocked creates a snapshot (must be called with lock held)
-func (r *Room) createSnapshotLocked(createdBy string, snapType SnapshotType, message string) *Snapshot {
+func (r *Room) createSnapshotLocked(ctx context.Context, createdBy string, snapType SnapshotType, message string) *Snapshot {
 	now := time.Now()
 
 	// Compute diff stats from previous snapshot
 	var linesAdded, linesRemoved int
 	if len(r.snapshots) > 0 {
 		prevContent := r.snapshots[len(r.snapshots)-1].Content
-		diff := computeLineDiff(prevContent, r.Content)
+		_, span := tracer.Start(ctx, "room.diff")
+		diff := ComputeLineDiff(prevContent, r.Content)
+		span.End()
 		linesAdded = diff.LinesAdded
 		linesRemoved = diff.LinesRemoved
 	}
@@ -432,11 +790,13 @@ func (r *Room) createSnapshotLocked(createdBy string, snapType SnapshotType, mes
 		CreatedBy:    createdBy,
 		Type:         snapType,
 		Message:      message,
+		Version:      r.Version,
 		LinesAdded:   linesAdded,
 		LinesRemoved: linesRemoved,
 	}
 
 	r.snapshots = append(r.snapshots, snapshot)
+	metrics.Snapshots.WithLabelValues(string(snapType)).Inc()
 	r.lastAutoSave = now
 	r.contentChangedSince = false
 
@@ -451,6 +811,12 @@ func (r *Room) createSnapshotLocked(createdBy string, snapType SnapshotType, mes
 		"type", snapType,
 		"linesAdded", linesAdded,
 		"linesRemoved", linesRemoved)
+	r.publish(snapshot.Version, EventSnapshot, SnapshotEvent{
+		SnapshotID: snapshot.ID,
+		Version:    snapshot.Version,
+		Type:       snapType,
+		CreatedBy:  createdBy,
+	})
 
 	return snapshot
 }
@@ -468,6 +834,7 @@ func (r *Room) GetSnapshots() []Snapshot {
 			CreatedBy:    s.CreatedBy,
 			Type:         s.Type,
 			Message:      s.Message,
+			Version:      s.Version,
 			LinesAdded:   s.LinesAdded,
 			LinesRemoved: s.LinesRemoved,
 			// Omit Content to reduce payload size
@@ -489,6 +856,63 @@ func (r *Room) GetSnapshotByID(snapshotID string) (*Snapshot, bool) {
 	return nil, false
 }
 
+// ContentAtVersion rebuilds the content at version by replaying the op
+// history from the newest snapshot at or before it. It reports false when
+// the version is in the future or older than the history the room keeps.
+func (r *Room) ContentAtVersion(version int) (string, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if version == r.Version {
+		return r.Content, true
+	}
+	if version < 0 || version > r.Version {
+		return "", false
+	}
+
+	var base *Snapshot
+	for _, s := range r.snapshots {
+		if s.Version <= version && (base == nil || s.Version >= base.Version) {
+			base = s
+		}
+	}
+	if base == nil {
+		return "", false
+	}
+	if base.Version == version {
+		return base.Content, true
+	}
+
+	// The history must run unbroken from the snapshot to version
+	historyStartVersion := r.Version - len(r.opHistory)
+	if base.Version < historyStartVersion {
+		return "", false
+	}
+
+	content := base.Content
+	for _, entry := range r.opHistory {
+		if entry.Version <= base.Version {
+			continue
+		}
+		if entry.Version > version {
+			break
+		}
+		updated, err := ApplyOperations(content, entry.Ops)
+		if err != nil {
+			return "", false
+		}
+		content = updated
+	}
+	return content, true
+}
+
+// retire stops the room taking any more batches
+func (r *Room) retire() {
+	r.mu.Lock()
+	r.retired = true
+	r.mu.Unlock()
+}
+
 // GetOriginalContent returns the original content when sharing started
 func (r *Room) GetOriginalContent() string {
 	r.mu.RLock()
@@ -497,9 +921,8 @@ func (r *Room) GetOriginalContent() string {
 }
 
 // RestoreToSnapshot restores the room content to a specific snapshot
-func (r *Room) RestoreToSnapshot(snapshotID string) (*Snapshot, error) {
-	r.mu.Lock()
-	defer r.mu.Unlock()
+func (r *Room) RestoreToSnapshot(ctx context.Context, snapshotID string) (*Snapshot, error) {
+	r.lock(ctx)
 
 	var targetSnapshot *Snapshot
 	for _, s := range r.snapshots {
@@ -510,18 +933,36 @@ func (r *Room) RestoreToSnapshot(snapshotID string) (*Snapshot, error) {
 	}
 
 	if targetSnapshot == nil {
+		r.mu.Unlock()
 		return nil, errors.New("snapshot not found")
 	}
+	if r.failed {
+		r.mu.Unlock()
+		return nil, ErrPersistFailed
+	}
 
 	// Create a pre-restore snapshot if there are unsaved changes
 	if r.contentChangedSince {
-		r.createSnapshotLocked("system", SnapshotPreClose, "Pre-restore backup")
+		r.createSnapshotLocked(ctx, "system", SnapshotPreClose, "Pre-restore backup")
 	}
 
-	// Restore content
-	r.Content = targetSnapshot.Content
-	r.Version++
+	// Restore content as an op batch so OT history, attribution and the
+	// persisted op log all see the change
+	entry := r.nextEntryLocked(OpHistoryEntry{
+		Ops:      DiffToOps(r.Content, targetSnapshot.Content),
+		ClientID: r.OwnerID,
+		UserID:   r.OwnerID,
+		OpID:     "restore-" + snapshotID + "-" + strconv.Itoa(r.Version+1),
+	})
+	if err := r.persistLocked(ctx, entry); err != nil {
+		r.mu.Unlock()
+		return nil, err
+	}
+	r.commitLocked(targetSnapshot.Content, entry)
 	r.contentChangedSince = false
+	r.publishOp(entry)
+	r.publish(entry.Version, EventRestore, RestoreEvent{SnapshotID: snapshotID, Version: entry.Version})
+	r.mu.Unlock()
 
 	r.logger.Info("restored to snapshot",
 		"roomId", r.ID,
@@ -531,8 +972,8 @@ func (r *Room) RestoreToSnapshot(snapshotID string) (*Snapshot, error) {
 }
 
 // GetDiff computes the diff between two snapshots
-func (r *Room) GetDiff(snapshot1ID, snapshot2ID string) (*DiffResult, error) {
-	r.mu.RLock()
+func (r *Room) GetDiff(ctx context.Context, snapshot1ID, snapshot2ID string) (*DiffResult, error) {
+	r.rlock(ctx)
 	defer r.mu.RUnlock()
 
 	var content1, content2 string
@@ -571,10 +1012,19 @@ func (r *Room) GetDiff(snapshot1ID, snapshot2ID string) (*DiffResult, error) {
 		return nil, errors.New("snapshot2 not found")
 	}
 
-	diff := computeLineDiff(content1, content2)
+	_, span := tracer.Start(ctx, "room.diff")
+	diff := ComputeLineDiff(content1, content2)
+	span.End()
 	return &diff, nil
 }
 
+// GetBlame returns per-line attribution of the current content
+func (r *Room) GetBlame() []BlameLine {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.blame.Lines(r.Content)
+}
+
 // CleanupSnapshots removes all snapshots except the last one (called when room ends)
 func (r *Room) CleanupSnapshots() {
 	r.mu.Lock()
@@ -618,8 +1068,8 @@ func (r *Room) ShouldAutoSave(interval time.Duration) bool {
 	return r.contentChangedSince && time.Since(r.lastAutoSave) >= interval
 }
 
-// computeLineDiff computes a line-based diff between two strings
-func computeLineDiff(oldContent, newContent string) DiffResult {
+// ComputeLineDiff computes a line-based diff between two strings
+func ComputeLineDiff(oldContent, newContent string) DiffResult {
 	oldLines := splitLines(oldContent)
 	newLines := splitLines(newContent)
 
@@ -720,10 +1170,33 @@ func splitLines(content string) []string {
 	return strings.Split(content, "\n")
 }
 
+// maxLCSCells bounds the table computeLCS builds. When the lines that differ
+// between the inputs would need more, they are treated as having nothing in
+// common, which callers see as one replaced block.
+const maxLCSCells = 4 << 20
+
 // computeLCS computes the longest common subsequence of two string slices
 func computeLCS(a, b []string) []string {
+	// Lines shared at either end are always part of a longest subsequence,
+	// so only the middle needs the quadratic table
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	lcs := make([]string, 0, prefix+suffix)
+	lcs = append(lcs, a[:prefix]...)
+	lcs = append(lcs, middleLCS(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	return append(lcs, a[len(a)-suffix:]...)
+}
+
+func middleLCS(a, b []string) []string {
 	m, n := len(a), len(b)
-	if m == 0 || n == 0 {
+	if m == 0 || n == 0 || m*n > maxLCSCells {
 		return []string{}
 	}
 
@@ -744,12 +1217,12 @@ func computeLCS(a, b []string) []string {
 		}
 	}
 
-	// Backtrack to find the LCS
+	// Backtrack to find the LCS, collected back to front
 	lcs := make([]string, 0, dp[m][n])
 	i, j := m, n
 	for i > 0 && j > 0 {
 		if a[i-1] == b[j-1] {
-			lcs = append([]string{a[i-1]}, lcs...)
+			lcs = append(lcs, a[i-1])
 			i--
 			j--
 		} else if dp[i-1][j] > dp[i][j-1] {
@@ -758,6 +1231,7 @@ func computeLCS(a, b []string) []string {
 			j--
 		}
 	}
+	slices.Reverse(lcs)
 
 	return lcs
 }
@@ -0,0 +1,134 @@
+package collab
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"sync/atomic"
+	"testing"
+)
+
+// recordingSink counts persisted batches
+type recordingSink struct {
+	persisted atomic.Int32
+}
+
+func (s *recordingSink) PersistOps(context.Context, string, OpHistoryEntry) error {
+	s.persisted.Add(1)
+	return nil
+}
+
+func TestDocumentRoomRefusesEditsWhileStoreIsDown(t *testing.T) {
+	registry := NewRoomRegistry(context.Background(), nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	defer registry.Stop()
+	var storeUp atomic.Bool
+	registry.SetStoreCheck(storeUp.Load)
+
+	sink := &recordingSink{}
+	room, _ := registry.OpenDocumentRoom("doc", "owner", "plaintext", "abc", 7, nil, sink)
+	batch := OpBatch{ClientID: "alice", OpID: "op_1", BaseVersion: 7, Ops: []Operation{{Type: OpInsert, Pos: 3, Text: "d"}}}
+
+	if _, version, err := room.Apply(context.Background(), batch); !errors.Is(err, ErrStoreUnavailable) || version != 7 {
+		t.Fatalf("Apply while down = version %d, %v; want version 7, ErrStoreUnavailable", version, err)
+	}
+	if content, _ := room.State(); content != "abc" || sink.persisted.Load() != 0 {
+		t.Fatalf("refused batch changed the room: %q, %d persisted", content, sink.persisted.Load())
+	}
+
+	// Anonymous rooms have nothing to save and keep working
+	anon, err := registry.CreateRoom("abc", "plaintext", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := anon.Apply(context.Background(), OpBatch{ClientID: "alice", OpID: "op_1", Ops: batch.Ops}); err != nil {
+		t.Fatalf("anonymous room Apply = %v", err)
+	}
+
+	storeUp.Store(true)
+	if _, version, err := room.Apply(context.Background(), batch); err != nil || version != 8 {
+		t.Fatalf("Apply after recovery = version %d, %v; want version 8", version, err)
+	}
+	if _, ok := registry.RoomForDocument("doc"); !ok {
+		t.Error("room was closed by the outage")
+	}
+}
+
+func TestDocumentRoomOwnerIsMatchedOnUserID(t *testing.T) {
+	registry := NewRoomRegistry(context.Background(), nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	defer registry.Stop()
+
+	room, _ := registry.OpenDocumentRoom("doc", "owner-uuid", "plaintext", "", 0, nil, nil)
+	if room.isOwnedBy(&Client{ID: "owner-uuid"}) {
+		t.Error("a client ID equal to the owner's user ID was taken as the owner")
+	}
+	if !room.isOwnedBy(&Client{ID: "tab-1", UserID: "owner-uuid"}) {
+		t.Error("the signed-in owner was not recognised")
+	}
+
+	anon, err := registry.CreateRoom("", "plaintext", "creator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !anon.isOwnedBy(&Client{ID: "creator"}) || anon.isOwnedBy(&Client{ID: "other", UserID: "creator"}) {
+		t.Error("anonymous room ownership should follow the creating client ID")
+	}
+}
+
+func TestClosedProjectRetiresFilesAndRefusesJoins(t *testing.T) {
+	registry := NewRoomRegistry(context.Background(), nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	defer registry.Stop()
+
+	project, err := registry.CreateProject("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	node, err := project.AddPath("main.go", "package main", "go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, _ := project.File(node.ID)
+
+	if !registry.CloseProject(project.ID, ProjectDeletedReason) {
+		t.Fatal("CloseProject reported no such project")
+	}
+	if _, ok := registry.GetProject(project.ID); ok {
+		t.Error("closed project is still registered")
+	}
+	if _, _, err := file.Apply(context.Background(), OpBatch{ClientID: "alice", OpID: "op_1", Ops: []Operation{{Type: OpInsert, Pos: 0, Text: "x"}}}); !errors.Is(err, ErrRoomClosed) {
+		t.Errorf("Apply to a file of a closed project = %v, want ErrRoomClosed", err)
+	}
+	if project.AddClient(&Client{ID: "late"}) {
+		t.Error("a client joined a closed project")
+	}
+	if registry.CloseProject(project.ID, ProjectDeletedReason) {
+		t.Error("closing the project twice reported success")
+	}
+}
+
+func TestProjectsCountTowardsRoomLimit(t *testing.T) {
+	registry := NewRoomRegistry(context.Background(), nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	defer registry.Stop()
+	registry.SetLimits(Limits{MaxRooms: 3})
+
+	if _, err := registry.CreateRoom("", "plaintext", ""); err != nil {
+		t.Fatal(err)
+	}
+	project, err := registry.CreateProject("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"a.go", "b.go"} {
+		if _, err := project.AddPath(path, "", "go"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// One room and a project with two files fill all three slots
+	if _, err := registry.CreateProject(""); !errors.Is(err, ErrRoomLimit) {
+		t.Errorf("CreateProject at the limit = %v, want ErrRoomLimit", err)
+	}
+	if _, err := registry.CreateRoom("", "plaintext", ""); !errors.Is(err, ErrRoomLimit) {
+		t.Errorf("CreateRoom at the limit = %v, want ErrRoomLimit", err)
+	}
+}
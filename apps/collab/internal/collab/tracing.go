@@ -0,0 +1,70 @@
+package collab
+
+import (
+	"context"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/NoumanAMalik/maple/apps/collab/internal/collab")
+
+// startMessageSpan starts the span for one WebSocket message. A connection
+// can stay open for hours, so each message gets its own trace, linked to the
+// one for the upgrade request rather than nested under it.
+func startMessageSpan(client *Client, msgType string) (context.Context, trace.Span) {
+	attrs := []attribute.KeyValue{
+		attribute.String("ws.message.type", msgType),
+		attribute.String("collab.client_id", client.ID),
+	}
+	if client.UserID != "" {
+		attrs = append(attrs, attribute.String("enduser.id", client.UserID))
+	}
+	if client.Room != nil {
+		attrs = append(attrs, roomAttributes(client.Room)...)
+	}
+	if client.Project != nil {
+		attrs = append(attrs, attribute.String("collab.project_id", client.Project.ID))
+	}
+	if requestID := middleware.GetReqID(client.ctx); requestID != "" {
+		attrs = append(attrs, attribute.String("http.request_id", requestID))
+	}
+
+	return tracer.Start(client.ctx, "ws "+msgType,
+		trace.WithNewRoot(),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithLinks(trace.LinkFromContext(client.ctx)),
+		trace.WithAttributes(attrs...),
+	)
+}
+
+func roomAttributes(r *Room) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{attribute.String("collab.room_id", r.ID)}
+	if r.DocumentID != "" {
+		attrs = append(attrs, attribute.String("collab.document_id", r.DocumentID))
+	}
+	return attrs
+}
+
+// lock takes the room's write lock, recording the wait as a span so a slow
+// request shows how much of it was contention
+func (r *Room) lock(ctx context.Context) {
+	_, span := tracer.Start(ctx, "room.lock")
+	r.mu.Lock()
+	span.End()
+}
+
+func (r *Room) rlock(ctx context.Context) {
+	_, span := tracer.Start(ctx, "room.rlock")
+	r.mu.RLock()
+	span.End()
+}
+
+// recordError marks span as failed with err
+func recordError(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
@@ -1,6 +1,10 @@
 package config
 
 import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/netip"
 	"os"
 	"strconv"
 	"strings"
@@ -22,68 +26,242 @@ type Config struct {
 	CookieSecure       bool
 	CookieSameSite     string
 	LogLevel           string
+	TrashRetention     time.Duration
+	WebhookRetention   time.Duration
+	SnapshotEveryOps   int64
+	SnapshotInterval   time.Duration
+	SnapshotRetain     int
+	AdminToken         string
+	MetricsToken       string
+	MetricsAddr        string
+	TracesExporter     string
+	RateLimit          int
+	RateLimitBurst     int
+	MaxRooms           int
+	MaxRoomClients     int
+	TrustedProxies     []netip.Prefix
+
+	// File is the config file that was read, if any
+	File string
+	// sources records where each setting came from, by key
+	sources map[string]string
 }
 
-func Load() *Config {
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
-	}
+// setting is one configuration value. Its key names it in config files and,
+// with dashes for underscores, as a flag.
+type setting struct {
+	key   string
+	env   []string // first one set wins
+	usage string
+	// def is the default, or derive computes it from settings earlier in the
+	// list
+	def    string
+	derive func(c *Config) string
+	secret bool
+	lower  bool
+	// reload marks settings a running server picks up from Reloader
+	reload bool
+	field  func(c *Config) any
+}
+
+// Settings are resolved in this order, so derived defaults may only depend
+// on settings above them.
+var settings = []setting{
+	{key: "environment", env: []string{"ENVIRONMENT"}, def: "development", lower: true, usage: "development or production",
+		field: func(c *Config) any { return &c.Environment }},
+	{key: "port", env: []string{"PORT"}, def: "8080", usage: "port to listen on",
+		field: func(c *Config) any { return &c.Port }},
+	{key: "base_url", env: []string{"PUBLIC_BASE_URL", "BASE_URL"}, def: "http://localhost:3000", usage: "public URL share links point at",
+		field: func(c *Config) any { return &c.BaseURL }},
+	{key: "cors_origins", env: []string{"CORS_ORIGINS"}, derive: defaultOrigins, reload: true, usage: "comma-separated origins allowed by CORS",
+		field: func(c *Config) any { return &c.AllowedOrigins }},
+	{key: "log_level", env: []string{"LOG_LEVEL"}, def: "info", lower: true, reload: true, usage: "debug, info, warn or error",
+		field: func(c *Config) any { return &c.LogLevel }},
+	{key: "database_url", env: []string{"DATABASE_URL"}, secret: true, usage: "Postgres connection URL",
+		field: func(c *Config) any { return &c.DatabaseURL }},
+	{key: "jwt_signing_key", env: []string{"JWT_SIGNING_KEY"}, secret: true, usage: "secret for signing access tokens",
+		field: func(c *Config) any { return &c.JWTSigningKey }},
+	{key: "refresh_token_pepper", env: []string{"REFRESH_TOKEN_PEPPER"}, secret: true, usage: "secret mixed into stored refresh token hashes",
+		field: func(c *Config) any { return &c.RefreshTokenPepper }},
+	{key: "access_token_expiry", env: []string{"ACCESS_TOKEN_EXPIRY"}, def: "15m", usage: "access token lifetime",
+		field: func(c *Config) any { return &c.AccessTokenExpiry }},
+	{key: "refresh_token_expiry", env: []string{"REFRESH_TOKEN_EXPIRY"}, def: "720h", usage: "refresh token lifetime",
+		field: func(c *Config) any { return &c.RefreshTokenExpiry }},
+	{key: "refresh_cookie_name", env: []string{"REFRESH_COOKIE_NAME"}, def: "maple_refresh", usage: "name of the refresh token cookie",
+		field: func(c *Config) any { return &c.RefreshCookieName }},
+	{key: "cookie_domain", env: []string{"COOKIE_DOMAIN"}, usage: "domain of the refresh token cookie",
+		field: func(c *Config) any { return &c.CookieDomain }},
+	{key: "cookie_secure", env: []string{"COOKIE_SECURE"}, derive: defaultCookieSecure, usage: "send the refresh cookie over HTTPS only",
+		field: func(c *Config) any { return &c.CookieSecure }},
+	{key: "cookie_samesite", env: []string{"COOKIE_SAMESITE"}, def: "lax", lower: true, usage: "lax, strict or none",
+		field: func(c *Config) any { return &c.CookieSameSite }},
+	{key: "trash_retention", env: []string{"TRASH_RETENTION"}, def: "720h", usage: "how long deleted documents are kept; 0 keeps them",
+		field: func(c *Config) any { return &c.TrashRetention }},
+	{key: "webhook_retention", env: []string{"WEBHOOK_RETENTION"}, def: "168h", usage: "how long finished webhook deliveries are kept; 0 keeps them",
+		field: func(c *Config) any { return &c.WebhookRetention }},
+	{key: "snapshot_every_ops", env: []string{"SNAPSHOT_EVERY_OPS"}, def: "100", usage: "ops between compaction snapshots; 0 disables this trigger",
+		field: func(c *Config) any { return &c.SnapshotEveryOps }},
+	{key: "snapshot_interval", env: []string{"SNAPSHOT_INTERVAL"}, def: "5m", usage: "max age of a document's latest snapshot; 0 disables this trigger",
+		field: func(c *Config) any { return &c.SnapshotInterval }},
+	{key: "snapshot_retain", env: []string{"SNAPSHOT_RETAIN"}, def: "0", usage: "snapshots kept per document; 0 keeps all",
+		field: func(c *Config) any { return &c.SnapshotRetain }},
+	{key: "admin_token", env: []string{"ADMIN_TOKEN"}, secret: true, usage: "bearer token for /v1/admin; empty disables it",
+		field: func(c *Config) any { return &c.AdminToken }},
+	{key: "metrics_token", env: []string{"METRICS_TOKEN"}, secret: true, usage: "bearer token for /metrics",
+		field: func(c *Config) any { return &c.MetricsToken }},
+	{key: "metrics_addr", env: []string{"METRICS_ADDR"}, usage: "serve /metrics on this address instead",
+		field: func(c *Config) any { return &c.MetricsAddr }},
+	{key: "traces_exporter", env: []string{"OTEL_TRACES_EXPORTER"}, def: "none", lower: true, usage: "otlp, stdout or none",
+		field: func(c *Config) any { return &c.TracesExporter }},
+	{key: "rate_limit", env: []string{"RATE_LIMIT"}, def: "0", reload: true, usage: "API requests per second per client IP; 0 disables",
+		field: func(c *Config) any { return &c.RateLimit }},
+	{key: "rate_limit_burst", env: []string{"RATE_LIMIT_BURST"}, def: "20", reload: true, usage: "requests a client may make at once before rate_limit applies",
+		field: func(c *Config) any { return &c.RateLimitBurst }},
+	{key: "max_rooms", env: []string{"MAX_ROOMS"}, def: "0", reload: true, usage: "open rooms, counting each project file, beyond which anonymous rooms and projects cannot be created; 0 is unlimited",
+		field: func(c *Config) any { return &c.MaxRooms }},
+	{key: "max_room_clients", env: []string{"MAX_ROOM_CLIENTS"}, def: "0", reload: true, usage: "clients allowed in one room or project; 0 is unlimited",
+		field: func(c *Config) any { return &c.MaxRoomClients }},
+	{key: "trusted_proxies", env: []string{"TRUSTED_PROXIES"}, usage: "comma-separated proxy addresses or CIDRs whose X-Forwarded-For is believed",
+		field: func(c *Config) any { return &c.TrustedProxies }},
+}
 
-	env := os.Getenv("ENVIRONMENT")
-	if env == "" {
-		env = "development"
+// Flags holds the values of the flags registered by NewFlags
+type Flags struct {
+	file   *string
+	values map[string]string
+}
+
+// NewFlags adds --config and a flag per setting to fs. Values are kept raw
+// so they are parsed and reported like every other source.
+func NewFlags(fs *flag.FlagSet) *Flags {
+	f := &Flags{
+		file:   fs.String("config", "", "YAML or TOML config file (defaults to CONFIG_FILE)"),
+		values: make(map[string]string),
+	}
+	for _, s := range settings {
+		key := s.key
+		store := func(value string) error {
+			f.values[key] = value
+			return nil
+		}
+		if _, isBool := s.field(&Config{}).(*bool); isBool {
+			fs.BoolFunc(flagName(key), s.usage, store)
+		} else {
+			fs.Func(flagName(key), s.usage, store)
+		}
 	}
+	return f
+}
 
-	baseURL := os.Getenv("PUBLIC_BASE_URL")
-	if baseURL == "" {
-		baseURL = os.Getenv("BASE_URL")
+// Load builds the configuration from, in increasing precedence: defaults, a
+// YAML or TOML config file, environment variables and flags, which may be
+// nil. The file is named by --config or CONFIG_FILE. Malformed values are
+// errors rather than falling back to the default; use Validate to check
+// that the result is safe to serve with.
+func Load(flags *Flags) (*Config, error) {
+	if flags == nil {
+		flags = &Flags{file: new(string)}
 	}
-	if baseURL == "" {
-		baseURL = "http://localhost:3000"
+	file := *flags.file
+	if file == "" {
+		file = strings.TrimSpace(os.Getenv("CONFIG_FILE"))
 	}
 
-	origins := buildAllowedOrigins(env)
-	if corsOrigins := strings.TrimSpace(os.Getenv("CORS_ORIGINS")); corsOrigins != "" {
-		origins = splitAndTrim(corsOrigins)
+	var fileValues map[string]string
+	if file != "" {
+		var err error
+		if fileValues, err = readFile(file); err != nil {
+			return nil, err
+		}
 	}
 
-	accessExpiry := parseDuration(os.Getenv("ACCESS_TOKEN_EXPIRY"), 15*time.Minute)
-	refreshExpiry := parseDuration(os.Getenv("REFRESH_TOKEN_EXPIRY"), 720*time.Hour)
+	c := &Config{File: file, sources: make(map[string]string, len(settings))}
+	var errs []error
+	for _, s := range settings {
+		raw, source := s.def, "default"
+		if s.derive != nil {
+			raw = s.derive(c)
+		}
+		if value, ok := fileValues[s.key]; ok {
+			raw, source = value, "file"
+		}
+		for _, name := range s.env {
+			if value := strings.TrimSpace(os.Getenv(name)); value != "" {
+				raw, source = value, "env "+name
+				break
+			}
+		}
+		if value, ok := flags.values[s.key]; ok {
+			raw, source = value, "flag --"+flagName(s.key)
+		}
 
-	cookieSecure := env == "production"
-	if raw := strings.TrimSpace(os.Getenv("COOKIE_SECURE")); raw != "" {
-		if parsed, err := strconv.ParseBool(raw); err == nil {
-			cookieSecure = parsed
+		if s.lower {
+			raw = strings.ToLower(raw)
+		}
+		if err := setValue(s.field(c), raw); err != nil {
+			errs = append(errs, fmt.Errorf("%s from %s: %w", s.key, source, err))
 		}
+		c.sources[s.key] = source
 	}
-
-	cookieSameSite := strings.TrimSpace(os.Getenv("COOKIE_SAMESITE"))
-	if cookieSameSite == "" {
-		cookieSameSite = "lax"
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
 	}
+	return c, nil
+}
 
-	logLevel := strings.TrimSpace(os.Getenv("LOG_LEVEL"))
-	if logLevel == "" {
-		logLevel = "info"
-	}
+func flagName(key string) string {
+	return strings.ReplaceAll(key, "_", "-")
+}
 
-	return &Config{
-		Port:               port,
-		AllowedOrigins:     origins,
-		Environment:        env,
-		BaseURL:            baseURL,
-		DatabaseURL:        strings.TrimSpace(os.Getenv("DATABASE_URL")),
-		JWTSigningKey:      strings.TrimSpace(os.Getenv("JWT_SIGNING_KEY")),
-		RefreshTokenPepper: strings.TrimSpace(os.Getenv("REFRESH_TOKEN_PEPPER")),
-		AccessTokenExpiry:  accessExpiry,
-		RefreshTokenExpiry: refreshExpiry,
-		RefreshCookieName:  fallbackString(os.Getenv("REFRESH_COOKIE_NAME"), "maple_refresh"),
-		CookieDomain:       strings.TrimSpace(os.Getenv("COOKIE_DOMAIN")),
-		CookieSecure:       cookieSecure,
-		CookieSameSite:     strings.ToLower(cookieSameSite),
-		LogLevel:           logLevel,
+func setValue(field any, raw string) error {
+	raw = strings.TrimSpace(raw)
+	switch p := field.(type) {
+	case *string:
+		*p = raw
+	case *[]string:
+		*p = splitAndTrim(raw)
+	case *[]netip.Prefix:
+		prefixes, err := parsePrefixes(splitAndTrim(raw))
+		if err != nil {
+			return err
+		}
+		*p = prefixes
+	case *bool:
+		parsed, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", raw)
+		}
+		*p = parsed
+	case *int:
+		parsed, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", raw)
+		}
+		*p = parsed
+	case *int64:
+		parsed, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", raw)
+		}
+		*p = parsed
+	case *time.Duration:
+		parsed, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", raw)
+		}
+		*p = parsed
+	default:
+		panic(fmt.Sprintf("config: unsupported field type %T", field))
 	}
+	return nil
+}
+
+func defaultOrigins(c *Config) string {
+	return strings.Join(buildAllowedOrigins(c.Environment), ",")
+}
+
+func defaultCookieSecure(c *Config) string {
+	return strconv.FormatBool(c.Environment == "production")
 }
 
 func buildAllowedOrigins(env string) []string {
@@ -101,18 +279,6 @@ func buildAllowedOrigins(env string) []string {
 	return origins
 }
 
-func parseDuration(raw string, fallback time.Duration) time.Duration {
-	raw = strings.TrimSpace(raw)
-	if raw == "" {
-		return fallback
-	}
-	parsed, err := time.ParseDuration(raw)
-	if err != nil {
-		return fallback
-	}
-	return parsed
-}
-
 func splitAndTrim(value string) []string {
 	parts := strings.Split(value, ",")
 	out := make([]string, 0, len(parts))
@@ -126,10 +292,19 @@ func splitAndTrim(value string) []string {
 	return out
 }
 
-func fallbackString(value, fallback string) string {
-	trimmed := strings.TrimSpace(value)
-	if trimmed == "" {
-		return fallback
+// parsePrefixes reads CIDRs, taking a bare address as a prefix of just itself
+func parsePrefixes(values []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(values))
+	for _, value := range values {
+		if addr, err := netip.ParseAddr(value); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address or CIDR %q", value)
+		}
+		prefixes = append(prefixes, prefix.Masked())
 	}
-	return trimmed
+	return prefixes, nil
 }
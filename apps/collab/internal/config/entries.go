@@ -0,0 +1,71 @@
+package config
+
+import (
+	"net/netip"
+	"net/url"
+	"time"
+)
+
+const redacted = "REDACTED"
+
+// Entry is one setting as shown by `collab config print`
+type Entry struct {
+	Key        string `json:"key"`
+	Value      any    `json:"value"`
+	Source     string `json:"source"`
+	Reloadable bool   `json:"reloadable"`
+}
+
+// Entries lists every setting with its effective value and where it came
+// from. Secrets are redacted; a database URL keeps everything but its
+// password.
+func (c *Config) Entries() []Entry {
+	entries := make([]Entry, 0, len(settings))
+	for _, s := range settings {
+		var value any
+		switch p := s.field(c).(type) {
+		case *string:
+			value = *p
+			if s.secret && *p != "" {
+				value = redactSecret(s.key, *p)
+			}
+		case *time.Duration:
+			value = p.String()
+		case *[]string:
+			value = *p
+		case *[]netip.Prefix:
+			prefixes := make([]string, len(*p))
+			for i, prefix := range *p {
+				prefixes[i] = prefix.String()
+			}
+			value = prefixes
+		case *bool:
+			value = *p
+		case *int:
+			value = *p
+		case *int64:
+			value = *p
+		}
+		entries = append(entries, Entry{Key: s.key, Value: value, Source: c.sources[s.key], Reloadable: s.reload})
+	}
+	return entries
+}
+
+func redactSecret(key, value string) string {
+	if key != "database_url" {
+		return redacted
+	}
+	u, err := url.Parse(value)
+	if err != nil || u.User == nil {
+		return redacted
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), redacted)
+	}
+	query := u.Query()
+	if query.Has("password") {
+		query.Set("password", redacted)
+		u.RawQuery = query.Encode()
+	}
+	return u.String()
+}
@@ -0,0 +1,85 @@
+package config
+
+import (
+	"maps"
+	"reflect"
+	"sync"
+)
+
+// Reloader holds the running configuration and re-reads it on demand. Only
+// settings marked reloadable take effect; others are reported as needing a
+// restart and left as they were, so the running config stays consistent.
+type Reloader struct {
+	mu          sync.Mutex
+	load        func() (*Config, error)
+	current     *Config
+	subscribers []func(*Config)
+}
+
+// ReloadResult lists the settings a reload changed, by key
+type ReloadResult struct {
+	Applied         []string `json:"applied"`
+	RestartRequired []string `json:"restartRequired"`
+}
+
+// NewReloader starts from cfg and reloads by calling load, which should read
+// the same sources cfg came from
+func NewReloader(cfg *Config, load func() (*Config, error)) *Reloader {
+	return &Reloader{load: load, current: cfg}
+}
+
+// Current returns the running configuration. It must not be modified.
+func (r *Reloader) Current() *Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.current
+}
+
+// Subscribe registers fn to be called with the new configuration after each
+// reload that changes a reloadable setting
+func (r *Reloader) Subscribe(fn func(*Config)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.subscribers = append(r.subscribers, fn)
+}
+
+// Reload re-reads the configuration. Nothing changes if it cannot be loaded
+// or fails Validate, since the server would not start with it either.
+func (r *Reloader) Reload() (*ReloadResult, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	loaded, err := r.load()
+	if err != nil {
+		return nil, err
+	}
+	if err := loaded.Validate(); err != nil {
+		return nil, err
+	}
+
+	next := *r.current
+	next.sources = maps.Clone(r.current.sources)
+	result := &ReloadResult{Applied: []string{}, RestartRequired: []string{}}
+	for _, s := range settings {
+		want := reflect.ValueOf(s.field(loaded)).Elem()
+		have := reflect.ValueOf(s.field(&next)).Elem()
+		if reflect.DeepEqual(want.Interface(), have.Interface()) {
+			continue
+		}
+		if !s.reload {
+			result.RestartRequired = append(result.RestartRequired, s.key)
+			continue
+		}
+		have.Set(want)
+		next.sources[s.key] = loaded.sources[s.key]
+		result.Applied = append(result.Applied, s.key)
+	}
+
+	if len(result.Applied) > 0 {
+		r.current = &next
+		for _, fn := range r.subscribers {
+			fn(&next)
+		}
+	}
+	return result, nil
+}
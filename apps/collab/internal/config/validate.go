@@ -0,0 +1,119 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	// minSecretLen applies to the signing key and pepper in production
+	minSecretLen = 32
+	// minTokenLen applies to the admin and metrics bearer tokens in production
+	minTokenLen = 16
+)
+
+// Validate reports every setting the server cannot run with. In production
+// it also refuses settings that would work but are unsafe, such as a short
+// signing key or cookies sent over plain HTTP.
+func (c *Config) Validate() error {
+	var errs []error
+	fail := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > 65535 {
+		fail("port %q is not a valid port number", c.Port)
+	}
+	if c.DatabaseURL == "" {
+		fail("database_url is required")
+	}
+	if c.JWTSigningKey == "" {
+		fail("jwt_signing_key is required")
+	}
+	if !oneOf(c.LogLevel, "debug", "info", "warn", "warning", "error") {
+		fail("log_level %q must be debug, info, warn or error", c.LogLevel)
+	}
+	if !oneOf(c.CookieSameSite, "lax", "strict", "none") {
+		fail("cookie_samesite %q must be lax, strict or none", c.CookieSameSite)
+	}
+	if c.CookieSameSite == "none" && !c.CookieSecure {
+		fail("cookie_samesite none requires cookie_secure; browsers drop the cookie otherwise")
+	}
+	if !oneOf(c.TracesExporter, "none", "otlp", "stdout") {
+		fail("traces_exporter %q must be otlp, stdout or none", c.TracesExporter)
+	}
+	if c.AccessTokenExpiry <= 0 || c.RefreshTokenExpiry <= 0 {
+		fail("access_token_expiry and refresh_token_expiry must be positive")
+	}
+	if c.TrashRetention < 0 || c.WebhookRetention < 0 || c.SnapshotInterval < 0 {
+		fail("trash_retention, webhook_retention and snapshot_interval must not be negative")
+	}
+	if c.SnapshotEveryOps < 0 || c.SnapshotRetain < 0 {
+		fail("snapshot_every_ops and snapshot_retain must not be negative")
+	}
+	if c.RateLimit < 0 || c.RateLimitBurst < 0 || c.MaxRooms < 0 || c.MaxRoomClients < 0 {
+		fail("rate_limit, rate_limit_burst, max_rooms and max_room_clients must not be negative")
+	}
+
+	if c.IsProduction() {
+		errs = append(errs, c.validateProduction()...)
+	}
+	return errors.Join(errs...)
+}
+
+func (c *Config) validateProduction() []error {
+	var errs []error
+	fail := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf("production: "+format, args...))
+	}
+
+	if c.JWTSigningKey != "" && len(c.JWTSigningKey) < minSecretLen {
+		fail("jwt_signing_key must be at least %d bytes", minSecretLen)
+	}
+	if len(c.RefreshTokenPepper) < minSecretLen {
+		fail("refresh_token_pepper must be set and at least %d bytes", minSecretLen)
+	}
+	if c.AdminToken != "" && len(c.AdminToken) < minTokenLen {
+		fail("admin_token must be at least %d bytes", minTokenLen)
+	}
+	if c.MetricsToken != "" && len(c.MetricsToken) < minTokenLen {
+		fail("metrics_token must be at least %d bytes", minTokenLen)
+	}
+	if !c.CookieSecure {
+		fail("cookie_secure must be true")
+	}
+	if !strings.HasPrefix(c.BaseURL, "https://") {
+		fail("base_url %q must use https", c.BaseURL)
+	}
+	for _, origin := range c.AllowedOrigins {
+		// Credentials are allowed, so a wildcard would let any site act as
+		// the signed-in user
+		if origin == "*" {
+			fail("cors_origins must not contain *")
+		} else if !strings.HasPrefix(origin, "https://") && !isLocalhost(origin) {
+			fail("cors origin %q must use https", origin)
+		}
+	}
+	return errs
+}
+
+func (c *Config) IsProduction() bool {
+	return c.Environment == "production"
+}
+
+func isLocalhost(origin string) bool {
+	u, err := url.Parse(origin)
+	return err == nil && (u.Hostname() == "localhost" || u.Hostname() == "127.0.0.1")
+}
+
+func oneOf(value string, allowed ...string) bool {
+	for _, a := range allowed {
+		if value == a {
+			return true
+		}
+	}
+	return false
+}
@@ -3,7 +3,10 @@ package db
 import (
 	"context"
 	"errors"
+	"fmt"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/NoumanAMalik/maple/apps/collab/internal/models"
 	"github.com/jackc/pgx/v5"
@@ -19,7 +22,20 @@ func NewDocumentRepo(pool *pgxpool.Pool) *DocumentRepo {
 	return &DocumentRepo{pool: pool}
 }
 
-func (r *DocumentRepo) CreateWithSnapshot(ctx context.Context, ownerID, title, language, content string) (*models.Document, *models.DocumentSnapshot, error) {
+// CreateWithSnapshot creates a document with its initial snapshot, inside
+// folderID or at the top level when folderID is nil.
+func (r *DocumentRepo) CreateWithSnapshot(ctx context.Context, ownerID, title, language, content string, folderID *string) (*models.Document, *models.DocumentSnapshot, error) {
+	return r.create(ctx, ownerID, title, language, content, folderID, nil, nil)
+}
+
+// CreateFork creates a document seeded with content, recording the parent
+// document and the version it was forked from. The fork is placed in the
+// parent's folder.
+func (r *DocumentRepo) CreateFork(ctx context.Context, ownerID, title, content string, parent *models.Document, parentVersion int64) (*models.Document, *models.DocumentSnapshot, error) {
+	return r.create(ctx, ownerID, title, parent.Language, content, parent.FolderID, &parent.ID, &parentVersion)
+}
+
+func (r *DocumentRepo) create(ctx context.Context, ownerID, title, language, content string, folderID, parentID *string, parentVersion *int64) (*models.Document, *models.DocumentSnapshot, error) {
 	cleanTitle := strings.TrimSpace(title)
 	if cleanTitle == "" {
 		cleanTitle = "Untitled"
@@ -36,11 +52,11 @@ func (r *DocumentRepo) CreateWithSnapshot(ctx context.Context, ownerID, title, l
 
 	var doc models.Document
 	row := tx.QueryRow(ctx, `
-		INSERT INTO documents (owner_id, title, language, current_version)
-		VALUES ($1, $2, $3, 0)
-		RETURNING id, owner_id, title, language, current_version, created_at, updated_at, deleted_at
-	`, ownerID, cleanTitle, nullableString(cleanLanguage))
-	if err := row.Scan(&doc.ID, &doc.OwnerID, &doc.Title, &doc.Language, &doc.CurrentVersion, &doc.CreatedAt, &doc.UpdatedAt, &doc.DeletedAt); err != nil {
+		INSERT INTO documents (owner_id, title, language, current_version, folder_id, parent_id, parent_version, search_content)
+		VALUES ($1, $2, $3, 0, $4, $5, $6, $7)
+		RETURNING `+documentColumns+`
+	`, ownerID, cleanTitle, nullableString(cleanLanguage), folderID, parentID, parentVersion, content)
+	if err := scanDocument(row, &doc); err != nil {
 		if pgErr, ok := err.(*pgconn.PgError); ok && pgErr.Code == "23503" {
 			return nil, nil, ErrNotFound
 		}
@@ -57,6 +73,10 @@ func (r *DocumentRepo) CreateWithSnapshot(ctx context.Context, ownerID, title, l
 		return nil, nil, err
 	}
 
+	if err := enqueueWebhooks(ctx, tx, doc.ID, models.WebhookDocumentCreated, doc); err != nil {
+		return nil, nil, err
+	}
+
 	if err := tx.Commit(ctx); err != nil {
 		return nil, nil, err
 	}
@@ -66,13 +86,13 @@ func (r *DocumentRepo) CreateWithSnapshot(ctx context.Context, ownerID, title, l
 
 func (r *DocumentRepo) GetByIDForOwner(ctx context.Context, docID, ownerID string) (*models.Document, error) {
 	row := r.pool.QueryRow(ctx, `
-		SELECT id, owner_id, title, language, current_version, created_at, updated_at, deleted_at
+		SELECT `+documentColumns+`
 		FROM documents
 		WHERE id = $1 AND owner_id = $2 AND deleted_at IS NULL
 	`, docID, ownerID)
 
 	var doc models.Document
-	if err := row.Scan(&doc.ID, &doc.OwnerID, &doc.Title, &doc.Language, &doc.CurrentVersion, &doc.CreatedAt, &doc.UpdatedAt, &doc.DeletedAt); err != nil {
+	if err := scanDocument(row, &doc); err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, ErrNotFound
 		}
@@ -82,56 +102,222 @@ func (r *DocumentRepo) GetByIDForOwner(ctx context.Context, docID, ownerID strin
 	return &doc, nil
 }
 
-func (r *DocumentRepo) ListByOwner(ctx context.Context, ownerID string, limit int) ([]models.Document, error) {
-	if limit <= 0 {
-		limit = 100
+// GetByID returns a document whatever its owner, including one in the
+// trash. It is meant for operators, not request handlers.
+func (r *DocumentRepo) GetByID(ctx context.Context, docID string) (*models.Document, error) {
+	row := r.pool.QueryRow(ctx, `
+		SELECT `+documentColumns+`
+		FROM documents
+		WHERE id = $1
+	`, docID)
+
+	var doc models.Document
+	if err := scanDocument(row, &doc); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return &doc, nil
+}
+
+// Sort keys accepted by DocumentRepo.List
+const (
+	DocumentSortUpdated = "updated"
+	DocumentSortCreated = "created"
+	DocumentSortTitle   = "title"
+)
+
+// DocumentCursor is the position after the last document of a page: its sort
+// key value and id, which breaks ties.
+type DocumentCursor struct {
+	Time  time.Time
+	Title string
+	ID    string
+}
+
+type DocumentListOptions struct {
+	// FilterFolder restricts the list to documents directly inside FolderID,
+	// or at the top level when FolderID is nil.
+	FilterFolder bool
+	FolderID     *string
+	Language     string
+	TitlePrefix  string
+	Sort         string
+	Descending   bool
+	After        *DocumentCursor
+	Limit        int
+}
+
+// List returns one page of a user's documents. The second result reports
+// whether more documents follow the page.
+func (r *DocumentRepo) List(ctx context.Context, ownerID string, opts DocumentListOptions) ([]models.Document, bool, error) {
+	if opts.Limit <= 0 {
+		opts.Limit = 100
+	}
+
+	sortColumn := "updated_at"
+	switch opts.Sort {
+	case DocumentSortCreated:
+		sortColumn = "created_at"
+	case DocumentSortTitle:
+		sortColumn = "title"
+	case "", DocumentSortUpdated:
+	default:
+		return nil, false, ErrInvalidInput
+	}
+	direction, comparison := "ASC", ">"
+	if opts.Descending {
+		direction, comparison = "DESC", "<"
+	}
+
+	conditions := []string{"owner_id = $1", "deleted_at IS NULL"}
+	args := []any{ownerID}
+	addCondition := func(format string, values ...any) {
+		placeholders := make([]any, len(values))
+		for i, value := range values {
+			args = append(args, value)
+			placeholders[i] = "$" + strconv.Itoa(len(args))
+		}
+		conditions = append(conditions, fmt.Sprintf(format, placeholders...))
+	}
+
+	if opts.FilterFolder {
+		addCondition("folder_id IS NOT DISTINCT FROM %s::uuid", opts.FolderID)
+	}
+	if opts.Language != "" {
+		addCondition("language = %s", opts.Language)
+	}
+	if opts.TitlePrefix != "" {
+		addCondition(`title ILIKE %s ESCAPE '\'`, escapeLike(opts.TitlePrefix)+"%")
+	}
+	if opts.After != nil {
+		var value any = opts.After.Time
+		if opts.Sort == DocumentSortTitle {
+			value = opts.After.Title
+		}
+		addCondition("("+sortColumn+", id) "+comparison+" (%s, %s::uuid)", value, opts.After.ID)
 	}
 
+	// Fetch one extra row to learn whether another page follows
+	args = append(args, opts.Limit+1)
 	rows, err := r.pool.Query(ctx, `
-		SELECT id, owner_id, title, language, current_version, created_at, updated_at, deleted_at
+		SELECT `+documentColumns+`
 		FROM documents
-		WHERE owner_id = $1 AND deleted_at IS NULL
-		ORDER BY updated_at DESC
-		LIMIT $2
-	`, ownerID, limit)
+		WHERE `+strings.Join(conditions, " AND ")+`
+		ORDER BY `+sortColumn+` `+direction+`, id `+direction+`
+		LIMIT $`+strconv.Itoa(len(args)), args...)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 	defer rows.Close()
 
 	docs := make([]models.Document, 0)
 	for rows.Next() {
 		var doc models.Document
-		if err := rows.Scan(&doc.ID, &doc.OwnerID, &doc.Title, &doc.Language, &doc.CurrentVersion, &doc.CreatedAt, &doc.UpdatedAt, &doc.DeletedAt); err != nil {
-			return nil, err
+		if err := scanDocument(rows, &doc); err != nil {
+			return nil, false, err
 		}
 		docs = append(docs, doc)
 	}
 
 	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+
+	if len(docs) > opts.Limit {
+		return docs[:opts.Limit], true, nil
+	}
+	return docs, false, nil
+}
+
+// MoveToFolder moves a document into folderID, or to the top level when
+// folderID is nil.
+func (r *DocumentRepo) MoveToFolder(ctx context.Context, docID, ownerID string, folderID *string) (*models.Document, error) {
+	row := r.pool.QueryRow(ctx, `
+		UPDATE documents
+		SET folder_id = $1, updated_at = NOW()
+		WHERE id = $2 AND owner_id = $3 AND deleted_at IS NULL
+		RETURNING `+documentColumns+`
+	`, folderID, docID, ownerID)
+
+	var doc models.Document
+	if err := scanDocument(row, &doc); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
 		return nil, err
 	}
 
-	return docs, nil
+	return &doc, nil
+}
+
+// Search runs a web-style full-text query over the titles and latest
+// snapshot content of a user's documents, best matches first. Only the
+// first 100,000 characters of each document are indexed.
+func (r *DocumentRepo) Search(ctx context.Context, ownerID, query string, limit int) ([]models.DocumentSearchHit, error) {
+	if limit <= 0 {
+		limit = 20
+	}
+
+	rows, err := r.pool.Query(ctx, `
+		SELECT `+documentColumns+`, search_content, ts_rank(search_vector, query) AS rank
+		FROM documents, websearch_to_tsquery('simple', $2) AS query
+		WHERE owner_id = $1 AND deleted_at IS NULL AND search_vector @@ query
+		ORDER BY rank DESC, updated_at DESC
+		LIMIT $3
+	`, ownerID, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hits := make([]models.DocumentSearchHit, 0)
+	for rows.Next() {
+		var hit models.DocumentSearchHit
+		if err := scanDocument(rows, &hit.Document, &hit.Content, &hit.Rank); err != nil {
+			return nil, err
+		}
+		hits = append(hits, hit)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return hits, nil
+}
+
+// DocumentRevision identifies one state of a document row. Conditional
+// writes only apply while the row is still at that revision.
+type DocumentRevision struct {
+	Version   int64
+	UpdatedAt time.Time
 }
 
-func (r *DocumentRepo) UpdateTitle(ctx context.Context, docID, ownerID, title string) (*models.Document, error) {
+// UpdateTitle renames a document. When expected is set the update only
+// applies if the document is still at that revision, otherwise it fails with
+// ErrPreconditionFailed.
+func (r *DocumentRepo) UpdateTitle(ctx context.Context, docID, ownerID, title string, expected *DocumentRevision) (*models.Document, error) {
 	cleanTitle := strings.TrimSpace(title)
 	if cleanTitle == "" {
 		return nil, ErrInvalidInput
 	}
 
+	version, updatedAt := expected.args()
 	row := r.pool.QueryRow(ctx, `
 		UPDATE documents
 		SET title = $1, updated_at = NOW()
 		WHERE id = $2 AND owner_id = $3 AND deleted_at IS NULL
-		RETURNING id, owner_id, title, language, current_version, created_at, updated_at, deleted_at
-	`, cleanTitle, docID, ownerID)
+			AND ($4::bigint IS NULL OR (current_version = $4 AND updated_at = $5))
+		RETURNING `+documentColumns+`
+	`, cleanTitle, docID, ownerID, version, updatedAt)
 
 	var doc models.Document
-	if err := row.Scan(&doc.ID, &doc.OwnerID, &doc.Title, &doc.Language, &doc.CurrentVersion, &doc.CreatedAt, &doc.UpdatedAt, &doc.DeletedAt); err != nil {
+	if err := scanDocument(row, &doc); err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
-			return nil, ErrNotFound
+			return nil, r.conditionalMiss(ctx, docID, ownerID, expected)
 		}
 		return nil, err
 	}
@@ -139,27 +325,111 @@ func (r *DocumentRepo) UpdateTitle(ctx context.Context, docID, ownerID, title st
 	return &doc, nil
 }
 
-func (r *DocumentRepo) SoftDelete(ctx context.Context, docID, ownerID string) error {
+// SoftDelete moves a document to the trash. expected works as in UpdateTitle.
+func (r *DocumentRepo) SoftDelete(ctx context.Context, docID, ownerID string, expected *DocumentRevision) error {
+	version, updatedAt := expected.args()
 	commandTag, err := r.pool.Exec(ctx, `
 		UPDATE documents
 		SET deleted_at = NOW(), updated_at = NOW()
 		WHERE id = $1 AND owner_id = $2 AND deleted_at IS NULL
-	`, docID, ownerID)
+			AND ($3::bigint IS NULL OR (current_version = $3 AND updated_at = $4))
+	`, docID, ownerID, version, updatedAt)
 	if err != nil {
 		return err
 	}
 	if commandTag.RowsAffected() == 0 {
-		return ErrNotFound
+		return r.conditionalMiss(ctx, docID, ownerID, expected)
 	}
 	return nil
 }
 
-func (r *DocumentRepo) UpdateVersion(ctx context.Context, docID string, version int64) error {
+// conditionalMiss explains why a conditional write touched no rows: either
+// the document is gone or it has moved past the expected revision.
+func (r *DocumentRepo) conditionalMiss(ctx context.Context, docID, ownerID string, expected *DocumentRevision) error {
+	if expected == nil {
+		return ErrNotFound
+	}
+	if _, err := r.GetByIDForOwner(ctx, docID, ownerID); err != nil {
+		return err
+	}
+	return ErrPreconditionFailed
+}
+
+func (rev *DocumentRevision) args() (*int64, *time.Time) {
+	if rev == nil {
+		return nil, nil
+	}
+	return &rev.Version, &rev.UpdatedAt
+}
+
+// ListDeleted lists a user's soft-deleted documents, most recently deleted
+// first.
+func (r *DocumentRepo) ListDeleted(ctx context.Context, ownerID string, limit int) ([]models.Document, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+
+	rows, err := r.pool.Query(ctx, `
+		SELECT `+documentColumns+`
+		FROM documents
+		WHERE owner_id = $1 AND deleted_at IS NOT NULL
+		ORDER BY deleted_at DESC
+		LIMIT $2
+	`, ownerID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	docs := make([]models.Document, 0)
+	for rows.Next() {
+		var doc models.Document
+		if err := scanDocument(rows, &doc); err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return docs, nil
+}
+
+// Restore brings a soft-deleted document back. If its folder is still
+// deleted, the document is restored to the top level instead.
+func (r *DocumentRepo) Restore(ctx context.Context, docID, ownerID string) (*models.Document, error) {
+	row := r.pool.QueryRow(ctx, `
+		UPDATE documents d
+		SET deleted_at = NULL,
+			updated_at = NOW(),
+			folder_id = CASE
+				WHEN EXISTS (SELECT 1 FROM folders f WHERE f.id = d.folder_id AND f.deleted_at IS NULL) THEN d.folder_id
+				ELSE NULL
+			END
+		WHERE d.id = $1 AND d.owner_id = $2 AND d.deleted_at IS NOT NULL
+		RETURNING `+documentColumns+`
+	`, docID, ownerID)
+
+	var doc models.Document
+	if err := scanDocument(row, &doc); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return &doc, nil
+}
+
+// HardDelete permanently removes a soft-deleted document; its ops and
+// snapshots go with it.
+func (r *DocumentRepo) HardDelete(ctx context.Context, docID, ownerID string) error {
 	commandTag, err := r.pool.Exec(ctx, `
-		UPDATE documents
-		SET current_version = $1, updated_at = NOW()
-		WHERE id = $2 AND deleted_at IS NULL
-	`, version, docID)
+		DELETE FROM documents
+		WHERE id = $1 AND owner_id = $2 AND deleted_at IS NOT NULL
+	`, docID, ownerID)
 	if err != nil {
 		return err
 	}
@@ -169,6 +439,67 @@ func (r *DocumentRepo) UpdateVersion(ctx context.Context, docID string, version
 	return nil
 }
 
+// PurgeDeletedBefore permanently removes every document soft-deleted before
+// cutoff and returns the ids of those removed.
+func (r *DocumentRepo) PurgeDeletedBefore(ctx context.Context, cutoff time.Time) ([]string, error) {
+	rows, err := r.pool.Query(ctx, `
+		DELETE FROM documents
+		WHERE deleted_at IS NOT NULL AND deleted_at < $1
+		RETURNING id
+	`, cutoff)
+	if err != nil {
+		return nil, err
+	}
+	return scanIDs(rows)
+}
+
+const documentColumns = `id, owner_id, title, language, current_version, folder_id, parent_id, parent_version, created_at, updated_at, deleted_at`
+
+// scanDocument scans documentColumns into doc, followed by any extra
+// columns selected after them
+func scanDocument(row pgx.Row, doc *models.Document, extra ...any) error {
+	var language *string
+	dest := []any{
+		&doc.ID,
+		&doc.OwnerID,
+		&doc.Title,
+		&language,
+		&doc.CurrentVersion,
+		&doc.FolderID,
+		&doc.ParentID,
+		&doc.ParentVersion,
+		&doc.CreatedAt,
+		&doc.UpdatedAt,
+		&doc.DeletedAt,
+	}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return err
+	}
+	if language != nil {
+		doc.Language = *language
+	}
+	return nil
+}
+
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
+
+// scanIDs reads a single id column from rows and closes them
+func scanIDs(rows pgx.Rows) ([]string, error) {
+	defer rows.Close()
+
+	ids := make([]string, 0)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
 func nullableString(value string) any {
 	if strings.TrimSpace(value) == "" {
 		return nil
@@ -5,3 +5,5 @@ import "errors"
 var ErrNotFound = errors.New("not found")
 var ErrDuplicate = errors.New("duplicate")
 var ErrInvalidInput = errors.New("invalid input")
+var ErrPreconditionFailed = errors.New("precondition failed")
+var ErrLimitReached = errors.New("limit reached")
@@ -0,0 +1,276 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/NoumanAMalik/maple/apps/collab/internal/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type FolderRepo struct {
+	pool *pgxpool.Pool
+}
+
+func NewFolderRepo(pool *pgxpool.Pool) *FolderRepo {
+	return &FolderRepo{pool: pool}
+}
+
+// Create adds a folder under parentID, or at the top level when parentID is
+// nil. The parent must be a live folder owned by the same user.
+func (r *FolderRepo) Create(ctx context.Context, ownerID, name string, parentID *string) (*models.Folder, error) {
+	cleanName := strings.TrimSpace(name)
+	if cleanName == "" {
+		return nil, ErrInvalidInput
+	}
+
+	if parentID != nil {
+		if _, err := r.GetByIDForOwner(ctx, *parentID, ownerID); err != nil {
+			return nil, err
+		}
+	}
+
+	row := r.pool.QueryRow(ctx, `
+		INSERT INTO folders (owner_id, parent_id, name)
+		VALUES ($1, $2, $3)
+		RETURNING `+folderColumns+`
+	`, ownerID, parentID, cleanName)
+
+	var folder models.Folder
+	if err := scanFolder(row, &folder); err != nil {
+		if pgErr, ok := err.(*pgconn.PgError); ok && pgErr.Code == "23505" {
+			return nil, ErrDuplicate
+		}
+		return nil, err
+	}
+
+	return &folder, nil
+}
+
+func (r *FolderRepo) GetByIDForOwner(ctx context.Context, folderID, ownerID string) (*models.Folder, error) {
+	row := r.pool.QueryRow(ctx, `
+		SELECT `+folderColumns+`
+		FROM folders
+		WHERE id = $1 AND owner_id = $2 AND deleted_at IS NULL
+	`, folderID, ownerID)
+
+	var folder models.Folder
+	if err := scanFolder(row, &folder); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return &folder, nil
+}
+
+// ListByOwner returns every live folder of a user, parents before children,
+// so callers can build the tree in one pass.
+func (r *FolderRepo) ListByOwner(ctx context.Context, ownerID string) ([]models.Folder, error) {
+	rows, err := r.pool.Query(ctx, `
+		WITH RECURSIVE tree AS (
+			SELECT `+folderColumns+`, 0 AS depth
+			FROM folders
+			WHERE owner_id = $1 AND parent_id IS NULL AND deleted_at IS NULL
+			UNION ALL
+			SELECT f.id, f.owner_id, f.parent_id, f.name, f.created_at, f.updated_at, f.deleted_at, tree.depth + 1
+			FROM folders f
+			JOIN tree ON f.parent_id = tree.id
+			WHERE f.deleted_at IS NULL
+		)
+		SELECT `+folderColumns+`
+		FROM tree
+		ORDER BY depth, name
+	`, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	folders := make([]models.Folder, 0)
+	for rows.Next() {
+		var folder models.Folder
+		if err := scanFolder(rows, &folder); err != nil {
+			return nil, err
+		}
+		folders = append(folders, folder)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return folders, nil
+}
+
+func (r *FolderRepo) Rename(ctx context.Context, folderID, ownerID, name string) (*models.Folder, error) {
+	cleanName := strings.TrimSpace(name)
+	if cleanName == "" {
+		return nil, ErrInvalidInput
+	}
+
+	row := r.pool.QueryRow(ctx, `
+		UPDATE folders
+		SET name = $1, updated_at = NOW()
+		WHERE id = $2 AND owner_id = $3 AND deleted_at IS NULL
+		RETURNING `+folderColumns+`
+	`, cleanName, folderID, ownerID)
+
+	var folder models.Folder
+	if err := scanFolder(row, &folder); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		if pgErr, ok := err.(*pgconn.PgError); ok && pgErr.Code == "23505" {
+			return nil, ErrDuplicate
+		}
+		return nil, err
+	}
+
+	return &folder, nil
+}
+
+// Move re-parents a folder, or moves it to the top level when parentID is
+// nil. Moving a folder into itself or one of its descendants is rejected
+// with ErrInvalidInput. Moves by the same owner are serialized so two of
+// them cannot each pass the cycle check and together form a cycle.
+func (r *FolderRepo) Move(ctx context.Context, folderID, ownerID string, parentID *string) (*models.Folder, error) {
+	tx, err := r.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext('folder_move:' || $1))`, ownerID); err != nil {
+		return nil, err
+	}
+
+	if parentID != nil {
+		var exists bool
+		err := tx.QueryRow(ctx, `
+			SELECT EXISTS (
+				SELECT 1 FROM folders
+				WHERE id = $1 AND owner_id = $2 AND deleted_at IS NULL
+			)
+		`, *parentID, ownerID).Scan(&exists)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, ErrNotFound
+		}
+
+		var cycle bool
+		err = tx.QueryRow(ctx, `
+			WITH RECURSIVE ancestors AS (
+				SELECT id, parent_id FROM folders WHERE id = $1
+				UNION
+				SELECT f.id, f.parent_id
+				FROM folders f
+				JOIN ancestors a ON f.id = a.parent_id
+			)
+			SELECT EXISTS (SELECT 1 FROM ancestors WHERE id = $2)
+		`, *parentID, folderID).Scan(&cycle)
+		if err != nil {
+			return nil, err
+		}
+		if cycle {
+			return nil, ErrInvalidInput
+		}
+	}
+
+	row := tx.QueryRow(ctx, `
+		UPDATE folders
+		SET parent_id = $1, updated_at = NOW()
+		WHERE id = $2 AND owner_id = $3 AND deleted_at IS NULL
+		RETURNING `+folderColumns+`
+	`, parentID, folderID, ownerID)
+
+	var folder models.Folder
+	if err := scanFolder(row, &folder); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		if pgErr, ok := err.(*pgconn.PgError); ok && pgErr.Code == "23505" {
+			return nil, ErrDuplicate
+		}
+		return nil, err
+	}
+
+	return &folder, tx.Commit(ctx)
+}
+
+// SoftDelete deletes a folder together with every folder and document
+// beneath it and returns the ids of the documents. Everything removed shares
+// the same deleted_at timestamp.
+func (r *FolderRepo) SoftDelete(ctx context.Context, folderID, ownerID string) ([]string, error) {
+	tx, err := r.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	rows, err := tx.Query(ctx, `
+		WITH RECURSIVE subtree AS (
+			SELECT id FROM folders
+			WHERE id = $1 AND owner_id = $2 AND deleted_at IS NULL
+			UNION
+			SELECT f.id
+			FROM folders f
+			JOIN subtree s ON f.parent_id = s.id
+			WHERE f.deleted_at IS NULL
+		)
+		UPDATE folders
+		SET deleted_at = NOW(), updated_at = NOW()
+		WHERE id IN (SELECT id FROM subtree)
+		RETURNING id
+	`, folderID, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	folderIDs, err := scanIDs(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(folderIDs) == 0 {
+		return nil, ErrNotFound
+	}
+
+	rows, err = tx.Query(ctx, `
+		UPDATE documents
+		SET deleted_at = NOW(), updated_at = NOW()
+		WHERE folder_id = ANY($1::uuid[]) AND deleted_at IS NULL
+		RETURNING id
+	`, folderIDs)
+	if err != nil {
+		return nil, err
+	}
+	docIDs, err := scanIDs(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	return docIDs, tx.Commit(ctx)
+}
+
+const folderColumns = `id, owner_id, parent_id, name, created_at, updated_at, deleted_at`
+
+func scanFolder(row pgx.Row, folder *models.Folder) error {
+	return row.Scan(
+		&folder.ID,
+		&folder.OwnerID,
+		&folder.ParentID,
+		&folder.Name,
+		&folder.CreatedAt,
+		&folder.UpdatedAt,
+		&folder.DeletedAt,
+	)
+}
@@ -1,14 +1,23 @@
 package db
 
 import (
+	"context"
 	"errors"
+	"io/fs"
 	"strings"
 
 	"github.com/golang-migrate/migrate/v4"
 	_ "github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/source"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+// migrationsSource is where migrations are read from, relative to the
+// working directory; the Docker image ships them next to the binary
+const migrationsSource = "file://migrations"
+
 func RunMigrations(databaseURL, direction string) error {
 	if strings.TrimSpace(databaseURL) == "" {
 		return ErrMissingDatabaseURL
@@ -17,7 +26,7 @@ func RunMigrations(databaseURL, direction string) error {
 		direction = "up"
 	}
 
-	m, err := migrate.New("file://migrations", databaseURL)
+	m, err := migrate.New(migrationsSource, databaseURL)
 	if err != nil {
 		return err
 	}
@@ -37,3 +46,44 @@ func RunMigrations(databaseURL, direction string) error {
 
 	return nil
 }
+
+// LatestMigration returns the newest migration version this build ships
+func LatestMigration() (uint, error) {
+	src, err := source.Open(migrationsSource)
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+
+	version, err := src.First()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		next, err := src.Next(version)
+		if errors.Is(err, fs.ErrNotExist) {
+			return version, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		version = next
+	}
+}
+
+// MigrationVersion returns the version the database was last migrated to,
+// and whether that migration failed partway
+func MigrationVersion(ctx context.Context, pool *pgxpool.Pool) (uint, bool, error) {
+	var (
+		version int64
+		dirty   bool
+	)
+	err := pool.QueryRow(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&version, &dirty)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return uint(version), dirty, nil
+}
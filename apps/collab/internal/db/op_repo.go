@@ -2,7 +2,9 @@ package db
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"time"
 
 	"github.com/NoumanAMalik/maple/apps/collab/internal/models"
 	"github.com/jackc/pgx/v5"
@@ -18,28 +20,71 @@ func NewOpRepo(pool *pgxpool.Pool) *OpRepo {
 	return &OpRepo{pool: pool}
 }
 
-func (r *OpRepo) Append(ctx context.Context, docID string, version int64, opID, clientID string, opsJSON []byte) error {
-	var clientIDValue any
-	if clientID != "" {
-		clientIDValue = clientID
+// Append records an applied op batch, advances the document's current
+// version to it and queues ops.applied webhook deliveries, all or nothing.
+// It returns ErrNotFound if the document is gone or in the trash.
+func (r *OpRepo) Append(ctx context.Context, entry *models.DocumentOp) error {
+	var presence any
+	if len(entry.Presence) > 0 {
+		presence = entry.Presence
 	}
 
-	_, err := r.pool.Exec(ctx, `
-		INSERT INTO document_ops (document_id, version, op_id, client_id, ops)
-		VALUES ($1, $2, $3, $4, $5)
-	`, docID, version, opID, clientIDValue, opsJSON)
+	tx, err := r.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO document_ops (document_id, version, op_id, client_id, user_id, ops, presence)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, entry.DocumentID, entry.Version, entry.OpID, nullableString(entry.ClientID), nullableString(entry.UserID), entry.Ops, presence)
 	if err != nil {
 		if pgErr, ok := err.(*pgconn.PgError); ok && pgErr.Code == "23505" {
 			return ErrDuplicate
 		}
 		return err
 	}
-	return nil
+
+	commandTag, err := tx.Exec(ctx, `
+		UPDATE documents
+		SET current_version = GREATEST(current_version, $1), updated_at = NOW()
+		WHERE id = $2 AND deleted_at IS NULL
+	`, entry.Version, entry.DocumentID)
+	if err != nil {
+		return err
+	}
+	if commandTag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+
+	if err := enqueueWebhooks(ctx, tx, entry.DocumentID, models.WebhookOpsApplied, webhookOps{
+		Version:  entry.Version,
+		OpID:     entry.OpID,
+		ClientID: entry.ClientID,
+		UserID:   entry.UserID,
+		Ops:      entry.Ops,
+	}); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// webhookOps is the ops.applied payload
+type webhookOps struct {
+	Version  int64           `json:"version"`
+	OpID     string          `json:"opId"`
+	ClientID string          `json:"clientId,omitempty"`
+	UserID   string          `json:"userId,omitempty"`
+	Ops      json.RawMessage `json:"ops"`
 }
 
 func (r *OpRepo) ListSince(ctx context.Context, docID string, version int64) ([]models.DocumentOp, error) {
 	rows, err := r.pool.Query(ctx, `
-		SELECT id, document_id, version, op_id, client_id, ops, created_at
+		SELECT id, document_id, version, op_id, client_id, user_id::text, ops, presence, created_at
 		FROM document_ops
 		WHERE document_id = $1 AND version > $2
 		ORDER BY version ASC
@@ -49,16 +94,23 @@ func (r *OpRepo) ListSince(ctx context.Context, docID string, version int64) ([]
 	}
 	defer rows.Close()
 
+	return scanOps(rows)
+}
+
+func scanOps(rows pgx.Rows) ([]models.DocumentOp, error) {
 	ops := make([]models.DocumentOp, 0)
 	for rows.Next() {
 		var entry models.DocumentOp
-		var clientID *string
-		if err := rows.Scan(&entry.ID, &entry.DocumentID, &entry.Version, &entry.OpID, &clientID, &entry.Ops, &entry.CreatedAt); err != nil {
+		var clientID, userID *string
+		if err := rows.Scan(&entry.ID, &entry.DocumentID, &entry.Version, &entry.OpID, &clientID, &userID, &entry.Ops, &entry.Presence, &entry.CreatedAt); err != nil {
 			return nil, err
 		}
 		if clientID != nil {
 			entry.ClientID = *clientID
 		}
+		if userID != nil {
+			entry.UserID = *userID
+		}
 		ops = append(ops, entry)
 	}
 
@@ -69,6 +121,39 @@ func (r *OpRepo) ListSince(ctx context.Context, docID string, version int64) ([]
 	return ops, nil
 }
 
+// ListRange returns the op batches with afterVersion < version <= upToVersion.
+func (r *OpRepo) ListRange(ctx context.Context, docID string, afterVersion, upToVersion int64) ([]models.DocumentOp, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT id, document_id, version, op_id, client_id, user_id::text, ops, presence, created_at
+		FROM document_ops
+		WHERE document_id = $1 AND version > $2 AND version <= $3
+		ORDER BY version ASC
+	`, docID, afterVersion, upToVersion)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanOps(rows)
+}
+
+// VersionAt returns the latest version applied at or before t, or 0 when no
+// ops had been applied yet.
+func (r *OpRepo) VersionAt(ctx context.Context, docID string, t time.Time) (int64, error) {
+	row := r.pool.QueryRow(ctx, `
+		SELECT COALESCE(MAX(version), 0)
+		FROM document_ops
+		WHERE document_id = $1 AND created_at <= $2
+	`, docID, t)
+
+	var version int64
+	if err := row.Scan(&version); err != nil {
+		return 0, err
+	}
+
+	return version, nil
+}
+
 func (r *OpRepo) LatestVersion(ctx context.Context, docID string) (int64, error) {
 	row := r.pool.QueryRow(ctx, `
 		SELECT COALESCE(MAX(version), 0)
@@ -79,6 +79,40 @@ func (r *SessionRepo) Revoke(ctx context.Context, sessionID string) error {
 	return err
 }
 
+// RevokeIfActive revokes a session that has not already been revoked
+func (r *SessionRepo) RevokeIfActive(ctx context.Context, sessionID string) error {
+	commandTag, err := r.pool.Exec(ctx, `
+		UPDATE sessions
+		SET revoked_at = NOW()
+		WHERE id = $1 AND revoked_at IS NULL
+	`, sessionID)
+	if err != nil {
+		return err
+	}
+	if commandTag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// RevokeAllForUser revokes every active session of a user and returns how
+// many were revoked.
+func (r *SessionRepo) RevokeAllForUser(ctx context.Context, userID string) (int64, error) {
+	return revokeUserSessions(ctx, r.pool, userID)
+}
+
+func revokeUserSessions(ctx context.Context, q execer, userID string) (int64, error) {
+	commandTag, err := q.Exec(ctx, `
+		UPDATE sessions
+		SET revoked_at = NOW()
+		WHERE user_id = $1 AND revoked_at IS NULL
+	`, userID)
+	if err != nil {
+		return 0, err
+	}
+	return commandTag.RowsAffected(), nil
+}
+
 func (r *SessionRepo) Rotate(ctx context.Context, sessionID, newTokenHash string, expiresAt time.Time) error {
 	_, err := r.pool.Exec(ctx, `
 		UPDATE sessions
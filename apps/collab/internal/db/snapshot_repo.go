@@ -3,6 +3,7 @@ package db
 import (
 	"context"
 	"errors"
+	"time"
 
 	"github.com/NoumanAMalik/maple/apps/collab/internal/models"
 	"github.com/jackc/pgx/v5"
@@ -17,18 +18,52 @@ func NewSnapshotRepo(pool *pgxpool.Pool) *SnapshotRepo {
 	return &SnapshotRepo{pool: pool}
 }
 
-func (r *SnapshotRepo) Create(ctx context.Context, docID string, version int64, content string) (*models.DocumentSnapshot, error) {
-	row := r.pool.QueryRow(ctx, `
-		INSERT INTO document_snapshots (document_id, version, content)
-		VALUES ($1, $2, $3)
+// Create stores a snapshot with its JSON blame, which may be nil, and, when
+// it is the newest one, refreshes the content the document is indexed under
+// for search.
+func (r *SnapshotRepo) Create(ctx context.Context, docID string, version int64, content string, blame []byte) (*models.DocumentSnapshot, error) {
+	tx, err := r.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	row := tx.QueryRow(ctx, `
+		INSERT INTO document_snapshots (document_id, version, content, blame)
+		VALUES ($1, $2, $3, $4)
 		RETURNING id, document_id, version, content, created_at
-	`, docID, version, content)
+	`, docID, version, content, blame)
 
 	var snapshot models.DocumentSnapshot
 	if err := row.Scan(&snapshot.ID, &snapshot.DocumentID, &snapshot.Version, &snapshot.Content, &snapshot.CreatedAt); err != nil {
 		return nil, err
 	}
 
+	if _, err := tx.Exec(ctx, `
+		UPDATE documents
+		SET search_content = $2
+		WHERE id = $1 AND NOT EXISTS (
+			SELECT 1 FROM document_snapshots
+			WHERE document_id = $1 AND version > $3
+		)
+	`, docID, content, version); err != nil {
+		return nil, err
+	}
+
+	if err := enqueueWebhooks(ctx, tx, docID, models.WebhookSnapshotCreated, webhookSnapshot{
+		ID:        snapshot.ID,
+		Version:   snapshot.Version,
+		CreatedAt: snapshot.CreatedAt,
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
 	return &snapshot, nil
 }
 
@@ -51,3 +86,223 @@ func (r *SnapshotRepo) GetLatest(ctx context.Context, docID string) (*models.Doc
 
 	return &snapshot, nil
 }
+
+func (r *SnapshotRepo) GetAtOrBefore(ctx context.Context, docID string, version int64) (*models.DocumentSnapshot, error) {
+	row := r.pool.QueryRow(ctx, `
+		SELECT id, document_id, version, content, created_at
+		FROM document_snapshots
+		WHERE document_id = $1 AND version <= $2
+		ORDER BY version DESC
+		LIMIT 1
+	`, docID, version)
+
+	var snapshot models.DocumentSnapshot
+	if err := row.Scan(&snapshot.ID, &snapshot.DocumentID, &snapshot.Version, &snapshot.Content, &snapshot.CreatedAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return &snapshot, nil
+}
+
+// GetBlameBase returns the snapshot blame of version should start from: the
+// newest one at or before it with saved blame, or failing that the earliest.
+func (r *SnapshotRepo) GetBlameBase(ctx context.Context, docID string, version int64) (*models.DocumentSnapshot, error) {
+	row := r.pool.QueryRow(ctx, `
+		SELECT id, document_id, version, content, created_at, blame
+		FROM document_snapshots
+		WHERE document_id = $1 AND version <= $2 AND (
+			blame IS NOT NULL OR version = (
+				SELECT MIN(version) FROM document_snapshots WHERE document_id = $1
+			)
+		)
+		ORDER BY version DESC
+		LIMIT 1
+	`, docID, version)
+
+	var snapshot models.DocumentSnapshot
+	if err := row.Scan(&snapshot.ID, &snapshot.DocumentID, &snapshot.Version, &snapshot.Content, &snapshot.CreatedAt, &snapshot.Blame); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return &snapshot, nil
+}
+
+// ListByDocument returns snapshot metadata for a document, newest first.
+// Content is left empty to keep listings small.
+func (r *SnapshotRepo) ListByDocument(ctx context.Context, docID string) ([]models.DocumentSnapshot, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT id, document_id, version, created_at
+		FROM document_snapshots
+		WHERE document_id = $1
+		ORDER BY version DESC
+	`, docID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	snapshots := make([]models.DocumentSnapshot, 0)
+	for rows.Next() {
+		var snapshot models.DocumentSnapshot
+		if err := rows.Scan(&snapshot.ID, &snapshot.DocumentID, &snapshot.Version, &snapshot.CreatedAt); err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return snapshots, nil
+}
+
+// webhookSnapshot is the snapshot.created payload; content is left out to
+// keep deliveries small.
+type webhookSnapshot struct {
+	ID        string    `json:"id"`
+	Version   int64     `json:"version"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// CompactionCandidate is a document whose op log has grown past its latest
+// snapshot.
+type CompactionCandidate struct {
+	DocumentID      string
+	OwnerID         string
+	CurrentVersion  int64
+	SnapshotVersion int64
+}
+
+// ListCompactionCandidates returns live documents that are at least minOps
+// versions ahead of their latest snapshot, or any versions ahead of a latest
+// snapshot taken before staleBefore. A minOps of zero or a nil staleBefore
+// leaves that trigger out. Documents furthest behind come first.
+func (r *SnapshotRepo) ListCompactionCandidates(ctx context.Context, minOps int64, staleBefore *time.Time, limit int) ([]CompactionCandidate, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT d.id, d.owner_id, d.current_version, s.version
+		FROM documents d
+		JOIN LATERAL (
+			SELECT version, created_at
+			FROM document_snapshots
+			WHERE document_id = d.id
+			ORDER BY version DESC
+			LIMIT 1
+		) s ON TRUE
+		WHERE d.deleted_at IS NULL
+			AND d.current_version > s.version
+			AND (($1::bigint > 0 AND d.current_version - s.version >= $1::bigint) OR s.created_at < $2::timestamptz)
+		ORDER BY d.current_version - s.version DESC
+		LIMIT $3
+	`, minOps, staleBefore, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	candidates := make([]CompactionCandidate, 0)
+	for rows.Next() {
+		var candidate CompactionCandidate
+		if err := rows.Scan(&candidate.DocumentID, &candidate.OwnerID, &candidate.CurrentVersion, &candidate.SnapshotVersion); err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, candidate)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return candidates, nil
+}
+
+// Prune keeps the newest keep snapshots of a document and deletes older
+// snapshots along with every op at or before the oldest one kept. Versions
+// before that snapshot can no longer be materialized afterwards, and blame
+// credits what they changed to the blame saved with it. It returns how many
+// snapshots and ops were removed.
+//
+// The snapshot and ops needed to materialize the version any fork of the
+// document was taken at are never removed, so merges keep working. Prune
+// then stops at the newest snapshot at or before that version instead.
+func (r *SnapshotRepo) Prune(ctx context.Context, docID string, keep int) (int64, int64, error) {
+	if keep < 1 {
+		return 0, 0, ErrInvalidInput
+	}
+
+	tx, err := r.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return 0, 0, err
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	var oldestKept int64
+	err = tx.QueryRow(ctx, `
+		SELECT version
+		FROM document_snapshots
+		WHERE document_id = $1
+		ORDER BY version DESC
+		OFFSET $2
+		LIMIT 1
+	`, docID, keep-1).Scan(&oldestKept)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			// Fewer snapshots than we keep, nothing to prune
+			return 0, 0, nil
+		}
+		return 0, 0, err
+	}
+
+	var forkVersion *int64
+	err = tx.QueryRow(ctx, `
+		SELECT MIN(parent_version)
+		FROM documents
+		WHERE parent_id = $1
+	`, docID).Scan(&forkVersion)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var pruneTo *int64
+	err = tx.QueryRow(ctx, `
+		SELECT MAX(version)
+		FROM document_snapshots
+		WHERE document_id = $1 AND version <= $2 AND ($3::bigint IS NULL OR version <= $3)
+	`, docID, oldestKept, forkVersion).Scan(&pruneTo)
+	if err != nil {
+		return 0, 0, err
+	}
+	if pruneTo == nil {
+		return 0, 0, nil
+	}
+
+	snapshotTag, err := tx.Exec(ctx, `
+		DELETE FROM document_snapshots
+		WHERE document_id = $1 AND version < $2
+	`, docID, *pruneTo)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	opTag, err := tx.Exec(ctx, `
+		DELETE FROM document_ops
+		WHERE document_id = $1 AND version <= $2
+	`, docID, *pruneTo)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, 0, err
+	}
+
+	return snapshotTag.RowsAffected(), opTag.RowsAffected(), nil
+}
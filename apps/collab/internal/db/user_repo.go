@@ -19,6 +19,8 @@ func NewUserRepo(pool *pgxpool.Pool) *UserRepo {
 	return &UserRepo{pool: pool}
 }
 
+const userColumns = `id, email, display_name, password_hash, created_at, updated_at, disabled_at`
+
 func (r *UserRepo) Create(ctx context.Context, email, displayName, passwordHash string) (*models.User, error) {
 	cleanEmail := strings.ToLower(strings.TrimSpace(email))
 	cleanDisplay := strings.TrimSpace(displayName)
@@ -26,11 +28,11 @@ func (r *UserRepo) Create(ctx context.Context, email, displayName, passwordHash
 	row := r.pool.QueryRow(ctx, `
 		INSERT INTO users (email, password_hash, display_name)
 		VALUES ($1, $2, $3)
-		RETURNING id, email, display_name, password_hash, created_at, updated_at
+		RETURNING `+userColumns+`
 	`, cleanEmail, passwordHash, cleanDisplay)
 
 	var user models.User
-	if err := row.Scan(&user.ID, &user.Email, &user.DisplayName, &user.PasswordHash, &user.CreatedAt, &user.UpdatedAt); err != nil {
+	if err := scanUser(row, &user); err != nil {
 		if pgErr, ok := err.(*pgconn.PgError); ok && pgErr.Code == "23505" {
 			return nil, ErrDuplicate
 		}
@@ -43,13 +45,13 @@ func (r *UserRepo) Create(ctx context.Context, email, displayName, passwordHash
 func (r *UserRepo) GetByEmail(ctx context.Context, email string) (*models.User, error) {
 	cleanEmail := strings.ToLower(strings.TrimSpace(email))
 	row := r.pool.QueryRow(ctx, `
-		SELECT id, email, display_name, password_hash, created_at, updated_at
+		SELECT `+userColumns+`
 		FROM users
 		WHERE email = $1
 	`, cleanEmail)
 
 	var user models.User
-	if err := row.Scan(&user.ID, &user.Email, &user.DisplayName, &user.PasswordHash, &user.CreatedAt, &user.UpdatedAt); err != nil {
+	if err := scanUser(row, &user); err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, ErrNotFound
 		}
@@ -61,13 +63,13 @@ func (r *UserRepo) GetByEmail(ctx context.Context, email string) (*models.User,
 
 func (r *UserRepo) GetByID(ctx context.Context, id string) (*models.User, error) {
 	row := r.pool.QueryRow(ctx, `
-		SELECT id, email, display_name, password_hash, created_at, updated_at
+		SELECT `+userColumns+`
 		FROM users
 		WHERE id = $1
 	`, id)
 
 	var user models.User
-	if err := row.Scan(&user.ID, &user.Email, &user.DisplayName, &user.PasswordHash, &user.CreatedAt, &user.UpdatedAt); err != nil {
+	if err := scanUser(row, &user); err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, ErrNotFound
 		}
@@ -77,6 +79,34 @@ func (r *UserRepo) GetByID(ctx context.Context, id string) (*models.User, error)
 	return &user, nil
 }
 
+// List returns users, newest first
+func (r *UserRepo) List(ctx context.Context, limit int) ([]models.User, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT `+userColumns+`
+		FROM users
+		ORDER BY created_at DESC, id
+		LIMIT $1
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		var user models.User
+		if err := scanUser(rows, &user); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
 func (r *UserRepo) UpdatePassword(ctx context.Context, id, passwordHash string) error {
 	commandTag, err := r.pool.Exec(ctx, `
 		UPDATE users
@@ -91,3 +121,47 @@ func (r *UserRepo) UpdatePassword(ctx context.Context, id, passwordHash string)
 	}
 	return nil
 }
+
+// Disable stops a user from logging in and revokes all of their sessions.
+// It returns how many sessions were revoked.
+func (r *UserRepo) Disable(ctx context.Context, id string) (int64, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	commandTag, err := tx.Exec(ctx, `
+		UPDATE users
+		SET disabled_at = COALESCE(disabled_at, NOW()), updated_at = NOW()
+		WHERE id = $1
+	`, id)
+	if err != nil {
+		return 0, err
+	}
+	if commandTag.RowsAffected() == 0 {
+		return 0, ErrNotFound
+	}
+
+	revoked, err := revokeUserSessions(ctx, tx, id)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	return revoked, nil
+}
+
+func scanUser(row pgx.Row, user *models.User) error {
+	return row.Scan(
+		&user.ID,
+		&user.Email,
+		&user.DisplayName,
+		&user.PasswordHash,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+		&user.DisabledAt,
+	)
+}
@@ -0,0 +1,301 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/NoumanAMalik/maple/apps/collab/internal/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type WebhookRepo struct {
+	pool *pgxpool.Pool
+}
+
+func NewWebhookRepo(pool *pgxpool.Pool) *WebhookRepo {
+	return &WebhookRepo{pool: pool}
+}
+
+// Create adds a webhook unless the owner already has max of them, in which
+// case it returns ErrLimitReached.
+func (r *WebhookRepo) Create(ctx context.Context, ownerID string, documentID *string, url, secret string, events []string, max int) (*models.Webhook, error) {
+	tx, err := r.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	// Serialize creates by the same owner so they cannot overshoot max
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext('webhook_create:' || $1))`, ownerID); err != nil {
+		return nil, err
+	}
+	var count int
+	if err := tx.QueryRow(ctx, `SELECT COUNT(*) FROM webhooks WHERE owner_id = $1`, ownerID).Scan(&count); err != nil {
+		return nil, err
+	}
+	if count >= max {
+		return nil, ErrLimitReached
+	}
+
+	row := tx.QueryRow(ctx, `
+		INSERT INTO webhooks (owner_id, document_id, url, secret, events)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING `+webhookColumns+`
+	`, ownerID, documentID, url, secret, events)
+
+	var hook models.Webhook
+	if err := scanWebhook(row, &hook); err != nil {
+		if pgErr, ok := err.(*pgconn.PgError); ok && pgErr.Code == "23503" {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return &hook, tx.Commit(ctx)
+}
+
+func (r *WebhookRepo) GetByIDForOwner(ctx context.Context, webhookID, ownerID string) (*models.Webhook, error) {
+	row := r.pool.QueryRow(ctx, `
+		SELECT `+webhookColumns+`
+		FROM webhooks
+		WHERE id = $1 AND owner_id = $2
+	`, webhookID, ownerID)
+
+	var hook models.Webhook
+	if err := scanWebhook(row, &hook); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return &hook, nil
+}
+
+func (r *WebhookRepo) ListByOwner(ctx context.Context, ownerID string) ([]models.Webhook, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT `+webhookColumns+`
+		FROM webhooks
+		WHERE owner_id = $1
+		ORDER BY created_at DESC
+	`, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hooks := make([]models.Webhook, 0)
+	for rows.Next() {
+		var hook models.Webhook
+		if err := scanWebhook(rows, &hook); err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, hook)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return hooks, nil
+}
+
+// Delete removes a webhook together with its delivery log.
+func (r *WebhookRepo) Delete(ctx context.Context, webhookID, ownerID string) error {
+	commandTag, err := r.pool.Exec(ctx, `
+		DELETE FROM webhooks
+		WHERE id = $1 AND owner_id = $2
+	`, webhookID, ownerID)
+	if err != nil {
+		return err
+	}
+	if commandTag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// DeleteFinishedDeliveries removes delivered and failed deliveries created
+// before cutoff and returns how many it removed. Pending ones are kept
+// however old, since the dispatcher still owes them an attempt.
+func (r *WebhookRepo) DeleteFinishedDeliveries(ctx context.Context, cutoff time.Time) (int64, error) {
+	commandTag, err := r.pool.Exec(ctx, `
+		DELETE FROM webhook_deliveries
+		WHERE status IN ('delivered', 'failed') AND created_at < $1
+	`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return commandTag.RowsAffected(), nil
+}
+
+// ListDeliveries returns a webhook's most recent deliveries, newest first.
+func (r *WebhookRepo) ListDeliveries(ctx context.Context, webhookID string, limit int) ([]models.WebhookDelivery, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT `+deliveryColumns+`
+		FROM webhook_deliveries
+		WHERE webhook_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2
+	`, webhookID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := make([]models.WebhookDelivery, 0)
+	for rows.Next() {
+		var delivery models.WebhookDelivery
+		if err := scanDelivery(rows, &delivery); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// Enqueue adds a delivery of event for docID to every webhook subscribed to
+// it. Use it for changes that are not written by a repo transaction.
+func (r *WebhookRepo) Enqueue(ctx context.Context, docID, event string, data any) error {
+	return enqueueWebhooks(ctx, r.pool, docID, event, data)
+}
+
+// ClaimDue picks up to limit deliveries that are due, counts the attempt and
+// leases them for lease so other dispatchers skip them while they are sent.
+func (r *WebhookRepo) ClaimDue(ctx context.Context, limit int, lease time.Duration) ([]models.WebhookDelivery, error) {
+	rows, err := r.pool.Query(ctx, `
+		UPDATE webhook_deliveries d
+		SET attempts = d.attempts + 1, next_attempt_at = NOW() + make_interval(secs => $2)
+		FROM webhooks w
+		WHERE w.id = d.webhook_id AND d.id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE status = 'pending' AND next_attempt_at <= NOW()
+			ORDER BY next_attempt_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING d.id, d.webhook_id, d.event, d.payload, d.status, d.attempts, d.next_attempt_at,
+			d.last_status_code, d.last_error, d.created_at, d.delivered_at, w.url, w.secret
+	`, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := make([]models.WebhookDelivery, 0)
+	for rows.Next() {
+		var delivery models.WebhookDelivery
+		if err := scanDelivery(rows, &delivery, &delivery.URL, &delivery.Secret); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+func (r *WebhookRepo) MarkDelivered(ctx context.Context, deliveryID string, statusCode int) error {
+	_, err := r.pool.Exec(ctx, `
+		UPDATE webhook_deliveries
+		SET status = 'delivered', last_status_code = $2, last_error = NULL, delivered_at = NOW()
+		WHERE id = $1
+	`, deliveryID, statusCode)
+	return err
+}
+
+// MarkFailed records a failed attempt. The delivery is retried at retryAt,
+// or given up on when retryAt is nil. statusCode is 0 when no response was
+// received.
+func (r *WebhookRepo) MarkFailed(ctx context.Context, deliveryID string, statusCode int, message string, retryAt *time.Time) error {
+	var code *int
+	if statusCode != 0 {
+		code = &statusCode
+	}
+
+	_, err := r.pool.Exec(ctx, `
+		UPDATE webhook_deliveries
+		SET status = CASE WHEN $4::timestamptz IS NULL THEN 'failed' ELSE 'pending' END,
+			next_attempt_at = COALESCE($4, next_attempt_at),
+			last_status_code = $2,
+			last_error = $3
+		WHERE id = $1
+	`, deliveryID, code, message, retryAt)
+	return err
+}
+
+type execer interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+}
+
+// enqueueWebhooks writes outbox rows for event through q, so callers can
+// record the delivery in the same transaction as the change itself.
+func enqueueWebhooks(ctx context.Context, q execer, docID, event string, data any) error {
+	payload, err := json.Marshal(models.WebhookPayload{
+		Event:      event,
+		DocumentID: docID,
+		OccurredAt: time.Now().UTC(),
+		Data:       data,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = q.Exec(ctx, `
+		INSERT INTO webhook_deliveries (webhook_id, event, payload)
+		SELECT w.id, $2, $3
+		FROM webhooks w
+		JOIN documents d ON d.id = $1 AND d.owner_id = w.owner_id
+		WHERE $2 = ANY(w.events) AND (w.document_id IS NULL OR w.document_id = d.id)
+	`, docID, event, payload)
+	return err
+}
+
+const webhookColumns = `id, owner_id, document_id, url, secret, events, created_at`
+
+func scanWebhook(row pgx.Row, hook *models.Webhook) error {
+	return row.Scan(
+		&hook.ID,
+		&hook.OwnerID,
+		&hook.DocumentID,
+		&hook.URL,
+		&hook.Secret,
+		&hook.Events,
+		&hook.CreatedAt,
+	)
+}
+
+const deliveryColumns = `id, webhook_id, event, payload, status, attempts, next_attempt_at, last_status_code, last_error, created_at, delivered_at`
+
+// scanDelivery scans deliveryColumns into delivery, followed by any extra
+// columns selected after them
+func scanDelivery(row pgx.Row, delivery *models.WebhookDelivery, extra ...any) error {
+	dest := []any{
+		&delivery.ID,
+		&delivery.WebhookID,
+		&delivery.Event,
+		&delivery.Payload,
+		&delivery.Status,
+		&delivery.Attempts,
+		&delivery.NextAttemptAt,
+		&delivery.LastStatusCode,
+		&delivery.LastError,
+		&delivery.CreatedAt,
+		&delivery.DeliveredAt,
+	}
+	return row.Scan(append(dest, extra...)...)
+}
@@ -0,0 +1,99 @@
+package events
+
+import "sync"
+
+// subscriberBuffer is how many events a subscriber may fall behind before
+// it is dropped
+const subscriberBuffer = 64
+
+// Event is one change to a document. ID is the document version the event
+// happened at, so feeds can resume from a version.
+type Event struct {
+	ID   int64
+	Type string
+	Data any
+}
+
+// Hub fans document events out to subscribers in this process. A nil Hub
+// accepts and discards every event.
+type Hub struct {
+	mu   sync.Mutex
+	subs map[string]map[*Subscription]struct{}
+}
+
+func NewHub() *Hub {
+	return &Hub{
+		subs: make(map[string]map[*Subscription]struct{}),
+	}
+}
+
+// Subscription receives the events published for one document
+type Subscription struct {
+	hub   *Hub
+	docID string
+	ch    chan Event
+}
+
+// Subscribe starts receiving events for docID. Callers must Close the
+// subscription when done.
+func (h *Hub) Subscribe(docID string) *Subscription {
+	sub := &Subscription{
+		hub:   h,
+		docID: docID,
+		ch:    make(chan Event, subscriberBuffer),
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.subs[docID] == nil {
+		h.subs[docID] = make(map[*Subscription]struct{})
+	}
+	h.subs[docID][sub] = struct{}{}
+	return sub
+}
+
+// Publish sends event to every subscriber of docID without blocking. A
+// subscriber whose buffer is full is dropped and its channel closed; it is
+// expected to reconnect and resume from the last event it saw.
+func (h *Hub) Publish(docID string, event Event) {
+	if h == nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sub := range h.subs[docID] {
+		select {
+		case sub.ch <- event:
+		default:
+			h.removeLocked(sub)
+		}
+	}
+}
+
+// Events returns the channel events are delivered on. It is closed when the
+// subscription is closed or dropped for falling behind.
+func (s *Subscription) Events() <-chan Event {
+	return s.ch
+}
+
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	s.hub.removeLocked(s)
+}
+
+func (h *Hub) removeLocked(sub *Subscription) {
+	subs, ok := h.subs[sub.docID]
+	if !ok {
+		return
+	}
+	if _, ok := subs[sub]; !ok {
+		return
+	}
+	delete(subs, sub)
+	close(sub.ch)
+	if len(subs) == 0 {
+		delete(h.subs, sub.docID)
+	}
+}
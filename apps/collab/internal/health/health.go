@@ -0,0 +1,188 @@
+// Package health runs the readiness checks behind /ready. Results are cached
+// between runs so request paths can ask whether a dependency is up without
+// waiting on it.
+package health
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Status of a check or of the server as a whole
+type Status string
+
+const (
+	StatusOK       Status = "ok"
+	StatusDegraded Status = "degraded"
+	StatusFail     Status = "fail"
+	StatusSkipped  Status = "skipped"
+)
+
+// Check is one dependency to probe. Run returns an optional detail to show
+// when it passes.
+type Check struct {
+	Name    string
+	Timeout time.Duration
+	// Critical checks make the server unready when they fail; others only
+	// degrade it
+	Critical bool
+	// DependsOn names a check that must pass first, otherwise this one is
+	// skipped. Only one level of dependency is supported.
+	DependsOn string
+	Run       func(ctx context.Context) (string, error)
+}
+
+type Result struct {
+	Status    Status    `json:"status"`
+	LatencyMS float64   `json:"latencyMs"`
+	Detail    string    `json:"detail,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checkedAt"`
+}
+
+type Report struct {
+	Status Status            `json:"status"`
+	Checks map[string]Result `json:"checks"`
+}
+
+// Checker runs a fixed set of checks on demand and in the background
+type Checker struct {
+	checks []Check
+	logger *slog.Logger
+
+	mu   sync.RWMutex
+	last Report
+}
+
+func NewChecker(logger *slog.Logger, checks ...Check) *Checker {
+	return &Checker{
+		checks: checks,
+		logger: logger,
+		last:   Report{Status: StatusOK, Checks: map[string]Result{}},
+	}
+}
+
+// Start runs the checks every interval until ctx is done, so Healthy stays
+// current even when nothing is probing /ready
+func (c *Checker) Start(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			c.Run(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Run executes every check, each bounded by its own timeout, and returns and
+// caches the report
+func (c *Checker) Run(ctx context.Context) Report {
+	results := make(map[string]Result, len(c.checks))
+
+	// Checks without dependencies first, in parallel, then their dependents
+	c.runAll(ctx, results, func(check Check) bool { return check.DependsOn == "" })
+	c.runAll(ctx, results, func(check Check) bool { return check.DependsOn != "" })
+
+	report := Report{Status: StatusOK, Checks: results}
+	for _, check := range c.checks {
+		if results[check.Name].Status != StatusFail {
+			continue
+		}
+		if check.Critical {
+			report.Status = StatusFail
+		} else if report.Status == StatusOK {
+			report.Status = StatusDegraded
+		}
+	}
+
+	c.mu.Lock()
+	previous := c.last.Status
+	c.last = report
+	c.mu.Unlock()
+
+	if report.Status != previous {
+		c.logger.Warn("readiness changed", "from", previous, "to", report.Status)
+	}
+	return report
+}
+
+func (c *Checker) runAll(ctx context.Context, results map[string]Result, include func(Check) bool) {
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	for _, check := range c.checks {
+		if !include(check) {
+			continue
+		}
+		if check.DependsOn != "" {
+			mu.Lock()
+			dep, ok := results[check.DependsOn]
+			skip := !ok || dep.Status != StatusOK
+			if skip {
+				results[check.Name] = Result{
+					Status:    StatusSkipped,
+					Detail:    check.DependsOn + " is unavailable",
+					CheckedAt: time.Now(),
+				}
+			}
+			mu.Unlock()
+			if skip {
+				continue
+			}
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result := runCheck(ctx, check)
+			mu.Lock()
+			results[check.Name] = result
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+}
+
+func runCheck(ctx context.Context, check Check) Result {
+	ctx, cancel := context.WithTimeout(ctx, check.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	detail, err := check.Run(ctx)
+	result := Result{
+		Status:    StatusOK,
+		LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+		Detail:    detail,
+		CheckedAt: start,
+	}
+	if err != nil {
+		result.Status = StatusFail
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// Report returns the report from the last run.
+func (c *Checker) Report() Report {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.last
+}
+
+// Healthy reports whether the named check passed on its last run. A check
+// that has not run yet counts as healthy.
+func (c *Checker) Healthy(name string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	result, ok := c.last.Checks[name]
+	return !ok || result.Status == StatusOK
+}
@@ -0,0 +1,287 @@
+package history
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/NoumanAMalik/maple/apps/collab/internal/collab"
+	"github.com/NoumanAMalik/maple/apps/collab/internal/db"
+	"github.com/NoumanAMalik/maple/apps/collab/internal/models"
+)
+
+// ErrVersionUnavailable is returned when a version cannot be rebuilt, either
+// because it is out of range or because the ops leading up to it are missing.
+var ErrVersionUnavailable = errors.New("version unavailable")
+
+// Service rebuilds historical document content from snapshots and the op
+// log, and records ops applied in live document rooms.
+type Service struct {
+	docs      *db.DocumentRepo
+	ops       *db.OpRepo
+	snapshots *db.SnapshotRepo
+	webhooks  *db.WebhookRepo
+}
+
+func NewService(docs *db.DocumentRepo, ops *db.OpRepo, snapshots *db.SnapshotRepo, webhooks *db.WebhookRepo) *Service {
+	return &Service{
+		docs:      docs,
+		ops:       ops,
+		snapshots: snapshots,
+		webhooks:  webhooks,
+	}
+}
+
+// Materialize returns the content of a document at exactly the given version
+// by replaying document_ops on top of the nearest snapshot at or before it.
+func (s *Service) Materialize(ctx context.Context, docID string, version int64) (string, error) {
+	if version < 0 {
+		return "", ErrVersionUnavailable
+	}
+
+	snapshot, err := s.snapshots.GetAtOrBefore(ctx, docID, version)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			return "", ErrVersionUnavailable
+		}
+		return "", err
+	}
+	if snapshot.Version == version {
+		return snapshot.Content, nil
+	}
+
+	entries, err := s.ops.ListRange(ctx, docID, snapshot.Version, version)
+	if err != nil {
+		return "", err
+	}
+
+	return Replay(snapshot.Content, snapshot.Version, version, entries)
+}
+
+// Diff computes a line diff between two versions of a document.
+func (s *Service) Diff(ctx context.Context, docID string, fromVersion, toVersion int64) (*collab.DiffResult, error) {
+	fromContent, err := s.Materialize(ctx, docID, fromVersion)
+	if err != nil {
+		return nil, err
+	}
+	toContent, err := s.Materialize(ctx, docID, toVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := collab.ComputeLineDiff(fromContent, toContent)
+	return &diff, nil
+}
+
+// Replay applies entries to content, which must be the document at
+// fromVersion, and returns the document at toVersion. Entries must be
+// contiguous and ordered by version.
+func Replay(content string, fromVersion, toVersion int64, entries []models.DocumentOp) (string, error) {
+	expected := fromVersion + 1
+	for _, entry := range entries {
+		if entry.Version > toVersion {
+			break
+		}
+		if entry.Version != expected {
+			return "", ErrVersionUnavailable
+		}
+
+		ops, err := DecodeOps(entry)
+		if err != nil {
+			return "", err
+		}
+		content, err = collab.ApplyOperations(content, ops)
+		if err != nil {
+			return "", err
+		}
+		expected++
+	}
+
+	if expected-1 != toVersion {
+		return "", ErrVersionUnavailable
+	}
+
+	return content, nil
+}
+
+// DecodeOps decodes the JSON op batch stored with a document_ops row.
+func DecodeOps(entry models.DocumentOp) ([]collab.Operation, error) {
+	var ops []collab.Operation
+	if err := json.Unmarshal(entry.Ops, &ops); err != nil {
+		return nil, err
+	}
+	return ops, nil
+}
+
+// Blame returns the author of the last change to every line of a document
+// at version. It replays the op log from the newest snapshot with saved
+// blame, or from the earliest retained snapshot when none has any. Content
+// from the initial snapshot is attributed to the document owner; content
+// from a compaction snapshot saved without blame has no known author beyond
+// its version.
+func (s *Service) Blame(ctx context.Context, doc *models.Document, version int64) ([]collab.BlameLine, error) {
+	if version < 0 || version > doc.CurrentVersion {
+		return nil, ErrVersionUnavailable
+	}
+
+	content, tracker, err := s.track(ctx, doc, version)
+	if err != nil {
+		return nil, err
+	}
+	return tracker.Lines(content), nil
+}
+
+// SnapshotState returns the content of a document at version along with its
+// blame as JSON, for saving with a compaction snapshot. Starting from the
+// previous one, it only replays the ops since.
+func (s *Service) SnapshotState(ctx context.Context, doc *models.Document, version int64) (string, []byte, error) {
+	content, tracker, err := s.track(ctx, doc, version)
+	if err != nil {
+		return "", nil, err
+	}
+	blame, err := json.Marshal(tracker.Spans())
+	if err != nil {
+		return "", nil, err
+	}
+	return content, blame, nil
+}
+
+// track replays a document up to version, following who wrote what
+func (s *Service) track(ctx context.Context, doc *models.Document, version int64) (string, *collab.BlameTracker, error) {
+	snapshot, err := s.snapshots.GetBlameBase(ctx, doc.ID, version)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			return "", nil, ErrVersionUnavailable
+		}
+		return "", nil, err
+	}
+
+	entries, err := s.ops.ListRange(ctx, doc.ID, snapshot.Version, version)
+	if err != nil {
+		return "", nil, err
+	}
+
+	content := snapshot.Content
+	tracker := snapshotTracker(doc, snapshot)
+	expected := snapshot.Version + 1
+	for _, entry := range entries {
+		if entry.Version != expected {
+			return "", nil, ErrVersionUnavailable
+		}
+
+		ops, err := DecodeOps(entry)
+		if err != nil {
+			return "", nil, err
+		}
+		content, err = collab.ApplyOperations(content, ops)
+		if err != nil {
+			return "", nil, err
+		}
+		tracker.Apply(ops, collab.Attribution{
+			UserID:    entry.UserID,
+			ClientID:  entry.ClientID,
+			Version:   int(entry.Version),
+			Timestamp: entry.CreatedAt,
+		})
+		expected++
+	}
+	if expected-1 != version {
+		return "", nil, ErrVersionUnavailable
+	}
+
+	return content, tracker, nil
+}
+
+// snapshotTracker starts blame at snapshot, from its saved blame when it has
+// usable blame and otherwise crediting all of it to the snapshot itself
+func snapshotTracker(doc *models.Document, snapshot *models.DocumentSnapshot) *collab.BlameTracker {
+	if len(snapshot.Blame) > 0 {
+		var spans []collab.BlameSpan
+		if err := json.Unmarshal(snapshot.Blame, &spans); err == nil {
+			if tracker, ok := collab.RestoreBlameTracker(snapshot.Content, spans); ok {
+				return tracker
+			}
+		}
+	}
+
+	seed := collab.Attribution{
+		Version:   int(snapshot.Version),
+		Timestamp: snapshot.CreatedAt,
+	}
+	if snapshot.Version == 0 {
+		seed.UserID = doc.OwnerID
+	}
+	return collab.NewBlameTracker(snapshot.Content, seed)
+}
+
+// PersistOps appends an op batch applied in a live document room to
+// document_ops and advances the document's current version.
+func (s *Service) PersistOps(ctx context.Context, docID string, entry collab.OpHistoryEntry) error {
+	opsJSON, err := json.Marshal(entry.Ops)
+	if err != nil {
+		return err
+	}
+
+	record := &models.DocumentOp{
+		DocumentID: docID,
+		Version:    int64(entry.Version),
+		OpID:       entry.OpID,
+		ClientID:   entry.ClientID,
+		UserID:     entry.UserID,
+		Ops:        opsJSON,
+	}
+	if entry.Presence != nil {
+		record.Presence, err = json.Marshal(entry.Presence)
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.ops.Append(ctx, record)
+}
+
+// DocumentRoomClosed queues room.closed webhook deliveries once a live
+// document room has been closed.
+func (s *Service) DocumentRoomClosed(ctx context.Context, docID string, version int) error {
+	return s.webhooks.Enqueue(ctx, docID, models.WebhookRoomClosed, roomClosed{Version: int64(version)})
+}
+
+type roomClosed struct {
+	Version int64 `json:"version"`
+}
+
+// RoomState returns what a live room for doc needs: the latest content and
+// the most recent op batches for transforming late submissions.
+func (s *Service) RoomState(ctx context.Context, doc *models.Document) (string, []collab.OpHistoryEntry, error) {
+	content, err := s.Materialize(ctx, doc.ID, doc.CurrentVersion)
+	if err != nil {
+		return "", nil, err
+	}
+
+	after := doc.CurrentVersion - collab.OpHistoryLimit
+	if after < 0 {
+		after = 0
+	}
+	entries, err := s.ops.ListRange(ctx, doc.ID, after, doc.CurrentVersion)
+	if err != nil {
+		return "", nil, err
+	}
+
+	history := make([]collab.OpHistoryEntry, 0, len(entries))
+	for _, entry := range entries {
+		ops, err := DecodeOps(entry)
+		if err != nil {
+			return "", nil, err
+		}
+		history = append(history, collab.OpHistoryEntry{
+			Version:   int(entry.Version),
+			Ops:       ops,
+			ClientID:  entry.ClientID,
+			UserID:    entry.UserID,
+			OpID:      entry.OpID,
+			AppliedAt: entry.CreatedAt,
+		})
+	}
+
+	return content, history, nil
+}
@@ -0,0 +1,53 @@
+package history
+
+import (
+	"context"
+	"errors"
+
+	"github.com/NoumanAMalik/maple/apps/collab/internal/collab"
+	"github.com/NoumanAMalik/maple/apps/collab/internal/models"
+)
+
+// ErrNotForked is returned when merging a document that has no parent.
+var ErrNotForked = errors.New("document is not a fork")
+
+// MergePlan is a three-way merge of a fork back into its parent. The base is
+// the parent at the version the fork was taken from, "ours" is the parent at
+// ParentVersion and "theirs" is the fork at ForkVersion.
+type MergePlan struct {
+	BaseVersion   int64
+	ParentVersion int64
+	ForkVersion   int64
+	ParentContent string
+	Result        *collab.MergeResult
+}
+
+// PlanMerge merges fork into its parent, whose content at parentVersion is
+// passed in so callers can merge against a live room rather than the op log.
+func (s *Service) PlanMerge(ctx context.Context, fork *models.Document, parentContent string, parentVersion int64) (*MergePlan, error) {
+	if fork.ParentID == nil || fork.ParentVersion == nil {
+		return nil, ErrNotForked
+	}
+
+	base, err := s.Materialize(ctx, *fork.ParentID, *fork.ParentVersion)
+	if err != nil {
+		return nil, err
+	}
+	theirs, err := s.Materialize(ctx, fork.ID, fork.CurrentVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MergePlan{
+		BaseVersion:   *fork.ParentVersion,
+		ParentVersion: parentVersion,
+		ForkVersion:   fork.CurrentVersion,
+		ParentContent: parentContent,
+		Result:        collab.ThreeWayMerge(base, parentContent, theirs),
+	}, nil
+}
+
+// Ops returns the op batch that turns the parent content into merged.
+func (p *MergePlan) Ops(merged string) []collab.Operation {
+	return collab.DiffToOps(p.ParentContent, merged)
+}
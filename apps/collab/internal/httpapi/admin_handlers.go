@@ -0,0 +1,198 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/NoumanAMalik/maple/apps/collab/internal/collab"
+	"github.com/NoumanAMalik/maple/apps/collab/internal/config"
+)
+
+// defaultCloseReason is sent to clients when an operator gives no reason
+const defaultCloseReason = "Room closed by an operator"
+
+// AdminHandlers serve operator endpoints under /v1/admin, guarded by
+// AdminMiddleware rather than user auth.
+type AdminHandlers struct {
+	registry *collab.RoomRegistry
+	reloader *config.Reloader
+	logger   *slog.Logger
+}
+
+func NewAdminHandlers(registry *collab.RoomRegistry, reloader *config.Reloader, logger *slog.Logger) *AdminHandlers {
+	return &AdminHandlers{
+		registry: registry,
+		reloader: reloader,
+		logger:   logger,
+	}
+}
+
+type AdminRoomResponse struct {
+	RoomID           string     `json:"roomId"`
+	DocumentID       string     `json:"documentId,omitempty"`
+	OwnerID          string     `json:"ownerId,omitempty"`
+	Language         string     `json:"language,omitempty"`
+	Version          int        `json:"version"`
+	ParticipantCount int        `json:"participantCount"`
+	ContentBytes     int        `json:"contentBytes"`
+	SnapshotCount    int        `json:"snapshotCount"`
+	LastEditAt       time.Time  `json:"lastEditAt"`
+	IdleSeconds      int64      `json:"idleSeconds"`
+	EmptySince       *time.Time `json:"emptySince,omitempty"`
+	CreatedAt        time.Time  `json:"createdAt"`
+}
+
+type AdminRoomListResponse struct {
+	Rooms []AdminRoomResponse `json:"rooms"`
+}
+
+type AdminParticipantResponse struct {
+	ClientID    string           `json:"clientId"`
+	UserID      string           `json:"userId,omitempty"`
+	DisplayName string           `json:"displayName,omitempty"`
+	Color       string           `json:"color"`
+	JoinedAt    time.Time        `json:"joinedAt"`
+	Presence    *collab.Presence `json:"presence,omitempty"`
+}
+
+type AdminRoomDetailResponse struct {
+	AdminRoomResponse
+	Participants []AdminParticipantResponse `json:"participants"`
+}
+
+type AdminCloseRoomRequest struct {
+	Reason string `json:"reason"`
+}
+
+type AdminSnapshotRequest struct {
+	Message string `json:"message"`
+}
+
+func (h *AdminHandlers) ListRooms(w http.ResponseWriter, r *http.Request) {
+	rooms := h.registry.Rooms()
+	resp := AdminRoomListResponse{Rooms: make([]AdminRoomResponse, 0, len(rooms))}
+	now := time.Now()
+	for _, room := range rooms {
+		resp.Rooms = append(resp.Rooms, adminRoomResponse(room, now))
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// GetRoom returns a room's summary along with who is connected and where
+// their cursors are
+func (h *AdminHandlers) GetRoom(w http.ResponseWriter, r *http.Request) {
+	room, ok := h.registry.GetRoom(chi.URLParam(r, "roomId"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "room_not_found", "Room does not exist")
+		return
+	}
+
+	participants := room.Participants()
+	resp := AdminRoomDetailResponse{
+		AdminRoomResponse: adminRoomResponse(room, time.Now()),
+		Participants:      make([]AdminParticipantResponse, 0, len(participants)),
+	}
+	for _, p := range participants {
+		resp.Participants = append(resp.Participants, AdminParticipantResponse{
+			ClientID:    p.ClientID,
+			UserID:      p.UserID,
+			DisplayName: p.DisplayName,
+			Color:       p.Color,
+			JoinedAt:    p.JoinedAt,
+			Presence:    p.Presence,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// CloseRoom disconnects everyone in a room and removes it. Clients are told
+// the reason and do not reconnect. Document rooms reopen from the database
+// on the next request; anonymous rooms are gone for good.
+func (h *AdminHandlers) CloseRoom(w http.ResponseWriter, r *http.Request) {
+	var req AdminCloseRoomRequest
+	if !decodeOptionalJSON(w, r, &req) {
+		return
+	}
+	if req.Reason == "" {
+		req.Reason = defaultCloseReason
+	}
+
+	roomID := chi.URLParam(r, "roomId")
+	if !h.registry.CloseRoom(roomID, req.Reason) {
+		writeError(w, http.StatusNotFound, "room_not_found", "Room does not exist")
+		return
+	}
+
+	h.logger.Warn("room force-closed by admin", "roomId", roomID, "reason", req.Reason)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CreateSnapshot takes a manual snapshot of a room, as if a participant had
+// saved
+func (h *AdminHandlers) CreateSnapshot(w http.ResponseWriter, r *http.Request) {
+	var req AdminSnapshotRequest
+	if !decodeOptionalJSON(w, r, &req) {
+		return
+	}
+
+	room, ok := h.registry.GetRoom(chi.URLParam(r, "roomId"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "room_not_found", "Room does not exist")
+		return
+	}
+
+	snapshot := room.SaveSnapshot(r.Context(), "admin", req.Message)
+	h.logger.Info("snapshot created by admin", "roomId", room.ID, "snapshotId", snapshot.ID, "version", snapshot.Version)
+	writeJSON(w, http.StatusCreated, snapshot)
+}
+
+// Reload re-reads the configuration like SIGHUP does and reports what
+// changed. A config that fails validation is rejected whole.
+func (h *AdminHandlers) Reload(w http.ResponseWriter, r *http.Request) {
+	result, err := h.reloader.Reload()
+	if err != nil {
+		h.logger.Error("config reload rejected", "problems", strings.Split(err.Error(), "\n"))
+		writeError(w, http.StatusUnprocessableEntity, "invalid_config", strings.ReplaceAll(err.Error(), "\n", "; "))
+		return
+	}
+
+	h.logger.Info("config reloaded", "applied", result.Applied, "restartRequired", result.RestartRequired)
+	writeJSON(w, http.StatusOK, result)
+}
+
+func adminRoomResponse(room *collab.Room, now time.Time) AdminRoomResponse {
+	info := room.Info()
+	return AdminRoomResponse{
+		RoomID:           room.ID,
+		DocumentID:       room.DocumentID,
+		OwnerID:          room.OwnerID,
+		Language:         room.Language,
+		Version:          info.Version,
+		ParticipantCount: info.Clients,
+		ContentBytes:     info.ContentBytes,
+		SnapshotCount:    info.SnapshotCount,
+		LastEditAt:       info.LastEditAt,
+		IdleSeconds:      int64(now.Sub(info.LastEditAt).Seconds()),
+		EmptySince:       info.EmptySince,
+		CreatedAt:        room.CreatedAt,
+	}
+}
+
+// decodeOptionalJSON decodes a request body that may be left out entirely,
+// writing a 400 if it is present but malformed
+func decodeOptionalJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, http.StatusBadRequest, "invalid_request", "Invalid JSON body")
+		return false
+	}
+	return true
+}
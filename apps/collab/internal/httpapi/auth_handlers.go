@@ -13,6 +13,7 @@ import (
 	"github.com/NoumanAMalik/maple/apps/collab/internal/auth"
 	"github.com/NoumanAMalik/maple/apps/collab/internal/config"
 	"github.com/NoumanAMalik/maple/apps/collab/internal/db"
+	"github.com/NoumanAMalik/maple/apps/collab/internal/metrics"
 	"github.com/NoumanAMalik/maple/apps/collab/internal/models"
 )
 
@@ -23,16 +24,18 @@ type AuthHandlers struct {
 	sessions *db.SessionRepo
 	tokens   *auth.TokenManager
 	hasher   *auth.PasswordHasher
+	tickets  *auth.TicketStore
 	cfg      *config.Config
 	logger   *slog.Logger
 }
 
-func NewAuthHandlers(users *db.UserRepo, sessions *db.SessionRepo, tokens *auth.TokenManager, hasher *auth.PasswordHasher, cfg *config.Config, logger *slog.Logger) *AuthHandlers {
+func NewAuthHandlers(users *db.UserRepo, sessions *db.SessionRepo, tokens *auth.TokenManager, hasher *auth.PasswordHasher, tickets *auth.TicketStore, cfg *config.Config, logger *slog.Logger) *AuthHandlers {
 	return &AuthHandlers{
 		users:    users,
 		sessions: sessions,
 		tokens:   tokens,
 		hasher:   hasher,
+		tickets:  tickets,
 		cfg:      cfg,
 		logger:   logger,
 	}
@@ -60,6 +63,11 @@ type UserResponse struct {
 	DisplayName string `json:"displayName"`
 }
 
+type TicketResponse struct {
+	Ticket    string `json:"ticket"`
+	ExpiresAt string `json:"expiresAt"`
+}
+
 type AuthResponse struct {
 	User        UserResponse `json:"user"`
 	AccessToken string       `json:"accessToken"`
@@ -130,6 +138,7 @@ func (h *AuthHandlers) Login(w http.ResponseWriter, r *http.Request) {
 
 	email, err := normalizeEmail(req.Email)
 	if err != nil {
+		metrics.AuthFailures.WithLabelValues(metrics.AuthInvalidCredentials).Inc()
 		writeError(w, http.StatusUnauthorized, "invalid_credentials", "Invalid credentials")
 		return
 	}
@@ -137,6 +146,7 @@ func (h *AuthHandlers) Login(w http.ResponseWriter, r *http.Request) {
 	user, err := h.users.GetByEmail(r.Context(), email)
 	if err != nil {
 		if errors.Is(err, db.ErrNotFound) {
+			metrics.AuthFailures.WithLabelValues(metrics.AuthInvalidCredentials).Inc()
 			writeError(w, http.StatusUnauthorized, "invalid_credentials", "Invalid credentials")
 			return
 		}
@@ -152,9 +162,14 @@ func (h *AuthHandlers) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if !valid {
+		metrics.AuthFailures.WithLabelValues(metrics.AuthInvalidCredentials).Inc()
 		writeError(w, http.StatusUnauthorized, "invalid_credentials", "Invalid credentials")
 		return
 	}
+	if user.DisabledAt != nil {
+		writeError(w, http.StatusForbidden, "account_disabled", "Account is disabled")
+		return
+	}
 
 	authResp, err := h.issueSession(w, r, user)
 	if err != nil {
@@ -197,6 +212,7 @@ func (h *AuthHandlers) ChangePassword(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if !valid {
+		metrics.AuthFailures.WithLabelValues(metrics.AuthInvalidCredentials).Inc()
 		writeError(w, http.StatusUnauthorized, "invalid_credentials", "Invalid credentials")
 		return
 	}
@@ -220,6 +236,7 @@ func (h *AuthHandlers) ChangePassword(w http.ResponseWriter, r *http.Request) {
 func (h *AuthHandlers) Refresh(w http.ResponseWriter, r *http.Request) {
 	refreshToken, err := h.readRefreshToken(r)
 	if err != nil {
+		metrics.AuthFailures.WithLabelValues(metrics.AuthInvalidRefresh).Inc()
 		writeError(w, http.StatusUnauthorized, "unauthorized", "Missing refresh token")
 		return
 	}
@@ -228,6 +245,7 @@ func (h *AuthHandlers) Refresh(w http.ResponseWriter, r *http.Request) {
 	session, err := h.sessions.GetByTokenHash(r.Context(), hash)
 	if err != nil {
 		if errors.Is(err, db.ErrNotFound) {
+			metrics.AuthFailures.WithLabelValues(metrics.AuthInvalidRefresh).Inc()
 			writeError(w, http.StatusUnauthorized, "unauthorized", "Invalid refresh token")
 			return
 		}
@@ -237,12 +255,14 @@ func (h *AuthHandlers) Refresh(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if session.RevokedAt != nil || time.Now().After(session.ExpiresAt) {
+		metrics.AuthFailures.WithLabelValues(metrics.AuthInvalidRefresh).Inc()
 		writeError(w, http.StatusUnauthorized, "unauthorized", "Refresh token expired")
 		return
 	}
 
 	user, err := h.users.GetByID(r.Context(), session.UserID)
-	if err != nil {
+	if err != nil || user.DisabledAt != nil {
+		metrics.AuthFailures.WithLabelValues(metrics.AuthInvalidRefresh).Inc()
 		writeError(w, http.StatusUnauthorized, "unauthorized", "Invalid session")
 		return
 	}
@@ -310,6 +330,29 @@ func (h *AuthHandlers) Me(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, userResponse(user))
 }
 
+// IssueTicket exchanges the caller's access token for a single-use ticket
+// to open one WebSocket or event stream with, so the token itself never ends
+// up in a URL.
+func (h *AuthHandlers) IssueTicket(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized", "Missing user")
+		return
+	}
+
+	ticket, expiresAt, err := h.tickets.Issue(userID)
+	if err != nil {
+		h.logger.Error("issue ticket failed", "error", err)
+		writeError(w, http.StatusInternalServerError, "server_error", "Could not issue ticket")
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, TicketResponse{
+		Ticket:    ticket,
+		ExpiresAt: expiresAt.UTC().Format(time.RFC3339),
+	})
+}
+
 func (h *AuthHandlers) issueSession(w http.ResponseWriter, r *http.Request, user *models.User) (*AuthResponse, error) {
 	refreshToken, err := auth.NewRefreshToken()
 	if err != nil {
@@ -410,28 +453,11 @@ func userResponse(user *models.User) UserResponse {
 	}
 }
 
+// requestIP is the client address RealIP settled on, or empty if RemoteAddr
+// is not an IP
 func requestIP(r *http.Request) string {
-	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
-		parts := strings.Split(forwarded, ",")
-		if len(parts) > 0 {
-			candidate := strings.TrimSpace(parts[0])
-			if net.ParseIP(candidate) != nil {
-				return candidate
-			}
-		}
-	}
-	if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); realIP != "" {
-		if net.ParseIP(realIP) != nil {
-			return realIP
-		}
-	}
-	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
-		if net.ParseIP(host) != nil {
-			return host
-		}
-	}
-	if net.ParseIP(r.RemoteAddr) != nil {
-		return r.RemoteAddr
+	if ip := clientIP(r); net.ParseIP(ip) != nil {
+		return ip
 	}
 	return ""
 }
@@ -1,23 +1,56 @@
 package httpapi
 
 import (
+	"crypto/subtle"
 	"log/slog"
 	"net/http"
+	"strings"
 
 	"github.com/NoumanAMalik/maple/apps/collab/internal/auth"
+	"github.com/NoumanAMalik/maple/apps/collab/internal/metrics"
 )
 
-func AuthMiddleware(tokens *auth.TokenManager, logger *slog.Logger) func(http.Handler) http.Handler {
+// AuthMiddleware requires a bearer access token. WebSocket and EventSource
+// requests, which browsers cannot add headers to, may instead pass a ticket
+// from POST /v1/auth/ticket as the ticket query param.
+func AuthMiddleware(tokens *auth.TokenManager, tickets *auth.TicketStore, logger *slog.Logger) func(http.Handler) http.Handler {
+	return authMiddleware(tokens, tickets, logger, true)
+}
+
+// OptionalAuthMiddleware identifies the user when the request carries an
+// access token and lets anonymous requests through. A token that is present
+// but invalid is still rejected.
+func OptionalAuthMiddleware(tokens *auth.TokenManager, tickets *auth.TicketStore, logger *slog.Logger) func(http.Handler) http.Handler {
+	return authMiddleware(tokens, tickets, logger, false)
+}
+
+func authMiddleware(tokens *auth.TokenManager, tickets *auth.TicketStore, logger *slog.Logger, required bool) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			token := auth.NormalizeBearer(r.Header.Get("Authorization"))
+			if ticket := strings.TrimSpace(r.URL.Query().Get("ticket")); token == "" && ticket != "" && (isWebSocketUpgrade(r) || isEventStream(r)) {
+				userID, ok := tickets.Redeem(ticket)
+				if !ok {
+					metrics.AuthFailures.WithLabelValues(metrics.AuthInvalidTicket).Inc()
+					writeError(w, http.StatusUnauthorized, "unauthorized", "Invalid or expired ticket")
+					return
+				}
+				next.ServeHTTP(w, r.WithContext(withUserID(r.Context(), userID)))
+				return
+			}
 			if token == "" {
+				if !required {
+					next.ServeHTTP(w, r)
+					return
+				}
+				metrics.AuthFailures.WithLabelValues(metrics.AuthMissingToken).Inc()
 				writeError(w, http.StatusUnauthorized, "unauthorized", "Missing access token")
 				return
 			}
 
 			claims, err := tokens.ParseAccessToken(token)
 			if err != nil {
+				metrics.AuthFailures.WithLabelValues(metrics.AuthInvalidToken).Inc()
 				logger.Warn("invalid access token", "error", err)
 				writeError(w, http.StatusUnauthorized, "unauthorized", "Invalid access token")
 				return
@@ -28,3 +61,38 @@ func AuthMiddleware(tokens *auth.TokenManager, logger *slog.Logger) func(http.Ha
 		})
 	}
 }
+
+// AdminMiddleware lets through requests carrying the configured admin token
+// as a bearer token.
+func AdminMiddleware(adminToken string, logger *slog.Logger) func(http.Handler) http.Handler {
+	return staticTokenMiddleware(adminToken, "admin", logger)
+}
+
+// MetricsMiddleware lets through requests carrying the configured metrics
+// token as a bearer token.
+func MetricsMiddleware(metricsToken string, logger *slog.Logger) func(http.Handler) http.Handler {
+	return staticTokenMiddleware(metricsToken, "metrics", logger)
+}
+
+func staticTokenMiddleware(expected, name string, logger *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := auth.NormalizeBearer(r.Header.Get("Authorization"))
+			if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(expected)) != 1 {
+				metrics.AuthFailures.WithLabelValues(metrics.AuthInvalidStaticToken).Inc()
+				logger.Warn("rejected "+name+" request", "path", r.URL.Path)
+				writeError(w, http.StatusUnauthorized, "unauthorized", "Invalid "+name+" token")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func isWebSocketUpgrade(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Upgrade"), "websocket")
+}
+
+func isEventStream(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+}
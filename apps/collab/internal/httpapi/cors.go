@@ -0,0 +1,60 @@
+package httpapi
+
+import (
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/go-chi/cors"
+	"nhooyr.io/websocket"
+)
+
+// reloadableCORS applies the CORS policy for the current origin list, which
+// can be swapped without rebuilding the router. The same list decides which
+// cross-origin pages may open a WebSocket.
+type reloadableCORS struct {
+	current        atomic.Pointer[cors.Cors]
+	originPatterns atomic.Pointer[[]string]
+}
+
+func newReloadableCORS(origins []string) *reloadableCORS {
+	c := &reloadableCORS{}
+	c.SetOrigins(origins)
+	return c
+}
+
+func (c *reloadableCORS) SetOrigins(origins []string) {
+	c.current.Store(cors.New(cors.Options{
+		AllowedOrigins:   origins,
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "If-Match", "If-None-Match"},
+		ExposedHeaders:   []string{"ETag", "Link"},
+		AllowCredentials: true,
+		MaxAge:           300,
+	}))
+
+	// websocket.Accept matches origins by host, so drop the scheme
+	patterns := make([]string, 0, len(origins))
+	for _, origin := range origins {
+		if _, host, ok := strings.Cut(origin, "://"); ok {
+			origin = host
+		}
+		patterns = append(patterns, strings.TrimSuffix(origin, "/"))
+	}
+	c.originPatterns.Store(&patterns)
+}
+
+// AcceptWebSocket upgrades the request, refusing pages served from an origin
+// outside the allowed list. Same-origin and non-browser clients, which send
+// no Origin header, are always accepted.
+func (c *reloadableCORS) AcceptWebSocket(w http.ResponseWriter, r *http.Request) (*websocket.Conn, error) {
+	return websocket.Accept(w, r, &websocket.AcceptOptions{
+		OriginPatterns: *c.originPatterns.Load(),
+	})
+}
+
+func (c *reloadableCORS) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.current.Load().Handler(next).ServeHTTP(w, r)
+	})
+}
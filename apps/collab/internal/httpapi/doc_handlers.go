@@ -4,36 +4,57 @@ import (
 	"encoding/json"
 	"log/slog"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
 
 	"github.com/NoumanAMalik/maple/apps/collab/internal/collab"
 	"github.com/NoumanAMalik/maple/apps/collab/internal/db"
+	"github.com/NoumanAMalik/maple/apps/collab/internal/events"
+	"github.com/NoumanAMalik/maple/apps/collab/internal/history"
 	"github.com/NoumanAMalik/maple/apps/collab/internal/models"
 )
 
 type DocumentHandlers struct {
 	docs      *db.DocumentRepo
+	folders   *db.FolderRepo
 	ops       *db.OpRepo
 	snapshots *db.SnapshotRepo
+	history   *history.Service
+	registry  *collab.RoomRegistry
+	wsHandler *collab.WSHandler
+	cors      *reloadableCORS
+	events    *events.Hub
 	logger    *slog.Logger
+	baseURL   string
 }
 
-func NewDocumentHandlers(docs *db.DocumentRepo, ops *db.OpRepo, snapshots *db.SnapshotRepo, logger *slog.Logger) *DocumentHandlers {
+func NewDocumentHandlers(docs *db.DocumentRepo, folders *db.FolderRepo, ops *db.OpRepo, snapshots *db.SnapshotRepo, history *history.Service, registry *collab.RoomRegistry, wsHandler *collab.WSHandler, cors *reloadableCORS, hub *events.Hub, logger *slog.Logger, baseURL string) *DocumentHandlers {
 	return &DocumentHandlers{
 		docs:      docs,
+		folders:   folders,
 		ops:       ops,
 		snapshots: snapshots,
+		history:   history,
+		registry:  registry,
+		wsHandler: wsHandler,
+		cors:      cors,
+		events:    hub,
 		logger:    logger,
+		baseURL:   baseURL,
 	}
 }
 
+const maxDocumentPageSize = 100
+
 type createDocumentRequest struct {
-	Title    string `json:"title"`
-	Content  string `json:"content"`
-	Language string `json:"language,omitempty"`
+	Title    string  `json:"title"`
+	Content  string  `json:"content"`
+	Language string  `json:"language,omitempty"`
+	FolderID *string `json:"folderId,omitempty"`
 }
 
 type updateDocumentRequest struct {
@@ -41,13 +62,17 @@ type updateDocumentRequest struct {
 }
 
 type DocumentResponse struct {
-	ID             string `json:"id"`
-	OwnerID        string `json:"ownerId"`
-	Title          string `json:"title"`
-	Language       string `json:"language,omitempty"`
-	CurrentVersion int64  `json:"currentVersion"`
-	CreatedAt      string `json:"createdAt"`
-	UpdatedAt      string `json:"updatedAt"`
+	ID             string  `json:"id"`
+	OwnerID        string  `json:"ownerId"`
+	Title          string  `json:"title"`
+	Language       string  `json:"language,omitempty"`
+	CurrentVersion int64   `json:"currentVersion"`
+	FolderID       *string `json:"folderId,omitempty"`
+	ParentID       *string `json:"parentId,omitempty"`
+	ParentVersion  *int64  `json:"parentVersion,omitempty"`
+	CreatedAt      string  `json:"createdAt"`
+	UpdatedAt      string  `json:"updatedAt"`
+	DeletedAt      *string `json:"deletedAt,omitempty"`
 }
 
 type SnapshotResponse struct {
@@ -94,7 +119,11 @@ func (h *DocumentHandlers) CreateDocument(w http.ResponseWriter, r *http.Request
 		title = "Untitled"
 	}
 
-	doc, snapshot, err := h.docs.CreateWithSnapshot(r.Context(), userID, title, req.Language, req.Content)
+	if req.FolderID != nil && !h.checkFolder(w, r, *req.FolderID) {
+		return
+	}
+
+	doc, snapshot, err := h.docs.CreateWithSnapshot(r.Context(), userID, title, req.Language, req.Content, req.FolderID)
 	if err != nil {
 		h.logger.Error("create document failed", "error", err)
 		writeError(w, http.StatusInternalServerError, "server_error", "Could not create document")
@@ -116,13 +145,72 @@ func (h *DocumentHandlers) ListDocuments(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	docs, err := h.docs.ListByOwner(r.Context(), userID, 100)
+	query := r.URL.Query()
+	opts := db.DocumentListOptions{
+		Language:    strings.TrimSpace(query.Get("language")),
+		TitlePrefix: strings.TrimSpace(query.Get("title")),
+		Sort:        query.Get("sort"),
+		Limit:       maxDocumentPageSize,
+	}
+	switch opts.Sort {
+	case "":
+		opts.Sort = db.DocumentSortUpdated
+		fallthrough
+	case db.DocumentSortUpdated, db.DocumentSortCreated:
+		opts.Descending = query.Get("order") != "asc"
+	case db.DocumentSortTitle:
+		opts.Descending = query.Get("order") == "desc"
+	default:
+		writeError(w, http.StatusBadRequest, "invalid_request", "sort must be updated, created or title")
+		return
+	}
+
+	if raw := query.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid_request", "limit must be a positive number")
+			return
+		}
+		opts.Limit = min(limit, maxDocumentPageSize)
+	}
+
+	// folderId narrows the list to one folder; "root" lists top-level documents
+	switch folderID := query.Get("folderId"); folderID {
+	case "":
+	case "root":
+		opts.FilterFolder = true
+	default:
+		if _, err := uuid.Parse(folderID); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_request", "folderId must be a folder id or root")
+			return
+		}
+		if !h.checkFolder(w, r, folderID) {
+			return
+		}
+		opts.FilterFolder = true
+		opts.FolderID = &folderID
+	}
+
+	if raw := query.Get("cursor"); raw != "" {
+		after, err := decodeDocumentCursor(raw, opts)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_cursor", "Cursor is invalid or was issued for a different sort")
+			return
+		}
+		opts.After = after
+	}
+
+	docs, more, err := h.docs.List(r.Context(), userID, opts)
 	if err != nil {
 		h.logger.Error("list documents failed", "error", err)
 		writeError(w, http.StatusInternalServerError, "server_error", "Could not load documents")
 		return
 	}
 
+	if more {
+		setNextLink(w, r, encodeDocumentCursor(opts, &docs[len(docs)-1]))
+	}
+
 	resp := make([]DocumentResponse, 0, len(docs))
 	for i := range docs {
 		resp = append(resp, formatDocument(&docs[i]))
@@ -149,6 +237,9 @@ func (h *DocumentHandlers) GetDocument(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusInternalServerError, "server_error", "Could not load document")
 		return
 	}
+	if notModified(w, r, doc) {
+		return
+	}
 
 	writeJSON(w, http.StatusOK, formatDocument(doc))
 }
@@ -171,6 +262,9 @@ func (h *DocumentHandlers) GetDocumentContent(w http.ResponseWriter, r *http.Req
 		writeError(w, http.StatusInternalServerError, "server_error", "Could not load document")
 		return
 	}
+	if notModified(w, r, doc) {
+		return
+	}
 
 	snapshot, err := h.snapshots.GetLatest(r.Context(), docID)
 	if err != nil {
@@ -216,21 +310,26 @@ func (h *DocumentHandlers) GetDocumentContent(w http.ResponseWriter, r *http.Req
 	writeJSON(w, http.StatusOK, resp)
 }
 
+// UpdateDocument renames a document. If-Match must carry the document's
+// current ETag (or "*") so concurrent renames cannot silently overwrite
+// each other.
 func (h *DocumentHandlers) UpdateDocument(w http.ResponseWriter, r *http.Request) {
-	userID, ok := userIDFromContext(r.Context())
-	if !ok {
-		writeError(w, http.StatusUnauthorized, "unauthorized", "Missing user")
-		return
-	}
-
-	docID := chi.URLParam(r, "id")
 	var req updateDocumentRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		writeError(w, http.StatusBadRequest, "invalid_request", "Invalid JSON body")
 		return
 	}
 
-	doc, err := h.docs.UpdateTitle(r.Context(), docID, userID, req.Title)
+	current, ok := h.loadDocument(w, r)
+	if !ok {
+		return
+	}
+	expected, ok := requireIfMatch(w, r, current)
+	if !ok {
+		return
+	}
+
+	doc, err := h.docs.UpdateTitle(r.Context(), current.ID, current.OwnerID, req.Title, expected)
 	if err != nil {
 		if err == db.ErrInvalidInput {
 			writeError(w, http.StatusBadRequest, "invalid_request", "Title is required")
@@ -240,45 +339,146 @@ func (h *DocumentHandlers) UpdateDocument(w http.ResponseWriter, r *http.Request
 			writeError(w, http.StatusNotFound, "not_found", "Document not found")
 			return
 		}
+		if err == db.ErrPreconditionFailed {
+			writeError(w, http.StatusPreconditionFailed, "precondition_failed", "Document has changed")
+			return
+		}
 		h.logger.Error("update document failed", "error", err)
 		writeError(w, http.StatusInternalServerError, "server_error", "Could not update document")
 		return
 	}
 
+	if doc.Title != current.Title {
+		h.events.Publish(doc.ID, events.Event{
+			ID:   doc.CurrentVersion,
+			Type: collab.EventRename,
+			Data: collab.RenameEvent{Title: doc.Title},
+		})
+	}
+
+	w.Header().Set("ETag", documentETag(doc))
 	writeJSON(w, http.StatusOK, formatDocument(doc))
 }
 
+// DeleteDocument moves a document to the trash. Like UpdateDocument it
+// requires If-Match.
 func (h *DocumentHandlers) DeleteDocument(w http.ResponseWriter, r *http.Request) {
-	userID, ok := userIDFromContext(r.Context())
+	current, ok := h.loadDocument(w, r)
+	if !ok {
+		return
+	}
+	expected, ok := requireIfMatch(w, r, current)
 	if !ok {
-		writeError(w, http.StatusUnauthorized, "unauthorized", "Missing user")
 		return
 	}
 
-	docID := chi.URLParam(r, "id")
-	if err := h.docs.SoftDelete(r.Context(), docID, userID); err != nil {
+	if err := h.docs.SoftDelete(r.Context(), current.ID, current.OwnerID, expected); err != nil {
 		if err == db.ErrNotFound {
 			writeError(w, http.StatusNotFound, "not_found", "Document not found")
 			return
 		}
+		if err == db.ErrPreconditionFailed {
+			writeError(w, http.StatusPreconditionFailed, "precondition_failed", "Document has changed")
+			return
+		}
 		h.logger.Error("delete document failed", "error", err)
 		writeError(w, http.StatusInternalServerError, "server_error", "Could not delete document")
 		return
 	}
+	h.registry.CloseDocumentRoom(current.ID, collab.DocumentDeletedReason)
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// MoveDocument moves a document into a folder, or to the top level when
+// folderId is null or omitted.
+func (h *DocumentHandlers) MoveDocument(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized", "Missing user")
+		return
+	}
+
+	var req moveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", "Invalid JSON body")
+		return
+	}
+	if req.FolderID != nil && !h.checkFolder(w, r, *req.FolderID) {
+		return
+	}
+
+	doc, err := h.docs.MoveToFolder(r.Context(), chi.URLParam(r, "id"), userID, req.FolderID)
+	if err != nil {
+		if err == db.ErrNotFound {
+			writeError(w, http.StatusNotFound, "not_found", "Document not found")
+			return
+		}
+		h.logger.Error("move document failed", "error", err)
+		writeError(w, http.StatusInternalServerError, "server_error", "Could not move document")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, formatDocument(doc))
+}
+
+// checkFolder verifies that folderID is a live folder owned by the caller,
+// writing the error response itself when it returns false.
+func (h *DocumentHandlers) checkFolder(w http.ResponseWriter, r *http.Request, folderID string) bool {
+	userID, _ := userIDFromContext(r.Context())
+	if _, err := h.folders.GetByIDForOwner(r.Context(), folderID, userID); err != nil {
+		if err == db.ErrNotFound {
+			writeError(w, http.StatusNotFound, "folder_not_found", "Folder not found")
+			return false
+		}
+		h.logger.Error("get folder failed", "error", err)
+		writeError(w, http.StatusInternalServerError, "server_error", "Could not load folder")
+		return false
+	}
+	return true
+}
+
+// loadDocument resolves the {id} URL param to a document owned by the caller,
+// writing the error response itself when it returns false.
+func (h *DocumentHandlers) loadDocument(w http.ResponseWriter, r *http.Request) (*models.Document, bool) {
+	userID, ok := userIDFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized", "Missing user")
+		return nil, false
+	}
+
+	doc, err := h.docs.GetByIDForOwner(r.Context(), chi.URLParam(r, "id"), userID)
+	if err != nil {
+		if err == db.ErrNotFound {
+			writeError(w, http.StatusNotFound, "not_found", "Document not found")
+			return nil, false
+		}
+		h.logger.Error("get document failed", "error", err)
+		writeError(w, http.StatusInternalServerError, "server_error", "Could not load document")
+		return nil, false
+	}
+
+	return doc, true
+}
+
 func formatDocument(doc *models.Document) DocumentResponse {
-	return DocumentResponse{
+	resp := DocumentResponse{
 		ID:             doc.ID,
 		OwnerID:        doc.OwnerID,
 		Title:          doc.Title,
 		Language:       doc.Language,
 		CurrentVersion: doc.CurrentVersion,
+		FolderID:       doc.FolderID,
+		ParentID:       doc.ParentID,
+		ParentVersion:  doc.ParentVersion,
 		CreatedAt:      doc.CreatedAt.Format(time.RFC3339),
 		UpdatedAt:      doc.UpdatedAt.Format(time.RFC3339),
 	}
+	if doc.DeletedAt != nil {
+		deletedAt := doc.DeletedAt.Format(time.RFC3339)
+		resp.DeletedAt = &deletedAt
+	}
+	return resp
 }
 
 func formatSnapshot(snapshot *models.DocumentSnapshot) SnapshotResponse {
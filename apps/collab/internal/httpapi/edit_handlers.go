@@ -0,0 +1,198 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/NoumanAMalik/maple/apps/collab/internal/collab"
+	"github.com/NoumanAMalik/maple/apps/collab/internal/models"
+)
+
+// applyOpsRequest mirrors the WebSocket op message. opId is optional and
+// generated when missing.
+type applyOpsRequest struct {
+	OpID        string             `json:"opId,omitempty"`
+	BaseVersion int                `json:"baseVersion"`
+	Ops         []collab.Operation `json:"ops"`
+}
+
+// replaceContentRequest replaces the whole document. baseVersion is the
+// version the content was derived from and defaults to the current one, in
+// which case the edit simply overwrites the document.
+type replaceContentRequest struct {
+	OpID        string `json:"opId,omitempty"`
+	BaseVersion *int   `json:"baseVersion,omitempty"`
+	Content     string `json:"content"`
+}
+
+type EditResponse struct {
+	DocumentID string             `json:"documentId"`
+	OpID       string             `json:"opId"`
+	Version    int                `json:"version"`
+	Ops        []collab.Operation `json:"ops"`
+}
+
+// ApplyOps applies an op batch to the document's live room exactly like a
+// WebSocket op: it is transformed past anything applied since baseVersion,
+// persisted and broadcast to connected editors as remote_op.
+func (h *DocumentHandlers) ApplyOps(w http.ResponseWriter, r *http.Request) {
+	doc, ok := h.loadDocument(w, r)
+	if !ok {
+		return
+	}
+
+	var req applyOpsRequest
+	if !decodeEditRequest(w, r, &req) {
+		return
+	}
+	if len(req.Ops) == 0 {
+		writeError(w, http.StatusBadRequest, "invalid_request", "ops is required")
+		return
+	}
+	for _, op := range req.Ops {
+		if op.Type != collab.OpInsert && op.Type != collab.OpDelete {
+			writeError(w, http.StatusBadRequest, "invalid_request", "Unknown operation type: "+op.Type)
+			return
+		}
+	}
+
+	room, err := h.liveRoom(r.Context(), doc)
+	if err != nil {
+		h.writeVersionError(w, err)
+		return
+	}
+
+	h.applyEdit(w, r, doc, room, req.OpID, req.BaseVersion, req.Ops)
+}
+
+// ReplaceContent diffs new full content against the document at baseVersion
+// and applies the difference as an op batch, so concurrent edits elsewhere
+// in the document are kept.
+func (h *DocumentHandlers) ReplaceContent(w http.ResponseWriter, r *http.Request) {
+	doc, ok := h.loadDocument(w, r)
+	if !ok {
+		return
+	}
+
+	var req replaceContentRequest
+	if !decodeEditRequest(w, r, &req) {
+		return
+	}
+
+	room, err := h.liveRoom(r.Context(), doc)
+	if err != nil {
+		h.writeVersionError(w, err)
+		return
+	}
+
+	base, version := room.State()
+	baseVersion := version
+	if req.BaseVersion != nil && *req.BaseVersion != version {
+		baseVersion = *req.BaseVersion
+		if baseVersion < 0 || baseVersion > version {
+			writeError(w, http.StatusBadRequest, "invalid_version", "baseVersion is not available")
+			return
+		}
+		var found bool
+		if base, found = room.ContentAtVersion(baseVersion); !found {
+			base, err = h.history.Materialize(r.Context(), doc.ID, int64(baseVersion))
+			if err != nil {
+				h.writeVersionError(w, err)
+				return
+			}
+		}
+	}
+
+	ops := collab.DiffToOps(base, req.Content)
+	if len(ops) == 0 {
+		writeJSON(w, http.StatusOK, EditResponse{
+			DocumentID: doc.ID,
+			OpID:       req.OpID,
+			Version:    room.GetVersion(),
+			Ops:        []collab.Operation{},
+		})
+		return
+	}
+
+	h.applyEdit(w, r, doc, room, req.OpID, baseVersion, ops)
+}
+
+// maxEditRequestBytes caps the body of an edit request
+const maxEditRequestBytes = 4 << 20
+
+// decodeEditRequest decodes a size-limited JSON edit body into v, writing
+// the error response itself when it returns false
+func decodeEditRequest(w http.ResponseWriter, r *http.Request, v any) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, maxEditRequestBytes)
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, "request_too_large", fmt.Sprintf("Edits are limited to %d bytes", maxEditRequestBytes))
+			return false
+		}
+		writeError(w, http.StatusBadRequest, "invalid_request", "Invalid JSON body")
+		return false
+	}
+	return true
+}
+
+// applyEdit runs ops through the room's OT path on behalf of the caller and
+// broadcasts the result to connected editors
+func (h *DocumentHandlers) applyEdit(w http.ResponseWriter, r *http.Request, doc *models.Document, room *collab.Room, opID string, baseVersion int, ops []collab.Operation) {
+	userID, _ := userIDFromContext(r.Context())
+	if opID == "" {
+		opID = "api-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+
+	clientID := "api:" + userID
+	applied, newVersion, err := room.Apply(r.Context(), collab.OpBatch{
+		ClientID:    clientID,
+		UserID:      userID,
+		OpID:        opID,
+		BaseVersion: baseVersion,
+		Ops:         ops,
+	})
+	if errors.Is(err, collab.ErrDuplicateBatch) {
+		// A retry of an edit that already went through
+		writeJSON(w, http.StatusOK, EditResponse{
+			DocumentID: doc.ID,
+			OpID:       opID,
+			Version:    newVersion,
+			Ops:        applied,
+		})
+		return
+	}
+	if err != nil {
+		if errors.Is(err, collab.ErrResyncRequired) {
+			writeError(w, http.StatusConflict, "stale_base_version", "baseVersion is too old or ahead of the document; reload and retry")
+			return
+		}
+		if errors.Is(err, collab.ErrPersistFailed) {
+			writeError(w, http.StatusServiceUnavailable, "persist_failed", "Edit could not be saved; retry")
+			return
+		}
+		if errors.Is(err, collab.ErrStoreUnavailable) {
+			writeDatabaseUnavailable(w)
+			return
+		}
+		h.logger.Error("apply edit failed", "documentId", doc.ID, "error", err)
+		writeError(w, http.StatusInternalServerError, "server_error", "Could not apply edit")
+		return
+	}
+	room.MarkContentChanged()
+
+	actor := collab.PlaybackActor(clientID)
+	actor.DisplayName = "API"
+	room.BroadcastRemoteOp(actor, newVersion, applied, "")
+
+	writeJSON(w, http.StatusOK, EditResponse{
+		DocumentID: doc.ID,
+		OpID:       opID,
+		Version:    newVersion,
+		Ops:        applied,
+	})
+}
@@ -0,0 +1,63 @@
+package httpapi
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/NoumanAMalik/maple/apps/collab/internal/db"
+	"github.com/NoumanAMalik/maple/apps/collab/internal/models"
+)
+
+// documentETag is a strong validator for a document. current_version moves
+// with every edit and updated_at with every metadata change, so together
+// they identify the row's state.
+func documentETag(doc *models.Document) string {
+	return `"` + strconv.FormatInt(doc.CurrentVersion, 10) + "-" + strconv.FormatInt(doc.UpdatedAt.UnixMicro(), 36) + `"`
+}
+
+// notModified sets the document's ETag and, when it matches If-None-Match,
+// answers 304 and returns true.
+func notModified(w http.ResponseWriter, r *http.Request, doc *models.Document) bool {
+	etag := documentETag(doc)
+	w.Header().Set("ETag", etag)
+
+	header := r.Header.Get("If-None-Match")
+	if header == "" {
+		return false
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+// requireIfMatch checks If-Match against the document's current ETag and
+// returns the revision a conditional write must still find, or nil for "*".
+// It writes 428 when the header is missing and 412 when nothing matches.
+func requireIfMatch(w http.ResponseWriter, r *http.Request, doc *models.Document) (*db.DocumentRevision, bool) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" {
+		writeError(w, http.StatusPreconditionRequired, "precondition_required", "If-Match header is required")
+		return nil, false
+	}
+	if header == "*" {
+		return nil, true
+	}
+
+	etag := documentETag(doc)
+	for _, tag := range strings.Split(header, ",") {
+		// If-Match uses strong comparison, so weak tags never match
+		if strings.TrimSpace(tag) == etag {
+			return &db.DocumentRevision{Version: doc.CurrentVersion, UpdatedAt: doc.UpdatedAt}, true
+		}
+	}
+
+	w.Header().Set("ETag", etag)
+	writeError(w, http.StatusPreconditionFailed, "precondition_failed", "Document has changed")
+	return nil, false
+}
@@ -0,0 +1,177 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/NoumanAMalik/maple/apps/collab/internal/collab"
+	"github.com/NoumanAMalik/maple/apps/collab/internal/events"
+	"github.com/NoumanAMalik/maple/apps/collab/internal/history"
+	"github.com/NoumanAMalik/maple/apps/collab/internal/models"
+)
+
+const (
+	eventKeepAliveInterval = 25 * time.Second
+	eventRetryMillis       = 3000
+)
+
+// eventResync tells a resuming client the ops it missed are no longer
+// available, so it should reload the document and resume from version.
+const eventResync = "resync"
+
+type ResyncEvent struct {
+	Version int64 `json:"version"`
+}
+
+// Events streams a document's changes as Server-Sent Events: applied op
+// batches, snapshots, restores, renames and clients joining or leaving.
+// Every event id is the document version it happened at. A client resuming
+// with Last-Event-ID (or the lastEventId query param) first receives the op
+// batches it missed from the op log; other event types are live only.
+func (h *DocumentHandlers) Events(w http.ResponseWriter, r *http.Request) {
+	doc, ok := h.loadDocument(w, r)
+	if !ok {
+		return
+	}
+
+	lastID := int64(-1)
+	raw := strings.TrimSpace(r.Header.Get("Last-Event-ID"))
+	if raw == "" {
+		raw = strings.TrimSpace(r.URL.Query().Get("lastEventId"))
+	}
+	if raw != "" {
+		parsed, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || parsed < 0 {
+			writeError(w, http.StatusBadRequest, "invalid_request", "Last-Event-ID must be a document version")
+			return
+		}
+		lastID = parsed
+	}
+
+	// Subscribe before reading the op log so nothing falls between replay
+	// and the live feed; duplicates are skipped by version below.
+	sub := h.events.Subscribe(doc.ID)
+	defer sub.Close()
+
+	rc := http.NewResponseController(w)
+	// The stream outlives the server's write timeout
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", eventRetryMillis)
+
+	sent := lastID
+	if lastID >= 0 {
+		var err error
+		if sent, err = h.replayEvents(w, r, doc, lastID); err != nil {
+			h.logger.Error("replay document events failed", "documentId", doc.ID, "error", err)
+			return
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		h.logger.Error("event stream not supported", "error", err)
+		return
+	}
+
+	keepAlive := time.NewTicker(eventKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			if _, err := io.WriteString(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case event, ok := <-sub.Events():
+			if !ok {
+				// Dropped for falling behind; the client reconnects and resumes
+				return
+			}
+			if event.Type == collab.EventOp && event.ID <= sent {
+				continue
+			}
+			if event.Type == collab.EventOp && sent >= 0 && event.ID > sent+1 {
+				// Versions are published in order, so a gap means the
+				// feed lost events, e.g. when the room was reopened;
+				// the client reloads rather than apply ops out of order
+				event = events.Event{ID: event.ID, Type: eventResync, Data: ResyncEvent{Version: event.ID}}
+			}
+			if err := writeEvent(w, event); err != nil {
+				return
+			}
+			if event.Type == collab.EventOp || event.Type == eventResync {
+				sent = event.ID
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// replayEvents writes the op batches after lastID from the op log and
+// returns the last version sent. When the log no longer reaches back to
+// lastID, a resync event is sent instead.
+func (h *DocumentHandlers) replayEvents(w http.ResponseWriter, r *http.Request, doc *models.Document, lastID int64) (int64, error) {
+	// Re-read the version now that the subscription is live
+	doc, err := h.docs.GetByIDForOwner(r.Context(), doc.ID, doc.OwnerID)
+	if err != nil {
+		return lastID, err
+	}
+
+	entries, err := h.ops.ListSince(r.Context(), doc.ID, lastID)
+	if err != nil {
+		return lastID, err
+	}
+	if lastID > doc.CurrentVersion || (len(entries) > 0 && entries[0].Version != lastID+1) ||
+		(len(entries) == 0 && doc.CurrentVersion > lastID) {
+		return doc.CurrentVersion, writeEvent(w, events.Event{
+			ID:   doc.CurrentVersion,
+			Type: eventResync,
+			Data: ResyncEvent{Version: doc.CurrentVersion},
+		})
+	}
+
+	sent := lastID
+	for _, entry := range entries {
+		ops, err := history.DecodeOps(entry)
+		if err != nil {
+			return sent, err
+		}
+		if err := writeEvent(w, events.Event{
+			ID:   entry.Version,
+			Type: collab.EventOp,
+			Data: collab.OpEvent{
+				Version:  int(entry.Version),
+				OpID:     entry.OpID,
+				ClientID: entry.ClientID,
+				UserID:   entry.UserID,
+				Ops:      ops,
+			},
+		}); err != nil {
+			return sent, err
+		}
+		sent = entry.Version
+	}
+	return sent, nil
+}
+
+func writeEvent(w io.Writer, event events.Event) error {
+	data, err := json.Marshal(event.Data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+	return err
+}
@@ -0,0 +1,185 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/NoumanAMalik/maple/apps/collab/internal/collab"
+	"github.com/NoumanAMalik/maple/apps/collab/internal/db"
+	"github.com/NoumanAMalik/maple/apps/collab/internal/models"
+)
+
+type FolderHandlers struct {
+	folders  *db.FolderRepo
+	registry *collab.RoomRegistry
+	logger   *slog.Logger
+}
+
+func NewFolderHandlers(folders *db.FolderRepo, registry *collab.RoomRegistry, logger *slog.Logger) *FolderHandlers {
+	return &FolderHandlers{
+		folders:  folders,
+		registry: registry,
+		logger:   logger,
+	}
+}
+
+type createFolderRequest struct {
+	Name     string  `json:"name"`
+	ParentID *string `json:"parentId,omitempty"`
+}
+
+type renameFolderRequest struct {
+	Name string `json:"name"`
+}
+
+// moveRequest moves a folder or document; a null or missing target moves it
+// to the top level.
+type moveRequest struct {
+	ParentID *string `json:"parentId,omitempty"`
+	FolderID *string `json:"folderId,omitempty"`
+}
+
+type FolderResponse struct {
+	ID        string  `json:"id"`
+	ParentID  *string `json:"parentId,omitempty"`
+	Name      string  `json:"name"`
+	CreatedAt string  `json:"createdAt"`
+	UpdatedAt string  `json:"updatedAt"`
+}
+
+func (h *FolderHandlers) CreateFolder(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized", "Missing user")
+		return
+	}
+
+	var req createFolderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", "Invalid JSON body")
+		return
+	}
+
+	folder, err := h.folders.Create(r.Context(), userID, req.Name, req.ParentID)
+	if err != nil {
+		h.writeFolderError(w, err, "create folder failed")
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, formatFolder(folder))
+}
+
+// ListFolders returns all of the caller's folders, parents before children.
+func (h *FolderHandlers) ListFolders(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized", "Missing user")
+		return
+	}
+
+	folders, err := h.folders.ListByOwner(r.Context(), userID)
+	if err != nil {
+		h.logger.Error("list folders failed", "error", err)
+		writeError(w, http.StatusInternalServerError, "server_error", "Could not load folders")
+		return
+	}
+
+	resp := make([]FolderResponse, 0, len(folders))
+	for i := range folders {
+		resp = append(resp, formatFolder(&folders[i]))
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (h *FolderHandlers) RenameFolder(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized", "Missing user")
+		return
+	}
+
+	var req renameFolderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", "Invalid JSON body")
+		return
+	}
+
+	folder, err := h.folders.Rename(r.Context(), chi.URLParam(r, "id"), userID, req.Name)
+	if err != nil {
+		h.writeFolderError(w, err, "rename folder failed")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, formatFolder(folder))
+}
+
+func (h *FolderHandlers) MoveFolder(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized", "Missing user")
+		return
+	}
+
+	var req moveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", "Invalid JSON body")
+		return
+	}
+
+	folder, err := h.folders.Move(r.Context(), chi.URLParam(r, "id"), userID, req.ParentID)
+	if err != nil {
+		h.writeFolderError(w, err, "move folder failed")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, formatFolder(folder))
+}
+
+// DeleteFolder soft deletes a folder and everything inside it.
+func (h *FolderHandlers) DeleteFolder(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized", "Missing user")
+		return
+	}
+
+	docIDs, err := h.folders.SoftDelete(r.Context(), chi.URLParam(r, "id"), userID)
+	if err != nil {
+		h.writeFolderError(w, err, "delete folder failed")
+		return
+	}
+	for _, docID := range docIDs {
+		h.registry.CloseDocumentRoom(docID, collab.DocumentDeletedReason)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *FolderHandlers) writeFolderError(w http.ResponseWriter, err error, logMessage string) {
+	switch err {
+	case db.ErrInvalidInput:
+		writeError(w, http.StatusBadRequest, "invalid_request", "Folder name is required and a folder cannot be moved inside itself")
+	case db.ErrNotFound:
+		writeError(w, http.StatusNotFound, "not_found", "Folder not found")
+	case db.ErrDuplicate:
+		writeError(w, http.StatusConflict, "name_taken", "A folder with that name already exists here")
+	default:
+		h.logger.Error(logMessage, "error", err)
+		writeError(w, http.StatusInternalServerError, "server_error", "Could not update folders")
+	}
+}
+
+func formatFolder(folder *models.Folder) FolderResponse {
+	return FolderResponse{
+		ID:        folder.ID,
+		ParentID:  folder.ParentID,
+		Name:      folder.Name,
+		CreatedAt: folder.CreatedAt.Format(time.RFC3339),
+		UpdatedAt: folder.UpdatedAt.Format(time.RFC3339),
+	}
+}
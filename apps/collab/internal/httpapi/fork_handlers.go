@@ -0,0 +1,57 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+)
+
+type forkDocumentRequest struct {
+	Title string `json:"title"`
+}
+
+// ForkDocument creates a new document seeded from the content of another at
+// the version given by the optional version query param (latest by default).
+func (h *DocumentHandlers) ForkDocument(w http.ResponseWriter, r *http.Request) {
+	parent, ok := h.loadDocument(w, r)
+	if !ok {
+		return
+	}
+
+	var req forkDocumentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, http.StatusBadRequest, "invalid_request", "Invalid JSON body")
+		return
+	}
+
+	version, err := h.resolveVersion(r, parent, r.URL.Query().Get("version"))
+	if err != nil {
+		h.writeVersionError(w, err)
+		return
+	}
+
+	content, err := h.history.Materialize(r.Context(), parent.ID, version)
+	if err != nil {
+		h.writeVersionError(w, err)
+		return
+	}
+
+	title := strings.TrimSpace(req.Title)
+	if title == "" {
+		title = parent.Title + " (fork)"
+	}
+
+	doc, snapshot, err := h.docs.CreateFork(r.Context(), parent.OwnerID, title, content, parent, version)
+	if err != nil {
+		h.logger.Error("fork document failed", "error", err)
+		writeError(w, http.StatusInternalServerError, "server_error", "Could not fork document")
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, CreateDocumentResponse{
+		Document: formatDocument(doc),
+		Snapshot: formatSnapshot(snapshot),
+	})
+}
@@ -0,0 +1,127 @@
+package httpapi
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/NoumanAMalik/maple/apps/collab/internal/collab"
+	"github.com/NoumanAMalik/maple/apps/collab/internal/db"
+	"github.com/NoumanAMalik/maple/apps/collab/internal/health"
+)
+
+const (
+	checkDatabase   = "database"
+	checkMigrations = "migrations"
+	checkRegistry   = "registry"
+
+	// healthInterval is how often checks run in the background, and so how
+	// long the database gate can lag behind an outage or a recovery
+	healthInterval = 5 * time.Second
+)
+
+// newHealthChecker builds the readiness checks. The database is a soft
+// dependency: anonymous rooms keep working without it, so losing it only
+// degrades the server. A stuck registry or a schema that does not match
+// this build makes it unready.
+func newHealthChecker(dbPool *pgxpool.Pool, registry *collab.RoomRegistry, logger *slog.Logger) *health.Checker {
+	latest, latestErr := db.LatestMigration()
+	if latestErr != nil {
+		logger.Warn("cannot read migrations; readiness will not compare schema versions", "error", latestErr)
+	}
+
+	return health.NewChecker(logger,
+		health.Check{
+			Name:    checkDatabase,
+			Timeout: 2 * time.Second,
+			Run: func(ctx context.Context) (string, error) {
+				if err := dbPool.Ping(ctx); err != nil {
+					return "", err
+				}
+				stat := dbPool.Stat()
+				return fmt.Sprintf("%d of %d connections in use", stat.AcquiredConns(), stat.MaxConns()), nil
+			},
+		},
+		health.Check{
+			Name:      checkMigrations,
+			Timeout:   2 * time.Second,
+			Critical:  true,
+			DependsOn: checkDatabase,
+			Run: func(ctx context.Context) (string, error) {
+				version, dirty, err := db.MigrationVersion(ctx, dbPool)
+				switch {
+				case err != nil:
+					return "", err
+				case dirty:
+					return "", fmt.Errorf("migration %d failed partway and needs fixing by hand", version)
+				case latestErr != nil:
+					return fmt.Sprintf("version %d; latest unknown", version), nil
+				case version < latest:
+					return "", fmt.Errorf("database is at version %d, this build needs %d", version, latest)
+				case version > latest:
+					// A newer build migrated ahead of us during a rolling deploy
+					return fmt.Sprintf("version %d, ahead of this build's %d", version, latest), nil
+				}
+				return fmt.Sprintf("version %d", version), nil
+			},
+		},
+		health.Check{
+			Name:     checkRegistry,
+			Timeout:  time.Second,
+			Critical: true,
+			Run: func(context.Context) (string, error) {
+				if err := registry.CheckLoops(); err != nil {
+					return "", err
+				}
+				return fmt.Sprintf("%d rooms, %d clients", registry.RoomCount(), registry.ClientCount()), nil
+			},
+		},
+	)
+}
+
+type ReadyResponse struct {
+	health.Report
+	Version string `json:"version"`
+}
+
+// Ready reports each check's status and latency from the last background
+// run, so probes cannot pile load onto a struggling database or flip the
+// gate RequireDatabase reads. It answers 503 only when the server cannot do
+// anything useful; with the database down it stays 200 with status
+// "degraded" so anonymous rooms keep their traffic.
+func Ready(checker *health.Checker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := checker.Report()
+
+		status := http.StatusOK
+		if report.Status == health.StatusFail {
+			status = http.StatusServiceUnavailable
+		}
+		writeJSON(w, status, ReadyResponse{Report: report, Version: version})
+	}
+}
+
+// RequireDatabase fails requests with 503 straight away while the last
+// database check failed, rather than letting each one wait out a connect
+// timeout
+func RequireDatabase(checker *health.Checker) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !checker.Healthy(checkDatabase) {
+				writeDatabaseUnavailable(w)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func writeDatabaseUnavailable(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(healthInterval.Seconds())))
+	writeError(w, http.StatusServiceUnavailable, "database_unavailable", "The database is unavailable; try again shortly")
+}
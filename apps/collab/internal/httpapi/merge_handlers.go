@@ -0,0 +1,253 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/NoumanAMalik/maple/apps/collab/internal/collab"
+	"github.com/NoumanAMalik/maple/apps/collab/internal/db"
+	"github.com/NoumanAMalik/maple/apps/collab/internal/history"
+	"github.com/NoumanAMalik/maple/apps/collab/internal/models"
+)
+
+type DocumentRoomResponse struct {
+	RoomID   string `json:"roomId"`
+	ShareURL string `json:"shareUrl"`
+	Version  int    `json:"version"`
+}
+
+type mergeRequest struct {
+	ParentVersion *int64   `json:"parentVersion,omitempty"`
+	Resolutions   []string `json:"resolutions,omitempty"`
+}
+
+type MergePreviewResponse struct {
+	DocumentID    string              `json:"documentId"`
+	ParentID      string              `json:"parentId"`
+	BaseVersion   int64               `json:"baseVersion"`
+	ParentVersion int64               `json:"parentVersion"`
+	ForkVersion   int64               `json:"forkVersion"`
+	Result        *collab.MergeResult `json:"result"`
+}
+
+type MergeConflictResponse struct {
+	ErrorResponse
+	Merge MergePreviewResponse `json:"merge"`
+}
+
+type MergeResponse struct {
+	ParentID string `json:"parentId"`
+	RoomID   string `json:"roomId"`
+	Version  int    `json:"version"`
+	Content  string `json:"content"`
+}
+
+// OpenRoom returns the live collaboration room for a document, opening it
+// from the latest persisted version when nobody is editing.
+func (h *DocumentHandlers) OpenRoom(w http.ResponseWriter, r *http.Request) {
+	doc, ok := h.loadDocument(w, r)
+	if !ok {
+		return
+	}
+
+	room, err := h.liveRoom(r.Context(), doc)
+	if err != nil {
+		h.writeVersionError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, DocumentRoomResponse{
+		RoomID:   room.ID,
+		ShareURL: h.baseURL + "/share/" + room.ID,
+		Version:  room.GetVersion(),
+	})
+}
+
+// PreviewMerge computes the three-way merge of a fork into its parent's
+// current content without applying it.
+func (h *DocumentHandlers) PreviewMerge(w http.ResponseWriter, r *http.Request) {
+	fork, parent, ok := h.loadMergePair(w, r)
+	if !ok {
+		return
+	}
+
+	room, err := h.liveRoom(r.Context(), parent)
+	if err != nil {
+		h.writeVersionError(w, err)
+		return
+	}
+	content, version := room.State()
+
+	plan, err := h.history.PlanMerge(r.Context(), fork, content, int64(version))
+	if err != nil {
+		h.writeVersionError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, formatMergePlan(fork, plan))
+}
+
+// Merge applies a fork to its parent's live room as a single op batch.
+// parentVersion pins the parent content the caller reviewed; edits made in
+// the room since then are transformed past the merge as usual. Conflicts
+// must be resolved with one of "ours", "theirs" or "both" each, in order.
+func (h *DocumentHandlers) Merge(w http.ResponseWriter, r *http.Request) {
+	userID, _ := userIDFromContext(r.Context())
+	fork, parent, ok := h.loadMergePair(w, r)
+	if !ok {
+		return
+	}
+
+	var req mergeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, http.StatusBadRequest, "invalid_request", "Invalid JSON body")
+		return
+	}
+
+	room, err := h.liveRoom(r.Context(), parent)
+	if err != nil {
+		h.writeVersionError(w, err)
+		return
+	}
+
+	content, version := room.State()
+	parentVersion := int64(version)
+	if req.ParentVersion != nil && *req.ParentVersion != parentVersion {
+		parentVersion = *req.ParentVersion
+		if parentVersion < 0 || parentVersion > int64(version) {
+			writeError(w, http.StatusBadRequest, "invalid_version", "parentVersion is not available")
+			return
+		}
+		var found bool
+		if content, found = room.ContentAtVersion(int(parentVersion)); !found {
+			content, err = h.history.Materialize(r.Context(), parent.ID, parentVersion)
+			if err != nil {
+				h.writeVersionError(w, err)
+				return
+			}
+		}
+	}
+
+	plan, err := h.history.PlanMerge(r.Context(), fork, content, parentVersion)
+	if err != nil {
+		h.writeVersionError(w, err)
+		return
+	}
+
+	merged, err := plan.Result.Resolve(req.Resolutions)
+	if err != nil {
+		writeJSON(w, http.StatusConflict, MergeConflictResponse{
+			ErrorResponse: ErrorResponse{Error: "Every conflict needs a resolution", Code: "merge_conflict"},
+			Merge:         formatMergePlan(fork, plan),
+		})
+		return
+	}
+
+	ops := plan.Ops(merged)
+	if len(ops) == 0 {
+		current, currentVersion := room.State()
+		writeJSON(w, http.StatusOK, MergeResponse{
+			ParentID: parent.ID,
+			RoomID:   room.ID,
+			Version:  currentVersion,
+			Content:  current,
+		})
+		return
+	}
+
+	clientID := "merge:" + fork.ID
+	applied, newVersion, err := room.Apply(r.Context(), collab.OpBatch{
+		ClientID:    clientID,
+		UserID:      userID,
+		OpID:        "merge-" + fork.ID + "-" + strconv.FormatInt(time.Now().UnixNano(), 36),
+		BaseVersion: int(parentVersion),
+		Ops:         ops,
+	})
+	if err != nil {
+		if errors.Is(err, collab.ErrResyncRequired) {
+			writeError(w, http.StatusConflict, "stale_parent_version", "Parent has moved on too far; preview the merge again")
+			return
+		}
+		if errors.Is(err, collab.ErrPersistFailed) {
+			writeError(w, http.StatusServiceUnavailable, "persist_failed", "Merge could not be saved; retry")
+			return
+		}
+		if errors.Is(err, collab.ErrStoreUnavailable) {
+			writeDatabaseUnavailable(w)
+			return
+		}
+		h.logger.Error("apply merge failed", "error", err)
+		writeError(w, http.StatusInternalServerError, "server_error", "Could not apply merge")
+		return
+	}
+
+	actor := collab.PlaybackActor(clientID)
+	actor.DisplayName = fork.Title
+	room.BroadcastRemoteOp(actor, newVersion, applied, "")
+
+	writeJSON(w, http.StatusOK, MergeResponse{
+		ParentID: parent.ID,
+		RoomID:   room.ID,
+		Version:  newVersion,
+		Content:  room.GetSnapshot(),
+	})
+}
+
+// liveRoom returns the open room for doc, opening one from the latest
+// persisted version if needed. Ops applied in the room are persisted to the
+// document's op log.
+func (h *DocumentHandlers) liveRoom(ctx context.Context, doc *models.Document) (*collab.Room, error) {
+	if room, ok := h.registry.RoomForDocument(doc.ID); ok {
+		return room, nil
+	}
+
+	content, ops, err := h.history.RoomState(ctx, doc)
+	if err != nil {
+		return nil, err
+	}
+
+	room, _ := h.registry.OpenDocumentRoom(doc.ID, doc.OwnerID, doc.Language, content, int(doc.CurrentVersion), ops, h.history)
+	return room, nil
+}
+
+// loadMergePair loads the fork named by the {id} URL param and its parent,
+// writing the error response itself when it returns false.
+func (h *DocumentHandlers) loadMergePair(w http.ResponseWriter, r *http.Request) (*models.Document, *models.Document, bool) {
+	fork, ok := h.loadDocument(w, r)
+	if !ok {
+		return nil, nil, false
+	}
+	if fork.ParentID == nil || fork.ParentVersion == nil {
+		writeError(w, http.StatusBadRequest, "not_a_fork", "Document is not a fork")
+		return nil, nil, false
+	}
+
+	parent, err := h.docs.GetByIDForOwner(r.Context(), *fork.ParentID, fork.OwnerID)
+	if err != nil {
+		if err == db.ErrNotFound {
+			writeError(w, http.StatusNotFound, "parent_not_found", "Parent document not found")
+			return nil, nil, false
+		}
+		h.logger.Error("get parent document failed", "error", err)
+		writeError(w, http.StatusInternalServerError, "server_error", "Could not load parent document")
+		return nil, nil, false
+	}
+
+	return fork, parent, true
+}
+
+func formatMergePlan(fork *models.Document, plan *history.MergePlan) MergePreviewResponse {
+	return MergePreviewResponse{
+		DocumentID:    fork.ID,
+		ParentID:      *fork.ParentID,
+		BaseVersion:   plan.BaseVersion,
+		ParentVersion: plan.ParentVersion,
+		ForkVersion:   plan.ForkVersion,
+		Result:        plan.Result,
+	}
+}
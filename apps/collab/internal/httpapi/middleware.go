@@ -3,9 +3,14 @@ package httpapi
 import (
 	"log/slog"
 	"net/http"
+	"strconv"
 	"time"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+
+	"github.com/NoumanAMalik/maple/apps/collab/internal/metrics"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func NewStructuredLogger(logger *slog.Logger) func(next http.Handler) http.Handler {
@@ -15,17 +20,42 @@ func NewStructuredLogger(logger *slog.Logger) func(next http.Handler) http.Handl
 			start := time.Now()
 
 			defer func() {
+				duration := time.Since(start)
 				logger.Info("request",
 					"method", r.Method,
 					"path", r.URL.Path,
 					"status", ww.Status(),
 					"bytes", ww.BytesWritten(),
-					"duration_ms", time.Since(start).Milliseconds(),
+					"duration_ms", duration.Milliseconds(),
 					"request_id", middleware.GetReqID(r.Context()),
+					"trace_id", traceID(r),
 				)
+				// Streams stay open for as long as the client is connected
+				if !isWebSocketUpgrade(r) && !isEventStream(r) {
+					metrics.HTTPRequestDuration.WithLabelValues(r.Method, routePattern(r), strconv.Itoa(ww.Status())).Observe(duration.Seconds())
+				}
 			}()
 
 			next.ServeHTTP(ww, r)
 		})
 	}
 }
+
+// traceID returns the ID of the trace r is part of, or "" when tracing is off
+func traceID(r *http.Request) string {
+	if sc := trace.SpanContextFromContext(r.Context()); sc.HasTraceID() {
+		return sc.TraceID().String()
+	}
+	return ""
+}
+
+// routePattern returns the chi route that matched r, such as
+// /v1/docs/{id}, so metrics are not split by IDs in the path
+func routePattern(r *http.Request) string {
+	if rctx := chi.RouteContext(r.Context()); rctx != nil {
+		if pattern := rctx.RoutePattern(); pattern != "" {
+			return pattern
+		}
+	}
+	return "unmatched"
+}
@@ -0,0 +1,73 @@
+package httpapi
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/NoumanAMalik/maple/apps/collab/internal/db"
+	"github.com/NoumanAMalik/maple/apps/collab/internal/models"
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// documentCursor is the JSON behind the opaque cursor handed to clients. The
+// sort it was issued for is recorded so it cannot be replayed against a
+// different ordering.
+type documentCursor struct {
+	Sort  string    `json:"s"`
+	Desc  bool      `json:"d,omitempty"`
+	Time  time.Time `json:"t,omitempty"`
+	Title string    `json:"n,omitempty"`
+	ID    string    `json:"id"`
+}
+
+func encodeDocumentCursor(opts db.DocumentListOptions, last *models.Document) string {
+	cursor := documentCursor{Sort: opts.Sort, Desc: opts.Descending, ID: last.ID}
+	switch opts.Sort {
+	case db.DocumentSortTitle:
+		cursor.Title = last.Title
+	case db.DocumentSortCreated:
+		cursor.Time = last.CreatedAt
+	default:
+		cursor.Time = last.UpdatedAt
+	}
+
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeDocumentCursor(raw string, opts db.DocumentListOptions) (*db.DocumentCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+
+	var cursor documentCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, errInvalidCursor
+	}
+	// The id reaches the query as a uuid, so anything else would fail there
+	if _, err := uuid.Parse(cursor.ID); err != nil {
+		return nil, errInvalidCursor
+	}
+	if cursor.Sort != opts.Sort || cursor.Desc != opts.Descending {
+		return nil, errInvalidCursor
+	}
+
+	return &db.DocumentCursor{Time: cursor.Time, Title: cursor.Title, ID: cursor.ID}, nil
+}
+
+// setNextLink adds a Link header pointing at the next page: the current
+// request with its cursor param replaced.
+func setNextLink(w http.ResponseWriter, r *http.Request, cursor string) {
+	query := r.URL.Query()
+	query.Set("cursor", cursor)
+	next := url.URL{Path: r.URL.Path, RawQuery: query.Encode()}
+	w.Header().Set("Link", "<"+next.String()+`>; rel="next"`)
+}
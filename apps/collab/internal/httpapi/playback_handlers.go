@@ -0,0 +1,95 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/NoumanAMalik/maple/apps/collab/internal/collab"
+	"github.com/NoumanAMalik/maple/apps/collab/internal/history"
+)
+
+// Playback replays a document's recorded editing session over a read-only
+// WebSocket. The from and to query params take the same version references
+// as the versions API; speed is a playback multiplier.
+func (h *DocumentHandlers) Playback(w http.ResponseWriter, r *http.Request) {
+	doc, ok := h.loadDocument(w, r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	fromRef := query.Get("from")
+	if strings.TrimSpace(fromRef) == "" {
+		fromRef = "0"
+	}
+	fromVersion, err := h.resolveVersion(r, doc, fromRef)
+	if err != nil {
+		h.writeVersionError(w, err)
+		return
+	}
+	toVersion, err := h.resolveVersion(r, doc, query.Get("to"))
+	if err != nil {
+		h.writeVersionError(w, err)
+		return
+	}
+	if fromVersion > toVersion {
+		writeError(w, http.StatusBadRequest, "invalid_request", "from must not be after to")
+		return
+	}
+
+	speed := 1.0
+	if raw := strings.TrimSpace(query.Get("speed")); raw != "" {
+		speed, err = strconv.ParseFloat(raw, 64)
+		if err != nil || speed <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid_request", "speed must be a positive number")
+			return
+		}
+	}
+
+	content, err := h.history.Materialize(r.Context(), doc.ID, fromVersion)
+	if err != nil {
+		h.writeVersionError(w, err)
+		return
+	}
+
+	entries, err := h.ops.ListRange(r.Context(), doc.ID, fromVersion, toVersion)
+	if err != nil {
+		h.logger.Error("get ops failed", "error", err)
+		writeError(w, http.StatusInternalServerError, "server_error", "Could not load operations")
+		return
+	}
+
+	frames := make([]collab.PlaybackFrame, 0, len(entries))
+	for _, entry := range entries {
+		ops, err := history.DecodeOps(entry)
+		if err != nil {
+			h.logger.Error("decode ops failed", "error", err)
+			writeError(w, http.StatusInternalServerError, "server_error", "Could not decode operations")
+			return
+		}
+
+		frame := collab.PlaybackFrame{
+			Version: int(entry.Version),
+			Actor:   collab.PlaybackActor(entry.ClientID),
+			Ops:     ops,
+			At:      entry.CreatedAt,
+		}
+		if len(entry.Presence) > 0 {
+			var presence collab.Presence
+			if err := json.Unmarshal(entry.Presence, &presence); err == nil {
+				frame.Presence = &presence
+			}
+		}
+		frames = append(frames, frame)
+	}
+
+	conn, err := h.cors.AcceptWebSocket(w, r)
+	if err != nil {
+		h.logger.Error("websocket accept error", "error", err)
+		return
+	}
+
+	h.wsHandler.HandlePlayback(r.Context(), conn, doc.ID, content, int(fromVersion), frames, speed)
+}
@@ -0,0 +1,143 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/NoumanAMalik/maple/apps/collab/internal/collab"
+)
+
+type ProjectHandlers struct {
+	registry  *collab.RoomRegistry
+	wsHandler *collab.WSHandler
+	cors      *reloadableCORS
+	logger    *slog.Logger
+	baseURL   string
+}
+
+func NewProjectHandlers(registry *collab.RoomRegistry, wsHandler *collab.WSHandler, cors *reloadableCORS, logger *slog.Logger, baseURL string) *ProjectHandlers {
+	return &ProjectHandlers{
+		registry:  registry,
+		wsHandler: wsHandler,
+		cors:      cors,
+		logger:    logger,
+		baseURL:   baseURL,
+	}
+}
+
+type CreateProjectFile struct {
+	Path     string `json:"path"`
+	Content  string `json:"content"`
+	Language string `json:"language,omitempty"`
+}
+
+// maxProjectRequestBytes caps a create request; each file is separately
+// held to collab.MaxProjectFileBytes
+const maxProjectRequestBytes = 8 << 20
+
+type CreateProjectRequest struct {
+	Files []CreateProjectFile `json:"files"`
+}
+
+type CreateProjectResponse struct {
+	ProjectID string               `json:"projectId"`
+	ShareURL  string               `json:"shareUrl"`
+	Tree      []collab.ProjectNode `json:"tree"`
+}
+
+type ProjectInfoResponse struct {
+	ProjectID        string               `json:"projectId"`
+	CreatedAt        string               `json:"createdAt"`
+	ParticipantCount int                  `json:"participantCount"`
+	Tree             []collab.ProjectNode `json:"tree"`
+}
+
+// CreateProject creates an anonymous project, optionally seeded with files
+// given by slash-separated paths; folders are created as needed.
+func (h *ProjectHandlers) CreateProject(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxProjectRequestBytes)
+	var req CreateProjectRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, "request_too_large", "Project files are too large")
+			return
+		}
+		writeError(w, http.StatusBadRequest, "invalid_request", "Invalid JSON body")
+		return
+	}
+
+	project, err := h.registry.CreateProject("")
+	if err != nil {
+		writeRoomLimitError(w)
+		return
+	}
+	for _, file := range req.Files {
+		if _, err := project.AddPath(file.Path, file.Content, file.Language); err != nil {
+			h.registry.CloseProject(project.ID, collab.ProjectDeletedReason)
+			switch {
+			case errors.Is(err, collab.ErrProjectFull):
+				writeError(w, http.StatusRequestEntityTooLarge, "project_full", fmt.Sprintf("Projects hold at most %d files and folders", collab.MaxProjectNodes))
+			case errors.Is(err, collab.ErrContentTooLarge):
+				writeError(w, http.StatusRequestEntityTooLarge, "file_too_large", fmt.Sprintf("File %s is over %d bytes", file.Path, collab.MaxProjectFileBytes))
+			default:
+				writeError(w, http.StatusBadRequest, "invalid_path", "Invalid file path: "+file.Path)
+			}
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusCreated, CreateProjectResponse{
+		ProjectID: project.ID,
+		ShareURL:  h.baseURL + "/share/project/" + project.ID,
+		Tree:      project.Tree(),
+	})
+}
+
+func (h *ProjectHandlers) GetProject(w http.ResponseWriter, r *http.Request) {
+	project, ok := h.registry.GetProject(chi.URLParam(r, "projectId"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "project_not_found", "Project does not exist")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, ProjectInfoResponse{
+		ProjectID:        project.ID,
+		CreatedAt:        project.CreatedAt.Format(time.RFC3339),
+		ParticipantCount: project.ClientCount(),
+		Tree:             project.Tree(),
+	})
+}
+
+func (h *ProjectHandlers) DeleteProject(w http.ResponseWriter, r *http.Request) {
+	if !h.registry.CloseProject(chi.URLParam(r, "projectId"), collab.ProjectDeletedReason) {
+		writeError(w, http.StatusNotFound, "project_not_found", "Project does not exist")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *ProjectHandlers) WebSocket(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "projectId")
+
+	if _, ok := h.registry.GetProject(projectID); !ok {
+		writeError(w, http.StatusNotFound, "project_not_found", "Project does not exist")
+		return
+	}
+
+	conn, err := h.cors.AcceptWebSocket(w, r)
+	if err != nil {
+		h.logger.Error("websocket accept error", "error", err)
+		return
+	}
+
+	userID, _ := userIDFromContext(r.Context())
+	h.wsHandler.HandleProjectConnection(r.Context(), conn, projectID, userID)
+}
@@ -0,0 +1,107 @@
+package httpapi
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// limiterIdle is how long a client's bucket is kept after its last request
+const limiterIdle = 3 * time.Minute
+
+// RateLimiter limits requests per client IP with a token bucket each. It
+// relies on RealIP having set RemoteAddr for requests from trusted proxies.
+// The limits can be changed while it runs.
+type RateLimiter struct {
+	mu      sync.Mutex
+	limit   rate.Limit
+	burst   int
+	clients map[string]*clientLimiter
+}
+
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// NewRateLimiter allows perSecond requests per client with bursts of up to
+// burst. Zero perSecond disables limiting. Idle clients are forgotten until
+// ctx is done.
+func NewRateLimiter(ctx context.Context, perSecond, burst int) *RateLimiter {
+	rl := &RateLimiter{clients: make(map[string]*clientLimiter)}
+	rl.SetLimit(perSecond, burst)
+	go rl.sweep(ctx)
+	return rl
+}
+
+// SetLimit changes the limits, including for clients already seen
+func (rl *RateLimiter) SetLimit(perSecond, burst int) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.limit = rate.Limit(perSecond)
+	rl.burst = max(burst, 1)
+	for _, client := range rl.clients {
+		client.limiter.SetLimit(rl.limit)
+		client.limiter.SetBurst(rl.burst)
+	}
+}
+
+func (rl *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !rl.allow(clientIP(r)) {
+			w.Header().Set("Retry-After", "1")
+			writeError(w, http.StatusTooManyRequests, "rate_limited", "Too many requests; slow down")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (rl *RateLimiter) allow(ip string) bool {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if rl.limit == 0 {
+		return true
+	}
+	client, ok := rl.clients[ip]
+	if !ok {
+		client = &clientLimiter{limiter: rate.NewLimiter(rl.limit, rl.burst)}
+		rl.clients[ip] = client
+	}
+	client.lastSeen = time.Now()
+	return client.limiter.Allow()
+}
+
+func (rl *RateLimiter) sweep(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			rl.mu.Lock()
+			for ip, client := range rl.clients {
+				if now.Sub(client.lastSeen) > limiterIdle {
+					delete(rl.clients, ip)
+				}
+			}
+			rl.mu.Unlock()
+		}
+	}
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -2,11 +2,13 @@ package httpapi
 
 import (
 	"encoding/json"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/go-chi/chi/v5"
-	"nhooyr.io/websocket"
 
 	"github.com/NoumanAMalik/maple/apps/collab/internal/collab"
 )
@@ -14,14 +16,16 @@ import (
 type RoomHandlers struct {
 	registry  *collab.RoomRegistry
 	wsHandler *collab.WSHandler
+	cors      *reloadableCORS
 	logger    *slog.Logger
 	baseURL   string
 }
 
-func NewRoomHandlers(registry *collab.RoomRegistry, wsHandler *collab.WSHandler, logger *slog.Logger, baseURL string) *RoomHandlers {
+func NewRoomHandlers(registry *collab.RoomRegistry, wsHandler *collab.WSHandler, cors *reloadableCORS, logger *slog.Logger, baseURL string) *RoomHandlers {
 	return &RoomHandlers{
 		registry:  registry,
 		wsHandler: wsHandler,
+		cors:      cors,
 		logger:    logger,
 		baseURL:   baseURL,
 	}
@@ -41,6 +45,15 @@ type RoomInfoResponse struct {
 	RoomID           string `json:"roomId"`
 	CreatedAt        string `json:"createdAt"`
 	ParticipantCount int    `json:"participantCount"`
+	ParentRoomID     string `json:"parentRoomId,omitempty"`
+	ParentVersion    *int   `json:"parentVersion,omitempty"`
+}
+
+type ForkRoomResponse struct {
+	RoomID        string `json:"roomId"`
+	ShareURL      string `json:"shareUrl"`
+	ParentRoomID  string `json:"parentRoomId"`
+	ParentVersion int    `json:"parentVersion"`
 }
 
 func (h *RoomHandlers) CreateRoom(w http.ResponseWriter, r *http.Request) {
@@ -51,7 +64,11 @@ func (h *RoomHandlers) CreateRoom(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// For anonymous rooms, ownerID is empty
-	room := h.registry.CreateRoom(req.Content, req.Language, "")
+	room, err := h.registry.CreateRoom(req.Content, req.Language, "")
+	if err != nil {
+		writeRoomLimitError(w)
+		return
+	}
 
 	resp := CreateRoomResponse{
 		RoomID:   room.ID,
@@ -75,20 +92,78 @@ func (h *RoomHandlers) GetRoom(w http.ResponseWriter, r *http.Request) {
 		CreatedAt:        room.CreatedAt.Format("2006-01-02T15:04:05Z07:00"),
 		ParticipantCount: room.ClientCount(),
 	}
+	if room.ParentRoomID != "" {
+		resp.ParentRoomID = room.ParentRoomID
+		resp.ParentVersion = &room.ParentVersion
+	}
 
 	writeJSON(w, http.StatusOK, resp)
 }
 
+// ForkRoom creates a new anonymous room from the room's current content, or
+// from its content at the version query param. Older versions can only be
+// rebuilt while they are within the room's op history (the last
+// collab.OpHistoryLimit batches) and have a snapshot at or before them.
+func (h *RoomHandlers) ForkRoom(w http.ResponseWriter, r *http.Request) {
+	roomID := chi.URLParam(r, "roomId")
+
+	room, ok := h.registry.GetRoom(roomID)
+	if !ok {
+		writeError(w, http.StatusNotFound, "room_not_found", "Room does not exist")
+		return
+	}
+
+	version := room.GetVersion()
+	if raw := strings.TrimSpace(r.URL.Query().Get("version")); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_version", "Version must be a number")
+			return
+		}
+		version = parsed
+	}
+
+	content, ok := room.ContentAtVersion(version)
+	if !ok {
+		writeError(w, http.StatusNotFound, "version_not_found", fmt.Sprintf(
+			"Version %d cannot be rebuilt; rooms keep history for their last %d edits (current version %d)",
+			version, collab.OpHistoryLimit, room.GetVersion()))
+		return
+	}
+
+	fork, err := h.registry.ForkRoom(room, content, version)
+	if err != nil {
+		writeRoomLimitError(w)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, ForkRoomResponse{
+		RoomID:        fork.ID,
+		ShareURL:      h.baseURL + "/share/" + fork.ID,
+		ParentRoomID:  room.ID,
+		ParentVersion: version,
+	})
+}
+
+// DeleteRoom closes an anonymous room, disconnecting everyone in it.
+// Document rooms belong to their document and close when it is deleted.
 func (h *RoomHandlers) DeleteRoom(w http.ResponseWriter, r *http.Request) {
 	roomID := chi.URLParam(r, "roomId")
 
-	_, ok := h.registry.GetRoom(roomID)
+	room, ok := h.registry.GetRoom(roomID)
 	if !ok {
 		writeError(w, http.StatusNotFound, "room_not_found", "Room does not exist")
 		return
 	}
+	if room.DocumentID != "" {
+		writeError(w, http.StatusConflict, "document_room", "Document rooms close when the document is deleted")
+		return
+	}
 
-	h.registry.DeleteRoom(roomID)
+	if !h.registry.CloseRoom(roomID, "Room was deleted") {
+		writeError(w, http.StatusNotFound, "room_not_found", "Room does not exist")
+		return
+	}
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -101,13 +176,18 @@ func (h *RoomHandlers) WebSocket(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{
-		OriginPatterns: []string{"*"}, // TODO: Configure properly for production
-	})
+	conn, err := h.cors.AcceptWebSocket(w, r)
 	if err != nil {
 		h.logger.Error("websocket accept error", "error", err)
 		return
 	}
 
-	h.wsHandler.HandleConnection(r.Context(), conn, roomID)
+	userID, _ := userIDFromContext(r.Context())
+	h.wsHandler.HandleConnection(r.Context(), conn, roomID, userID)
+}
+
+// writeRoomLimitError answers a create refused by the room quota
+func writeRoomLimitError(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", "60")
+	writeError(w, http.StatusServiceUnavailable, "room_limit_reached", "Too many rooms are open; try again later")
 }
@@ -9,42 +9,54 @@ import (
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
-	"github.com/go-chi/cors"
 
 	"github.com/NoumanAMalik/maple/apps/collab/internal/auth"
 	"github.com/NoumanAMalik/maple/apps/collab/internal/collab"
 	"github.com/NoumanAMalik/maple/apps/collab/internal/config"
 	"github.com/NoumanAMalik/maple/apps/collab/internal/db"
+	"github.com/NoumanAMalik/maple/apps/collab/internal/events"
+	"github.com/NoumanAMalik/maple/apps/collab/internal/history"
+	"github.com/NoumanAMalik/maple/apps/collab/internal/jobs"
+	"github.com/NoumanAMalik/maple/apps/collab/internal/metrics"
+	"github.com/NoumanAMalik/maple/apps/collab/internal/webhooks"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 const version = "0.1.0"
 
-func NewRouter(ctx context.Context, cfg *config.Config, logger *slog.Logger, dbPool *pgxpool.Pool) (http.Handler, error) {
+// NewRouter builds the HTTP API. Settings reloaded through reloader take
+// effect without rebuilding it.
+func NewRouter(ctx context.Context, reloader *config.Reloader, logger *slog.Logger, dbPool *pgxpool.Pool) (http.Handler, error) {
 	if dbPool == nil {
 		return nil, errors.New("database pool is required")
 	}
+	cfg := reloader.Current()
 
 	r := chi.NewRouter()
 
 	r.Use(middleware.RequestID)
-	r.Use(middleware.RealIP)
+	r.Use(RealIP(cfg.TrustedProxies))
+	r.Use(TracingMiddleware)
 	r.Use(NewStructuredLogger(logger))
 	r.Use(middleware.Recoverer)
 	r.Use(middleware.Heartbeat("/ping"))
 
-	r.Use(cors.Handler(cors.Options{
-		AllowedOrigins:   cfg.AllowedOrigins,
-		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type"},
-		ExposedHeaders:   []string{"Link"},
-		AllowCredentials: true,
-		MaxAge:           300,
-	}))
+	corsHandler := newReloadableCORS(cfg.AllowedOrigins)
+	r.Use(corsHandler.Handler)
 
-	registry := collab.NewRoomRegistry(ctx, logger)
+	eventHub := events.NewHub()
+	registry := collab.NewRoomRegistry(ctx, eventHub, logger)
 	wsHandler := collab.NewWSHandler(registry, logger)
-	roomHandlers := NewRoomHandlers(registry, wsHandler, logger, cfg.BaseURL)
+	metrics.TrackRooms(registry.RoomCount, registry.ClientCount)
+	registry.SetLimits(roomLimits(cfg))
+	rateLimiter := NewRateLimiter(ctx, cfg.RateLimit, cfg.RateLimitBurst)
+	reloader.Subscribe(func(cfg *config.Config) {
+		corsHandler.SetOrigins(cfg.AllowedOrigins)
+		rateLimiter.SetLimit(cfg.RateLimit, cfg.RateLimitBurst)
+		registry.SetLimits(roomLimits(cfg))
+	})
+	roomHandlers := NewRoomHandlers(registry, wsHandler, corsHandler, logger, cfg.BaseURL)
+	projectHandlers := NewProjectHandlers(registry, wsHandler, corsHandler, logger, cfg.BaseURL)
 
 	tokenManager, err := auth.NewTokenManager(cfg.JWTSigningKey, "maple", cfg.AccessTokenExpiry)
 	if err != nil {
@@ -52,11 +64,32 @@ func NewRouter(ctx context.Context, cfg *config.Config, logger *slog.Logger, dbP
 	}
 	userRepo := db.NewUserRepo(dbPool)
 	sessionRepo := db.NewSessionRepo(dbPool)
-	authHandlers := NewAuthHandlers(userRepo, sessionRepo, tokenManager, auth.DefaultPasswordHasher(), cfg, logger)
+	tickets := auth.NewTicketStore()
+	requireAuth := AuthMiddleware(tokenManager, tickets, logger)
+	optionalAuth := OptionalAuthMiddleware(tokenManager, tickets, logger)
+	authHandlers := NewAuthHandlers(userRepo, sessionRepo, tokenManager, auth.DefaultPasswordHasher(), tickets, cfg, logger)
 	docRepo := db.NewDocumentRepo(dbPool)
+	folderRepo := db.NewFolderRepo(dbPool)
 	opRepo := db.NewOpRepo(dbPool)
 	snapshotRepo := db.NewSnapshotRepo(dbPool)
-	docHandlers := NewDocumentHandlers(docRepo, opRepo, snapshotRepo, logger)
+	webhookRepo := db.NewWebhookRepo(dbPool)
+	historyService := history.NewService(docRepo, opRepo, snapshotRepo, webhookRepo)
+	go jobs.NewTrashPurger(docRepo, registry, cfg.TrashRetention, logger).Run(ctx)
+	go jobs.NewCompactor(historyService, snapshotRepo, jobs.CompactorConfig{
+		EveryOps: cfg.SnapshotEveryOps,
+		Interval: cfg.SnapshotInterval,
+		Retain:   cfg.SnapshotRetain,
+	}, logger).Run(ctx)
+	go webhooks.NewDispatcher(webhookRepo, logger).Run(ctx)
+	go jobs.NewDeliveryPruner(webhookRepo, cfg.WebhookRetention, logger).Run(ctx)
+
+	folderHandlers := NewFolderHandlers(folderRepo, registry, logger)
+	webhookHandlers := NewWebhookHandlers(webhookRepo, docRepo, logger)
+	docHandlers := NewDocumentHandlers(docRepo, folderRepo, opRepo, snapshotRepo, historyService, registry, wsHandler, corsHandler, eventHub, logger, cfg.BaseURL)
+	adminHandlers := NewAdminHandlers(registry, reloader, logger)
+	healthChecker := newHealthChecker(dbPool, registry, logger)
+	healthChecker.Start(ctx, healthInterval)
+	registry.SetStoreCheck(func() bool { return healthChecker.Healthy(checkDatabase) })
 
 	r.Get("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
@@ -65,8 +98,16 @@ func NewRouter(ctx context.Context, cfg *config.Config, logger *slog.Logger, dbP
 			"version": version,
 		})
 	})
+	r.Get("/ready", Ready(healthChecker))
+
+	// With a separate METRICS_ADDR, metrics are served there instead
+	if cfg.MetricsToken != "" && cfg.MetricsAddr == "" {
+		r.With(MetricsMiddleware(cfg.MetricsToken, logger)).Handle("/metrics", metrics.Handler())
+	}
 
 	r.Route("/v1", func(r chi.Router) {
+		r.Use(rateLimiter.Middleware)
+
 		r.Get("/health", func(w http.ResponseWriter, r *http.Request) {
 			w.Header().Set("Content-Type", "application/json")
 			json.NewEncoder(w).Encode(map[string]string{
@@ -74,33 +115,102 @@ func NewRouter(ctx context.Context, cfg *config.Config, logger *slog.Logger, dbP
 				"version": version,
 			})
 		})
+		r.Get("/ready", Ready(healthChecker))
 
 		r.Route("/rooms", func(r chi.Router) {
 			r.Post("/", roomHandlers.CreateRoom)
 			r.Get("/{roomId}", roomHandlers.GetRoom)
 			r.Delete("/{roomId}", roomHandlers.DeleteRoom)
-			r.Get("/{roomId}/ws", roomHandlers.WebSocket)
+			r.Post("/{roomId}/fork", roomHandlers.ForkRoom)
+			r.With(optionalAuth).Get("/{roomId}/ws", roomHandlers.WebSocket)
 		})
 
-		r.Route("/auth", func(r chi.Router) {
-			r.Post("/register", authHandlers.Register)
-			r.Post("/login", authHandlers.Login)
-			r.Post("/refresh", authHandlers.Refresh)
-			r.Post("/logout", authHandlers.Logout)
-			r.With(AuthMiddleware(tokenManager, logger)).Post("/password", authHandlers.ChangePassword)
+		r.Route("/projects", func(r chi.Router) {
+			r.Post("/", projectHandlers.CreateProject)
+			r.Get("/{projectId}", projectHandlers.GetProject)
+			r.Delete("/{projectId}", projectHandlers.DeleteProject)
+			r.With(optionalAuth).Get("/{projectId}/ws", projectHandlers.WebSocket)
 		})
 
-		r.With(AuthMiddleware(tokenManager, logger)).Get("/me", authHandlers.Me)
+		// Everything below needs the database. While it is down these fail
+		// fast, and anonymous rooms and projects above carry on.
+		r.Group(func(r chi.Router) {
+			r.Use(RequireDatabase(healthChecker))
+
+			r.Route("/auth", func(r chi.Router) {
+				r.Post("/register", authHandlers.Register)
+				r.Post("/login", authHandlers.Login)
+				r.Post("/refresh", authHandlers.Refresh)
+				r.Post("/logout", authHandlers.Logout)
+				r.With(requireAuth).Post("/password", authHandlers.ChangePassword)
+				r.With(requireAuth).Post("/ticket", authHandlers.IssueTicket)
+			})
+
+			r.With(requireAuth).Get("/me", authHandlers.Me)
+
+			r.With(requireAuth).Route("/folders", func(r chi.Router) {
+				r.Post("/", folderHandlers.CreateFolder)
+				r.Get("/", folderHandlers.ListFolders)
+				r.Patch("/{id}", folderHandlers.RenameFolder)
+				r.Post("/{id}/move", folderHandlers.MoveFolder)
+				r.Delete("/{id}", folderHandlers.DeleteFolder)
+			})
+
+			r.With(requireAuth).Route("/trash", func(r chi.Router) {
+				r.Get("/", docHandlers.ListTrash)
+				r.Delete("/{id}", docHandlers.PurgeDocument)
+			})
+
+			r.With(requireAuth).Route("/webhooks", func(r chi.Router) {
+				r.Post("/", webhookHandlers.CreateWebhook)
+				r.Get("/", webhookHandlers.ListWebhooks)
+				r.Delete("/{id}", webhookHandlers.DeleteWebhook)
+				r.Get("/{id}/deliveries", webhookHandlers.ListDeliveries)
+			})
 
-		r.With(AuthMiddleware(tokenManager, logger)).Route("/docs", func(r chi.Router) {
-			r.Post("/", docHandlers.CreateDocument)
-			r.Get("/", docHandlers.ListDocuments)
-			r.Get("/{id}", docHandlers.GetDocument)
-			r.Get("/{id}/content", docHandlers.GetDocumentContent)
-			r.Patch("/{id}", docHandlers.UpdateDocument)
-			r.Delete("/{id}", docHandlers.DeleteDocument)
+			r.With(requireAuth).Route("/docs", func(r chi.Router) {
+				r.Post("/", docHandlers.CreateDocument)
+				r.Get("/", docHandlers.ListDocuments)
+				r.Get("/search", docHandlers.SearchDocuments)
+				r.Get("/{id}", docHandlers.GetDocument)
+				r.Get("/{id}/content", docHandlers.GetDocumentContent)
+				r.Get("/{id}/versions", docHandlers.ListVersions)
+				r.Get("/{id}/versions/{version}", docHandlers.GetVersion)
+				r.Get("/{id}/diff", docHandlers.DiffVersions)
+				r.Get("/{id}/blame", docHandlers.GetBlame)
+				r.Get("/{id}/playback", docHandlers.Playback)
+				r.Get("/{id}/events", docHandlers.Events)
+				r.Post("/{id}/fork", docHandlers.ForkDocument)
+				r.Get("/{id}/merge", docHandlers.PreviewMerge)
+				r.Post("/{id}/merge", docHandlers.Merge)
+				r.Post("/{id}/room", docHandlers.OpenRoom)
+				r.Post("/{id}/ops", docHandlers.ApplyOps)
+				r.Put("/{id}/content", docHandlers.ReplaceContent)
+				r.Post("/{id}/move", docHandlers.MoveDocument)
+				r.Post("/{id}/restore", docHandlers.RestoreDocument)
+				r.Patch("/{id}", docHandlers.UpdateDocument)
+				r.Delete("/{id}", docHandlers.DeleteDocument)
+			})
 		})
+
+		// Admin endpoints exist only when an admin token is configured
+		if cfg.AdminToken != "" {
+			r.With(AdminMiddleware(cfg.AdminToken, logger)).Route("/admin", func(r chi.Router) {
+				r.Get("/rooms", adminHandlers.ListRooms)
+				r.Get("/rooms/{roomId}", adminHandlers.GetRoom)
+				r.Post("/rooms/{roomId}/close", adminHandlers.CloseRoom)
+				r.Post("/rooms/{roomId}/snapshots", adminHandlers.CreateSnapshot)
+				r.Post("/reload", adminHandlers.Reload)
+			})
+		}
 	})
 
 	return r, nil
 }
+
+func roomLimits(cfg *config.Config) collab.Limits {
+	return collab.Limits{
+		MaxRooms:       cfg.MaxRooms,
+		MaxRoomClients: cfg.MaxRoomClients,
+	}
+}
@@ -0,0 +1,169 @@
+package httpapi
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 50
+	maxSearchSnippets  = 3
+)
+
+// SearchHighlight is a matched range within a snippet line, in UTF-16 code
+// units like editor columns.
+type SearchHighlight struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+type SearchSnippet struct {
+	Line       int               `json:"line"`
+	Content    string            `json:"content"`
+	Highlights []SearchHighlight `json:"highlights"`
+}
+
+type SearchResultResponse struct {
+	Document DocumentResponse `json:"document"`
+	Rank     float32          `json:"rank"`
+	Snippets []SearchSnippet  `json:"snippets"`
+}
+
+type SearchResponse struct {
+	Query   string                 `json:"query"`
+	Results []SearchResultResponse `json:"results"`
+}
+
+// SearchDocuments runs a full-text search over the caller's documents. q
+// accepts web search syntax: quoted phrases, "or" and -excluded words.
+func (h *DocumentHandlers) SearchDocuments(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized", "Missing user")
+		return
+	}
+
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		writeError(w, http.StatusBadRequest, "invalid_request", "q is required")
+		return
+	}
+
+	limit := defaultSearchLimit
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid_request", "limit must be a positive number")
+			return
+		}
+		limit = min(parsed, maxSearchLimit)
+	}
+
+	hits, err := h.docs.Search(r.Context(), userID, query, limit)
+	if err != nil {
+		h.logger.Error("search documents failed", "error", err)
+		writeError(w, http.StatusInternalServerError, "server_error", "Could not search documents")
+		return
+	}
+
+	terms := searchTerms(query)
+	resp := SearchResponse{
+		Query:   query,
+		Results: make([]SearchResultResponse, 0, len(hits)),
+	}
+	for i := range hits {
+		resp.Results = append(resp.Results, SearchResultResponse{
+			Document: formatDocument(&hits[i].Document),
+			Rank:     hits[i].Rank,
+			Snippets: searchSnippets(hits[i].Content, terms, maxSearchSnippets),
+		})
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// searchTerms extracts the lowercased words to highlight from a web search
+// query, skipping operators and excluded words
+func searchTerms(query string) []string {
+	var terms []string
+	for _, field := range strings.Fields(query) {
+		if strings.HasPrefix(field, "-") || strings.EqualFold(field, "or") {
+			continue
+		}
+		for _, word := range strings.FieldsFunc(field, func(r rune) bool {
+			return !isWordRune(r)
+		}) {
+			terms = append(terms, strings.ToLower(word))
+		}
+	}
+	return terms
+}
+
+// searchSnippets returns up to max lines of content containing a whole-word
+// match of any term, with every match highlighted
+func searchSnippets(content string, terms []string, max int) []SearchSnippet {
+	snippets := make([]SearchSnippet, 0)
+	if len(terms) == 0 {
+		return snippets
+	}
+
+	for i, line := range strings.Split(content, "\n") {
+		highlights := matchTerms([]rune(line), terms)
+		if len(highlights) == 0 {
+			continue
+		}
+		snippets = append(snippets, SearchSnippet{
+			Line:       i + 1,
+			Content:    line,
+			Highlights: highlights,
+		})
+		if len(snippets) == max {
+			break
+		}
+	}
+	return snippets
+}
+
+func matchTerms(line []rune, terms []string) []SearchHighlight {
+	lower := []rune(strings.ToLower(string(line)))
+	if len(lower) != len(line) {
+		// Case folding changed the rune count, so offsets would not line up
+		lower = line
+	}
+
+	var highlights []SearchHighlight
+	for start := 0; start < len(lower); start++ {
+		if start > 0 && isWordRune(lower[start-1]) {
+			continue
+		}
+		for _, term := range terms {
+			termRunes := []rune(term)
+			end := start + len(termRunes)
+			if end > len(lower) || string(lower[start:end]) != term {
+				continue
+			}
+			if end < len(lower) && isWordRune(lower[end]) {
+				continue
+			}
+			highlights = append(highlights, SearchHighlight{
+				Start: utf16Len(line[:start]),
+				End:   utf16Len(line[:end]),
+			})
+			start = end - 1
+			break
+		}
+	}
+	return highlights
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func utf16Len(runes []rune) int {
+	return len(utf16.Encode(runes))
+}
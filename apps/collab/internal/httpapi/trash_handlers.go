@@ -0,0 +1,77 @@
+package httpapi
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/NoumanAMalik/maple/apps/collab/internal/collab"
+	"github.com/NoumanAMalik/maple/apps/collab/internal/db"
+)
+
+// ListTrash lists the caller's deleted documents, most recently deleted first.
+func (h *DocumentHandlers) ListTrash(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized", "Missing user")
+		return
+	}
+
+	docs, err := h.docs.ListDeleted(r.Context(), userID, 100)
+	if err != nil {
+		h.logger.Error("list trash failed", "error", err)
+		writeError(w, http.StatusInternalServerError, "server_error", "Could not load trash")
+		return
+	}
+
+	resp := make([]DocumentResponse, 0, len(docs))
+	for i := range docs {
+		resp = append(resp, formatDocument(&docs[i]))
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (h *DocumentHandlers) RestoreDocument(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized", "Missing user")
+		return
+	}
+
+	doc, err := h.docs.Restore(r.Context(), chi.URLParam(r, "id"), userID)
+	if err != nil {
+		if err == db.ErrNotFound {
+			writeError(w, http.StatusNotFound, "not_found", "Document not found in trash")
+			return
+		}
+		h.logger.Error("restore document failed", "error", err)
+		writeError(w, http.StatusInternalServerError, "server_error", "Could not restore document")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, formatDocument(doc))
+}
+
+// PurgeDocument permanently deletes a document that is already in the trash.
+func (h *DocumentHandlers) PurgeDocument(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized", "Missing user")
+		return
+	}
+
+	docID := chi.URLParam(r, "id")
+	if err := h.docs.HardDelete(r.Context(), docID, userID); err != nil {
+		if err == db.ErrNotFound {
+			writeError(w, http.StatusNotFound, "not_found", "Document not found in trash")
+			return
+		}
+		h.logger.Error("purge document failed", "error", err)
+		writeError(w, http.StatusInternalServerError, "server_error", "Could not delete document")
+		return
+	}
+	h.registry.CloseDocumentRoom(docID, collab.DocumentDeletedReason)
+
+	w.WriteHeader(http.StatusNoContent)
+}
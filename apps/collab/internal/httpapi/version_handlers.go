@@ -0,0 +1,205 @@
+package httpapi
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/NoumanAMalik/maple/apps/collab/internal/collab"
+	"github.com/NoumanAMalik/maple/apps/collab/internal/history"
+	"github.com/NoumanAMalik/maple/apps/collab/internal/models"
+)
+
+var errInvalidVersionRef = errors.New("invalid version reference")
+
+type VersionSummaryResponse struct {
+	SnapshotID string `json:"snapshotId"`
+	Version    int64  `json:"version"`
+	CreatedAt  string `json:"createdAt"`
+}
+
+type VersionListResponse struct {
+	Document  DocumentResponse         `json:"document"`
+	Snapshots []VersionSummaryResponse `json:"snapshots"`
+}
+
+type VersionContentResponse struct {
+	DocumentID string `json:"documentId"`
+	Version    int64  `json:"version"`
+	Language   string `json:"language,omitempty"`
+	Content    string `json:"content"`
+}
+
+type VersionDiffResponse struct {
+	DocumentID  string            `json:"documentId"`
+	FromVersion int64             `json:"fromVersion"`
+	ToVersion   int64             `json:"toVersion"`
+	Language    string            `json:"language,omitempty"`
+	Result      collab.DiffResult `json:"result"`
+}
+
+type BlameResponse struct {
+	DocumentID string             `json:"documentId"`
+	Version    int64              `json:"version"`
+	Lines      []collab.BlameLine `json:"lines"`
+}
+
+func (h *DocumentHandlers) ListVersions(w http.ResponseWriter, r *http.Request) {
+	doc, ok := h.loadDocument(w, r)
+	if !ok {
+		return
+	}
+
+	snapshots, err := h.snapshots.ListByDocument(r.Context(), doc.ID)
+	if err != nil {
+		h.logger.Error("list snapshots failed", "error", err)
+		writeError(w, http.StatusInternalServerError, "server_error", "Could not load versions")
+		return
+	}
+
+	resp := VersionListResponse{
+		Document:  formatDocument(doc),
+		Snapshots: make([]VersionSummaryResponse, 0, len(snapshots)),
+	}
+	for _, snapshot := range snapshots {
+		resp.Snapshots = append(resp.Snapshots, VersionSummaryResponse{
+			SnapshotID: snapshot.ID,
+			Version:    snapshot.Version,
+			CreatedAt:  snapshot.CreatedAt.Format(time.RFC3339),
+		})
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (h *DocumentHandlers) GetVersion(w http.ResponseWriter, r *http.Request) {
+	doc, ok := h.loadDocument(w, r)
+	if !ok {
+		return
+	}
+
+	version, err := h.resolveVersion(r, doc, chi.URLParam(r, "version"))
+	if err != nil {
+		h.writeVersionError(w, err)
+		return
+	}
+
+	content, err := h.history.Materialize(r.Context(), doc.ID, version)
+	if err != nil {
+		h.writeVersionError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, VersionContentResponse{
+		DocumentID: doc.ID,
+		Version:    version,
+		Language:   doc.Language,
+		Content:    content,
+	})
+}
+
+func (h *DocumentHandlers) DiffVersions(w http.ResponseWriter, r *http.Request) {
+	doc, ok := h.loadDocument(w, r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	if strings.TrimSpace(query.Get("from")) == "" {
+		writeError(w, http.StatusBadRequest, "invalid_request", "from is required")
+		return
+	}
+
+	fromVersion, err := h.resolveVersion(r, doc, query.Get("from"))
+	if err != nil {
+		h.writeVersionError(w, err)
+		return
+	}
+	toVersion, err := h.resolveVersion(r, doc, query.Get("to"))
+	if err != nil {
+		h.writeVersionError(w, err)
+		return
+	}
+
+	diff, err := h.history.Diff(r.Context(), doc.ID, fromVersion, toVersion)
+	if err != nil {
+		h.writeVersionError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, VersionDiffResponse{
+		DocumentID:  doc.ID,
+		FromVersion: fromVersion,
+		ToVersion:   toVersion,
+		Language:    doc.Language,
+		Result:      *diff,
+	})
+}
+
+// resolveVersion accepts an integer version, "latest" (or empty), or an
+// RFC 3339 timestamp, which resolves to the last version applied at that time.
+func (h *DocumentHandlers) resolveVersion(r *http.Request, doc *models.Document, ref string) (int64, error) {
+	ref = strings.TrimSpace(ref)
+	if ref == "" || ref == "latest" {
+		return doc.CurrentVersion, nil
+	}
+
+	if version, err := strconv.ParseInt(ref, 10, 64); err == nil {
+		if version < 0 || version > doc.CurrentVersion {
+			return 0, history.ErrVersionUnavailable
+		}
+		return version, nil
+	}
+
+	at, err := time.Parse(time.RFC3339, ref)
+	if err != nil {
+		return 0, errInvalidVersionRef
+	}
+	if at.Before(doc.CreatedAt) {
+		return 0, history.ErrVersionUnavailable
+	}
+	return h.ops.VersionAt(r.Context(), doc.ID, at)
+}
+
+func (h *DocumentHandlers) writeVersionError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errInvalidVersionRef):
+		writeError(w, http.StatusBadRequest, "invalid_version", "Version must be a number, \"latest\" or an RFC 3339 timestamp")
+	case errors.Is(err, history.ErrNotForked):
+		writeError(w, http.StatusBadRequest, "not_a_fork", "Document is not a fork")
+	case errors.Is(err, history.ErrVersionUnavailable):
+		writeError(w, http.StatusNotFound, "version_not_found", "Version is not available")
+	default:
+		h.logger.Error("materialize version failed", "error", err)
+		writeError(w, http.StatusInternalServerError, "server_error", "Could not load version")
+	}
+}
+
+func (h *DocumentHandlers) GetBlame(w http.ResponseWriter, r *http.Request) {
+	doc, ok := h.loadDocument(w, r)
+	if !ok {
+		return
+	}
+
+	version, err := h.resolveVersion(r, doc, r.URL.Query().Get("version"))
+	if err != nil {
+		h.writeVersionError(w, err)
+		return
+	}
+
+	lines, err := h.history.Blame(r.Context(), doc, version)
+	if err != nil {
+		h.writeVersionError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, BlameResponse{
+		DocumentID: doc.ID,
+		Version:    version,
+		Lines:      lines,
+	})
+}
@@ -0,0 +1,263 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/NoumanAMalik/maple/apps/collab/internal/db"
+	"github.com/NoumanAMalik/maple/apps/collab/internal/models"
+	"github.com/NoumanAMalik/maple/apps/collab/internal/webhooks"
+)
+
+const (
+	defaultDeliveryLimit = 50
+	maxDeliveryLimit     = 200
+	// maxWebhooksPerOwner bounds how many deliveries one user's edits fan
+	// out to
+	maxWebhooksPerOwner = 20
+)
+
+type WebhookHandlers struct {
+	webhooks *db.WebhookRepo
+	docs     *db.DocumentRepo
+	logger   *slog.Logger
+}
+
+func NewWebhookHandlers(webhooks *db.WebhookRepo, docs *db.DocumentRepo, logger *slog.Logger) *WebhookHandlers {
+	return &WebhookHandlers{
+		webhooks: webhooks,
+		docs:     docs,
+		logger:   logger,
+	}
+}
+
+type createWebhookRequest struct {
+	URL        string   `json:"url"`
+	Events     []string `json:"events"`
+	DocumentID *string  `json:"documentId,omitempty"`
+}
+
+type WebhookResponse struct {
+	ID         string   `json:"id"`
+	DocumentID *string  `json:"documentId,omitempty"`
+	URL        string   `json:"url"`
+	Events     []string `json:"events"`
+	CreatedAt  string   `json:"createdAt"`
+}
+
+// CreateWebhookResponse includes the signing secret, which is only ever
+// returned when the webhook is created.
+type CreateWebhookResponse struct {
+	WebhookResponse
+	Secret string `json:"secret"`
+}
+
+type WebhookDeliveryResponse struct {
+	ID             string          `json:"id"`
+	Event          string          `json:"event"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  *string         `json:"nextAttemptAt,omitempty"`
+	LastStatusCode *int            `json:"lastStatusCode,omitempty"`
+	LastError      *string         `json:"lastError,omitempty"`
+	Payload        json.RawMessage `json:"payload"`
+	CreatedAt      string          `json:"createdAt"`
+	DeliveredAt    *string         `json:"deliveredAt,omitempty"`
+}
+
+// CreateWebhook subscribes a URL to events on all of the caller's documents,
+// or only on documentId when it is given.
+func (h *WebhookHandlers) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized", "Missing user")
+		return
+	}
+
+	var req createWebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", "Invalid JSON body")
+		return
+	}
+
+	target, err := webhooks.ParseURL(r.Context(), req.URL)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	if len(req.Events) == 0 {
+		writeError(w, http.StatusBadRequest, "invalid_request", "events is required")
+		return
+	}
+	for _, event := range req.Events {
+		if !slices.Contains(models.WebhookEvents, event) {
+			writeError(w, http.StatusBadRequest, "invalid_request", "Unknown event: "+event)
+			return
+		}
+	}
+	events := slices.Compact(slices.Sorted(slices.Values(req.Events)))
+
+	if req.DocumentID != nil {
+		if _, err := h.docs.GetByIDForOwner(r.Context(), *req.DocumentID, userID); err != nil {
+			if err == db.ErrNotFound {
+				writeError(w, http.StatusNotFound, "not_found", "Document not found")
+				return
+			}
+			h.logger.Error("get document failed", "error", err)
+			writeError(w, http.StatusInternalServerError, "server_error", "Could not load document")
+			return
+		}
+	}
+
+	secret, err := webhooks.NewSecret()
+	if err != nil {
+		h.logger.Error("generate webhook secret failed", "error", err)
+		writeError(w, http.StatusInternalServerError, "server_error", "Could not create webhook")
+		return
+	}
+
+	hook, err := h.webhooks.Create(r.Context(), userID, req.DocumentID, target.String(), secret, events, maxWebhooksPerOwner)
+	if err != nil {
+		if err == db.ErrNotFound {
+			writeError(w, http.StatusNotFound, "not_found", "Document not found")
+			return
+		}
+		if err == db.ErrLimitReached {
+			writeError(w, http.StatusConflict, "webhook_limit_reached", fmt.Sprintf("Accounts can have at most %d webhooks", maxWebhooksPerOwner))
+			return
+		}
+		h.logger.Error("create webhook failed", "error", err)
+		writeError(w, http.StatusInternalServerError, "server_error", "Could not create webhook")
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, CreateWebhookResponse{
+		WebhookResponse: formatWebhook(hook),
+		Secret:          hook.Secret,
+	})
+}
+
+func (h *WebhookHandlers) ListWebhooks(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized", "Missing user")
+		return
+	}
+
+	hooks, err := h.webhooks.ListByOwner(r.Context(), userID)
+	if err != nil {
+		h.logger.Error("list webhooks failed", "error", err)
+		writeError(w, http.StatusInternalServerError, "server_error", "Could not load webhooks")
+		return
+	}
+
+	resp := make([]WebhookResponse, 0, len(hooks))
+	for i := range hooks {
+		resp = append(resp, formatWebhook(&hooks[i]))
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (h *WebhookHandlers) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized", "Missing user")
+		return
+	}
+
+	if err := h.webhooks.Delete(r.Context(), chi.URLParam(r, "id"), userID); err != nil {
+		if err == db.ErrNotFound {
+			writeError(w, http.StatusNotFound, "not_found", "Webhook not found")
+			return
+		}
+		h.logger.Error("delete webhook failed", "error", err)
+		writeError(w, http.StatusInternalServerError, "server_error", "Could not delete webhook")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListDeliveries returns a webhook's delivery log, newest first.
+func (h *WebhookHandlers) ListDeliveries(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized", "Missing user")
+		return
+	}
+
+	limit := defaultDeliveryLimit
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid_request", "limit must be a positive number")
+			return
+		}
+		limit = min(parsed, maxDeliveryLimit)
+	}
+
+	hook, err := h.webhooks.GetByIDForOwner(r.Context(), chi.URLParam(r, "id"), userID)
+	if err != nil {
+		if err == db.ErrNotFound {
+			writeError(w, http.StatusNotFound, "not_found", "Webhook not found")
+			return
+		}
+		h.logger.Error("get webhook failed", "error", err)
+		writeError(w, http.StatusInternalServerError, "server_error", "Could not load webhook")
+		return
+	}
+
+	deliveries, err := h.webhooks.ListDeliveries(r.Context(), hook.ID, limit)
+	if err != nil {
+		h.logger.Error("list webhook deliveries failed", "error", err)
+		writeError(w, http.StatusInternalServerError, "server_error", "Could not load deliveries")
+		return
+	}
+
+	resp := make([]WebhookDeliveryResponse, 0, len(deliveries))
+	for i := range deliveries {
+		resp = append(resp, formatDelivery(&deliveries[i]))
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func formatWebhook(hook *models.Webhook) WebhookResponse {
+	return WebhookResponse{
+		ID:         hook.ID,
+		DocumentID: hook.DocumentID,
+		URL:        hook.URL,
+		Events:     hook.Events,
+		CreatedAt:  hook.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+func formatDelivery(delivery *models.WebhookDelivery) WebhookDeliveryResponse {
+	resp := WebhookDeliveryResponse{
+		ID:             delivery.ID,
+		Event:          delivery.Event,
+		Status:         delivery.Status,
+		Attempts:       delivery.Attempts,
+		LastStatusCode: delivery.LastStatusCode,
+		LastError:      delivery.LastError,
+		Payload:        delivery.Payload,
+		CreatedAt:      delivery.CreatedAt.Format(time.RFC3339),
+	}
+	if delivery.Status == models.DeliveryPending {
+		next := delivery.NextAttemptAt.Format(time.RFC3339)
+		resp.NextAttemptAt = &next
+	}
+	if delivery.DeliveredAt != nil {
+		delivered := delivery.DeliveredAt.Format(time.RFC3339)
+		resp.DeliveredAt = &delivered
+	}
+	return resp
+}
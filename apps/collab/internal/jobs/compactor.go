@@ -0,0 +1,127 @@
+package jobs
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/NoumanAMalik/maple/apps/collab/internal/db"
+	"github.com/NoumanAMalik/maple/apps/collab/internal/history"
+	"github.com/NoumanAMalik/maple/apps/collab/internal/metrics"
+	"github.com/NoumanAMalik/maple/apps/collab/internal/models"
+)
+
+const (
+	compactionCheckInterval = 30 * time.Second
+	compactionBatchSize     = 50
+)
+
+// CompactorConfig controls when documents are snapshotted and how much
+// history is kept.
+type CompactorConfig struct {
+	// EveryOps snapshots a document once it is this many versions ahead of
+	// its latest snapshot. Zero or less disables this trigger.
+	EveryOps int64
+	// Interval snapshots a document with any new ops once its latest
+	// snapshot is older than this. Zero or less disables this trigger;
+	// compaction stops when both are disabled.
+	Interval time.Duration
+	// Retain is how many snapshots to keep per document. Older snapshots and
+	// the ops they cover are pruned; zero or less keeps everything.
+	Retain int
+}
+
+// Compactor folds the op log into snapshots so loading a document replays a
+// bounded number of ops.
+type Compactor struct {
+	history   *history.Service
+	snapshots *db.SnapshotRepo
+	cfg       CompactorConfig
+	logger    *slog.Logger
+}
+
+func NewCompactor(historyService *history.Service, snapshots *db.SnapshotRepo, cfg CompactorConfig, logger *slog.Logger) *Compactor {
+	return &Compactor{
+		history:   historyService,
+		snapshots: snapshots,
+		cfg:       cfg,
+		logger:    logger,
+	}
+}
+
+// Run compacts once straight away and then every 30 seconds until ctx is
+// done.
+func (c *Compactor) Run(ctx context.Context) {
+	if c.cfg.EveryOps <= 0 && c.cfg.Interval <= 0 {
+		c.logger.Info("snapshot compaction disabled")
+		return
+	}
+
+	ticker := time.NewTicker(compactionCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		c.compact(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *Compactor) compact(ctx context.Context) {
+	var staleBefore *time.Time
+	if c.cfg.Interval > 0 {
+		cutoff := time.Now().Add(-c.cfg.Interval)
+		staleBefore = &cutoff
+	}
+
+	candidates, err := c.snapshots.ListCompactionCandidates(ctx, max(c.cfg.EveryOps, 0), staleBefore, compactionBatchSize)
+	if err != nil {
+		if ctx.Err() == nil {
+			c.logger.Error("list compaction candidates failed", "error", err)
+		}
+		return
+	}
+
+	for _, candidate := range candidates {
+		if ctx.Err() != nil {
+			return
+		}
+		c.compactDocument(ctx, candidate)
+	}
+}
+
+func (c *Compactor) compactDocument(ctx context.Context, candidate db.CompactionCandidate) {
+	doc := &models.Document{
+		ID:             candidate.DocumentID,
+		OwnerID:        candidate.OwnerID,
+		CurrentVersion: candidate.CurrentVersion,
+	}
+	content, blame, err := c.history.SnapshotState(ctx, doc, candidate.CurrentVersion)
+	if err != nil {
+		c.logger.Error("materialize for compaction failed", "documentId", candidate.DocumentID, "version", candidate.CurrentVersion, "error", err)
+		return
+	}
+
+	if _, err := c.snapshots.Create(ctx, candidate.DocumentID, candidate.CurrentVersion, content, blame); err != nil {
+		c.logger.Error("create compaction snapshot failed", "documentId", candidate.DocumentID, "error", err)
+		return
+	}
+	metrics.Snapshots.WithLabelValues("compaction").Inc()
+	c.logger.Debug("compacted document", "documentId", candidate.DocumentID, "fromVersion", candidate.SnapshotVersion, "toVersion", candidate.CurrentVersion)
+
+	if c.cfg.Retain <= 0 {
+		return
+	}
+	snapshots, ops, err := c.snapshots.Prune(ctx, candidate.DocumentID, c.cfg.Retain)
+	if err != nil {
+		c.logger.Error("prune document history failed", "documentId", candidate.DocumentID, "error", err)
+		return
+	}
+	if snapshots > 0 || ops > 0 {
+		c.logger.Info("pruned document history", "documentId", candidate.DocumentID, "snapshots", snapshots, "ops", ops)
+	}
+}
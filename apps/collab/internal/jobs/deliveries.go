@@ -0,0 +1,64 @@
+package jobs
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/NoumanAMalik/maple/apps/collab/internal/db"
+)
+
+const deliveryPruneInterval = time.Hour
+
+// DeliveryPruner removes webhook deliveries that were delivered or given up
+// on longer ago than the retention period, so the outbox does not grow with
+// every edit forever.
+type DeliveryPruner struct {
+	webhooks  *db.WebhookRepo
+	retention time.Duration
+	logger    *slog.Logger
+}
+
+func NewDeliveryPruner(webhooks *db.WebhookRepo, retention time.Duration, logger *slog.Logger) *DeliveryPruner {
+	return &DeliveryPruner{
+		webhooks:  webhooks,
+		retention: retention,
+		logger:    logger,
+	}
+}
+
+// Run prunes once straight away and then every hour until ctx is done. A
+// retention of zero or less disables pruning.
+func (p *DeliveryPruner) Run(ctx context.Context) {
+	if p.retention <= 0 {
+		p.logger.Info("webhook delivery pruning disabled")
+		return
+	}
+
+	ticker := time.NewTicker(deliveryPruneInterval)
+	defer ticker.Stop()
+
+	for {
+		p.prune(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *DeliveryPruner) prune(ctx context.Context) {
+	cutoff := time.Now().Add(-p.retention)
+	removed, err := p.webhooks.DeleteFinishedDeliveries(ctx, cutoff)
+	if err != nil {
+		if ctx.Err() == nil {
+			p.logger.Error("webhook delivery prune failed", "error", err)
+		}
+		return
+	}
+	if removed > 0 {
+		p.logger.Info("pruned webhook deliveries", "count", removed, "cutoff", cutoff)
+	}
+}
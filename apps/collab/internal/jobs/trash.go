@@ -0,0 +1,69 @@
+package jobs
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/NoumanAMalik/maple/apps/collab/internal/collab"
+	"github.com/NoumanAMalik/maple/apps/collab/internal/db"
+)
+
+const trashPurgeInterval = time.Hour
+
+// TrashPurger permanently removes documents that have been in the trash
+// longer than the retention period.
+type TrashPurger struct {
+	docs      *db.DocumentRepo
+	registry  *collab.RoomRegistry
+	retention time.Duration
+	logger    *slog.Logger
+}
+
+func NewTrashPurger(docs *db.DocumentRepo, registry *collab.RoomRegistry, retention time.Duration, logger *slog.Logger) *TrashPurger {
+	return &TrashPurger{
+		docs:      docs,
+		registry:  registry,
+		retention: retention,
+		logger:    logger,
+	}
+}
+
+// Run purges once straight away and then every hour until ctx is done. A
+// retention of zero or less disables purging.
+func (p *TrashPurger) Run(ctx context.Context) {
+	if p.retention <= 0 {
+		p.logger.Info("trash purge disabled")
+		return
+	}
+
+	ticker := time.NewTicker(trashPurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		p.purge(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *TrashPurger) purge(ctx context.Context) {
+	cutoff := time.Now().Add(-p.retention)
+	purged, err := p.docs.PurgeDeletedBefore(ctx, cutoff)
+	if err != nil {
+		if ctx.Err() == nil {
+			p.logger.Error("trash purge failed", "error", err)
+		}
+		return
+	}
+	for _, docID := range purged {
+		p.registry.CloseDocumentRoom(docID, collab.DocumentDeletedReason)
+	}
+	if len(purged) > 0 {
+		p.logger.Info("purged trashed documents", "count", len(purged), "cutoff", cutoff)
+	}
+}
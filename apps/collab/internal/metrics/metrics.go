@@ -0,0 +1,122 @@
+// Package metrics holds the server's Prometheus metrics. They are registered
+// on Registry rather than the global default registry, so /metrics exposes
+// only what this server records plus Go runtime and process stats.
+package metrics
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "maple"
+
+var Registry = prometheus.NewRegistry()
+
+var (
+	OpsApplied = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "collab",
+		Name:      "ops_applied_total",
+		Help:      "Operations applied to rooms, after transformation.",
+	})
+	OpBatchDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "collab",
+		Name:      "op_batch_duration_seconds",
+		Help:      "Time to transform, apply and persist one op batch.",
+		Buckets:   []float64{.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	})
+	OpBatchSize = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "collab",
+		Name:      "op_batch_size",
+		Help:      "Operations per submitted batch.",
+		Buckets:   []float64{1, 2, 4, 8, 16, 32, 64, 128, 256},
+	})
+	Resyncs = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "collab",
+		Name:      "resyncs_total",
+		Help:      "Batches rejected with resync_required because their base version was too old or too new.",
+	})
+	DroppedMessages = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "collab",
+		Name:      "dropped_messages_total",
+		Help:      "Messages dropped because a client's send buffer was full.",
+	})
+	Snapshots = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "collab",
+		Name:      "snapshots_total",
+		Help:      "Room snapshots created, by type.",
+	}, []string{"type"})
+	AuthFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "auth",
+		Name:      "failures_total",
+		Help:      "Rejected authentication attempts, by reason.",
+	}, []string{"reason"})
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "HTTP request latency by route pattern. Long-lived WebSocket and event stream requests are not included.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+)
+
+// Auth failure reasons
+const (
+	AuthMissingToken       = "missing_token"
+	AuthInvalidToken       = "invalid_token"
+	AuthInvalidCredentials = "invalid_credentials"
+	AuthInvalidRefresh     = "invalid_refresh_token"
+	AuthInvalidStaticToken = "invalid_static_token"
+	AuthInvalidTicket      = "invalid_ticket"
+)
+
+var (
+	gaugesMu     sync.Mutex
+	roomCount    func() int
+	clientCount  func() int
+	roomsGauge   = prometheus.NewGaugeFunc(prometheus.GaugeOpts{Namespace: namespace, Subsystem: "collab", Name: "rooms", Help: "Open rooms."}, func() float64 { return readGauge(&roomCount) })
+	clientsGauge = prometheus.NewGaugeFunc(prometheus.GaugeOpts{Namespace: namespace, Subsystem: "collab", Name: "clients", Help: "Connected WebSocket clients across all rooms."}, func() float64 { return readGauge(&clientCount) })
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		OpsApplied, OpBatchDuration, OpBatchSize, Resyncs, DroppedMessages,
+		Snapshots, AuthFailures, HTTPRequestDuration,
+		roomsGauge, clientsGauge,
+	)
+}
+
+// TrackRooms sets where the room and client gauges read from. They are
+// computed when scraped rather than kept up to date on every join.
+func TrackRooms(rooms, clients func() int) {
+	gaugesMu.Lock()
+	defer gaugesMu.Unlock()
+	roomCount, clientCount = rooms, clients
+}
+
+func readGauge(fn *func() int) float64 {
+	gaugesMu.Lock()
+	read := *fn
+	gaugesMu.Unlock()
+	if read == nil {
+		return 0
+	}
+	return float64(read())
+}
+
+// Handler serves Registry in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
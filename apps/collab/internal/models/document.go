@@ -8,6 +8,9 @@ type Document struct {
 	Title          string     `json:"title"`
 	Language       string     `json:"language,omitempty"`
 	CurrentVersion int64      `json:"currentVersion"`
+	FolderID       *string    `json:"folderId,omitempty"`
+	ParentID       *string    `json:"parentId,omitempty"`
+	ParentVersion  *int64     `json:"parentVersion,omitempty"`
 	CreatedAt      time.Time  `json:"createdAt"`
 	UpdatedAt      time.Time  `json:"updatedAt"`
 	DeletedAt      *time.Time `json:"deletedAt,omitempty"`
@@ -19,7 +22,9 @@ type DocumentOp struct {
 	Version    int64     `json:"version"`
 	OpID       string    `json:"opId"`
 	ClientID   string    `json:"clientId,omitempty"`
+	UserID     string    `json:"userId,omitempty"`
 	Ops        []byte    `json:"ops"`
+	Presence   []byte    `json:"presence,omitempty"`
 	CreatedAt  time.Time `json:"createdAt"`
 }
 
@@ -29,4 +34,15 @@ type DocumentSnapshot struct {
 	Version    int64     `json:"version"`
 	Content    string    `json:"content"`
 	CreatedAt  time.Time `json:"createdAt"`
+	// Blame is the JSON line attribution saved with compaction snapshots;
+	// it is only loaded where blame needs it
+	Blame []byte `json:"-"`
+}
+
+// DocumentSearchHit is a document matched by full-text search, with the
+// content it was indexed under
+type DocumentSearchHit struct {
+	Document Document
+	Content  string
+	Rank     float32
 }
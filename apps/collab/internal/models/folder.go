@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+type Folder struct {
+	ID        string     `json:"id"`
+	OwnerID   string     `json:"ownerId"`
+	ParentID  *string    `json:"parentId,omitempty"`
+	Name      string     `json:"name"`
+	CreatedAt time.Time  `json:"createdAt"`
+	UpdatedAt time.Time  `json:"updatedAt"`
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+}
@@ -0,0 +1,149 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/NoumanAMalik/maple/apps/collab/internal/db"
+	"github.com/NoumanAMalik/maple/apps/collab/internal/models"
+)
+
+const (
+	pollInterval   = 5 * time.Second
+	claimBatchSize = 20
+	claimLease     = time.Minute
+	requestTimeout = 10 * time.Second
+
+	// maxAttempts is how many times a delivery is tried before it is marked
+	// failed
+	maxAttempts = 8
+	baseBackoff = 30 * time.Second
+	maxBackoff  = 6 * time.Hour
+)
+
+// Dispatcher sends queued webhook deliveries, retrying failures with
+// exponential backoff. Several dispatchers can share a database; claimed
+// deliveries are leased so each is sent by one of them.
+type Dispatcher struct {
+	webhooks *db.WebhookRepo
+	client   *http.Client
+	logger   *slog.Logger
+}
+
+func NewDispatcher(webhooks *db.WebhookRepo, logger *slog.Logger) *Dispatcher {
+	return &Dispatcher{
+		webhooks: webhooks,
+		client:   newClient(),
+		logger:   logger,
+	}
+}
+
+// Run sends due deliveries every few seconds until ctx is done.
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		d.dispatch(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *Dispatcher) dispatch(ctx context.Context) {
+	for {
+		deliveries, err := d.webhooks.ClaimDue(ctx, claimBatchSize, claimLease)
+		if err != nil {
+			if ctx.Err() == nil {
+				d.logger.Error("claim webhook deliveries failed", "error", err)
+			}
+			return
+		}
+
+		for i := range deliveries {
+			d.deliver(ctx, &deliveries[i])
+		}
+		if len(deliveries) < claimBatchSize || ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, delivery *models.WebhookDelivery) {
+	statusCode, err := d.send(ctx, delivery)
+	if err == nil {
+		if err := d.webhooks.MarkDelivered(ctx, delivery.ID, statusCode); err != nil {
+			d.logger.Error("mark webhook delivered failed", "deliveryId", delivery.ID, "error", err)
+		}
+		return
+	}
+
+	var retryAt *time.Time
+	if delivery.Attempts < maxAttempts {
+		next := time.Now().Add(backoff(delivery.Attempts))
+		retryAt = &next
+	}
+	d.logger.Warn("webhook delivery failed",
+		"deliveryId", delivery.ID,
+		"webhookId", delivery.WebhookID,
+		"attempt", delivery.Attempts,
+		"error", err)
+	if err := d.webhooks.MarkFailed(ctx, delivery.ID, statusCode, err.Error(), retryAt); err != nil {
+		d.logger.Error("mark webhook failed failed", "deliveryId", delivery.ID, "error", err)
+	}
+}
+
+// send POSTs a delivery and returns the response status. Any non-2xx
+// response is an error, including redirects, which are not followed.
+func (d *Dispatcher) send(ctx context.Context, delivery *models.WebhookDelivery) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Maple-Webhooks/1")
+	req.Header.Set(HeaderEvent, delivery.Event)
+	req.Header.Set(HeaderDelivery, delivery.ID)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, Sign(delivery.Secret, timestamp, delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// backoff returns the wait before retrying after the given number of
+// attempts: 30s doubling each time, capped at six hours.
+func backoff(attempts int) time.Duration {
+	if attempts < 1 {
+		attempts = 1
+	}
+	wait := baseBackoff
+	for i := 1; i < attempts && wait < maxBackoff; i++ {
+		wait *= 2
+	}
+	return min(wait, maxBackoff)
+}
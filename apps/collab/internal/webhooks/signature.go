@@ -0,0 +1,37 @@
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+)
+
+// Delivery request headers
+const (
+	HeaderEvent     = "X-Maple-Event"
+	HeaderDelivery  = "X-Maple-Delivery"
+	HeaderTimestamp = "X-Maple-Timestamp"
+	HeaderSignature = "X-Maple-Signature"
+)
+
+// NewSecret returns a random signing secret for a webhook.
+func NewSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(buf), nil
+}
+
+// Sign returns the X-Maple-Signature value for a delivery: an HMAC-SHA256 of
+// "<timestamp>.<body>" keyed with the webhook's secret. Receivers recompute
+// it and should reject stale timestamps to prevent replays.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1 @@
+ALTER TABLE document_ops DROP COLUMN IF EXISTS user_id;
@@ -0,0 +1 @@
+ALTER TABLE document_ops ADD COLUMN user_id UUID REFERENCES users(id) ON DELETE SET NULL;
@@ -0,0 +1 @@
+ALTER TABLE document_ops ADD COLUMN presence JSONB;
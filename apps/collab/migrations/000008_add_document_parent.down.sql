@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_documents_parent_id;
+ALTER TABLE documents DROP COLUMN IF EXISTS parent_version;
+ALTER TABLE documents DROP COLUMN IF EXISTS parent_id;
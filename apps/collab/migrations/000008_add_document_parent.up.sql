@@ -0,0 +1,4 @@
+ALTER TABLE documents ADD COLUMN parent_id UUID REFERENCES documents(id) ON DELETE SET NULL;
+ALTER TABLE documents ADD COLUMN parent_version BIGINT;
+
+CREATE INDEX idx_documents_parent_id ON documents(parent_id);
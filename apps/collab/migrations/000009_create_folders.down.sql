@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_documents_folder_id;
+ALTER TABLE documents DROP COLUMN IF EXISTS folder_id;
+DROP TABLE IF EXISTS folders;
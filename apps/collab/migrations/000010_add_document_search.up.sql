@@ -0,0 +1,19 @@
+-- Latest snapshot content, kept in sync by SnapshotRepo.Create
+ALTER TABLE documents ADD COLUMN search_content TEXT NOT NULL DEFAULT '';
+
+-- 'simple' keeps identifiers intact instead of stemming them like prose
+ALTER TABLE documents ADD COLUMN search_vector tsvector GENERATED ALWAYS AS (
+    setweight(to_tsvector('simple', title), 'A') ||
+    setweight(to_tsvector('simple', search_content), 'B')
+) STORED;
+
+CREATE INDEX idx_documents_search_vector ON documents USING GIN (search_vector);
+
+UPDATE documents d
+SET search_content = latest.content
+FROM (
+    SELECT DISTINCT ON (document_id) document_id, content
+    FROM document_snapshots
+    ORDER BY document_id, version DESC
+) latest
+WHERE latest.document_id = d.id;
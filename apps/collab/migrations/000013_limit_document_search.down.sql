@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS idx_documents_search_vector;
+ALTER TABLE documents DROP COLUMN IF EXISTS search_vector;
+
+ALTER TABLE documents ADD COLUMN search_vector tsvector GENERATED ALWAYS AS (
+    setweight(to_tsvector('simple', title), 'A') ||
+    setweight(to_tsvector('simple', search_content), 'B')
+) STORED;
+
+CREATE INDEX idx_documents_search_vector ON documents USING GIN (search_vector);
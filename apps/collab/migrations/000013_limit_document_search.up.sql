@@ -0,0 +1,11 @@
+-- to_tsvector fails on vectors over 1MB, which large documents can reach, so
+-- only the start of the content is indexed
+DROP INDEX IF EXISTS idx_documents_search_vector;
+ALTER TABLE documents DROP COLUMN IF EXISTS search_vector;
+
+ALTER TABLE documents ADD COLUMN search_vector tsvector GENERATED ALWAYS AS (
+    setweight(to_tsvector('simple', left(title, 1000)), 'A') ||
+    setweight(to_tsvector('simple', left(search_content, 100000)), 'B')
+) STORED;
+
+CREATE INDEX idx_documents_search_vector ON documents USING GIN (search_vector);
@@ -0,0 +1 @@
+ALTER TABLE document_snapshots DROP COLUMN IF EXISTS blame;
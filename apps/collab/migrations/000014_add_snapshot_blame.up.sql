@@ -0,0 +1,3 @@
+-- Line authorship as of each compaction snapshot, so blame can start there
+-- and older history can be pruned without losing it
+ALTER TABLE document_snapshots ADD COLUMN blame JSONB;
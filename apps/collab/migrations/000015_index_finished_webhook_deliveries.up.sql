@@ -0,0 +1,2 @@
+-- Lets retention find old delivered and failed rows without a full scan
+CREATE INDEX idx_webhook_deliveries_finished ON webhook_deliveries(created_at) WHERE status <> 'pending';
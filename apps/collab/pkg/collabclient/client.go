@@ -0,0 +1,942 @@
+// Package collabclient is a Go client for Maple's collaboration WebSocket
+// protocol. It keeps a local copy of a room's content, sends local edits one
+// batch at a time and transforms remote edits against those still in
+// flight, the same way the web editor does.
+package collabclient
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"nhooyr.io/websocket"
+
+	"github.com/NoumanAMalik/maple/apps/collab/internal/collab"
+)
+
+const (
+	handshakeTimeout  = 10 * time.Second
+	writeTimeout      = 10 * time.Second
+	keepAliveInterval = 30 * time.Second
+	maxReconnectDelay = 30 * time.Second
+	// maxHeldEvents is how many out-of-order server events to hold while
+	// waiting for a missing version before giving up and resyncing
+	maxHeldEvents = 64
+	// stallTimeout is how long to wait for a missing version or for the
+	// in-flight batch's ack before assuming it was lost and resyncing
+	stallTimeout  = 10 * time.Second
+	watchInterval = time.Second
+)
+
+// serverRev tags the server's state when merging local edits into a fresh
+// snapshot
+const serverRev = "~server"
+
+// ErrClosed is returned by methods called after Close.
+var ErrClosed = errors.New("collabclient: client closed")
+
+// ErrRoomClosed is returned by Err when an operator closed the room. The
+// client does not reconnect; the wrapped message carries the given reason.
+var ErrRoomClosed = errors.New("collabclient: room closed")
+
+// Options configures a Client. Callbacks run on the client's read goroutine,
+// one at a time and in message order; they may call back into the Client.
+type Options struct {
+	// ClientID identifies this connection in the room. A random one is used
+	// when empty.
+	ClientID    string
+	DisplayName string
+	// Header is sent with the WebSocket handshake, e.g. for authorization.
+	Header http.Header
+	// MaxReconnects is how many times in a row to reconnect after the
+	// connection drops. Zero disables reconnecting.
+	MaxReconnects int
+
+	// OnRemoteOp receives another client's edit, already transformed so it
+	// applies to Content as it was just before the call.
+	OnRemoteOp func(ops []Operation, actor ActorInfo, version int)
+	// OnResync is called when the local state was rebuilt from a fresh
+	// snapshot, after a resync or a reconnect. Unacknowledged edits are
+	// rebased onto it. A batch whose ack was lost is first sent again so the
+	// server can tell whether it already applied it; only when it is too old
+	// for that are it and later edits discarded and passed as dropped.
+	OnResync     func(content string, version int, dropped []Operation)
+	OnPresence   func(update PresenceUpdateMessage)
+	OnUserJoined func(actor ActorInfo)
+	OnUserLeft   func(clientID string)
+	OnError      func(msg ErrorMessage)
+	OnDisconnect func(err error)
+	OnReconnect  func(version int)
+	// OnAck is called when the server acknowledges a batch, with the time
+	// since it was sent.
+	OnAck func(version int, latency time.Duration)
+}
+
+// Stats counts what a Client has sent and received since Dial.
+type Stats struct {
+	BatchesSent  int `json:"batchesSent"`
+	BatchesAcked int `json:"batchesAcked"`
+	RemoteOps    int `json:"remoteOps"`
+	Reconnects   int `json:"reconnects"`
+	Resyncs      int `json:"resyncs"`
+	// LostEvents counts the times an ack or broadcast never arrived and the
+	// client had to resync to recover.
+	LostEvents int `json:"lostEvents"`
+	// DroppedOps counts local operations discarded on resync because the
+	// server may already have applied them and could no longer tell.
+	DroppedOps int `json:"droppedOps"`
+}
+
+// Client is a connection to one collaboration room.
+type Client struct {
+	opts     Options
+	url      string
+	roomID   string
+	clientID string
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+
+	connMu sync.Mutex
+	conn   *websocket.Conn
+
+	// content is confirmed with inflight and buffer applied on top.
+	// confirmed is the server's content at version.
+	mu        sync.Mutex
+	content   string
+	confirmed string
+	version   int
+	isOwner   bool
+	inflight  *pendingBatch
+	buffer    []Operation
+	held      map[int]serverEvent
+	heldSince time.Time
+	stalled   bool
+	stats     Stats
+
+	// Only used by the read goroutine
+	lastResync  time.Time
+	resyncDelay time.Duration
+}
+
+// serverEvent is an ack or remote op, held until the versions before it
+// have been seen. The server can deliver them out of order when several
+// clients submit at once.
+type serverEvent struct {
+	ack    *AckMessage
+	remote *RemoteOpMessage
+}
+
+// pendingBatch is the op batch sent and waiting for its ack. ops is
+// rebased past remote edits as they arrive; sent keeps the batch as it went
+// out so it can be retried after a reconnect.
+type pendingBatch struct {
+	opID        string
+	ops         []Operation
+	sent        []Operation
+	baseVersion int
+	sentAt      time.Time
+	// applied is set once a retry shows the server has the batch
+	applied bool
+}
+
+// Dial connects to a room on the collab server at baseURL (http, https, ws
+// or wss) and completes the hello/welcome handshake. Rooms for saved
+// documents are opened with POST /v1/docs/{id}/room.
+func Dial(ctx context.Context, baseURL, roomID string, opts Options) (*Client, error) {
+	if roomID == "" {
+		return nil, errors.New("collabclient: room ID is required")
+	}
+	if opts.ClientID == "" {
+		opts.ClientID = "go_" + randomID()
+	}
+
+	url := strings.TrimRight(baseURL, "/")
+	switch {
+	case strings.HasPrefix(url, "https://"):
+		url = "wss://" + strings.TrimPrefix(url, "https://")
+	case strings.HasPrefix(url, "http://"):
+		url = "ws://" + strings.TrimPrefix(url, "http://")
+	}
+
+	clientCtx, cancel := context.WithCancel(context.Background())
+	c := &Client{
+		opts:     opts,
+		url:      url + "/v1/rooms/" + roomID + "/ws",
+		roomID:   roomID,
+		clientID: opts.ClientID,
+		ctx:      clientCtx,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+
+	conn, welcome, err := c.connect(ctx, nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	c.conn = conn
+	c.content = welcome.Snapshot
+	c.confirmed = welcome.Snapshot
+	c.version = welcome.ServerVersion
+	c.isOwner = welcome.IsOwner
+
+	go c.run(conn, nil)
+	return c, nil
+}
+
+// ClientID returns the ID this client joined the room with.
+func (c *Client) ClientID() string {
+	return c.clientID
+}
+
+// IsOwner reports whether this client owns the room.
+func (c *Client) IsOwner() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.isOwner
+}
+
+// Content returns the local content, including edits not yet acknowledged.
+func (c *Client) Content() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.content
+}
+
+// Version returns the last server version the local content is based on.
+func (c *Client) Version() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.version
+}
+
+// Pending reports whether local edits are still waiting to be acknowledged.
+func (c *Client) Pending() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.inflight != nil || len(c.buffer) > 0
+}
+
+// Stats returns the client's counters.
+func (c *Client) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+// Apply applies a local edit and queues it for the server. Edits made while
+// a batch is in flight are combined into the next batch.
+func (c *Client) Apply(ops ...Operation) error {
+	return c.Edit(func(string) []Operation { return ops })
+}
+
+// Replace edits the local content to match content, sending the difference.
+func (c *Client) Replace(content string) error {
+	return c.Edit(func(current string) []Operation { return Diff(current, content) })
+}
+
+// Edit applies the operations fn returns for the current content. No remote
+// edit lands between fn seeing the content and its operations being applied,
+// so fn can compute edits from it safely. fn must not call into the Client.
+func (c *Client) Edit(fn func(content string) []Operation) error {
+	if c.ctx.Err() != nil {
+		return ErrClosed
+	}
+
+	c.mu.Lock()
+	ops := fn(c.content)
+	for _, op := range ops {
+		if op.Type != OpInsert && op.Type != OpDelete {
+			c.mu.Unlock()
+			return fmt.Errorf("collabclient: unknown operation type %q", op.Type)
+		}
+	}
+	if len(ops) == 0 {
+		c.mu.Unlock()
+		return nil
+	}
+	updated, err := collab.ApplyOperations(c.content, ops)
+	if err != nil {
+		c.mu.Unlock()
+		return err
+	}
+	c.content = updated
+	c.buffer = append(c.buffer, ops...)
+	msg := c.flushLocked()
+	c.mu.Unlock()
+
+	return c.sendOp(msg)
+}
+
+// SendPresence shares this client's cursor and selection.
+func (c *Client) SendPresence(cursor Position, selection *Selection) error {
+	return c.write(c.ctx, PresenceMessage{
+		V:           1,
+		T:           "presence",
+		Cursor:      cursor,
+		Selection:   selection,
+		DisplayName: c.opts.DisplayName,
+	})
+}
+
+// Done is closed once the client has stopped, either through Close or
+// because the connection was lost for good.
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns why the client stopped, or nil while it is running or after
+// Close.
+func (c *Client) Err() error {
+	select {
+	case <-c.done:
+		return c.err
+	default:
+		return nil
+	}
+}
+
+// Close leaves the room. Unacknowledged edits are discarded.
+func (c *Client) Close() error {
+	c.cancel()
+	c.connMu.Lock()
+	if c.conn != nil {
+		c.conn.Close(websocket.StatusNormalClosure, "client closed")
+	}
+	c.connMu.Unlock()
+	<-c.done
+	return nil
+}
+
+// connect dials the room and waits for its welcome
+func (c *Client) connect(ctx context.Context, lastSeenVersion *int) (*websocket.Conn, *WelcomeMessage, error) {
+	ctx, cancel := context.WithTimeout(ctx, handshakeTimeout)
+	defer cancel()
+
+	conn, _, err := websocket.Dial(ctx, c.url, &websocket.DialOptions{HTTPHeader: c.opts.Header})
+	if err != nil {
+		return nil, nil, err
+	}
+	conn.SetReadLimit(-1)
+
+	hello := HelloMessage{V: 1, T: "hello", DocID: c.roomID, ClientID: c.clientID}
+	if lastSeenVersion != nil {
+		hello.Resume = &struct {
+			LastSeenVersion int `json:"lastSeenVersion"`
+		}{LastSeenVersion: *lastSeenVersion}
+	}
+	if err := writeJSON(ctx, conn, hello); err != nil {
+		conn.Close(websocket.StatusInternalError, "")
+		return nil, nil, err
+	}
+
+	for {
+		_, data, err := conn.Read(ctx)
+		if err != nil {
+			conn.Close(websocket.StatusInternalError, "")
+			return nil, nil, err
+		}
+
+		var base collab.ClientMessage
+		if err := json.Unmarshal(data, &base); err != nil {
+			continue
+		}
+		switch base.T {
+		case "welcome":
+			var welcome WelcomeMessage
+			if err := json.Unmarshal(data, &welcome); err != nil {
+				conn.Close(websocket.StatusInternalError, "")
+				return nil, nil, err
+			}
+			return conn, &welcome, nil
+		case "error":
+			var msg ErrorMessage
+			_ = json.Unmarshal(data, &msg)
+			conn.Close(websocket.StatusNormalClosure, "")
+			return nil, nil, fmt.Errorf("collabclient: %s: %s", msg.Code, msg.Message)
+		}
+	}
+}
+
+// run reads from conn until the client is closed, reconnecting when the
+// connection drops or the server asks for a resync. queued are messages
+// already read from conn.
+func (c *Client) run(conn *websocket.Conn, queued [][]byte) {
+	defer close(c.done)
+
+	for {
+		err := c.readLoop(conn, queued)
+		if c.ctx.Err() != nil {
+			return
+		}
+
+		if errors.Is(err, ErrRoomClosed) {
+			if c.opts.OnDisconnect != nil {
+				c.opts.OnDisconnect(err)
+			}
+			c.err = err
+			return
+		}
+
+		rejected := errors.Is(err, errResync)
+		resync := rejected || errors.Is(err, errOutOfSync)
+		if !resync && c.opts.OnDisconnect != nil {
+			c.opts.OnDisconnect(err)
+		}
+
+		conn, queued, err = c.reconnect(resync, rejected)
+		if err != nil {
+			c.err = err
+			return
+		}
+	}
+}
+
+var (
+	// errResync means the server rejected the in-flight batch
+	errResync = errors.New("resync required")
+	// errOutOfSync means the local state can no longer be trusted
+	errOutOfSync = errors.New("out of sync")
+	// errAppliedLater means a retried batch was applied after the welcome's
+	// snapshot was taken, so the snapshot does not include it
+	errAppliedLater = errors.New("batch applied after snapshot")
+)
+
+// reconnect opens a new connection, resuming from the current version, and
+// returns it with any messages read from it while retrying the in-flight
+// batch. rejected reports whether that batch is known not to have been
+// applied.
+func (c *Client) reconnect(resync, rejected bool) (*websocket.Conn, [][]byte, error) {
+	attempts := c.opts.MaxReconnects
+	if resync && attempts == 0 {
+		// A resync needs a fresh welcome even when reconnecting is disabled
+		attempts = 1
+	}
+
+	if resync {
+		select {
+		case <-c.ctx.Done():
+			return nil, nil, ErrClosed
+		case <-time.After(c.resyncBackoff()):
+		}
+	}
+
+	var lastErr error
+	delay := time.Second
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-c.ctx.Done():
+				return nil, nil, ErrClosed
+			case <-time.After(delay):
+			}
+			delay = min(delay*2, maxReconnectDelay)
+		}
+
+		version := c.Version()
+		conn, welcome, err := c.connect(c.ctx, &version)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		var queued [][]byte
+		if !rejected {
+			queued, err = c.retryInflight(conn, welcome.ServerVersion)
+			if errors.Is(err, errAppliedLater) {
+				// The batch is now known to be applied; a fresh welcome has it
+				conn.Close(websocket.StatusNormalClosure, "resync")
+				conn, welcome, err = c.connect(c.ctx, &version)
+			}
+			if err != nil {
+				if conn != nil {
+					conn.Close(websocket.StatusNormalClosure, "")
+				}
+				lastErr = err
+				continue
+			}
+		}
+
+		c.connMu.Lock()
+		c.conn = conn
+		c.connMu.Unlock()
+		c.resume(welcome, resync, rejected)
+		return conn, queued, nil
+	}
+
+	if lastErr == nil {
+		lastErr = errors.New("connection lost")
+	}
+	return nil, nil, fmt.Errorf("collabclient: reconnect failed: %w", lastErr)
+}
+
+// resyncBackoff returns how long to wait before resyncing. Resyncs in quick
+// succession back off, so a server refusing every batch, e.g. while its
+// database is down, is not hit in a tight loop.
+func (c *Client) resyncBackoff() time.Duration {
+	now := time.Now()
+	if now.Sub(c.lastResync) < maxReconnectDelay {
+		c.resyncDelay = min(max(2*c.resyncDelay, 250*time.Millisecond), maxReconnectDelay)
+	} else {
+		c.resyncDelay = 0
+	}
+	c.lastResync = now
+	return c.resyncDelay
+}
+
+// retryInflight sends the unacknowledged batch again, exactly as it was
+// first sent, and waits for the server's answer. The server acks a batch it
+// has already applied with its original version instead of applying it
+// twice, so an ack marks the batch applied. A resync_required leaves its
+// outcome unknown: the server can only check recent batches. Other messages
+// read meanwhile are returned to be handled once the client has resumed.
+func (c *Client) retryInflight(conn *websocket.Conn, snapshotVersion int) ([][]byte, error) {
+	c.mu.Lock()
+	batch := c.inflight
+	c.mu.Unlock()
+	if batch == nil || batch.applied {
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(c.ctx, stallTimeout)
+	defer cancel()
+	err := writeJSON(ctx, conn, OpMessage{
+		V:           1,
+		T:           "op",
+		OpID:        batch.opID,
+		BaseVersion: batch.baseVersion,
+		Ops:         batch.sent,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var queued [][]byte
+	for {
+		_, data, err := conn.Read(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		var base collab.ClientMessage
+		if err := json.Unmarshal(data, &base); err != nil {
+			continue
+		}
+		switch base.T {
+		case "ack":
+			var msg AckMessage
+			if json.Unmarshal(data, &msg) == nil && msg.OpID == batch.opID {
+				c.mu.Lock()
+				batch.applied = true
+				c.mu.Unlock()
+				if msg.NewVersion > snapshotVersion {
+					return nil, errAppliedLater
+				}
+				return queued, nil
+			}
+		case "resync_required":
+			return queued, nil
+		case "room_closed":
+			return append(queued, data), nil
+		}
+		queued = append(queued, data)
+	}
+}
+
+// resume adopts the server state from a new welcome and rebases local edits
+// onto it. An in-flight batch the server rejected is rebased too, and one it
+// applied is already in the snapshot. Otherwise its outcome is unknown, and
+// it and everything after it are dropped rather than risk applying them
+// twice.
+func (c *Client) resume(welcome *WelcomeMessage, resync, rejected bool) {
+	c.mu.Lock()
+	c.isOwner = welcome.IsOwner
+	c.held = nil
+	c.heldSince = time.Time{}
+	c.stalled = false
+
+	// base is the server content the pending edits apply to
+	base := c.confirmed
+	var pending, dropped []Operation
+	switch {
+	case c.inflight == nil:
+	case c.inflight.applied:
+		applied, err := collab.ApplyOperations(c.confirmed, c.inflight.ops)
+		if err != nil {
+			dropped = append(dropped, c.inflight.ops...)
+			break
+		}
+		base = applied
+	case rejected:
+		pending = append(pending, c.inflight.ops...)
+	default:
+		dropped = append(dropped, c.inflight.ops...)
+	}
+	if len(dropped) > 0 {
+		dropped = append(dropped, c.buffer...)
+	} else {
+		pending = append(pending, c.buffer...)
+	}
+
+	changed := welcome.Snapshot != base
+	if changed && len(pending) > 0 {
+		missed := Diff(base, welcome.Snapshot)
+		pending = collab.TransformBatch(pending, missed, c.clientID, serverRev)
+	}
+	content, err := collab.ApplyOperations(welcome.Snapshot, pending)
+	if err != nil {
+		dropped = append(dropped, pending...)
+		pending = nil
+		content = welcome.Snapshot
+	}
+
+	c.content = content
+	c.confirmed = welcome.Snapshot
+	c.version = welcome.ServerVersion
+	c.inflight = nil
+	c.buffer = pending
+	if resync {
+		c.stats.Resyncs++
+	} else {
+		c.stats.Reconnects++
+	}
+	c.stats.DroppedOps += len(dropped)
+	msg := c.flushLocked()
+	c.mu.Unlock()
+
+	if !resync && c.opts.OnReconnect != nil {
+		c.opts.OnReconnect(welcome.ServerVersion)
+	}
+	if (resync || changed || len(dropped) > 0) && c.opts.OnResync != nil {
+		c.opts.OnResync(content, welcome.ServerVersion, dropped)
+	}
+	_ = c.sendOp(msg)
+}
+
+// readLoop handles queued and then messages read from conn until the
+// connection drops or a message calls for a resync
+func (c *Client) readLoop(conn *websocket.Conn, queued [][]byte) error {
+	watchCtx, stopWatch := context.WithCancel(c.ctx)
+	defer stopWatch()
+	go c.watch(watchCtx, conn)
+
+	for {
+		var data []byte
+		if len(queued) > 0 {
+			data, queued = queued[0], queued[1:]
+		} else {
+			var err error
+			_, data, err = conn.Read(c.ctx)
+			if err != nil {
+				if c.takeStalled() {
+					return errOutOfSync
+				}
+				return err
+			}
+		}
+
+		if err := c.handleMessage(data); err != nil {
+			conn.Close(websocket.StatusNormalClosure, "resync")
+			return err
+		}
+	}
+}
+
+// handleMessage dispatches one server message
+func (c *Client) handleMessage(data []byte) error {
+	var base collab.ClientMessage
+	if err := json.Unmarshal(data, &base); err != nil {
+		return nil
+	}
+
+	var err error
+	switch base.T {
+	case "ack":
+		var msg AckMessage
+		if json.Unmarshal(data, &msg) == nil {
+			err = c.handleEvent(msg.NewVersion, serverEvent{ack: &msg})
+		}
+	case "remote_op":
+		var msg RemoteOpMessage
+		if json.Unmarshal(data, &msg) == nil {
+			err = c.handleEvent(msg.Version, serverEvent{remote: &msg})
+		}
+	case "resync_required":
+		err = errResync
+	case "snapshot_restored":
+		// A restore replaces the content wholesale; start again from it
+		err = errOutOfSync
+	case "room_closed":
+		var msg RoomClosedMessage
+		_ = json.Unmarshal(data, &msg)
+		err = fmt.Errorf("%w: %s", ErrRoomClosed, msg.Reason)
+	case "presence_update":
+		var msg PresenceUpdateMessage
+		if json.Unmarshal(data, &msg) == nil && c.opts.OnPresence != nil {
+			c.opts.OnPresence(msg)
+		}
+	case "user_joined":
+		var msg UserJoinedMessage
+		if json.Unmarshal(data, &msg) == nil && c.opts.OnUserJoined != nil {
+			c.opts.OnUserJoined(msg.Actor)
+		}
+	case "user_left":
+		var msg UserLeftMessage
+		if json.Unmarshal(data, &msg) == nil && c.opts.OnUserLeft != nil {
+			c.opts.OnUserLeft(msg.ClientID)
+		}
+	case "error":
+		var msg ErrorMessage
+		if json.Unmarshal(data, &msg) == nil && c.opts.OnError != nil {
+			c.opts.OnError(msg)
+		}
+	}
+	return err
+}
+
+// watch sends pings so the server does not time out an idle connection, and
+// closes conn when an ack or broadcast has gone missing so readLoop resyncs.
+// The server drops messages to clients that fall too far behind.
+func (c *Client) watch(ctx context.Context, conn *websocket.Conn) {
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+	lastPing := time.Now()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if c.checkStalled(now) {
+				conn.Close(websocket.StatusNormalClosure, "resync")
+				return
+			}
+			if now.Sub(lastPing) >= keepAliveInterval {
+				if err := writeJSON(ctx, conn, PingMessage{V: 1, T: "ping"}); err != nil {
+					return
+				}
+				lastPing = now
+			}
+		}
+	}
+}
+
+// checkStalled reports whether a held event or the in-flight batch has
+// waited longer than stallTimeout, and marks the connection as stalled
+func (c *Client) checkStalled(now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	waiting := len(c.held) > 0 && now.Sub(c.heldSince) > stallTimeout
+	if c.inflight != nil && now.Sub(c.inflight.sentAt) > stallTimeout {
+		waiting = true
+	}
+	if waiting {
+		c.stalled = true
+		c.stats.LostEvents++
+	}
+	return waiting
+}
+
+func (c *Client) takeStalled() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stalled := c.stalled
+	c.stalled = false
+	return stalled
+}
+
+// handleEvent applies server events in version order, holding any that
+// arrive ahead of a missing version
+func (c *Client) handleEvent(version int, ev serverEvent) error {
+	c.mu.Lock()
+	if version <= c.version {
+		c.mu.Unlock()
+		return nil
+	}
+	if c.held == nil {
+		c.held = make(map[int]serverEvent)
+	}
+	if len(c.held) == 0 {
+		c.heldSince = time.Now()
+	}
+	c.held[version] = ev
+
+	type ackEvent struct {
+		version int
+		latency time.Duration
+	}
+	var (
+		applied []RemoteOpMessage
+		acked   []ackEvent
+	)
+	for {
+		next, ok := c.held[c.version+1]
+		if !ok {
+			break
+		}
+		delete(c.held, c.version+1)
+
+		if next.ack != nil {
+			sentAt := time.Time{}
+			if c.inflight != nil {
+				sentAt = c.inflight.sentAt
+			}
+			if !c.ackLocked(*next.ack) {
+				c.mu.Unlock()
+				return errOutOfSync
+			}
+			acked = append(acked, ackEvent{version: next.ack.NewVersion, latency: time.Since(sentAt)})
+			continue
+		}
+		msg, ok := c.remoteOpLocked(*next.remote)
+		if !ok {
+			c.mu.Unlock()
+			return errOutOfSync
+		}
+		applied = append(applied, msg)
+	}
+	if len(c.held) > maxHeldEvents {
+		// A broadcast was lost, most likely dropped by the server as too slow
+		c.stats.LostEvents++
+		c.mu.Unlock()
+		return errOutOfSync
+	}
+	if len(c.held) > 0 && len(applied)+len(acked) > 0 {
+		// Still waiting, but now for a later version
+		c.heldSince = time.Now()
+	}
+	msg := c.flushLocked()
+	c.mu.Unlock()
+
+	_ = c.sendOp(msg)
+	if c.opts.OnAck != nil {
+		for _, ack := range acked {
+			c.opts.OnAck(ack.version, ack.latency)
+		}
+	}
+	if c.opts.OnRemoteOp != nil {
+		for _, remote := range applied {
+			c.opts.OnRemoteOp(remote.Ops, remote.Actor, remote.Version)
+		}
+	}
+	return nil
+}
+
+// ackLocked confirms the in-flight batch. It reports false when the ack does
+// not match it and the local state can no longer be trusted.
+func (c *Client) ackLocked(msg AckMessage) bool {
+	if c.inflight == nil || c.inflight.opID != msg.OpID {
+		return false
+	}
+	confirmed, err := collab.ApplyOperations(c.confirmed, c.inflight.ops)
+	if err != nil {
+		return false
+	}
+	c.confirmed = confirmed
+	c.version = msg.NewVersion
+	c.inflight = nil
+	c.stats.BatchesAcked++
+	return true
+}
+
+// remoteOpLocked applies another client's batch. It is transformed past the
+// local edits the server has not seen yet, and they past it; the server
+// makes the same transform when the in-flight batch arrives. The returned
+// message carries the ops as applied to content.
+func (c *Client) remoteOpLocked(msg RemoteOpMessage) (RemoteOpMessage, bool) {
+	confirmed, err := collab.ApplyOperations(c.confirmed, msg.Ops)
+	if err != nil {
+		return msg, false
+	}
+
+	ops := msg.Ops
+	remoteID := msg.Actor.ClientID
+	if c.inflight != nil {
+		ops, c.inflight.ops = collab.TransformPair(ops, c.inflight.ops, remoteID, c.clientID)
+	}
+	if len(c.buffer) > 0 {
+		ops, c.buffer = collab.TransformPair(ops, c.buffer, remoteID, c.clientID)
+	}
+
+	content, err := collab.ApplyOperations(c.content, ops)
+	if err != nil {
+		return msg, false
+	}
+	c.content = content
+	c.confirmed = confirmed
+	c.version = msg.Version
+	c.stats.RemoteOps++
+	msg.Ops = ops
+	return msg, true
+}
+
+// flushLocked moves buffered edits in flight when nothing else is, and
+// returns the message to send for them
+func (c *Client) flushLocked() *OpMessage {
+	if c.inflight != nil || len(c.buffer) == 0 {
+		return nil
+	}
+
+	c.inflight = &pendingBatch{
+		opID:        "op_" + randomID(),
+		ops:         c.buffer,
+		sent:        c.buffer,
+		baseVersion: c.version,
+		sentAt:      time.Now(),
+	}
+	c.buffer = nil
+	c.stats.BatchesSent++
+	return &OpMessage{
+		V:           1,
+		T:           "op",
+		OpID:        c.inflight.opID,
+		BaseVersion: c.version,
+		Ops:         c.inflight.ops,
+	}
+}
+
+func (c *Client) sendOp(msg *OpMessage) error {
+	if msg == nil {
+		return nil
+	}
+	// A failed write leaves the batch in flight; the resulting disconnect
+	// resolves it through a resync
+	return c.write(c.ctx, msg)
+}
+
+func (c *Client) write(ctx context.Context, msg any) error {
+	c.connMu.Lock()
+	conn := c.conn
+	c.connMu.Unlock()
+	if conn == nil || c.ctx.Err() != nil {
+		return ErrClosed
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, writeTimeout)
+	defer cancel()
+	return writeJSON(ctx, conn, msg)
+}
+
+func writeJSON(ctx context.Context, conn *websocket.Conn, msg any) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return conn.Write(ctx, websocket.MessageText, data)
+}
+
+func randomID() string {
+	buf := make([]byte, 8)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
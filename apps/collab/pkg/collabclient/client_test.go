@@ -0,0 +1,475 @@
+package collabclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"nhooyr.io/websocket"
+
+	"github.com/NoumanAMalik/maple/apps/collab/internal/collab"
+)
+
+// testClientHeader names a test client so roomServer can drop its connection
+const testClientHeader = "X-Test-Client"
+
+// roomServer serves the real room protocol from a RoomRegistry
+type roomServer struct {
+	*httptest.Server
+	room *collab.Room
+
+	mu    sync.Mutex
+	conns map[string]*websocket.Conn
+}
+
+func newRoomServer(t *testing.T, content string) *roomServer {
+	t.Helper()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	registry := collab.NewRoomRegistry(context.Background(), nil, logger)
+	room, err := registry.CreateRoom(content, "plaintext", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &roomServer{room: room, conns: make(map[string]*websocket.Conn)}
+	handler := collab.NewWSHandler(registry, logger)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/rooms/{id}/ws", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[r.Header.Get(testClientHeader)] = conn
+		s.mu.Unlock()
+		handler.HandleConnection(r.Context(), conn, r.PathValue("id"), "")
+	})
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(func() {
+		s.Close()
+		registry.Stop()
+	})
+	return s
+}
+
+// drop closes the server side of name's current connection
+func (s *roomServer) drop(name string) {
+	s.mu.Lock()
+	conn := s.conns[name]
+	s.mu.Unlock()
+	if conn != nil {
+		conn.Close(websocket.StatusGoingAway, "dropped")
+	}
+}
+
+func dial(t *testing.T, url, roomID, name string, opts Options) *Client {
+	t.Helper()
+	opts.Header = http.Header{testClientHeader: {name}}
+	opts.ClientID = name
+	c, err := Dial(context.Background(), url, roomID, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// waitConverged waits for every client to have its edits acknowledged and
+// to match the room
+func waitConverged(t *testing.T, room *collab.Room, clients ...*Client) string {
+	t.Helper()
+	waitFor(t, "clients to converge", func() bool {
+		content, version := room.State()
+		for _, c := range clients {
+			if c.Pending() || c.Version() != version || c.Content() != content {
+				return false
+			}
+		}
+		return true
+	})
+	content, _ := room.State()
+	return content
+}
+
+// randomEdit returns an insert or delete at a random position in content,
+// which must be ASCII so byte offsets are UTF-16 offsets
+func randomEdit(rng *rand.Rand, content string) []Operation {
+	pos := rng.Intn(len(content) + 1)
+	if len(content) > 0 && rng.Intn(3) == 0 {
+		if pos == len(content) {
+			pos--
+		}
+		return []Operation{Delete(pos, 1+rng.Intn(min(4, len(content)-pos)))}
+	}
+	return []Operation{Insert(pos, string(rune('a'+rng.Intn(26))))}
+}
+
+func TestConcurrentEditsConverge(t *testing.T) {
+	srv := newRoomServer(t, "the quick brown fox")
+	names := []string{"a", "b", "c"}
+	clients := make([]*Client, len(names))
+	for i, name := range names {
+		clients[i] = dial(t, srv.URL, srv.room.ID, name, Options{})
+	}
+
+	var wg sync.WaitGroup
+	for i, c := range clients {
+		wg.Add(1)
+		go func(c *Client, seed int64) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(seed))
+			for range 100 {
+				if err := c.Edit(func(content string) []Operation { return randomEdit(rng, content) }); err != nil {
+					t.Error(err)
+					return
+				}
+				if rng.Intn(4) == 0 {
+					time.Sleep(time.Millisecond)
+				}
+			}
+		}(c, int64(i+1))
+	}
+	wg.Wait()
+
+	waitConverged(t, srv.room, clients...)
+	remote := 0
+	for _, c := range clients {
+		stats := c.Stats()
+		if stats.Resyncs != 0 || stats.DroppedOps != 0 {
+			t.Errorf("client %s: unexpected resync: %+v", c.ClientID(), stats)
+		}
+		remote += stats.RemoteOps
+	}
+	if remote == 0 {
+		t.Error("no remote ops were received")
+	}
+}
+
+func TestRemoteOpTransformedPastInflight(t *testing.T) {
+	srv := newScriptServer(t,
+		func(t *testing.T, conn *websocket.Conn) {
+			readHello(t, conn)
+			send(t, conn, WelcomeMessage{V: 1, T: "welcome", DocID: "room", ServerVersion: 1, Snapshot: "abc"})
+			op := readOp(t, conn)
+			// Another client's insert at 0 was applied first
+			send(t, conn, RemoteOpMessage{
+				V: 1, T: "remote_op", Version: 2,
+				Actor: ActorInfo{ClientID: "other"},
+				Ops:   []Operation{Insert(0, "12")},
+			})
+			send(t, conn, AckMessage{V: 1, T: "ack", OpID: op.OpID, NewVersion: 3})
+			waitClosed(conn)
+		},
+	)
+
+	var remote []Operation
+	c := dial(t, srv.URL, "room", "a", Options{
+		OnRemoteOp: func(ops []Operation, _ ActorInfo, _ int) { remote = ops },
+	})
+	if err := c.Apply(Insert(3, "X"), Delete(0, 1)); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "ack", func() bool { return !c.Pending() })
+
+	if got, want := c.Content(), "12bcX"; got != want {
+		t.Errorf("content = %q, want %q", got, want)
+	}
+	if c.Version() != 3 {
+		t.Errorf("version = %d, want 3", c.Version())
+	}
+	if want := []Operation{Insert(0, "12")}; !reflect.DeepEqual(remote, want) {
+		t.Errorf("remote ops = %+v, want %+v", remote, want)
+	}
+}
+
+func TestResyncRebasesRejectedBatch(t *testing.T) {
+	srv := newScriptServer(t,
+		func(t *testing.T, conn *websocket.Conn) {
+			readHello(t, conn)
+			send(t, conn, WelcomeMessage{V: 1, T: "welcome", DocID: "room", ServerVersion: 1, Snapshot: "abc"})
+			readOp(t, conn)
+			send(t, conn, ResyncRequiredMessage{V: 1, T: "resync_required"})
+			waitClosed(conn)
+		},
+		func(t *testing.T, conn *websocket.Conn) {
+			hello := readHello(t, conn)
+			if hello.Resume == nil || hello.Resume.LastSeenVersion != 1 {
+				t.Errorf("hello did not resume from version 1: %+v", hello.Resume)
+			}
+			send(t, conn, WelcomeMessage{V: 1, T: "welcome", DocID: "room", ServerVersion: 3, Snapshot: "zzabc"})
+			op := readOp(t, conn)
+			if op.BaseVersion != 3 || !reflect.DeepEqual(op.Ops, []Operation{Insert(5, "X")}) {
+				t.Errorf("rebased op = base %d %+v", op.BaseVersion, op.Ops)
+			}
+			send(t, conn, AckMessage{V: 1, T: "ack", OpID: op.OpID, NewVersion: 4})
+			waitClosed(conn)
+		},
+	)
+
+	var resynced string
+	var dropped []Operation
+	c := dial(t, srv.URL, "room", "a", Options{
+		OnResync: func(content string, _ int, d []Operation) {
+			resynced, dropped = content, d
+		},
+	})
+	if err := c.Apply(Insert(3, "X")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "rebased batch to be acked", func() bool { return c.Version() == 4 && !c.Pending() })
+
+	if got := c.Content(); got != "zzabcX" {
+		t.Errorf("content = %q, want %q", got, "zzabcX")
+	}
+	if resynced != "zzabcX" || len(dropped) != 0 {
+		t.Errorf("OnResync(%q, dropped %+v)", resynced, dropped)
+	}
+	if stats := c.Stats(); stats.Resyncs != 1 || stats.DroppedOps != 0 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestResumeRetriesUnackedBatch(t *testing.T) {
+	// The first connection takes the batch and drops without acking it
+	lostAck := func(t *testing.T, conn *websocket.Conn) {
+		readHello(t, conn)
+		send(t, conn, WelcomeMessage{V: 1, T: "welcome", DocID: "room", ServerVersion: 1, Snapshot: "abc"})
+		readOp(t, conn)
+		conn.Close(websocket.StatusGoingAway, "dropped")
+	}
+	// expectRetry answers the retried batch, which must match the original
+	expectRetry := func(t *testing.T, conn *websocket.Conn, reply any) {
+		op := readOp(t, conn)
+		if op.BaseVersion != 1 || !reflect.DeepEqual(op.Ops, []Operation{Insert(3, "X")}) {
+			t.Errorf("retried op = base %d %+v", op.BaseVersion, op.Ops)
+		}
+		if ack, ok := reply.(AckMessage); ok {
+			ack.OpID = op.OpID
+			reply = ack
+		}
+		send(t, conn, reply)
+	}
+
+	tests := []struct {
+		name        string
+		scripts     []scriptFunc
+		wantContent string
+		wantVersion int
+		wantDropped []Operation
+	}{
+		{
+			name: "applied before the snapshot",
+			scripts: []scriptFunc{lostAck, func(t *testing.T, conn *websocket.Conn) {
+				readHello(t, conn)
+				send(t, conn, WelcomeMessage{V: 1, T: "welcome", DocID: "room", ServerVersion: 2, Snapshot: "abcX"})
+				expectRetry(t, conn, AckMessage{V: 1, T: "ack", NewVersion: 2})
+				waitClosed(conn)
+			}},
+			wantContent: "abcX",
+			wantVersion: 2,
+		},
+		{
+			name: "applied by the retry",
+			scripts: []scriptFunc{lostAck, func(t *testing.T, conn *websocket.Conn) {
+				readHello(t, conn)
+				send(t, conn, WelcomeMessage{V: 1, T: "welcome", DocID: "room", ServerVersion: 1, Snapshot: "abc"})
+				expectRetry(t, conn, AckMessage{V: 1, T: "ack", NewVersion: 2})
+				waitClosed(conn)
+			}, func(t *testing.T, conn *websocket.Conn) {
+				readHello(t, conn)
+				send(t, conn, WelcomeMessage{V: 1, T: "welcome", DocID: "room", ServerVersion: 2, Snapshot: "abcX"})
+				waitClosed(conn)
+			}},
+			wantContent: "abcX",
+			wantVersion: 2,
+		},
+		{
+			name: "too old to retry",
+			scripts: []scriptFunc{lostAck, func(t *testing.T, conn *websocket.Conn) {
+				readHello(t, conn)
+				send(t, conn, WelcomeMessage{V: 1, T: "welcome", DocID: "room", ServerVersion: 500, Snapshot: "Zabc"})
+				expectRetry(t, conn, ResyncRequiredMessage{V: 1, T: "resync_required"})
+				waitClosed(conn)
+			}},
+			wantContent: "Zabc",
+			wantVersion: 500,
+			wantDropped: []Operation{Insert(3, "X")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newScriptServer(t, tt.scripts...)
+			var mu sync.Mutex
+			var dropped []Operation
+			c := dial(t, srv.URL, "room", "a", Options{
+				MaxReconnects: 3,
+				OnResync: func(_ string, _ int, d []Operation) {
+					mu.Lock()
+					dropped = d
+					mu.Unlock()
+				},
+			})
+			if err := c.Apply(Insert(3, "X")); err != nil {
+				t.Fatal(err)
+			}
+			waitFor(t, "resume", func() bool { return c.Version() == tt.wantVersion && !c.Pending() })
+
+			if got := c.Content(); got != tt.wantContent {
+				t.Errorf("content = %q, want %q", got, tt.wantContent)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if !reflect.DeepEqual(dropped, tt.wantDropped) {
+				t.Errorf("dropped = %+v, want %+v", dropped, tt.wantDropped)
+			}
+			if got := c.Stats().DroppedOps; got != len(tt.wantDropped) {
+				t.Errorf("DroppedOps = %d, want %d", got, len(tt.wantDropped))
+			}
+		})
+	}
+}
+
+func TestReconnectKeepsEditsExactlyOnce(t *testing.T) {
+	srv := newRoomServer(t, "")
+	a := dial(t, srv.URL, srv.room.ID, "a", Options{MaxReconnects: 5})
+	b := dial(t, srv.URL, srv.room.ID, "b", Options{MaxReconnects: 5})
+
+	rng := rand.New(rand.NewSource(1))
+	const edits = 20
+	for i := range edits {
+		marker := fmt.Sprintf("[%02d]", i)
+		if err := a.Edit(func(content string) []Operation {
+			return []Operation{Insert(len(content), marker)}
+		}); err != nil {
+			t.Fatal(err)
+		}
+		// Drop the connection at varying points around the batch's ack
+		time.Sleep(time.Duration(rng.Intn(3)) * time.Millisecond)
+		srv.drop("a")
+		if err := b.Apply(Insert(0, "b")); err != nil {
+			t.Fatal(err)
+		}
+		waitFor(t, "reconnect", func() bool { return a.Stats().Reconnects > i })
+	}
+
+	content := waitConverged(t, srv.room, a, b)
+	for i := range edits {
+		if n := strings.Count(content, fmt.Sprintf("[%02d]", i)); n != 1 {
+			t.Errorf("edit %d appears %d times in %q", i, n, content)
+		}
+	}
+	if got := strings.Count(content, "b"); got != edits {
+		t.Errorf("content has %d of b's edits, want %d", got, edits)
+	}
+	if stats := a.Stats(); stats.DroppedOps != 0 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+// scriptFunc plays the server's side of one connection
+type scriptFunc func(t *testing.T, conn *websocket.Conn)
+
+// newScriptServer serves each connection with the next script in order
+func newScriptServer(t *testing.T, scripts ...scriptFunc) *httptest.Server {
+	t.Helper()
+	var mu sync.Mutex
+	next := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		n := next
+		next++
+		mu.Unlock()
+		conn, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.CloseNow()
+		if n >= len(scripts) {
+			t.Errorf("unexpected connection %d", n+1)
+			return
+		}
+		scripts[n](t, conn)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// read returns the next message of type typ, skipping pings
+func read(t *testing.T, conn *websocket.Conn, typ string, v any) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for {
+		_, data, err := conn.Read(ctx)
+		if err != nil {
+			t.Errorf("waiting for %s: %v", typ, err)
+			return
+		}
+		var base collab.ClientMessage
+		if json.Unmarshal(data, &base) != nil || base.T == "ping" {
+			continue
+		}
+		if base.T != typ {
+			t.Errorf("got %s message, want %s", base.T, typ)
+			return
+		}
+		if err := json.Unmarshal(data, v); err != nil {
+			t.Error(err)
+		}
+		return
+	}
+}
+
+func readHello(t *testing.T, conn *websocket.Conn) HelloMessage {
+	t.Helper()
+	var hello HelloMessage
+	read(t, conn, "hello", &hello)
+	return hello
+}
+
+func readOp(t *testing.T, conn *websocket.Conn) OpMessage {
+	t.Helper()
+	var op OpMessage
+	read(t, conn, "op", &op)
+	return op
+}
+
+func send(t *testing.T, conn *websocket.Conn, msg any) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := writeJSON(ctx, conn, msg); err != nil {
+		t.Errorf("send: %v", err)
+	}
+}
+
+// waitClosed blocks until the client closes conn
+func waitClosed(conn *websocket.Conn) {
+	for {
+		if _, _, err := conn.Read(context.Background()); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package collabclient
+
+import "github.com/NoumanAMalik/maple/apps/collab/internal/collab"
+
+// Protocol types, shared with the server so they cannot drift.
+type (
+	Operation    = collab.Operation
+	Position     = collab.Position
+	Selection    = collab.Selection
+	Presence     = collab.Presence
+	ActorInfo    = collab.ActorInfo
+	PresenceInfo = collab.PresenceInfo
+	Snapshot     = collab.Snapshot
+
+	HelloMessage          = collab.HelloMessage
+	WelcomeMessage        = collab.WelcomeMessage
+	OpMessage             = collab.OpMessage
+	AckMessage            = collab.AckMessage
+	RemoteOpMessage       = collab.RemoteOpMessage
+	ResyncRequiredMessage = collab.ResyncRequiredMessage
+	PresenceMessage       = collab.PresenceMessage
+	PresenceUpdateMessage = collab.PresenceUpdateMessage
+	UserJoinedMessage     = collab.UserJoinedMessage
+	UserLeftMessage       = collab.UserLeftMessage
+	ErrorMessage          = collab.ErrorMessage
+	RoomClosedMessage     = collab.RoomClosedMessage
+	PingMessage           = collab.PingMessage
+)
+
+// Operation types
+const (
+	OpInsert = collab.OpInsert
+	OpDelete = collab.OpDelete
+)
+
+// Insert returns an operation inserting text at pos, in UTF-16 code units.
+func Insert(pos int, text string) Operation {
+	return Operation{Type: OpInsert, Pos: pos, Text: text}
+}
+
+// Delete returns an operation deleting length UTF-16 code units at pos.
+func Delete(pos, length int) Operation {
+	return Operation{Type: OpDelete, Pos: pos, Len: length}
+}
+
+// Diff returns the operations that turn oldContent into newContent.
+func Diff(oldContent, newContent string) []Operation {
+	return collab.DiffToOps(oldContent, newContent)
+}
+
+// Transform rewrites ops, made concurrently with applied, so they apply after
+// it. opsClientID and appliedClientID break ties between inserts at the same
+// position.
+func Transform(ops, applied []Operation, opsClientID, appliedClientID string) []Operation {
+	return collab.TransformBatch(ops, applied, opsClientID, appliedClientID)
+}
@@ -324,6 +324,16 @@ export function useCollab(): UseCollabResult {
             onSnapshotRestoredRef.current?.(content, snapshotId, version);
         };
 
+        client.onRoomClosed = (reason: string) => {
+            // An operator closed the room; the session is over
+            console.warn("[useCollab] Room closed:", reason);
+            rejectPendingDiffRequests(new Error(reason));
+            setIsSharing(false);
+            setShareUrl(null);
+            setRoomId(null);
+            setCollaborators([]);
+        };
+
         client.onDiffResult = (requestId, result, serverVersion, language) => {
             const pending = pendingDiffRequestsRef.current.get(requestId);
             if (pending) {
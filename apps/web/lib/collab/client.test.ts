@@ -0,0 +1,89 @@
+import { describe, it, expect, beforeEach, afterEach, vi } from "vitest";
+import type { ClientMessage, Operation, ServerMessage } from "@maple/protocol";
+import { CollabClient } from "./client";
+
+class FakeWebSocket {
+    static readonly OPEN = 1;
+    static last: FakeWebSocket | null = null;
+
+    readyState = FakeWebSocket.OPEN;
+    sent: ClientMessage[] = [];
+    onopen: (() => void) | null = null;
+    onmessage: ((event: { data: string }) => void) | null = null;
+    onclose: ((event: { wasClean: boolean }) => void) | null = null;
+    onerror: (() => void) | null = null;
+
+    constructor(readonly url: string) {
+        FakeWebSocket.last = this;
+    }
+
+    send(data: string): void {
+        this.sent.push(JSON.parse(data));
+    }
+
+    close(): void {}
+
+    receive(message: ServerMessage): void {
+        this.onmessage?.({ data: JSON.stringify(message) });
+    }
+}
+
+function apply(content: string, ops: Operation[]): string {
+    for (const op of ops) {
+        if (op.type === "insert") {
+            content = content.slice(0, op.pos) + op.text + content.slice(op.pos);
+        } else {
+            content = content.slice(0, op.pos) + content.slice(op.pos + op.len);
+        }
+    }
+    return content;
+}
+
+describe("CollabClient", () => {
+    let client: CollabClient;
+    let ws: FakeWebSocket;
+
+    beforeEach(() => {
+        vi.stubGlobal("WebSocket", FakeWebSocket);
+        vi.stubEnv("NEXT_PUBLIC_COLLAB_URL", "http://collab.test");
+
+        client = new CollabClient();
+        client.connect("room");
+        ws = FakeWebSocket.last as FakeWebSocket;
+        ws.onopen?.();
+        ws.receive({
+            v: 1,
+            t: "welcome",
+            docId: "room",
+            serverVersion: 1,
+            snapshot: "abcdef",
+            presence: [],
+            snapshots: [],
+            isOwner: false,
+        });
+    });
+
+    afterEach(() => {
+        vi.unstubAllGlobals();
+        vi.unstubAllEnvs();
+    });
+
+    it("transforms consecutive remote ops against a pending batch as it stands", () => {
+        let content = "abcdef";
+        client.onRemoteOperations = (ops) => {
+            content = apply(content, ops);
+        };
+
+        const local: Operation[] = [{ type: "delete", pos: 1, len: 4 }];
+        content = apply(content, local);
+        client.sendOperations(local);
+
+        // The server applied these from another client before the local batch
+        const actor = { clientId: "other", color: "#000" };
+        ws.receive({ v: 1, t: "remote_op", version: 2, actor, ops: [{ type: "insert", pos: 3, text: "XY" }] });
+        ws.receive({ v: 1, t: "remote_op", version: 3, actor, ops: [{ type: "insert", pos: 4, text: "Z" }] });
+
+        // What the server ends with once it transforms the local batch
+        expect(content).toBe("aXZYf");
+    });
+});
@@ -16,6 +16,7 @@ import type {
     GetDiffMessage,
     DiffResult,
 } from "@maple/protocol";
+import { transformPair } from "./transform";
 
 export type ConnectionStatus = "connecting" | "connected" | "disconnected";
 
@@ -57,6 +58,7 @@ export class CollabClient {
     onSnapshotCreated: ((snapshot: Snapshot) => void) | null = null;
     onSnapshotsList: ((snapshots: Snapshot[]) => void) | null = null;
     onSnapshotRestored: ((content: string, snapshotId: string, version: number) => void) | null = null;
+    onRoomClosed: ((reason: string) => void) | null = null;
     onDiffResult:
         | ((
               requestId: string,
@@ -219,6 +221,12 @@ export class CollabClient {
                 this.pendingOps = [];
                 this.onSnapshotRestored?.(message.content, message.snapshotId, message.version);
                 break;
+            case "room_closed":
+                // The room is gone; forget it so the close does not trigger a reconnect
+                this.roomId = null;
+                this.pendingOps = [];
+                this.onRoomClosed?.(message.reason);
+                break;
             case "diff_result":
                 this.onDiffResult?.(
                     message.requestId,
@@ -297,7 +305,12 @@ export class CollabClient {
     }
 
     private handleRemoteOp(message: { version: number; actor: Actor; ops: Operation[] }): void {
-        const transformed = transformIncomingOps(message.ops, this.pendingOps, message.actor.clientId, this.clientId);
+        // Pending batches move past the remote ops too, so the next remote op
+        // is transformed against them as they now stand
+        let transformed = message.ops;
+        for (const entry of this.pendingOps) {
+            [transformed, entry.ops] = transformPair(transformed, entry.ops, message.actor.clientId, this.clientId);
+        }
         this.localVersion = Math.max(this.localVersion, message.version);
         this.onRemoteOperations?.(transformed, message.actor, message.version);
     }
@@ -367,90 +380,3 @@ function generateOpId(): string {
     }
     return `op_${Date.now()}_${Math.random().toString(36).substring(2, 9)}`;
 }
-
-function isNoop(op: Operation): boolean {
-    if (op.type === "insert") {
-        return op.text.length === 0;
-    }
-    return op.len <= 0;
-}
-
-function compareClientIds(a: string, b: string): number {
-    if (a === b) return 0;
-    return a < b ? -1 : 1;
-}
-
-function transformIncomingOps(
-    ops: Operation[],
-    pending: Array<{ ops: Operation[] }>,
-    remoteClientId: string,
-    localClientId: string,
-): Operation[] {
-    const transformed = ops.map((op) => ({ ...op }));
-
-    for (const pendingEntry of pending) {
-        for (let index = 0; index < transformed.length; index += 1) {
-            let incoming = transformed[index];
-            for (const localOp of pendingEntry.ops) {
-                incoming = transformOperation(incoming, localOp, remoteClientId, localClientId);
-            }
-            transformed[index] = incoming;
-        }
-    }
-
-    return transformed.filter((op) => !isNoop(op));
-}
-
-function transformOperation(op: Operation, other: Operation, opClientId: string, otherClientId: string): Operation {
-    if (isNoop(op)) {
-        return op;
-    }
-
-    if (op.type === "insert") {
-        if (other.type === "insert") {
-            if (op.pos > other.pos || (op.pos === other.pos && compareClientIds(opClientId, otherClientId) > 0)) {
-                op.pos += other.text.length;
-            }
-        } else {
-            const otherEnd = other.pos + other.len;
-            if (op.pos > otherEnd) {
-                op.pos -= other.len;
-            } else if (op.pos > other.pos) {
-                op.pos = other.pos;
-            }
-        }
-        return op;
-    }
-
-    if (other.type === "insert") {
-        if (op.pos >= other.pos) {
-            op.pos += other.text.length;
-        } else if (op.pos + op.len > other.pos) {
-            op.len += other.text.length;
-        }
-        return op;
-    }
-
-    const otherEnd = other.pos + other.len;
-    const opEnd = op.pos + op.len;
-
-    if (op.pos >= otherEnd) {
-        op.pos -= other.len;
-        return op;
-    }
-
-    if (opEnd <= other.pos) {
-        return op;
-    }
-
-    const overlapStart = Math.max(op.pos, other.pos);
-    const overlapEnd = Math.min(opEnd, otherEnd);
-    const overlapLen = overlapEnd - overlapStart;
-
-    op.len -= overlapLen;
-    if (other.pos < op.pos) {
-        op.pos = other.pos;
-    }
-
-    return op;
-}
@@ -0,0 +1,94 @@
+import { describe, it, expect } from "vitest";
+import type { Operation } from "@maple/protocol";
+import { transformPair } from "./transform";
+
+function apply(content: string, ops: Operation[]): string {
+    for (const op of ops) {
+        if (op.type === "insert") {
+            content = content.slice(0, op.pos) + op.text + content.slice(op.pos);
+        } else {
+            content = content.slice(0, op.pos) + content.slice(op.pos + op.len);
+        }
+    }
+    return content;
+}
+
+// mulberry32, so failures replay
+function seededRandom(seed: number): () => number {
+    return () => {
+        seed = (seed + 0x6d2b79f5) | 0;
+        let t = Math.imul(seed ^ (seed >>> 15), 1 | seed);
+        t = (t + Math.imul(t ^ (t >>> 7), 61 | t)) ^ t;
+        return ((t ^ (t >>> 14)) >>> 0) / 4294967296;
+    };
+}
+
+function randomBatch(random: () => number, content: string, count: number): Operation[] {
+    const texts = ["x", "yz", "é", "\n", "abc"];
+    const ops: Operation[] = [];
+    for (let i = 0; i < count; i += 1) {
+        let op: Operation;
+        if (content.length > 0 && random() < 0.5) {
+            const pos = Math.floor(random() * content.length);
+            const len = 1 + Math.floor(random() * Math.min(5, content.length - pos));
+            op = { type: "delete", pos, len };
+        } else {
+            const pos = Math.floor(random() * (content.length + 1));
+            op = { type: "insert", pos, text: texts[Math.floor(random() * texts.length)] };
+        }
+        content = apply(content, [op]);
+        ops.push(op);
+    }
+    return ops;
+}
+
+describe("transformPair", () => {
+    it("converges for random concurrent batches", () => {
+        const random = seededRandom(1);
+        for (let i = 0; i < 5000; i += 1) {
+            const content = apply("", randomBatch(random, "", Math.floor(random() * 8)));
+            const a = randomBatch(random, content, 1 + Math.floor(random() * 4));
+            const b = randomBatch(random, content, 1 + Math.floor(random() * 4));
+
+            const [aPrime, bPrime] = transformPair(a, b, "alice", "bob");
+            const viaA = apply(apply(content, a), bPrime);
+            const viaB = apply(apply(content, b), aPrime);
+            expect(viaA, JSON.stringify({ content, a, b })).toBe(viaB);
+        }
+    });
+
+    it("does not modify its inputs", () => {
+        const a: Operation[] = [{ type: "insert", pos: 0, text: "x" }];
+        const b: Operation[] = [{ type: "insert", pos: 0, text: "y" }];
+        transformPair(a, b, "bob", "alice");
+        expect(a).toEqual([{ type: "insert", pos: 0, text: "x" }]);
+        expect(b).toEqual([{ type: "insert", pos: 0, text: "y" }]);
+    });
+
+    it("splits a delete around a concurrent insert inside it", () => {
+        const del: Operation[] = [{ type: "delete", pos: 1, len: 4 }];
+        const ins: Operation[] = [{ type: "insert", pos: 3, text: "XY" }];
+
+        const [delPrime, insPrime] = transformPair(del, ins, "alice", "bob");
+        expect(delPrime).toEqual([
+            { type: "delete", pos: 1, len: 2 },
+            { type: "delete", pos: 3, len: 2 },
+        ]);
+        expect(apply(apply("abcdef", ins), delPrime)).toBe("aXYf");
+        expect(apply(apply("abcdef", del), insPrime)).toBe("aXYf");
+    });
+
+    it("orders inserts at the same position by client ID", () => {
+        const a: Operation[] = [{ type: "insert", pos: 1, text: "A" }];
+        const b: Operation[] = [{ type: "insert", pos: 1, text: "B" }];
+
+        const [aPrime, bPrime] = transformPair(a, b, "alice", "bob");
+        expect(apply(apply("xy", a), bPrime)).toBe("xABy");
+        expect(apply(apply("xy", b), aPrime)).toBe("xABy");
+    });
+
+    it("drops ops that transform to nothing", () => {
+        const del: Operation[] = [{ type: "delete", pos: 0, len: 3 }];
+        expect(transformPair(del, del, "alice", "bob")).toEqual([[], []]);
+    });
+});
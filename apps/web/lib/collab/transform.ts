@@ -0,0 +1,126 @@
+import type { Operation } from "@maple/protocol";
+
+function isNoop(op: Operation): boolean {
+    if (op.type === "insert") {
+        return op.text.length === 0;
+    }
+    return op.len <= 0;
+}
+
+function compareClientIds(a: string, b: string): number {
+    if (a === b) return 0;
+    return a < b ? -1 : 1;
+}
+
+/**
+ * Transforms two concurrent batches past each other, mirroring TransformPair
+ * on the server: applying a and then bPrime gives the same content as b and
+ * then aPrime. Both batches are sequential and made against the same content.
+ * Ties between inserts at the same position go to the lower client ID. Ops
+ * that become no-ops are dropped.
+ */
+export function transformPair(
+    a: Operation[],
+    b: Operation[],
+    aClientId: string,
+    bClientId: string,
+): [Operation[], Operation[]] {
+    const [aPrime, bPrime] = transformLists(a, b, aClientId, bClientId);
+    return [aPrime.filter((op) => !isNoop(op)), bPrime.filter((op) => !isNoop(op))];
+}
+
+function transformLists(
+    a: Operation[],
+    b: Operation[],
+    aClientId: string,
+    bClientId: string,
+): [Operation[], Operation[]] {
+    if (a.length === 0 || b.length === 0) {
+        return [a, b];
+    }
+    if (a.length === 1 && b.length === 1) {
+        return [transformOp(a[0], b[0], aClientId, bClientId), transformOp(b[0], a[0], bClientId, aClientId)];
+    }
+    if (a.length > 1) {
+        // a[1..] was made after a[0], so it moves past b as b stood after a[0]
+        const [head, bRest] = transformLists(a.slice(0, 1), b, aClientId, bClientId);
+        const [tail, bPrime] = transformLists(a.slice(1), bRest, aClientId, bClientId);
+        return [[...head, ...tail], bPrime];
+    }
+    const [aRest, head] = transformLists(a, b.slice(0, 1), aClientId, bClientId);
+    const [aPrime, tail] = transformLists(aRest, b.slice(1), aClientId, bClientId);
+    return [aPrime, [...head, ...tail]];
+}
+
+// A delete spanning a concurrent insert is split around it so the inserted
+// text survives.
+function transformOp(op: Operation, other: Operation, opClientId: string, otherClientId: string): Operation[] {
+    if (
+        op.type === "delete" &&
+        other.type === "insert" &&
+        !isNoop(other) &&
+        op.pos < other.pos &&
+        other.pos < op.pos + op.len
+    ) {
+        const before = other.pos - op.pos;
+        return [
+            { type: "delete", pos: op.pos, len: before },
+            { type: "delete", pos: op.pos + other.text.length, len: op.len - before },
+        ];
+    }
+    return [transformOperation({ ...op }, other, opClientId, otherClientId)];
+}
+
+function transformOperation(op: Operation, other: Operation, opClientId: string, otherClientId: string): Operation {
+    if (isNoop(op)) {
+        return op;
+    }
+
+    if (op.type === "insert") {
+        if (other.type === "insert") {
+            if (op.pos > other.pos || (op.pos === other.pos && compareClientIds(opClientId, otherClientId) > 0)) {
+                op.pos += other.text.length;
+            }
+        } else {
+            const otherEnd = other.pos + other.len;
+            if (op.pos > otherEnd) {
+                op.pos -= other.len;
+            } else if (op.pos > other.pos) {
+                op.pos = other.pos;
+            }
+        }
+        return op;
+    }
+
+    if (other.type === "insert") {
+        if (op.pos >= other.pos) {
+            op.pos += other.text.length;
+        } else if (op.pos + op.len > other.pos) {
+            op.len += other.text.length;
+        }
+        return op;
+    }
+
+    const otherEnd = other.pos + other.len;
+    const opEnd = op.pos + op.len;
+
+    if (op.pos >= otherEnd) {
+        op.pos -= other.len;
+        return op;
+    }
+
+    if (opEnd <= other.pos) {
+        return op;
+    }
+
+    const overlapStart = Math.max(op.pos, other.pos);
+    const overlapEnd = Math.min(opEnd, otherEnd);
+    const overlapLen = overlapEnd - overlapStart;
+
+    op.len -= overlapLen;
+    if (other.pos < op.pos) {
+        op.pos = other.pos;
+    }
+
+    return op;
+}
@@ -23,6 +23,7 @@ export interface Selection {
 }
 
 export interface Presence {
+    fileId?: string; // project connections only
     cursor: Position;
     selection?: Selection;
 }
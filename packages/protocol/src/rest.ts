@@ -1,3 +1,5 @@
+import type { ProjectNode } from "./ws";
+
 export interface CreateRoomRequest {
     content: string;
     language?: string;
@@ -14,6 +16,23 @@ export interface RoomInfoResponse {
     participantCount: number;
 }
 
+export interface CreateProjectRequest {
+    files?: Array<{ path: string; content: string; language?: string }>;
+}
+
+export interface CreateProjectResponse {
+    projectId: string;
+    shareUrl: string;
+    tree: ProjectNode[];
+}
+
+export interface ProjectInfoResponse {
+    projectId: string;
+    createdAt: string;
+    participantCount: number;
+    tree: ProjectNode[];
+}
+
 export interface HealthResponse {
     status: "ok";
     version: string;
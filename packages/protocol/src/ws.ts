@@ -10,6 +10,7 @@ export interface Snapshot {
     timestamp: string; // ISO 8601 format
     createdBy: string;
     type: SnapshotType;
+    version: number;
     message?: string;
     linesAdded: number;
     linesRemoved: number;
@@ -27,6 +28,7 @@ export interface HelloMessage {
 export interface OpMessage {
     v: 1;
     t: "op";
+    fileId?: string; // project connections only
     opId: string;
     baseVersion: number;
     ops: Operation[];
@@ -36,6 +38,7 @@ export interface OpMessage {
 export interface PresenceMessage {
     v: 1;
     t: "presence";
+    fileId?: string; // project connections only
     cursor: Position;
     selection?: Selection;
     displayName?: string;
@@ -92,6 +95,79 @@ export interface GetDiffMessage {
     target: "current";
 }
 
+export interface GetBlameMessage {
+    v: 1;
+    t: "get_blame";
+    requestId: string;
+}
+
+// Playback (replay-only sessions)
+export type PlaybackAction = "play" | "pause" | "seek" | "speed";
+
+export interface PlaybackControlMessage {
+    v: 1;
+    t: "playback_control";
+    action: PlaybackAction;
+    version?: number; // for "seek"
+    speed?: number; // for "speed"
+}
+
+// Project (multi-file) client messages
+export type ProjectNodeKind = "file" | "folder";
+
+export interface ProjectNode {
+    id: string;
+    parentId?: string; // omitted for top-level entries
+    name: string;
+    kind: ProjectNodeKind;
+    language?: string;
+}
+
+export interface ProjectFileState {
+    fileId: string;
+    version: number;
+    content: string;
+}
+
+export interface FileCreateMessage {
+    v: 1;
+    t: "file_create";
+    requestId?: string;
+    parentId?: string;
+    name: string;
+    kind: ProjectNodeKind;
+    content?: string;
+    language?: string;
+}
+
+export interface FileRenameMessage {
+    v: 1;
+    t: "file_rename";
+    requestId?: string;
+    fileId: string;
+    name: string;
+}
+
+export interface FileMoveMessage {
+    v: 1;
+    t: "file_move";
+    requestId?: string;
+    fileId: string;
+    parentId?: string; // omit to move to the top level
+}
+
+export interface FileDeleteMessage {
+    v: 1;
+    t: "file_delete";
+    requestId?: string;
+    fileId: string;
+}
+
+export interface PingMessage {
+    v: 1;
+    t: "ping";
+}
+
 export type ClientMessage =
     | HelloMessage
     | OpMessage
@@ -99,7 +175,14 @@ export type ClientMessage =
     | SaveMessage
     | RestoreMessage
     | GetSnapshotsMessage
-    | GetDiffMessage;
+    | GetDiffMessage
+    | GetBlameMessage
+    | PlaybackControlMessage
+    | FileCreateMessage
+    | FileRenameMessage
+    | FileMoveMessage
+    | FileDeleteMessage
+    | PingMessage;
 
 export interface WelcomeMessage {
     v: 1;
@@ -115,6 +198,7 @@ export interface WelcomeMessage {
 export interface AckMessage {
     v: 1;
     t: "ack";
+    fileId?: string;
     opId: string;
     newVersion: number;
 }
@@ -122,6 +206,7 @@ export interface AckMessage {
 export interface RemoteOpMessage {
     v: 1;
     t: "remote_op";
+    fileId?: string;
     version: number;
     actor: Actor;
     ops: Operation[];
@@ -130,6 +215,7 @@ export interface RemoteOpMessage {
 export interface PresenceUpdateMessage {
     v: 1;
     t: "presence_update";
+    fileId?: string;
     actor: Actor;
     cursor: Position;
     selection?: Selection;
@@ -138,6 +224,7 @@ export interface PresenceUpdateMessage {
 export interface ErrorMessage {
     v: 1;
     t: "error";
+    requestId?: string;
     code: string;
     message: string;
 }
@@ -145,6 +232,12 @@ export interface ErrorMessage {
 export interface ResyncRequiredMessage {
     v: 1;
     t: "resync_required";
+    fileId?: string;
+}
+
+export interface PongMessage {
+    v: 1;
+    t: "pong";
 }
 
 export interface UserJoinedMessage {
@@ -180,6 +273,14 @@ export interface SnapshotRestoredMessage {
     version: number;
 }
 
+// Sent before the server closes the connection when an operator closes the
+// room. Clients should not reconnect.
+export interface RoomClosedMessage {
+    v: 1;
+    t: "room_closed";
+    reason: string;
+}
+
 export interface DiffResultMessage {
     v: 1;
     t: "diff_result";
@@ -191,6 +292,76 @@ export interface DiffResultMessage {
     result: DiffResult;
 }
 
+// Blame types
+export interface BlameLine {
+    line: number;
+    content: string;
+    userId?: string;
+    clientId?: string;
+    version: number;
+    timestamp: string; // ISO 8601 format
+}
+
+export interface BlameResultMessage {
+    v: 1;
+    t: "blame_result";
+    requestId: string;
+    serverVersion: number;
+    lines: BlameLine[];
+}
+
+export interface PlaybackStateMessage {
+    v: 1;
+    t: "playback_state";
+    version: number;
+    fromVersion: number;
+    toVersion: number;
+    content: string;
+    playing: boolean;
+    speed: number;
+}
+
+export interface PlaybackEndedMessage {
+    v: 1;
+    t: "playback_ended";
+    version: number;
+}
+
+export interface ProjectWelcomeMessage {
+    v: 1;
+    t: "project_welcome";
+    projectId: string;
+    tree: ProjectNode[];
+    files: ProjectFileState[];
+    presence: Array<{ actor: Actor; presence: Presence }>;
+    isOwner: boolean;
+}
+
+export interface FileCreatedMessage {
+    v: 1;
+    t: "file_created";
+    requestId?: string;
+    actor: Actor;
+    node: ProjectNode;
+    content?: string;
+}
+
+export interface FileChangedMessage {
+    v: 1;
+    t: "file_renamed" | "file_moved";
+    requestId?: string;
+    actor: Actor;
+    node: ProjectNode;
+}
+
+export interface FileDeletedMessage {
+    v: 1;
+    t: "file_deleted";
+    requestId?: string;
+    actor: Actor;
+    fileIds: string[];
+}
+
 export type ServerMessage =
     | WelcomeMessage
     | AckMessage
@@ -203,4 +374,13 @@ export type ServerMessage =
     | SnapshotCreatedMessage
     | SnapshotsListMessage
     | SnapshotRestoredMessage
-    | DiffResultMessage;
+    | RoomClosedMessage
+    | DiffResultMessage
+    | BlameResultMessage
+    | PlaybackStateMessage
+    | PlaybackEndedMessage
+    | ProjectWelcomeMessage
+    | FileCreatedMessage
+    | FileChangedMessage
+    | FileDeletedMessage
+    | PongMessage;